@@ -0,0 +1,179 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Planes per site allowed by the plans of forecast.solar
+var planPlanes = map[string]int{
+	"public":            1,
+	"personal":          2,
+	"professional":      3,
+	"professional plus": 4,
+}
+
+// apiAccount exports the plan of the forecast.solar account of the API key
+// and its request quota, as reported with the rate limit of every response.
+// Requests without a valid API key are limited per IP address, which reveals
+// a lapsed subscription.
+type apiAccount struct {
+	name string // Of the account of the API key of sites, empty for -api-key
+
+	mu       sync.Mutex
+	limit    *rateLimit
+	degraded bool
+
+	plan      *prometheus.Desc
+	planes    *prometheus.Desc
+	quota     *prometheus.Desc
+	period    *prometheus.Desc
+	remaining *prometheus.Desc
+	lapsed    *prometheus.Desc
+}
+
+// newAPIAccount returns an account, with labels identifying it among several
+func newAPIAccount(labels prometheus.Labels) *apiAccount {
+	return &apiAccount{
+		plan: prometheus.NewDesc(
+			"forecast_solar_api_plan_info",
+			"Plan of the forecast.solar account, as told by the zone of its rate limit",
+			[]string{"plan"},
+			labels,
+		),
+		planes: prometheus.NewDesc(
+			"forecast_solar_api_plan_planes",
+			"Number of planes per site the plan of the forecast.solar account allows",
+			nil,
+			labels,
+		),
+		quota: prometheus.NewDesc(
+			"forecast_solar_api_quota_requests",
+			"Number of requests to forecast.solar allowed per period of the rate limit",
+			nil,
+			labels,
+		),
+		period: prometheus.NewDesc(
+			"forecast_solar_api_quota_period_seconds",
+			"Period of the rate limit of forecast.solar",
+			nil,
+			labels,
+		),
+		remaining: prometheus.NewDesc(
+			"forecast_solar_api_quota_remaining_requests",
+			"Number of requests to forecast.solar remaining in the current period of the rate limit",
+			nil,
+			labels,
+		),
+		lapsed: prometheus.NewDesc(
+			"forecast_solar_api_plan_lapsed",
+			"Whether forecast.solar applies the limits of the public plan despite the API key",
+			nil,
+			labels,
+		),
+	}
+}
+
+// planOf returns the plan of a rate limit zone, e.g. "IP 192.0.2.1" of the
+// public plan or "Personal"
+func planOf(zone string) string {
+	if zone == "" || strings.HasPrefix(zone, "IP") {
+		return "public"
+	}
+	return strings.ToLower(zone)
+}
+
+// update records the rate limit of a response, logging when the account
+// falls back to the public plan and recovers
+func (a *apiAccount) update(l *rateLimit) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.limit = l
+	degraded := planOf(l.Zone) == "public"
+	of := ""
+	if a.name != "" {
+		of = " of account " + a.name
+	}
+	if degraded && !a.degraded {
+		log.Printf("Warning: forecast.solar limits the requests%s by IP address despite the API key, check the subscription", of)
+	} else if !degraded && a.degraded {
+		log.Printf("forecast.solar applies the %s plan%s again", planOf(l.Zone), of)
+	}
+	a.degraded = degraded
+}
+
+func (a *apiAccount) Describe(ch chan<- *prometheus.Desc) {
+	ch <- a.plan
+	ch <- a.planes
+	ch <- a.quota
+	ch <- a.period
+	ch <- a.remaining
+	ch <- a.lapsed
+}
+
+func (a *apiAccount) Collect(ch chan<- prometheus.Metric) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.limit == nil {
+		return
+	}
+
+	plan := planOf(a.limit.Zone)
+	ch <- prometheus.MustNewConstMetric(a.plan, prometheus.GaugeValue, 1, plan)
+	if planes, ok := planPlanes[plan]; ok {
+		ch <- prometheus.MustNewConstMetric(a.planes, prometheus.GaugeValue, float64(planes))
+	}
+	ch <- prometheus.MustNewConstMetric(a.quota, prometheus.GaugeValue, float64(a.limit.Limit))
+	ch <- prometheus.MustNewConstMetric(a.period, prometheus.GaugeValue, float64(a.limit.Period))
+	ch <- prometheus.MustNewConstMetric(a.remaining, prometheus.GaugeValue, float64(a.limit.Remaining))
+	var lapsed float64
+	if a.degraded {
+		lapsed = 1
+	}
+	ch <- prometheus.MustNewConstMetric(a.lapsed, prometheus.GaugeValue, lapsed)
+}
+
+// apiAccounts holds the accounts of the API keys of sites, which are tracked
+// apart from -api-key with an account label. It is an unchecked collector, as
+// accounts are added when sites are reloaded.
+type apiAccounts struct {
+	mu    sync.Mutex
+	byKey map[string]*apiAccount
+}
+
+func newAPIAccounts() *apiAccounts {
+	return &apiAccounts{byKey: map[string]*apiAccount{}}
+}
+
+// get returns the account of the API key of a site, created on first use.
+// Accounts are named by the account of the site, or its name.
+func (a *apiAccounts) get(s site) *apiAccount {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if account, ok := a.byKey[s.APIKey]; ok {
+		return account
+	}
+	name := s.Account
+	if name == "" {
+		name = s.Name
+	}
+	account := newAPIAccount(prometheus.Labels{"account": name})
+	account.name = name
+	a.byKey[s.APIKey] = account
+	return account
+}
+
+func (a *apiAccounts) Describe(ch chan<- *prometheus.Desc) {}
+
+func (a *apiAccounts) Collect(ch chan<- prometheus.Metric) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, account := range a.byKey {
+		account.Collect(ch)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newACMEManager returns the manager obtaining and renewing certificates for
+// the comma separated hosts from an ACME CA such as Let's Encrypt, the
+// production directory of Let's Encrypt if directoryURL is empty. Requests for
+// other hosts are refused, so scanners can't make it request certificates.
+func newACMEManager(hosts, cacheDir, email, directoryURL string) *autocert.Manager {
+	var whitelist []string
+	for _, host := range strings.Split(hosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			whitelist = append(whitelist, host)
+		}
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(whitelist...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      email,
+	}
+	if directoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: directoryURL}
+	}
+	return m
+}
+
+// servesTLS returns whether a listener serves HTTPS with ACME certificates.
+// Unix sockets and loopback addresses, used by local reverse proxies and the
+// healthcheck command, keep serving plain HTTP.
+func servesTLS(l net.Listener) bool {
+	tcp, ok := l.Addr().(*net.TCPAddr)
+	return ok && !tcp.IP.IsLoopback()
+}
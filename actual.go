@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// actualSource retrieves the energy produced today so far in watt hours
+type actualSource interface {
+	fetch(client *http.Client) (float64, error)
+}
+
+// promQLSource evaluates an instant query against a Prometheus server
+type promQLSource struct {
+	url   string
+	query string
+}
+
+func (p *promQLSource) fetch(client *http.Client) (float64, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(p.url, "/")+"/api/v1/query?"+url.Values{"query": {p.query}}.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	res := &struct {
+		Data struct {
+			ResultType string          `json:"resultType"`
+			Result     json.RawMessage `json:"result"`
+		} `json:"data"`
+	}{}
+	if err := getJSON(client, req, res); err != nil {
+		return 0, err
+	}
+
+	// Samples are [timestamp, "value"] pairs
+	var sample []interface{}
+	switch res.Data.ResultType {
+	case "scalar":
+		if err := json.Unmarshal(res.Data.Result, &sample); err != nil {
+			return 0, err
+		}
+	case "vector":
+		var vector []struct {
+			Value []interface{} `json:"value"`
+		}
+		if err := json.Unmarshal(res.Data.Result, &vector); err != nil {
+			return 0, err
+		}
+		if len(vector) != 1 {
+			return 0, fmt.Errorf("query returned %d series, expected 1", len(vector))
+		}
+		sample = vector[0].Value
+	default:
+		return 0, fmt.Errorf("unexpected result type %q", res.Data.ResultType)
+	}
+	if len(sample) != 2 {
+		return 0, fmt.Errorf("invalid sample %v", sample)
+	}
+	return parseNumber(sample[1])
+}
+
+// jsonSource reads a field of a JSON document, e.g. from the API of an
+// inverter
+type jsonSource struct {
+	url string
+
+	// Dot separated path to the field, numbers index arrays
+	field string
+}
+
+func (j *jsonSource) fetch(client *http.Client) (float64, error) {
+	req, err := http.NewRequest(http.MethodGet, j.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	var v interface{}
+	if err := getJSON(client, req, &v); err != nil {
+		return 0, err
+	}
+
+	for _, key := range strings.Split(j.field, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = node[key]; !ok {
+				return 0, fmt.Errorf("field %s not found", j.field)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return 0, fmt.Errorf("invalid index %q in field %s", key, j.field)
+			}
+			v = node[i]
+		default:
+			return 0, fmt.Errorf("field %s not found", j.field)
+		}
+	}
+	return parseNumber(v)
+}
+
+// parseNumber returns a JSON number or a string containing one as float
+func parseNumber(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case string:
+		return strconv.ParseFloat(n, 64)
+	}
+	return 0, fmt.Errorf("not a number: %v", v)
+}
+
+// actualProduction polls the actual production of today and keeps the last
+// value of the previous day as its total
+type actualProduction struct {
+	source   actualSource
+	interval time.Duration
+	history  *historyStore // Records the totals of completed days, optional
+	metric   *prometheus.Desc
+	final    *prometheus.Desc
+
+	mu        sync.Mutex
+	day       string
+	wh        float64
+	doneDay   string
+	doneTotal float64
+}
+
+func newActualProduction(source actualSource, interval time.Duration, history *historyStore) *actualProduction {
+	return &actualProduction{
+		source:   source,
+		interval: interval,
+		history:  history,
+		metric: prometheus.NewDesc(
+			"forecast_solar_actual_kwh",
+			"Energy actually produced today so far",
+			nil,
+			nil,
+		),
+		final: prometheus.NewDesc(
+			"forecast_solar_actual_final_kwh",
+			"Energy actually produced on the previous day, frozen at local midnight",
+			nil,
+			nil,
+		),
+	}
+}
+
+// run retrieves the production every interval until ctx is cancelled, each
+// retrieval has to complete within the interval
+func (a *actualProduction) run(ctx context.Context, client *http.Client) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		a.poll(ctx, client)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *actualProduction) poll(ctx context.Context, client *http.Client) {
+	pollCtx, cancel := context.WithTimeout(ctx, a.interval)
+	defer cancel()
+
+	wh, err := a.source.fetch(withContext(pollCtx, client))
+	if err != nil {
+		// Aborted on shutdown
+		if ctx.Err() == nil {
+			log.Printf("Error retrieving actual production: %s", err)
+		}
+		return
+	}
+	a.set(time.Now().Format(time.DateOnly), wh)
+}
+
+func (a *actualProduction) set(day string, wh float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.day != "" && a.day != day {
+		a.complete()
+	}
+	a.day, a.wh = day, wh
+}
+
+// closeDay completes the given day at its end, so its total is the last
+// value retrieved before midnight
+func (a *actualProduction) closeDay(day string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.day == day {
+		a.complete()
+		a.day, a.wh = "", 0
+	}
+}
+
+// complete records the current day as completed, the caller must hold the
+// lock
+func (a *actualProduction) complete() {
+	a.doneDay, a.doneTotal = a.day, a.wh
+	if a.history != nil {
+		if err := a.history.recordActual(a.doneDay, a.doneTotal); err != nil {
+			log.Printf("Error recording actual production: %s", err)
+		}
+	}
+}
+
+// completed returns the day and total production of the last completed day
+func (a *actualProduction) completed() (string, float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.doneDay, a.doneTotal
+}
+
+func (a *actualProduction) Describe(ch chan<- *prometheus.Desc) {
+	ch <- a.metric
+	ch <- a.final
+}
+
+func (a *actualProduction) Collect(ch chan<- prometheus.Metric) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.doneDay != "" {
+		t, _ := time.ParseInLocation(time.DateOnly, a.doneDay, time.Local)
+		ch <- prometheus.NewMetricWithTimestamp(t, prometheus.MustNewConstMetric(a.final, prometheus.GaugeValue, a.doneTotal/1000))
+	}
+	if a.day != time.Now().Format(time.DateOnly) {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(a.metric, prometheus.GaugeValue, a.wh/1000)
+}
+
+// errorCollector compares the last forecast of the previous day with the
+// energy actually produced that day
+type errorCollector struct {
+	actual   *actualProduction
+	forecast *forecastCollector
+
+	errorKwh     *prometheus.Desc
+	errorPercent *prometheus.Desc
+}
+
+func newErrorCollector(actual *actualProduction, forecast *forecastCollector) *errorCollector {
+	return &errorCollector{
+		actual:   actual,
+		forecast: forecast,
+		errorKwh: prometheus.NewDesc(
+			"forecast_solar_error_kwh",
+			"Forecast minus actual production of the previous day",
+			nil,
+			nil,
+		),
+		errorPercent: prometheus.NewDesc(
+			"forecast_solar_error_percent",
+			"Forecast error of the previous day relative to the actual production",
+			nil,
+			nil,
+		),
+	}
+}
+
+func (c *errorCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.errorKwh
+	ch <- c.errorPercent
+}
+
+func (c *errorCollector) Collect(ch chan<- prometheus.Metric) {
+	day, actual := c.actual.completed()
+	date, forecast := c.forecast.get()
+	if day == "" || date.Format(time.DateOnly) != day {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.errorKwh, prometheus.GaugeValue, (forecast-actual)/1000)
+	if actual > 0 {
+		ch <- prometheus.MustNewConstMetric(c.errorPercent, prometheus.GaugeValue, (forecast-actual)/actual*100)
+	}
+}
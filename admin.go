@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// adminGuard restricts the state changing requests of admin endpoints to
+// clients presenting the admin token and connecting from an allowed network,
+// so clients of the read API can't e.g. trigger quota consuming polls. Without
+// token and allowlist, changes are denied unless the guard is explicitly
+// unauthenticated, e.g. behind an authenticating proxy.
+type adminGuard struct {
+	token           string
+	allow           []*net.IPNet
+	unauthenticated bool
+}
+
+// newAdminGuard returns a guard for the token and the comma separated list
+// of allowed networks or addresses, accepting all clients if unauthenticated
+// and neither is given
+func newAdminGuard(token, allow string, unauthenticated bool) (*adminGuard, error) {
+	if unauthenticated && (token != "" || allow != "") {
+		return nil, fmt.Errorf("unauthenticated admin endpoints conflict with a token or allowlist")
+	}
+	g := &adminGuard{token: token, unauthenticated: unauthenticated}
+	if allow == "" {
+		return g, nil
+	}
+	for _, entry := range strings.Split(allow, ",") {
+		cidr := strings.TrimSpace(entry)
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network or address %q", entry)
+		}
+		g.allow = append(g.allow, network)
+	}
+	return g, nil
+}
+
+// configured returns whether a token or an allowlist is configured
+func (g *adminGuard) configured() bool {
+	return g.token != "" || len(g.allow) > 0
+}
+
+// allowed returns whether the request passes all configured checks
+func (g *adminGuard) allowed(r *http.Request) bool {
+	if !g.configured() {
+		return g.unauthenticated
+	}
+	if g.token != "" {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		// Browsers send the token as password of basic authentication
+		if !found {
+			_, token, found = r.BasicAuth()
+		}
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(g.token)) != 1 {
+			return false
+		}
+	}
+	if len(g.allow) > 0 {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return false
+		}
+		ip := net.ParseIP(host)
+		for _, network := range g.allow {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// changes returns whether a request may change state. Changes of other sites
+// are denied, as browsers send the credentials of basic authentication along
+// with e.g. their forms.
+func changes(r *http.Request) bool {
+	return r.Method != http.MethodGet && r.Method != http.MethodHead
+}
+
+// wrap protects all but GET and HEAD requests of the handler, which only
+// report state
+func (g *adminGuard) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if changes(r) && (!sameOrigin(r) || !g.allowed(r)) {
+			log.Printf("Denied %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// require protects all requests of the handler including GET, e.g. of
+// profiles revealing the command line
+func (g *adminGuard) require(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if changes(r) && !sameOrigin(r) {
+			log.Printf("Denied %s %s from %s of origin %s", r.Method, r.URL.Path, r.RemoteAddr, r.Header.Get("Origin"))
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if !g.allowed(r) {
+			log.Printf("Denied %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Basic realm="forecast_solar_exporter"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// readOnlyHandler reports the read-only mode on GET and changes it on POST or
+// PUT with the "enabled" parameter, e.g. POST /-/read-only?enabled=true.
+// Changes are recorded in the audit log if given.
+func readOnlyHandler(readOnly *atomic.Bool, audit *auditLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost, http.MethodPut:
+			enabled, err := strconv.ParseBool(r.FormValue("enabled"))
+			if err != nil {
+				http.Error(w, "Parameter enabled must be true or false", http.StatusBadRequest)
+				return
+			}
+			if readOnly.Swap(enabled) != enabled {
+				log.Printf("Read-only mode set to %t by %s", enabled, r.RemoteAddr)
+				if audit != nil {
+					if err := audit.record(r, "read-only", strconv.FormatBool(enabled)); err != nil {
+						log.Printf("Error writing audit log: %s", err)
+					}
+				}
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST, PUT")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		fmt.Fprintf(w, "read-only: %t\n", readOnly.Load())
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testAdminRequest returns a request of method from addr presenting the
+// token as given by auth, "bearer", "basic" or "" for none
+func testAdminRequest(method, addr, auth, token string) *http.Request {
+	r := httptest.NewRequest(method, "/-/read-only?enabled=true", nil)
+	r.RemoteAddr = addr
+	switch auth {
+	case "bearer":
+		r.Header.Set("Authorization", "Bearer "+token)
+	case "basic":
+		r.SetBasicAuth("admin", token)
+	}
+	return r
+}
+
+func TestAdminGuard(t *testing.T) {
+	type guardConfig struct {
+		token, allow    string
+		unauthenticated bool
+	}
+	guards := map[string]guardConfig{
+		"token":           {token: "secret"},
+		"allowlist":       {allow: "192.168.1.0/24"},
+		"token+allowlist": {token: "secret", allow: "192.168.1.0/24"},
+		"unauthenticated": {unauthenticated: true},
+		"none":            {},
+	}
+	tests := []struct {
+		guard string
+		auth  string
+		token string
+		addr  string
+		// Status of a change via wrap and of any request via require
+		change, require int
+	}{
+		{"token", "bearer", "secret", "203.0.113.1:1234", http.StatusOK, http.StatusOK},
+		{"token", "basic", "secret", "203.0.113.1:1234", http.StatusOK, http.StatusOK},
+		{"token", "bearer", "wrong", "203.0.113.1:1234", http.StatusForbidden, http.StatusUnauthorized},
+		{"token", "basic", "wrong", "203.0.113.1:1234", http.StatusForbidden, http.StatusUnauthorized},
+		{"token", "", "", "127.0.0.1:1234", http.StatusForbidden, http.StatusUnauthorized},
+
+		{"allowlist", "", "", "192.168.1.10:1234", http.StatusOK, http.StatusOK},
+		{"allowlist", "", "", "203.0.113.1:1234", http.StatusForbidden, http.StatusUnauthorized},
+		{"allowlist", "bearer", "secret", "127.0.0.1:1234", http.StatusForbidden, http.StatusUnauthorized},
+
+		{"token+allowlist", "bearer", "secret", "192.168.1.10:1234", http.StatusOK, http.StatusOK},
+		{"token+allowlist", "bearer", "secret", "203.0.113.1:1234", http.StatusForbidden, http.StatusUnauthorized},
+		{"token+allowlist", "", "", "192.168.1.10:1234", http.StatusForbidden, http.StatusUnauthorized},
+
+		{"unauthenticated", "", "", "203.0.113.1:1234", http.StatusOK, http.StatusOK},
+		{"unauthenticated", "bearer", "anything", "203.0.113.1:1234", http.StatusOK, http.StatusOK},
+
+		// Loopback is not trusted, a reverse proxy makes all clients look
+		// like it
+		{"none", "", "", "127.0.0.1:1234", http.StatusForbidden, http.StatusUnauthorized},
+		{"none", "", "", "[::1]:1234", http.StatusForbidden, http.StatusUnauthorized},
+		{"none", "bearer", "secret", "127.0.0.1:1234", http.StatusForbidden, http.StatusUnauthorized},
+		{"none", "basic", "secret", "203.0.113.1:1234", http.StatusForbidden, http.StatusUnauthorized},
+		// Unix socket listeners have no address
+		{"none", "", "", "@", http.StatusForbidden, http.StatusUnauthorized},
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, tt := range tests {
+		t.Run(tt.guard+"/"+tt.auth+"/"+tt.addr, func(t *testing.T) {
+			c := guards[tt.guard]
+			g, err := newAdminGuard(c.token, c.allow, c.unauthenticated)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			g.wrap(ok).ServeHTTP(w, testAdminRequest(http.MethodPost, tt.addr, tt.auth, tt.token))
+			if w.Code != tt.change {
+				t.Errorf("got status %d for a change, want %d", w.Code, tt.change)
+			}
+			// Reading the state is always allowed
+			w = httptest.NewRecorder()
+			g.wrap(ok).ServeHTTP(w, testAdminRequest(http.MethodGet, tt.addr, tt.auth, tt.token))
+			if w.Code != http.StatusOK {
+				t.Errorf("got status %d for reading, want 200", w.Code)
+			}
+			w = httptest.NewRecorder()
+			g.require(ok).ServeHTTP(w, testAdminRequest(http.MethodGet, tt.addr, tt.auth, tt.token))
+			if w.Code != tt.require {
+				t.Errorf("got status %d for a required token, want %d", w.Code, tt.require)
+			}
+		})
+	}
+}
+
+func TestAdminGuardConflicts(t *testing.T) {
+	if _, err := newAdminGuard("secret", "", true); err == nil {
+		t.Error("got no error for an unauthenticated guard with a token")
+	}
+	if _, err := newAdminGuard("", "127.0.0.1", true); err == nil {
+		t.Error("got no error for an unauthenticated guard with an allowlist")
+	}
+	if _, err := newAdminGuard("", "not-an-address", false); err == nil {
+		t.Error("got no error for an invalid allowlist")
+	}
+}
+
+// Browsers send cached credentials of basic authentication along with forms of
+// other sites, which must not change state
+func TestAdminGuardOrigin(t *testing.T) {
+	g, err := newAdminGuard("secret", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		origin string
+		want   int
+	}{
+		{"", http.StatusOK},
+		{"http://example.com", http.StatusOK},
+		{"https://evil.example.org", http.StatusForbidden},
+		{"null", http.StatusForbidden},
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, tt := range tests {
+		for name, h := range map[string]http.Handler{"wrap": g.wrap(ok), "require": g.require(ok)} {
+			r := testAdminRequest(http.MethodPost, "203.0.113.1:1234", "basic", "secret")
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("%s: got status %d for origin %q, want %d", name, w.Code, tt.origin, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// aheadCollector exports the forecasted power 1 to N hours from now,
+// interpolated from the power curve on every scrape, which gives a view of
+// the future without samples timestamped in the future
+type aheadCollector struct {
+	source *source
+	hours  int
+	metric *prometheus.Desc
+}
+
+func newAheadCollector(s *source, hours int) *aheadCollector {
+	return &aheadCollector{
+		source: s,
+		hours:  hours,
+		metric: prometheus.NewDesc(
+			"forecast_solar_power_watts_ahead",
+			"Forecasted power the given number of hours from now",
+			[]string{"hours"},
+			nil,
+		),
+	}
+}
+
+func (c *aheadCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.metric
+}
+
+func (c *aheadCollector) Collect(ch chan<- prometheus.Metric) {
+	res := c.source.forecast.Load()
+	if res == nil {
+		return
+	}
+	curve := newPowerCurve(res.Result.Watts, res.location())
+	now := clock()
+	for h := 1; h <= c.hours; h++ {
+		if w, ok := curve.at(now.Add(time.Duration(h) * time.Hour)); ok {
+			ch <- prometheus.MustNewConstMetric(c.metric, prometheus.GaugeValue, w, strconv.Itoa(h))
+		}
+	}
+}
+
+type powerPoint struct {
+	time  time.Time
+	watts float64
+}
+
+// powerCurve is the forecasted power in chronological order
+type powerCurve []powerPoint
+
+// newPowerCurve sorts the forecasted power by time. Times are in the local
+// time of the plant at loc.
+func newPowerCurve(watts map[string]float64, loc *time.Location) powerCurve {
+	curve := make(powerCurve, 0, len(watts))
+	for period, w := range watts {
+		t, err := time.ParseInLocation(time.DateTime, period, loc)
+		if err != nil {
+			continue
+		}
+		curve = append(curve, powerPoint{t, w})
+	}
+	sort.Slice(curve, func(i, j int) bool { return curve[i].time.Before(curve[j].time) })
+	return curve
+}
+
+// at interpolates the power linearly at t. Before the first point, which is
+// sunrise of today, no power is expected. It returns false after the last
+// point, beyond the forecast.
+func (c powerCurve) at(t time.Time) (float64, bool) {
+	i := sort.Search(len(c), func(i int) bool { return c[i].time.After(t) })
+	switch {
+	case len(c) == 0 || t.After(c[len(c)-1].time):
+		return 0, false
+	case i == 0:
+		return 0, true
+	case i == len(c):
+		return c[i-1].watts, true
+	}
+	prev, next := c[i-1], c[i]
+	fraction := float64(t.Sub(prev.time)) / float64(next.time.Sub(prev.time))
+	return prev.watts + (next.watts-prev.watts)*fraction, true
+}
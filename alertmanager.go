@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Interval between checks of the forecasts for silences, which is cheap as
+// silences are only created once per site and day
+const silenceCheckInterval = time.Minute
+
+// alertSilencer creates Alertmanager silences for low production alerts on
+// days the forecast is below a threshold, as low production is expected then
+type alertSilencer struct {
+	client    *http.Client
+	url       string
+	matchers  map[string]string
+	threshold float64 // kWh
+	sources   *sourceSet
+
+	silenced map[string]string // Day silenced by site
+}
+
+type silenceMatcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	IsEqual bool   `json:"isEqual"`
+}
+
+type silence struct {
+	Matchers  []silenceMatcher `json:"matchers"`
+	StartsAt  time.Time        `json:"startsAt"`
+	EndsAt    time.Time        `json:"endsAt"`
+	CreatedBy string           `json:"createdBy"`
+	Comment   string           `json:"comment"`
+}
+
+func newAlertSilencer(client *http.Client, url string, matchers map[string]string, threshold float64, sources *sourceSet) *alertSilencer {
+	return &alertSilencer{
+		client:    client,
+		url:       strings.TrimSuffix(url, "/") + "/api/v2/silences",
+		matchers:  matchers,
+		threshold: threshold,
+		sources:   sources,
+		silenced:  map[string]string{},
+	}
+}
+
+// run checks the forecasts of today until ctx is cancelled
+func (a *alertSilencer) run(ctx context.Context) {
+	for {
+		a.check(clock())
+		if !sleep(ctx, silenceCheckInterval) {
+			return
+		}
+	}
+}
+
+// check silences the alerts of each site whose forecast for today, summed
+// over its planes, is below the threshold, once per day. In fleet mode, the
+// silences match the site.
+func (a *alertSilencer) check(now time.Time) {
+	today := now.Format(time.DateOnly)
+	for _, t := range siteTotals(a.sources.all()) {
+		name := t.provider
+		if t.site != "" {
+			name += "/" + t.site
+		}
+		if t.date.Format(time.DateOnly) != today || t.wh/1000 >= a.threshold || a.silenced[t.site] == today {
+			continue
+		}
+
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+		sil := silence{
+			StartsAt:  now,
+			EndsAt:    midnight,
+			CreatedBy: "forecast_solar_exporter",
+			Comment:   fmt.Sprintf("Low production expected, the forecast of %s for today is %.1f kWh", name, t.wh/1000),
+		}
+		for _, name := range sortedKeys(a.matchers) {
+			sil.Matchers = append(sil.Matchers, silenceMatcher{Name: name, Value: a.matchers[name], IsEqual: true})
+		}
+		if t.site != "" {
+			sil.Matchers = append(sil.Matchers, silenceMatcher{Name: "site", Value: t.site, IsEqual: true})
+		}
+		sort.Slice(sil.Matchers, func(i, j int) bool { return sil.Matchers[i].Name < sil.Matchers[j].Name })
+
+		id, err := a.create(sil)
+		if err != nil {
+			log.Printf("Error creating Alertmanager silence for %s: %s", name, err)
+			continue
+		}
+		a.silenced[t.site] = today
+		log.Printf("Created Alertmanager silence %s until midnight, the forecast of %s for today is %.1f kWh", id, name, t.wh/1000)
+	}
+}
+
+// create creates a silence, returning its ID
+func (a *alertSilencer) create(sil silence) (string, error) {
+	body, err := json.Marshal(sil)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	r, err := doRequest(a.client, req)
+	if err != nil {
+		return "", err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(r.Body, 1024))
+		return "", fmt.Errorf("unexpected status %s: %s", r.Status, bytes.TrimSpace(msg))
+	}
+
+	var res struct {
+		SilenceID string `json:"silenceID"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+		return "", fmt.Errorf("error decoding JSON: %s", err)
+	}
+	return res.SilenceID, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+const degrees = math.Pi / 180
+
+// solarPosition returns the elevation and azimuth of the sun in degrees at
+// the given time and location, using the NOAA approximation. The azimuth
+// follows the API convention, South = 0, West = 90, East = -90.
+func solarPosition(t time.Time, latitude, longitude float64) (elevation, azimuth float64) {
+	t = t.UTC()
+	hours := float64(t.Hour()) + float64(t.Minute())/60 + float64(t.Second())/3600
+	eqTime, decl := sunParameters(t)
+
+	// Hour angle in radians, negative before solar noon
+	solarTime := hours*60 + eqTime + 4*longitude
+	ha := (solarTime/4 - 180) * degrees
+
+	lat := latitude * degrees
+	cosZenith := math.Sin(lat)*math.Sin(decl) + math.Cos(lat)*math.Cos(decl)*math.Cos(ha)
+	zenith := math.Acos(math.Max(-1, math.Min(1, cosZenith)))
+
+	az := math.Atan2(math.Sin(ha), math.Cos(ha)*math.Sin(lat)-math.Tan(decl)*math.Cos(lat))
+	return 90 - zenith/degrees, az / degrees
+}
+
+// sunParameters returns the equation of time in minutes and the declination
+// of the sun in radians at t
+func sunParameters(t time.Time) (eqTime, decl float64) {
+	t = t.UTC()
+	hours := float64(t.Hour()) + float64(t.Minute())/60 + float64(t.Second())/3600
+
+	// Fractional year in radians
+	g := 2 * math.Pi / 365 * (float64(t.YearDay()-1) + (hours-12)/24)
+
+	eqTime = 229.18 * (0.000075 + 0.001868*math.Cos(g) - 0.032077*math.Sin(g) -
+		0.014615*math.Cos(2*g) - 0.040849*math.Sin(2*g))
+	decl = 0.006918 - 0.399912*math.Cos(g) + 0.070257*math.Sin(g) -
+		0.006758*math.Cos(2*g) + 0.000907*math.Sin(2*g) -
+		0.002697*math.Cos(3*g) + 0.00148*math.Sin(3*g)
+	return eqTime, decl
+}
+
+// sunEvents returns solar noon, sunrise and sunset of the UTC day of t at the
+// given location, using the NOAA approximation. Without sunrise and sunset
+// due to polar day or night, polar is true and sunrise and sunset are zero.
+func sunEvents(t time.Time, latitude, longitude float64) (noon, sunrise, sunset time.Time, polar bool) {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	eqTime, decl := sunParameters(day.Add(12 * time.Hour))
+
+	minutes := func(m float64) time.Time {
+		return day.Add(time.Duration(m * float64(time.Minute)))
+	}
+	noonMinutes := 720 - 4*longitude - eqTime
+	noon = minutes(noonMinutes)
+
+	// Hour angle of sunrise, with the zenith corrected for refraction and the
+	// size of the sun
+	lat := latitude * degrees
+	cosHA := math.Cos(90.833*degrees)/(math.Cos(lat)*math.Cos(decl)) - math.Tan(lat)*math.Tan(decl)
+	if cosHA < -1 || cosHA > 1 {
+		return noon, time.Time{}, time.Time{}, true
+	}
+	ha := math.Acos(cosHA) / degrees
+	return noon, minutes(noonMinutes - 4*ha), minutes(noonMinutes + 4*ha), false
+}
+
+// clearSkyIrradiance returns the plane-of-array irradiance in W/m² on a plane
+// with the given declination and azimuth under a clear sky, using the Meinel
+// air mass model and an isotropic sky
+func clearSkyIrradiance(elevation, azimuth, declination, planeAzimuth float64) float64 {
+	if elevation <= 0 {
+		return 0
+	}
+
+	zenith := (90 - elevation) * degrees
+	airMass := 1 / math.Cos(zenith)
+	direct := 1353 * math.Pow(0.7, math.Pow(airMass, 0.678))
+	diffuse := 0.1 * direct
+	global := direct*math.Cos(zenith) + diffuse
+
+	tilt := declination * degrees
+	cosIncidence := math.Cos(zenith)*math.Cos(tilt) +
+		math.Sin(zenith)*math.Sin(tilt)*math.Cos((azimuth-planeAzimuth)*degrees)
+
+	const albedo = 0.2
+	return math.Max(0, direct*cosIncidence) +
+		diffuse*(1+math.Cos(tilt))/2 +
+		albedo*global*(1-math.Cos(tilt))/2
+}
+
+// clearSkyIrradiation returns the plane-of-array irradiation in Wh/m² under a
+// clear sky for the day of t in its location
+func clearSkyIrradiation(t time.Time, latitude, longitude, declination, planeAzimuth float64) float64 {
+	const step = 10 * time.Minute
+
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	end := start.AddDate(0, 0, 1)
+	var wh float64
+	for ts := start.Add(step / 2); ts.Before(end); ts = ts.Add(step) {
+		elevation, azimuth := solarPosition(ts, latitude, longitude)
+		wh += clearSkyIrradiance(elevation, azimuth, declination, planeAzimuth) * step.Hours()
+	}
+	return wh
+}
+
+// planeGeometry is the location and orientation of a plane as numbers, for
+// computations done locally
+type planeGeometry struct {
+	latitude, longitude float64
+	declination         float64
+	azimuth             float64 // API convention, South = 0
+}
+
+func parsePlaneGeometry(latitude, longitude, declination, azimuth string) (planeGeometry, error) {
+	var g planeGeometry
+	for _, v := range []struct {
+		name  string
+		value string
+		dst   *float64
+	}{
+		{"latitude", latitude, &g.latitude},
+		{"longitude", longitude, &g.longitude},
+		{"declination", declination, &g.declination},
+		{"azimuth", azimuth, &g.azimuth},
+	} {
+		f, err := strconv.ParseFloat(v.value, 64)
+		if err != nil {
+			return g, fmt.Errorf("invalid %s %q: %s", v.name, v.value, err)
+		}
+		*v.dst = f
+	}
+	return g, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Number of audit log entries kept in memory and served by /api/v1/audit
+const auditLogEntries = 1000
+
+// auditEntry is an admin action
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Detail string    `json:"detail,omitempty"`
+	Remote string    `json:"remote,omitempty"` // Client IP, empty for automatic actions
+}
+
+// auditLog appends admin actions to a JSON lines file, keeping the latest
+// entries in memory
+type auditLog struct {
+	mu      sync.Mutex
+	file    *os.File
+	entries []auditEntry
+}
+
+// openAuditLog opens the audit log at path for appending, loading the latest
+// entries
+func openAuditLog(path string) (*auditLog, error) {
+	a := &auditLog{}
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var e auditEntry
+			if json.Unmarshal(scanner.Bytes(), &e) == nil {
+				a.append(e)
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	a.file = f
+	return a, nil
+}
+
+// append adds an entry to the in-memory log, the caller must hold the lock
+// unless the log is not shared yet
+func (a *auditLog) append(e auditEntry) {
+	a.entries = append(a.entries, e)
+	if len(a.entries) > auditLogEntries {
+		a.entries = a.entries[len(a.entries)-auditLogEntries:]
+	}
+}
+
+// record logs an action, triggered by the request r or automatically if nil
+func (a *auditLog) record(r *http.Request, action, detail string) error {
+	e := auditEntry{Time: time.Now(), Action: action, Detail: detail}
+	if r != nil {
+		e.Remote = r.RemoteAddr
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			e.Remote = host
+		}
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.append(e)
+	_, err = a.file.Write(append(line, '\n'))
+	return err
+}
+
+// ServeHTTP returns the latest entries as JSON, newest first, limited by the
+// limit parameter
+func (a *auditLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if l := r.FormValue("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 {
+			http.Error(w, "Parameter limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	a.mu.Lock()
+	if limit > len(a.entries) {
+		limit = len(a.entries)
+	}
+	entries := make([]auditEntry, 0, limit)
+	for i := len(a.entries) - 1; i >= 0 && len(entries) < limit; i-- {
+		entries = append(entries, a.entries[i])
+	}
+	a.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Samples per remote write request of the backfill
+const backfillBatchSize = 1000
+
+// backfillSample is a sample of the backfill, the labels include the metric
+// name
+type backfillSample struct {
+	labels map[string]string
+	key    string // Series with sorted labels, to keep its samples together
+	time   time.Time
+	value  float64
+}
+
+// backfill implements the backfill subcommand, pushing the daily forecasts of
+// the history store, and of the history endpoint of paid plans, via remote
+// write with the timestamps they were exported with, e.g. to restore the
+// series lost in a migration of the TSDB
+func backfill(args []string) {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	var (
+		historyFile = fs.String("history-file", "", "History file to push the daily forecasts of")
+		writeURL    = fs.String("remote-write.url", "", "Prometheus remote write endpoint to push the forecasts to")
+		writeToken  = fs.String("remote-write.bearer-token", "", "Bearer token for the remote write endpoint")
+		writeUser   = fs.String("remote-write.username", "", "Basic auth username for the remote write endpoint")
+		writePass   = fs.String("remote-write.password", "", "Basic auth password for the remote write endpoint")
+		from        = fs.String("from", "", "First day to push, e.g. 2026-09-01, all days if empty")
+		to          = fs.String("to", "", "Last day to push, up to today if empty")
+		prefix      = fs.String("metric-prefix", defaultMetricPrefix, "Prefix of the pushed metrics")
+		apiKey      = fs.String("api-key", "", "API key for forecast.solar paid plans, to push the days of the history endpoint the history file lacks")
+		apiURL      = fs.String("api-url", "https://api.forecast.solar", "Base URL of the forecast.solar API")
+		latitude    = fs.String("latitude", "54.9", "Latitude of your location, for the history endpoint")
+		longitude   = fs.String("longitude", "25.3", "Longitude of your location, for the history endpoint")
+		declination = fs.String("declination", "45", "Solar plane declination, for the history endpoint")
+		az          = fs.String("az", "0", "Solar plane azimuth in the API convention, for the history endpoint")
+		kwp         = fs.String("kWp", "10", "Solar plane max. peak power in kilo watt, for the history endpoint")
+		source      = fs.String("source", "forecast.solar", "Source in the history file to add the days of the history endpoint to, e.g. forecast.solar/home/east in fleet mode")
+		apiTimeout  = fs.Int("api-timeout", 30, "Timeout of requests to the APIs in seconds")
+		proxyURL    = fs.String("proxy-url", "", "Proxy for requests to the APIs, e.g. http://proxy:3128, overrides HTTP_PROXY, HTTPS_PROXY and NO_PROXY")
+		apiCAFile   = fs.String("api.tls.ca-file", "", "PEM bundle of CAs to trust for requests to the APIs in addition to the system roots")
+		apiCertFile = fs.String("api.tls.cert-file", "", "PEM client certificate for requests to the APIs")
+		apiKeyFile  = fs.String("api.tls.key-file", "", "PEM key of the client certificate")
+		apiInsecure = fs.Bool("api.tls.insecure-skip-verify", false, "Skip verifying the certificates of the APIs, insecure")
+		dryRun      = fs.Bool("dry-run", false, "Print the samples instead of pushing them")
+	)
+	labels := keyValueFlag{}
+	fs.Var(labels, "label", "Label key=value to add to all pushed metrics, can be repeated")
+	fs.Parse(args)
+
+	if *historyFile == "" && *apiKey == "" {
+		log.Fatal("-history-file or -api-key is required")
+	}
+	if *writeURL == "" && !*dryRun {
+		log.Fatal("-remote-write.url is required")
+	}
+	if _, err := parseConstLabels(labels); err != nil {
+		log.Fatalf("Error parsing labels: %s", err)
+	}
+	if *to == "" {
+		*to = time.Now().Format(time.DateOnly)
+	}
+	for _, day := range []string{*from, *to} {
+		if _, err := time.Parse(time.DateOnly, day); day != "" && err != nil {
+			log.Fatalf("Invalid day %q, expected e.g. 2026-09-01", day)
+		}
+	}
+
+	// The history file is only read, days of the history endpoint are added
+	// in memory
+	h, err := openHistoryReadOnly(*historyFile)
+	if err != nil {
+		log.Fatalf("Error opening history: %s", err)
+	}
+	transport, err := newAPITransport(*proxyURL, *apiCAFile, *apiCertFile, *apiKeyFile, *apiInsecure)
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+	client := newAPIClient(transport, time.Duration(*apiTimeout)*time.Second)
+	if *apiKey != "" {
+		url := fmt.Sprintf("%s/%s/history/%s/%s/%s/%s/%s", strings.TrimSuffix(*apiURL, "/"), *apiKey, *latitude, *longitude, *declination, *az, *kwp)
+		res, err := (&forecastSolar{url: url}).fetch(client)
+		if err != nil {
+			log.Fatalf("Error retrieving forecast history: %s", err)
+		}
+		added, err := h.backfill(*source, res.Result.WattHoursDay)
+		if err != nil {
+			log.Fatalf("Error adding forecast history: %s", err)
+		}
+		log.Printf("Retrieved %d days of the history endpoint missing in the history file", added)
+	}
+
+	samples := h.backfillSamples(*from, *to, *prefix, labels)
+	if len(samples) == 0 {
+		log.Fatal("No forecasts to push")
+	}
+	if *dryRun {
+		for _, s := range samples {
+			fmt.Printf("%s %g %d\n", s.key, s.value, s.time.UnixMilli())
+		}
+		return
+	}
+
+	w := &remoteWriter{
+		client:      client,
+		url:         *writeURL,
+		bearerToken: *writeToken,
+		username:    *writeUser,
+		password:    *writePass,
+	}
+	for i := 0; i < len(samples); i += backfillBatchSize {
+		end := i + backfillBatchSize
+		if end > len(samples) {
+			end = len(samples)
+		}
+		var body []byte
+		for _, s := range samples[i:end] {
+			body = protowire.AppendTag(body, 1, protowire.BytesType)
+			body = protowire.AppendBytes(body, encodeTimeSeries(s.labels, s.value, s.time.UnixMilli()))
+		}
+		if err := w.send(context.Background(), body); err != nil {
+			log.Fatalf("Error pushing forecasts, %d of %d samples pushed: %s", i, len(samples), err)
+		}
+	}
+	log.Printf("Pushed %d samples from %s to %s", len(samples), samples[0].time.Format(time.DateOnly), samples[len(samples)-1].time.Format(time.DateOnly))
+}
+
+// backfillSamples returns the daily forecasts between from and to as the
+// samples of the today and tomorrow metrics, timestamped with their day like
+// the exported ones, sorted by series and time. The forecast for today is the
+// latest of the day, the forecast for tomorrow the latest issued the day
+// before.
+func (h *historyStore) backfillSamples(from, to, prefix string, extra map[string]string) []backfillSample {
+	var samples []backfillSample
+	add := func(source, metric, day string, wh float64) {
+		t, err := time.Parse(time.DateOnly, day)
+		if err != nil {
+			return
+		}
+		labels := map[string]string{"__name__": prefix + "_" + metric}
+		for k, v := range extra {
+			labels[k] = v
+		}
+		// Sources are named provider, or provider/site/plane in fleet mode
+		parts := strings.SplitN(source, "/", 3)
+		for i, name := range []string{"provider", "site", "plane"} {
+			if i < len(parts) {
+				labels[name] = parts[i]
+			}
+		}
+		pairs := make([]string, 0, len(labels))
+		for _, k := range sortedKeys(labels) {
+			if k != "__name__" {
+				pairs = append(pairs, fmt.Sprintf("%s=%q", k, labels[k]))
+			}
+		}
+		key := labels["__name__"] + "{" + strings.Join(pairs, ",") + "}"
+		samples = append(samples, backfillSample{labels: labels, key: key, time: t, value: wh})
+	}
+
+	for source, days := range h.latest(from, to) {
+		for day, wh := range days {
+			add(source, "today", day, wh)
+		}
+	}
+
+	h.mu.Lock()
+	for source, forecasts := range h.Sources {
+		for issued, forecast := range forecasts {
+			t, err := time.Parse(time.DateOnly, issued)
+			if err != nil {
+				continue
+			}
+			day := t.AddDate(0, 0, 1).Format(time.DateOnly)
+			if wh, ok := forecast[day]; ok && day >= from && day <= to {
+				add(source, "tomorrow", day, wh)
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].key != samples[j].key {
+			return samples[i].key < samples[j].key
+		}
+		return samples[i].time.Before(samples[j].time)
+	})
+	return samples
+}
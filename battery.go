@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type pvgisResponse struct {
+	Outputs struct {
+		Hourly []struct {
+			Time string  `json:"time"`
+			P    float64 `json:"P"`
+		} `json:"hourly"`
+	} `json:"outputs"`
+}
+
+// fetchPVGIS retrieves the hourly production of a plane in a past year from
+// the PVGIS climatology, in watt hours by local time
+func fetchPVGIS(client *http.Client, latitude, longitude, declination, az, kwp string, year int) (map[time.Time]float64, error) {
+	query := url.Values{}
+	query.Set("lat", latitude)
+	query.Set("lon", longitude)
+	query.Set("angle", declination)
+	query.Set("aspect", az)
+	query.Set("peakpower", kwp)
+	query.Set("loss", "14")
+	query.Set("pvcalculation", "1")
+	query.Set("startyear", strconv.Itoa(year))
+	query.Set("endyear", strconv.Itoa(year))
+	query.Set("outputformat", "json")
+
+	req, err := http.NewRequest(http.MethodGet, "https://re.jrc.ec.europa.eu/api/v5_2/seriescalc?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res := &pvgisResponse{}
+	if err := getJSON(client, req, res); err != nil {
+		return nil, err
+	}
+
+	production := make(map[time.Time]float64, len(res.Outputs.Hourly))
+	for _, h := range res.Outputs.Hourly {
+		t, err := time.Parse("20060102:1504", h.Time)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time %q: %s", h.Time, err)
+		}
+		// Average power over the hour equals the watt hours of that hour
+		production[t.Truncate(time.Hour).In(time.Local)] = h.P
+	}
+	return production, nil
+}
+
+// sizeBattery implements the size-battery subcommand, simulating a year of
+// production with different battery capacities
+func sizeBattery(args []string) {
+	fs := flag.NewFlagSet("size-battery", flag.ExitOnError)
+	var (
+		latitude    = fs.String("latitude", "54.9", "Latitude of your location")
+		longitude   = fs.String("longitude", "25.3", "Longitude of your location")
+		declination = fs.String("declination", "45", "Solar plane declination, 0 = horizontal, 90 = vertical")
+		az          = fs.String("az", "0", "Solar plane azimuth, West = 90, South = 0, East = -90")
+		kwp         = fs.String("kWp", "10", "Solar plane max. peak power in kilo watt")
+		year        = fs.Int("year", 2020, "Year of the PVGIS climatology to simulate")
+		loadProfile = fs.String("load-profile", "400", "Household load in watts, either constant or per hour range like 0-7=200,7-22=450,22-24=200")
+		exportLimit = fs.Float64("export-limit", -1, "Grid export limit in watts, -1 for no limit")
+		capacities  = fs.String("capacities", "0,2.5,5,7.5,10,15", "Comma separated battery capacities in kWh to simulate")
+	)
+	fs.Parse(args)
+
+	load, err := parseLoadProfile(*loadProfile)
+	if err != nil {
+		log.Fatalf("Error parsing load profile: %s", err)
+	}
+	limit := *exportLimit
+	if limit < 0 {
+		limit = math.Inf(1)
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	hourly, err := fetchPVGIS(client, *latitude, *longitude, *declination, *az, *kwp, *year)
+	if err != nil {
+		log.Fatalf("Error retrieving PVGIS data: %s", err)
+	}
+	if len(hourly) == 0 {
+		log.Fatal("Error: PVGIS returned no data")
+	}
+
+	// Order the year chronologically and apply the daily load profile
+	start := time.Date(*year, 1, 1, 0, 0, 0, 0, time.Local)
+	end := start.AddDate(1, 0, 0)
+	var production, demand []float64
+	for t := start; t.Before(end); t = t.Add(time.Hour) {
+		production = append(production, hourly[t])
+		demand = append(demand, load[t.Hour()])
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Capacity\tAutarky\tSelf-consumption\tImport\tExport\tCurtailed\t")
+	for _, c := range strings.Split(*capacities, ",") {
+		capacity, err := strconv.ParseFloat(strings.TrimSpace(c), 64)
+		if err != nil {
+			log.Fatalf("Invalid capacity %q: %s", c, err)
+		}
+
+		f := simulate(production, demand, capacity*1000, limit)
+		fmt.Fprintf(w, "%.1f kWh\t%.1f%%\t%.1f%%\t%.0f kWh\t%.0f kWh\t%.0f kWh\t\n",
+			capacity, f.Autarky()*100, f.SelfConsumption()*100, f.Import/1000, f.Export/1000, f.Curtailed/1000)
+	}
+	w.Flush()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// benchmark implements the bench subcommand, measuring how long gathering
+// and encoding the metrics of a synthetic fleet takes
+func benchmark(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	var (
+		sites      = fs.Int("sites", 500, "Number of synthetic sites")
+		hours      = fs.Int("hours", 48, "Hours of forecast per site, at most 48 (today and tomorrow)")
+		iterations = fs.Int("iterations", 20, "Number of scrapes to measure")
+		polled     = fs.Int("polled", 10, "Number of sites polled between scrapes")
+		reuse      = fs.Bool("reuse", true, "Reuse the gathered metrics of sites not polled since the last scrape, as fleet mode does")
+	)
+	fs.Parse(args)
+
+	if *polled < 0 || *polled > *sites {
+		log.Fatal("-polled must be between 0 and -sites")
+	}
+	if *sites < 1 || *iterations < 1 {
+		log.Fatal("-sites and -iterations must be at least 1")
+	}
+	if *hours < 1 || *hours > 48 {
+		log.Fatal("-hours must be between 1 and 48")
+	}
+
+	g, sources, res, err := newBenchFleet(*sites, *hours, *reuse)
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+
+	var gatherTime, encodeTime time.Duration
+	var mallocs, bytes uint64
+	var series, size int
+	var before, after runtime.MemStats
+	for i := 0; i < *iterations; i++ {
+		for j := 0; j < *polled; j++ {
+			if err := sources[(i**polled+j)%*sites].update(res); err != nil {
+				log.Fatalf("Error updating synthetic forecast: %s", err)
+			}
+		}
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+
+		start := time.Now()
+		families, err := g.Gather()
+		if err != nil {
+			log.Fatalf("Error gathering metrics: %s", err)
+		}
+		gathered := time.Now()
+
+		w := &countingWriter{w: io.Discard}
+		enc := expfmt.NewEncoder(w, expfmt.FmtText)
+		series = 0
+		for _, mf := range families {
+			series += len(mf.Metric)
+			if err := enc.Encode(mf); err != nil {
+				log.Fatalf("Error encoding metrics: %s", err)
+			}
+		}
+		encodeTime += time.Since(gathered)
+		gatherTime += gathered.Sub(start)
+		size = w.n
+
+		runtime.ReadMemStats(&after)
+		mallocs += after.Mallocs - before.Mallocs
+		bytes += after.TotalAlloc - before.TotalAlloc
+	}
+
+	n := time.Duration(*iterations)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Sites\t%d\n", *sites)
+	fmt.Fprintf(tw, "Series\t%d\n", series)
+	fmt.Fprintf(tw, "Exposition size\t%d bytes\n", size)
+	fmt.Fprintf(tw, "Gather\t%s\n", (gatherTime / n).Round(time.Microsecond))
+	fmt.Fprintf(tw, "Encode\t%s\n", (encodeTime / n).Round(time.Microsecond))
+	fmt.Fprintf(tw, "Scrape\t%s\n", ((gatherTime + encodeTime) / n).Round(time.Microsecond))
+	fmt.Fprintf(tw, "Allocations per scrape\t%d (%d bytes)\n", mallocs/uint64(*iterations), bytes/uint64(*iterations))
+	tw.Flush()
+}
+
+// newBenchFleet returns the gatherer of a synthetic fleet of forecast.solar
+// sites, gathered once, its sources and their forecast. With reuse, the
+// metrics of sites not polled since the last scrape are reused as fleet mode
+// does.
+func newBenchFleet(sites, hours int, reuse bool) (prometheus.Gatherer, []*source, *apiResponse, error) {
+	reg := prometheus.NewRegistry()
+	var g prometheus.Gatherer = reg
+	fleet := newFleetGatherer(reg)
+	if reuse {
+		g = fleet
+	}
+	opts := &sourceOptions{readOnly: &atomic.Bool{}, quotaBehavior: "keep", quotaAfter: 3, backoffMax: time.Hour, outputs: newOutputs()}
+	res := syntheticForecast(hours, time.Now())
+	var sources []*source
+	for i := 0; i < sites; i++ {
+		name := "site" + strconv.Itoa(i)
+		s := newSource(name, nil, time.Hour, opts)
+		if err := s.update(res); err != nil {
+			return nil, nil, nil, fmt.Errorf("updating synthetic forecast: %s", err)
+		}
+		labels := prometheus.Labels{"provider": "forecast.solar", "site": name}
+		wrapped := prometheus.WrapRegistererWith(labels, reg)
+		volatile := wrapped
+		if reuse {
+			wrapped, volatile = fleet.add(s, labels)
+		}
+		s.registerDataAge(volatile)
+		s.register(wrapped)
+		wrapped.MustRegister(newHourlyCollector(s.hourly))
+		sources = append(sources, s)
+	}
+	// Gather once so the measured scrapes only gather the polled sites again
+	if _, err := g.Gather(); err != nil {
+		return nil, nil, nil, fmt.Errorf("gathering metrics: %s", err)
+	}
+	return g, sources, res, nil
+}
+
+// syntheticForecast returns a forecast of the given number of hours starting
+// today, following a bell curve around noon
+func syntheticForecast(hours int, now time.Time) *apiResponse {
+	res := &apiResponse{}
+	res.Result.Watts = map[string]float64{}
+	res.Result.WattHoursPeriod = map[string]float64{}
+	res.Result.WattHoursDay = map[string]float64{}
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	for h := 1; h <= hours; h++ {
+		t := midnight.Add(time.Duration(h) * time.Hour)
+		wh := math.Max(0, 1000*math.Cos(float64(t.Hour()-12)/12*math.Pi))
+		period := t.Format(time.DateTime)
+		res.Result.Watts[period] = wh
+		res.Result.WattHoursPeriod[period] = wh
+		res.Result.WattHoursDay[t.Add(-time.Second).Format(time.DateOnly)] += wh
+	}
+	return res
+}
+
+// countingWriter counts the bytes written
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Resolution of the start times and the integration of the best windows
+const (
+	bestWindowStep      = 15 * time.Minute
+	bestWindowIntegrate = 5 * time.Minute
+)
+
+// bestWindow is a load duration as configured, which is its label
+type bestWindow struct {
+	label    string
+	duration time.Duration
+}
+
+// parseBestWindows parses comma separated durations such as 2h,90m
+func parseBestWindows(s string) ([]bestWindow, error) {
+	var windows []bestWindow
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		d, err := time.ParseDuration(part)
+		if err != nil || d < bestWindowStep {
+			return nil, fmt.Errorf("invalid duration %q, expected e.g. 2h of at least %s", part, bestWindowStep)
+		}
+		windows = append(windows, bestWindow{part, d})
+	}
+	return windows, nil
+}
+
+// bestWindowCollector recommends when to run a load of the given durations,
+// e.g. the washing machine or charging the car, from the power curve on
+// every scrape: the window covering most of the load with solar power, or
+// with electricity prices the window of the cheapest energy from the grid
+type bestWindowCollector struct {
+	source  *source
+	windows []bestWindow
+	load    float64 // Watts, 0 for the sunniest window
+	price   tariffSchedule
+
+	start  *prometheus.Desc
+	energy *prometheus.Desc
+}
+
+func newBestWindowCollector(s *source, windows []bestWindow, load float64, price tariffSchedule) *bestWindowCollector {
+	return &bestWindowCollector{
+		source:  s,
+		windows: windows,
+		load:    load,
+		price:   price,
+		start: prometheus.NewDesc(
+			"forecast_solar_best_window_start_seconds",
+			"Unix timestamp of the recommended start of a load of the given duration",
+			[]string{"duration"},
+			nil,
+		),
+		energy: prometheus.NewDesc(
+			"forecast_solar_best_window_energy_kwh",
+			"Forecasted solar energy available to the load in the recommended window",
+			[]string{"duration"},
+			nil,
+		),
+	}
+}
+
+func (c *bestWindowCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.start
+	ch <- c.energy
+}
+
+func (c *bestWindowCollector) Collect(ch chan<- prometheus.Metric) {
+	res := c.source.forecast.Load()
+	if res == nil {
+		return
+	}
+	curve := newPowerCurve(res.Result.Watts, res.location())
+	if len(curve) == 0 {
+		return
+	}
+	now := clock()
+	for _, w := range c.windows {
+		if start, wh, ok := c.best(curve, now, w.duration); ok {
+			ch <- prometheus.MustNewConstMetric(c.start, prometheus.GaugeValue, float64(start.Unix()), w.label)
+			ch <- prometheus.MustNewConstMetric(c.energy, prometheus.GaugeValue, wh/1000, w.label)
+		}
+	}
+}
+
+// best returns the start of the best window of duration d starting after now
+// and ending within the forecast, and the solar energy available to the load
+// in it. Ties go to the earliest window.
+func (c *bestWindowCollector) best(curve powerCurve, now time.Time, d time.Duration) (time.Time, float64, bool) {
+	load := c.load
+	if load <= 0 {
+		load = math.Inf(1)
+	}
+	cheapest := c.load > 0 && c.price.enabled()
+
+	var bestStart time.Time
+	var bestEnergy float64
+	bestScore := math.Inf(-1)
+	end := curve[len(curve)-1].time
+	for start := now.Truncate(bestWindowStep).Add(bestWindowStep); !start.Add(d).After(end); start = start.Add(bestWindowStep) {
+		var energy, cost float64
+		for t := start; t.Before(start.Add(d)); t = t.Add(bestWindowIntegrate) {
+			w, _ := curve.at(t.Add(bestWindowIntegrate / 2))
+			covered := math.Min(w, load)
+			hours := bestWindowIntegrate.Hours()
+			energy += covered * hours
+			if cheapest {
+				cost += (c.load - covered) * hours / 1000 * c.price.at(t)
+			}
+		}
+		score := energy
+		if cheapest {
+			score = -cost
+		}
+		if score > bestScore {
+			bestStart, bestEnergy, bestScore = start, energy, score
+		}
+	}
+	return bestStart, bestEnergy, !bestStart.IsZero()
+}
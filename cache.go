@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// writeFileAtomic replaces the file at path with body, so readers never see a partially written file
+func writeFileAtomic(path string, body []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(body); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// cacheEntry is the last forecast of a source and when it was retrieved
+type cacheEntry struct {
+	Time     time.Time    `json:"time"`
+	Forecast *apiResponse `json:"forecast"`
+}
+
+// cacheStore persists the last forecast of each source to a file, so the
+// metrics are populated right after a restart
+type cacheStore struct {
+	mu      sync.Mutex
+	path    string
+	Entries map[string]cacheEntry `json:"entries"`
+
+	// Forecast for today issued the day before, of each source
+	Predicted map[string]cacheDay `json:"predicted,omitempty"`
+}
+
+// cacheDay is the forecasted energy of a day in Wh
+type cacheDay struct {
+	Date      time.Time `json:"date"`
+	WattHours float64   `json:"watt_hours"`
+}
+
+// openCache loads the cache from path, starting with an empty one if the
+// file does not exist yet
+func openCache(path string) (*cacheStore, error) {
+	c := &cacheStore{
+		path:      path,
+		Entries:   map[string]cacheEntry{},
+		Predicted: map[string]cacheDay{},
+	}
+
+	body, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *cacheStore) get(name string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.Entries[name]
+	return entry, ok && entry.Forecast != nil
+}
+
+// predicted returns the forecast for today of a source issued the day before
+func (c *cacheStore) predicted(name string) (cacheDay, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	day, ok := c.Predicted[name]
+	return day, ok
+}
+
+// setPredicted stores the forecast for today of a source issued the day
+// before, persisted along with the next forecast
+func (c *cacheStore) setPredicted(name string, date time.Time, wh float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Predicted[name] = cacheDay{Date: date, WattHours: wh}
+}
+
+// put stores the forecast of a source and persists the cache
+func (c *cacheStore) put(name string, forecast *apiResponse) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Entries[name] = cacheEntry{Time: clock(), Forecast: forecast}
+
+	body, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path, body)
+}
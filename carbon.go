@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Latest carbon intensity of a zone by Electricity Maps
+const electricityMapsURL = "https://api.electricitymap.org/v3/carbon-intensity/latest"
+
+// carbonIntensity is the carbon intensity of the grid in g CO2eq per kWh,
+// either configured or retrieved periodically from Electricity Maps, with
+// the configured one as fallback until the first retrieval
+type carbonIntensity struct {
+	client   *http.Client
+	url      string
+	token    string
+	zone     string
+	interval time.Duration
+
+	mu    sync.Mutex
+	value float64
+}
+
+func newCarbonIntensity(client *http.Client, value float64, token, zone string, interval time.Duration) *carbonIntensity {
+	return &carbonIntensity{
+		client:   client,
+		url:      electricityMapsURL,
+		token:    token,
+		zone:     zone,
+		interval: interval,
+		value:    value,
+	}
+}
+
+func (c *carbonIntensity) get() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
+
+func (c *carbonIntensity) register(reg prometheus.Registerer) {
+	opts := prometheus.GaugeOpts{
+		Name: "forecast_solar_grid_carbon_intensity_grams_per_kwh",
+		Help: "Carbon intensity of the grid the forecasted CO2 avoided is computed with",
+	}
+	if c.zone != "" {
+		opts.ConstLabels = prometheus.Labels{"zone": c.zone}
+	}
+	reg.MustRegister(prometheus.NewGaugeFunc(opts, c.get))
+}
+
+// run retrieves the carbon intensity of the zone until ctx is cancelled
+func (c *carbonIntensity) run(ctx context.Context) {
+	for {
+		if err := c.update(); err != nil {
+			log.Printf("Error retrieving carbon intensity of %s: %s", c.zone, err)
+		}
+		if !sleep(ctx, c.interval) {
+			return
+		}
+	}
+}
+
+func (c *carbonIntensity) update() error {
+	req, err := http.NewRequest(http.MethodGet, c.url+"?"+url.Values{"zone": {c.zone}}.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("auth-token", c.token)
+	r, err := doRequest(c.client, req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return newStatusError(r)
+	}
+
+	var latest struct {
+		CarbonIntensity *float64 `json:"carbonIntensity"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&latest); err != nil {
+		return err
+	}
+	if latest.CarbonIntensity == nil {
+		return nil
+	}
+	c.mu.Lock()
+	c.value = *latest.CarbonIntensity
+	c.mu.Unlock()
+	return nil
+}
+
+// carbonCollector exports the CO2 the forecasted harvest avoids compared to
+// drawing the energy from the grid
+type carbonCollector struct {
+	source    *source
+	intensity *carbonIntensity
+	metric    *prometheus.Desc
+}
+
+func newCarbonCollector(s *source, intensity *carbonIntensity) *carbonCollector {
+	return &carbonCollector{
+		source:    s,
+		intensity: intensity,
+		metric: prometheus.NewDesc(
+			"forecast_solar_co2_avoided_kg",
+			"CO2 avoided by the forecasted solar harvest at the carbon intensity of the grid",
+			[]string{"day"},
+			nil,
+		),
+	}
+}
+
+func (c *carbonCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.metric
+}
+
+func (c *carbonCollector) Collect(ch chan<- prometheus.Metric) {
+	intensity := c.intensity.get()
+	if intensity <= 0 {
+		return
+	}
+	for day, f := range map[string]*forecastCollector{"today": c.source.today, "tomorrow": c.source.tomorrow} {
+		// The collectors hold watt hours
+		if date, wh := f.get(); !date.IsZero() {
+			ch <- prometheus.MustNewConstMetric(c.metric, prometheus.GaugeValue, wh/1000*intensity/1000, day)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// chaosTransport makes upstream requests fail or return canned payloads on
+// demand, for testing alerting, backoff and fallbacks end-to-end
+type chaosTransport struct {
+	next http.RoundTripper
+
+	mu        sync.Mutex
+	remaining int    // Number of requests still to intercept
+	status    int    // Status to respond with, 0 fails with a network error
+	payload   []byte // Body of the response
+}
+
+var errChaos = errors.New("injected failure")
+
+func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	if t.remaining == 0 {
+		t.mu.Unlock()
+		return t.next.RoundTrip(req)
+	}
+	t.remaining--
+	status, payload := t.status, t.payload
+	t.mu.Unlock()
+
+	log.Printf("Injecting failure into %s %s", req.Method, req.URL.Host)
+	if status == 0 {
+		return nil, errChaos
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(payload)),
+		ContentLength: int64(len(payload)),
+		Request:       req,
+	}, nil
+}
+
+// chaosHandler reports the pending injections on GET. POST or PUT intercepts
+// the next "count" upstream requests: with "status", they are answered with
+// that status and the request body as payload, otherwise they fail with a
+// network error, e.g. POST /-/chaos?count=3&status=503
+func chaosHandler(t *chaosTransport, audit *auditLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost, http.MethodPut:
+			// Parameters are read from the query only, the body is the payload
+			payload, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			count, err := strconv.Atoi(r.URL.Query().Get("count"))
+			if err != nil || count < 0 {
+				http.Error(w, "Parameter count must be a number of requests", http.StatusBadRequest)
+				return
+			}
+			status := 0
+			if s := r.URL.Query().Get("status"); s != "" {
+				if status, err = strconv.Atoi(s); err != nil || status < 100 || status > 599 {
+					http.Error(w, "Parameter status must be an HTTP status code", http.StatusBadRequest)
+					return
+				}
+			}
+
+			t.mu.Lock()
+			t.remaining, t.status, t.payload = count, status, payload
+			t.mu.Unlock()
+
+			detail := fmt.Sprintf("count=%d status=%d", count, status)
+			log.Printf("Chaos injection set to %s by %s", detail, r.RemoteAddr)
+			if audit != nil {
+				if err := audit.record(r, "chaos", detail); err != nil {
+					log.Printf("Error writing audit log: %s", err)
+				}
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST, PUT")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		fmt.Fprintf(w, "remaining: %d\nstatus: %d\n", t.remaining, t.status)
+	}
+}
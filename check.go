@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// checkParameters validates the plane parameters against the check endpoint
+// of the API, returning the validation message of the API on failure
+func checkParameters(client *http.Client, url string) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	r, err := doRequest(client, req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode == 200 {
+		return nil
+	}
+
+	// The API explains what is wrong with the parameters in the message text
+	res := struct {
+		Message struct {
+			Text string `json:"text"`
+		} `json:"message"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil || res.Message.Text == "" {
+		return fmt.Errorf("%s", r.Status)
+	}
+	return fmt.Errorf("%s: %s", r.Status, res.Message.Text)
+}
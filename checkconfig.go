@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// checkSites returns the problems of sites the API would reject or
+// misinterpret, such as coordinates out of range. Unlike the validation on
+// startup, all problems are returned instead of the first one.
+func checkSites(sites []site) []string {
+	var problems []string
+	// The coordinates are shared by the planes of a site
+	add := func(format string, args ...interface{}) {
+		if p := fmt.Sprintf(format, args...); !contains(problems, p) {
+			problems = append(problems, p)
+		}
+	}
+	check := func(what, name, value string, min, max float64) {
+		f, err := strconv.ParseFloat(value, 64)
+		switch {
+		case err != nil:
+			add("%s: invalid %s %q", what, name, value)
+		case f < min || f > max:
+			add("%s: %s %s out of range %g to %g", what, name, value, min, max)
+		}
+	}
+	checkPlane := func(what, declination, azimuth, kwp string) {
+		check(what, "declination", declination, 0, 90)
+		check(what, "azimuth", azimuth, -180, 180)
+		if f, err := strconv.ParseFloat(kwp, 64); err != nil || f <= 0 {
+			add("%s: kwp %q must be a positive number", what, kwp)
+		}
+	}
+
+	for _, s := range sites {
+		what := "plane"
+		if s.Name != "" {
+			what = "site " + s.Name
+		}
+		check(what, "latitude", s.Latitude, -90, 90)
+		check(what, "longitude", s.Longitude, -180, 180)
+		if s.Plane != "" {
+			what = fmt.Sprintf("plane %s of %s", s.Plane, what)
+		}
+		checkPlane(what, s.Declination, s.Azimuth, s.Kwp)
+	}
+	return problems
+}
+
+// checkFleetKeys returns the keys of the fleet file at path the exporter
+// doesn't know, typically typos silently ignored on startup
+func checkFleetKeys(path string) ([]string, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(body))
+	decoder.KnownFields(true)
+	var config fleetConfig
+	err = decoder.Decode(&config)
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		return typeErr.Errors, nil
+	}
+	return nil, err
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// requestGroup coalesces the requests of sources polling the same
+// forecast.solar request, such as the planes of sites with the same location
+// parameters that only differ in their labels. Polls of the same request are
+// serialized, and a poll within maxAge of another one is served its forecast
+// instead of requesting the API again.
+type requestGroup struct {
+	mu        sync.Mutex
+	locks     map[string]*sync.Mutex
+	latest    map[string]*peerForecast
+	coalesced prometheus.Counter
+}
+
+func newRequestGroup() *requestGroup {
+	return &requestGroup{
+		locks:  map[string]*sync.Mutex{},
+		latest: map[string]*peerForecast{},
+		coalesced: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "forecast_solar_coalesced_requests_total",
+			Help: "Number of polls served by a recent forecast of another source with the same request instead of the API",
+		}),
+	}
+}
+
+// lock serializes the polls of a request until the returned function is
+// called, so polls started at the same time request the API once
+func (g *requestGroup) lock(key string) func() {
+	g.mu.Lock()
+	l, ok := g.locks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		g.locks[key] = l
+	}
+	g.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// record remembers the forecast of a request retrieved from the API
+func (g *requestGroup) record(key string, f *peerForecast) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.latest[key] = f
+}
+
+// lookup returns the forecast of the request retrieved within maxAge, or nil
+// if there is none
+func (g *requestGroup) lookup(key string, maxAge time.Duration) *apiResponse {
+	g.mu.Lock()
+	f := g.latest[key]
+	g.mu.Unlock()
+	if f == nil || clock().Sub(f.Time) >= maxAge {
+		return nil
+	}
+	g.coalesced.Inc()
+
+	// The rate limit was already accounted by the source that retrieved it
+	res := *f.Forecast
+	res.Message.RateLimit = nil
+	return &res
+}
+
+// distinctRequests returns the number of distinct forecast.solar requests of
+// the sources, as sources with the same request share their polls
+func distinctRequests(sources []*source) int {
+	keys := map[string]bool{}
+	for _, s := range sources {
+		if key := requestKey(s.provider); key != "" {
+			keys[key] = true
+		}
+	}
+	return len(keys)
+}
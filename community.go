@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Interval between checks for a completed day to share and updates of the
+// community accuracy
+const communityInterval = time.Hour
+
+// Forecast errors are clipped to this many percent before adding noise, which
+// bounds the influence of a single sample
+const communityErrorBound = 100
+
+// communitySharer shares the forecast error of the previous day per provider
+// with a community aggregation service and exports the average accuracy of
+// the region in comparison. Only a coarse grid cell is shared as location and
+// the error is obfuscated with Laplace noise for differential privacy.
+type communitySharer struct {
+	client  *http.Client
+	url     string
+	grid    string
+	epsilon float64
+	sources []*source
+	actual  *actualProduction
+
+	shared map[string]string // Day shared by provider
+
+	meanError     *prometheus.GaugeVec
+	absoluteError *prometheus.GaugeVec
+	samples       *prometheus.GaugeVec
+}
+
+// communitySample is the anonymized accuracy of a forecast of a day
+type communitySample struct {
+	Grid         string  `json:"grid"`
+	Provider     string  `json:"provider"`
+	Date         string  `json:"date"`
+	ErrorPercent float64 `json:"error_percent"`
+}
+
+// communityAccuracy is the average accuracy of the forecasts of a region
+type communityAccuracy struct {
+	MeanErrorPercent    float64 `json:"mean_error_percent"`
+	MeanAbsErrorPercent float64 `json:"mean_absolute_error_percent"`
+	Samples             int     `json:"samples"`
+}
+
+func newCommunitySharer(client *http.Client, baseURL string, latitude, longitude, gridDegrees, epsilon float64, sources []*source, actual *actualProduction) *communitySharer {
+	return &communitySharer{
+		client:  client,
+		url:     strings.TrimSuffix(baseURL, "/"),
+		grid:    communityGrid(latitude, longitude, gridDegrees),
+		epsilon: epsilon,
+		sources: sources,
+		actual:  actual,
+		shared:  map[string]string{},
+		meanError: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "forecast_solar_community_error_percent",
+			Help: "Mean forecast error of the community in the region relative to the actual production",
+		}, []string{"provider"}),
+		absoluteError: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "forecast_solar_community_absolute_error_percent",
+			Help: "Mean absolute forecast error of the community in the region relative to the actual production",
+		}, []string{"provider"}),
+		samples: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "forecast_solar_community_samples",
+			Help: "Number of samples the accuracy of the community in the region is based on",
+		}, []string{"provider"}),
+	}
+}
+
+// communityGrid returns the south-west corner of the grid cell of a location,
+// e.g. 52,13 for 1 degree cells
+func communityGrid(latitude, longitude, degrees float64) string {
+	return fmt.Sprintf("%g,%g", math.Floor(latitude/degrees)*degrees, math.Floor(longitude/degrees)*degrees)
+}
+
+// laplaceNoise returns a sample of the Laplace distribution with the given
+// scale. u is drawn from (-0.5, 0.5), as -0.5 would take the log of 0.
+func laplaceNoise(scale float64) float64 {
+	f := rand.Float64()
+	for f == 0 {
+		f = rand.Float64()
+	}
+	u := f - 0.5
+	if u < 0 {
+		return scale * math.Log(1+2*u)
+	}
+	return -scale * math.Log(1-2*u)
+}
+
+// anonymize clips the error and adds noise for epsilon-differential privacy
+func (c *communitySharer) anonymize(errorPercent float64) float64 {
+	clipped := math.Max(-communityErrorBound, math.Min(communityErrorBound, errorPercent))
+	return clipped + laplaceNoise(2*communityErrorBound/c.epsilon)
+}
+
+func (c *communitySharer) register(reg prometheus.Registerer) {
+	reg.MustRegister(c.meanError, c.absoluteError, c.samples)
+}
+
+// run shares each completed day once and updates the accuracy of the region
+// until ctx is cancelled
+func (c *communitySharer) run(ctx context.Context) {
+	for {
+		c.share()
+		for _, s := range c.sources {
+			provider, _, _ := splitSourceName(s.name)
+			if err := c.update(provider); err != nil {
+				log.Printf("Error retrieving community accuracy of %s: %s", provider, err)
+			}
+		}
+		if !sleep(ctx, communityInterval) {
+			return
+		}
+	}
+}
+
+// share sends the error of the forecast of the previous day of each provider
+func (c *communitySharer) share() {
+	day, actual := c.actual.completed()
+	if day == "" || actual <= 0 {
+		return
+	}
+	for _, s := range c.sources {
+		provider, _, _ := splitSourceName(s.name)
+		date, forecast := s.previous.get()
+		if date.Format(time.DateOnly) != day || c.shared[provider] == day {
+			continue
+		}
+		sample := communitySample{
+			Grid:         c.grid,
+			Provider:     provider,
+			Date:         day,
+			ErrorPercent: c.anonymize((forecast - actual) / actual * 100),
+		}
+		if err := c.post(sample); err != nil {
+			log.Printf("Error sharing forecast accuracy of %s: %s", provider, err)
+			continue
+		}
+		c.shared[provider] = day
+	}
+}
+
+func (c *communitySharer) post(sample communitySample) error {
+	body, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.url+"/api/v1/samples", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	r, err := doRequest(c.client, req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK && r.StatusCode != http.StatusCreated && r.StatusCode != http.StatusNoContent {
+		msg, _ := io.ReadAll(io.LimitReader(r.Body, 1024))
+		return fmt.Errorf("unexpected status %s: %s", r.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// update retrieves the accuracy of the community in the grid cell
+func (c *communitySharer) update(provider string) error {
+	req, err := http.NewRequest(http.MethodGet, c.url+"/api/v1/accuracy?"+url.Values{"grid": {c.grid}, "provider": {provider}}.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	var res communityAccuracy
+	if err := getJSON(c.client, req, &res); err != nil {
+		return err
+	}
+	if res.Samples == 0 {
+		c.meanError.DeleteLabelValues(provider)
+		c.absoluteError.DeleteLabelValues(provider)
+	} else {
+		c.meanError.WithLabelValues(provider).Set(res.MeanErrorPercent)
+		c.absoluteError.WithLabelValues(provider).Set(res.MeanAbsErrorPercent)
+	}
+	c.samples.WithLabelValues(provider).Set(float64(res.Samples))
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// parseConstLabels validates the labels of -label and returns them as sorted
+// label pairs
+func parseConstLabels(labels map[string]string) ([]*dto.LabelPair, error) {
+	var pairs []*dto.LabelPair
+	for _, name := range sortedKeys(labels) {
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("invalid label name %q", name)
+		}
+		name, value := name, labels[name]
+		pairs = append(pairs, &dto.LabelPair{Name: &name, Value: &value})
+	}
+	return pairs, nil
+}
+
+// withConstLabels adds labels to all gathered metrics. Labels a metric has
+// itself, e.g. site in fleet mode, take precedence. Metrics are copied, as the
+// fleet gatherer shares them between scrapes.
+func withConstLabels(g prometheus.Gatherer, labels []*dto.LabelPair) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := g.Gather()
+		for _, mf := range families {
+			metrics := make([]*dto.Metric, len(mf.Metric))
+			for i, m := range mf.Metric {
+				metrics[i] = &dto.Metric{
+					Label:       mergeLabels(m.Label, labels),
+					Gauge:       m.Gauge,
+					Counter:     m.Counter,
+					Summary:     m.Summary,
+					Untyped:     m.Untyped,
+					Histogram:   m.Histogram,
+					TimestampMs: m.TimestampMs,
+				}
+			}
+			mf.Metric = metrics
+		}
+		return families, err
+	})
+}
+
+// mergeLabels merges sorted label pairs, keeping the first pair of duplicate
+// names
+func mergeLabels(a, b []*dto.LabelPair) []*dto.LabelPair {
+	merged := make([]*dto.LabelPair, 0, len(a)+len(b))
+	merged = append(merged, a...)
+	for _, pair := range b {
+		i := sort.Search(len(a), func(i int) bool { return a[i].GetName() >= pair.GetName() })
+		if i < len(a) && a[i].GetName() == pair.GetName() {
+			continue
+		}
+		merged = append(merged, pair)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].GetName() < merged[j].GetName() })
+	return merged
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Interval between attempts to register the service while Consul is
+// unreachable
+const consulRetryInterval = 30 * time.Second
+
+// consulService registers the exporter as service in the local Consul agent,
+// so Prometheus discovers it via consul_sd_configs, with a health check of
+// /-/healthy run by the agent
+type consulService struct {
+	client  *http.Client
+	url     string // Of the agent
+	token   string
+	name    string
+	tags    []string
+	address string // Advertised, the address of the agent's node if empty
+	port    int
+
+	metricsPath string
+	done        chan struct{} // Closed once run returned
+}
+
+// newConsulService returns the service for the exporter listening on addr,
+// which has to be a TCP address
+func newConsulService(client *http.Client, agentURL, token, name, tags, address string, addr net.Addr, metricsPath string) (*consulService, error) {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("can't register the %s address %s", addr.Network(), addr)
+	}
+
+	c := &consulService{
+		client:      client,
+		url:         strings.TrimSuffix(agentURL, "/"),
+		token:       token,
+		name:        name,
+		address:     address,
+		port:        tcp.Port,
+		metricsPath: metricsPath,
+		done:        make(chan struct{}),
+	}
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			c.tags = append(c.tags, tag)
+		}
+	}
+	return c, nil
+}
+
+// id is the ID of the service, unique per port so several exporters can run
+// on one node
+func (c *consulService) id() string {
+	return c.name + "-" + strconv.Itoa(c.port)
+}
+
+// run registers the service, retrying while Consul is unreachable, and
+// deregisters it once ctx is cancelled
+func (c *consulService) run(ctx context.Context) {
+	defer close(c.done)
+	for {
+		err := c.register()
+		if err == nil {
+			log.Printf("Registered service %s in Consul", c.id())
+			break
+		}
+		log.Printf("Error registering service in Consul, retrying in %s: %s", consulRetryInterval, err)
+		select {
+		case <-time.After(consulRetryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	<-ctx.Done()
+	if err := c.deregister(); err != nil {
+		log.Printf("Error deregistering service from Consul: %s", err)
+	} else {
+		log.Printf("Deregistered service %s from Consul", c.id())
+	}
+}
+
+// wait waits for the service to be deregistered after the context of run
+// was cancelled, at most until ctx is done
+func (c *consulService) wait(ctx context.Context) {
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+	}
+}
+
+func (c *consulService) register() error {
+	// The agent runs the check, on the advertised address or its own node
+	host := c.address
+	if host == "" {
+		host = "localhost"
+	}
+	service := map[string]interface{}{
+		"ID":   c.id(),
+		"Name": c.name,
+		"Tags": c.tags,
+		"Port": c.port,
+		"Meta": map[string]string{"metrics_path": c.metricsPath},
+		"Check": map[string]string{
+			"HTTP":     "http://" + net.JoinHostPort(host, strconv.Itoa(c.port)) + "/-/healthy",
+			"Interval": "30s",
+			"Timeout":  "5s",
+		},
+	}
+	if c.address != "" {
+		service["Address"] = c.address
+	}
+	body, err := json.Marshal(service)
+	if err != nil {
+		return err
+	}
+	return c.put("/v1/agent/service/register", body)
+}
+
+func (c *consulService) deregister() error {
+	return c.put("/v1/agent/service/deregister/"+url.PathEscape(c.id()), nil)
+}
+
+func (c *consulService) put(path string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPut, c.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	r, err := doRequest(c.client, req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(r.Body, 1024))
+		return fmt.Errorf("unexpected status %s: %s", r.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// parseRequestCosts parses the estimated costs of a request per provider,
+// e.g. solcast=1 for a credit per request
+func parseRequestCosts(costs map[string]string) (map[string]float64, error) {
+	parsed := map[string]float64{}
+	for provider, cost := range costs {
+		v, err := strconv.ParseFloat(cost, 64)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("invalid cost of %s: %s", provider, cost)
+		}
+		parsed[provider] = v
+	}
+	return parsed, nil
+}
+
+// dailyTotal sums up values of the current day, starting from zero each day
+type dailyTotal struct {
+	mu    sync.Mutex
+	day   string
+	total float64
+}
+
+func (d *dailyTotal) add(t time.Time, v float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if day := t.Format(time.DateOnly); day != d.day {
+		d.day, d.total = day, 0
+	}
+	d.total += v
+}
+
+// get returns the total of the day of t
+func (d *dailyTotal) get(t time.Time) float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if t.Format(time.DateOnly) != d.day {
+		return 0
+	}
+	return d.total
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// cumulativeCollector exports the forecasted energy of today up to now from
+// the cumulative watt_hours curve of forecast.solar, which can be compared
+// directly with the energy today counters of inverters, and from the energy
+// per period of all providers along with the energy remaining today
+type cumulativeCollector struct {
+	source    *source
+	metric    *prometheus.Desc
+	soFar     *prometheus.Desc
+	remaining *prometheus.Desc
+}
+
+func newCumulativeCollector(s *source) *cumulativeCollector {
+	return &cumulativeCollector{
+		source: s,
+		metric: prometheus.NewDesc(
+			"forecast_solar_cumulative_today_kwh",
+			"Forecasted energy of today up to now, interpolated from the cumulative watt_hours curve",
+			nil,
+			nil,
+		),
+		soFar: prometheus.NewDesc(
+			"forecast_solar_produced_so_far_today_kwh",
+			"Forecasted energy from midnight to now, from the energy per period",
+			nil,
+			nil,
+		),
+		remaining: prometheus.NewDesc(
+			"forecast_solar_remaining_today_kwh",
+			"Forecasted energy from now to midnight, from the energy per period",
+			nil,
+			nil,
+		),
+	}
+}
+
+func (c *cumulativeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.metric
+	ch <- c.soFar
+	ch <- c.remaining
+}
+
+func (c *cumulativeCollector) Collect(ch chan<- prometheus.Metric) {
+	res := c.source.forecast.Load()
+	if res == nil {
+		return
+	}
+	now := clock()
+	if wh, ok := cumulativeAt(res.Result.WattHours, now, res.location()); ok {
+		ch <- prometheus.MustNewConstMetric(c.metric, prometheus.GaugeValue, wh/1000)
+	}
+	if soFar, total, ok := producedAt(res.Result.WattHoursPeriod, now, res.location()); ok {
+		ch <- prometheus.MustNewConstMetric(c.soFar, prometheus.GaugeValue, soFar/1000)
+		ch <- prometheus.MustNewConstMetric(c.remaining, prometheus.GaugeValue, (total-soFar)/1000)
+	}
+}
+
+// cumulativeAt interpolates the cumulative curve of the day of t linearly at
+// t. Before the first point of the day, nothing was produced yet, after the
+// last one the day's total is reached. It returns false if the curve has no
+// points on the day of t. Times are in the local time of the plant at loc.
+func cumulativeAt(curve map[string]float64, t time.Time, loc *time.Location) (float64, bool) {
+	type point struct {
+		time time.Time
+		wh   float64
+	}
+	day := t.In(loc).Format(time.DateOnly)
+	var points []point
+	for period, wh := range curve {
+		pt, err := time.ParseInLocation(time.DateTime, period, loc)
+		if err != nil || pt.Format(time.DateOnly) != day {
+			continue
+		}
+		points = append(points, point{pt, wh})
+	}
+	if len(points) == 0 {
+		return 0, false
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].time.Before(points[j].time) })
+
+	i := sort.Search(len(points), func(i int) bool { return points[i].time.After(t) })
+	switch {
+	case i == 0:
+		return 0, true
+	case i == len(points):
+		return points[i-1].wh, true
+	}
+	prev, next := points[i-1], points[i]
+	fraction := float64(t.Sub(prev.time)) / float64(next.time.Sub(prev.time))
+	return prev.wh + (next.wh-prev.wh)*fraction, true
+}
+
+// producedAt returns the energy of the day of t up to t and of the whole day
+// from the energy per period ending at the given times. The energy of the
+// period containing t is prorated, a period starts at the end of the previous
+// one or an hour before its end if it is the first. It returns false if there
+// are no periods on the day of t. Times are in the local time of the plant at
+// loc.
+func producedAt(periods map[string]float64, t time.Time, loc *time.Location) (soFar, total float64, ok bool) {
+	day := t.In(loc).Format(time.DateOnly)
+	ends := make([]time.Time, 0, len(periods))
+	energy := map[time.Time]float64{}
+	for period, wh := range periods {
+		end, err := time.ParseInLocation(time.DateTime, period, loc)
+		if err != nil || end.Add(-time.Second).Format(time.DateOnly) != day {
+			continue
+		}
+		ends = append(ends, end)
+		energy[end] = wh
+	}
+	if len(ends) == 0 {
+		return 0, 0, false
+	}
+	sort.Slice(ends, func(i, j int) bool { return ends[i].Before(ends[j]) })
+
+	for i, end := range ends {
+		wh := energy[end]
+		total += wh
+		start := end.Add(-time.Hour)
+		if i > 0 {
+			start = ends[i-1]
+		}
+		switch {
+		case !end.After(t):
+			soFar += wh
+		case start.Before(t):
+			soFar += wh * float64(t.Sub(start)) / float64(end.Sub(start))
+		}
+	}
+	return soFar, total, true
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// parseLoadProfile parses the household load in watts, either a constant like
+// 300 or per hour range like 0-7=200,7-22=450,22-24=200. Hours not covered by
+// a range have no load.
+func parseLoadProfile(s string) ([24]float64, error) {
+	var profile [24]float64
+
+	if watts, err := strconv.ParseFloat(s, 64); err == nil {
+		for hour := range profile {
+			profile[hour] = watts
+		}
+		return profile, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		hours, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			return profile, fmt.Errorf("expected start-end=watts, got %q", part)
+		}
+		start, end, found := strings.Cut(hours, "-")
+		if !found {
+			return profile, fmt.Errorf("expected start-end hours, got %q", hours)
+		}
+
+		startHour, err := strconv.Atoi(start)
+		if err != nil {
+			return profile, fmt.Errorf("invalid start hour %q: %s", start, err)
+		}
+		endHour, err := strconv.Atoi(end)
+		if err != nil {
+			return profile, fmt.Errorf("invalid end hour %q: %s", end, err)
+		}
+		if startHour < 0 || endHour > 24 || startHour >= endHour {
+			return profile, fmt.Errorf("invalid hours %s", hours)
+		}
+		watts, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return profile, fmt.Errorf("invalid load %q: %s", value, err)
+		}
+
+		for hour := startHour; hour < endHour; hour++ {
+			profile[hour] = watts
+		}
+	}
+	return profile, nil
+}
+
+// curtailmentCollector splits the hourly forecast into energy used by the
+// household, exported to the grid and curtailed because of the export limit
+type curtailmentCollector struct {
+	forecast    *hourlyForecast
+	exportLimit float64     // Watts
+	load        [24]float64 // Watts by hour of the day
+
+	selfUse   *prometheus.Desc
+	export    *prometheus.Desc
+	curtailed *prometheus.Desc
+}
+
+func newCurtailmentCollector(forecast *hourlyForecast, exportLimit float64, load [24]float64) *curtailmentCollector {
+	return &curtailmentCollector{
+		forecast:    forecast,
+		exportLimit: exportLimit,
+		load:        load,
+		selfUse: prometheus.NewDesc(
+			"forecast_solar_self_use_kwh",
+			"Forecasted energy used by the household given the load profile",
+			[]string{"day"},
+			nil,
+		),
+		export: prometheus.NewDesc(
+			"forecast_solar_export_kwh",
+			"Forecasted energy exported to the grid given the load profile and export limit",
+			[]string{"day"},
+			nil,
+		),
+		curtailed: prometheus.NewDesc(
+			"forecast_solar_curtailed_kwh",
+			"Forecasted energy lost to the export limit, which a battery could recover",
+			[]string{"day"},
+			nil,
+		),
+	}
+}
+
+func (c *curtailmentCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.selfUse
+	ch <- c.export
+	ch <- c.curtailed
+}
+
+func (c *curtailmentCollector) Collect(ch chan<- prometheus.Metric) {
+	c.forecast.mu.Lock()
+	defer c.forecast.mu.Unlock()
+
+	for day, hours := range c.forecast.energy {
+		production := make([]float64, 24)
+		for hour, wh := range hours {
+			production[hour] = wh
+		}
+		f := simulate(production, c.load[:], 0, c.exportLimit)
+
+		ch <- prometheus.MustNewConstMetric(c.selfUse, prometheus.GaugeValue, f.SelfUse/1000, day)
+		ch <- prometheus.MustNewConstMetric(c.export, prometheus.GaugeValue, f.Export/1000, day)
+		ch <- prometheus.MustNewConstMetric(c.curtailed, prometheus.GaugeValue, f.Curtailed/1000, day)
+	}
+}
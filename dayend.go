@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// runDayEnd freezes the final forecast and actual production of each day at
+// local midnight, so daily comparisons use the values at the end of the day
+// instead of whatever the last poll or scrape captured, until ctx is cancelled
+func runDayEnd(ctx context.Context, sources *sourceSet, actual *actualProduction, history *historyStore) {
+	for {
+		now := time.Now()
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.Local)
+		if !sleep(ctx, time.Until(midnight)) {
+			return
+		}
+
+		closing := midnight.Add(-time.Hour).Format(time.DateOnly)
+		for _, s := range sources.all() {
+			date, wh := s.today.get()
+			if date.Format(time.DateOnly) != closing {
+				continue
+			}
+			s.final.set(date, wh)
+			s.changed()
+			if history != nil {
+				if err := history.recordFinal(s.name, closing, wh); err != nil {
+					log.Printf("Error recording final forecast of %s: %s", s.name, err)
+				}
+			}
+		}
+		if actual != nil {
+			actual.closeDay(closing)
+		}
+	}
+}
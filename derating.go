@@ -0,0 +1,33 @@
+package main
+
+// derate returns a copy of the forecast with all values scaled by factor, e.g.
+// 0.92 for aging panels, soiling and cable losses the provider doesn't know of
+func derate(res *apiResponse, factor float64) *apiResponse {
+	scale := func(m map[string]float64) map[string]float64 {
+		if m == nil {
+			return nil
+		}
+		scaled := make(map[string]float64, len(m))
+		for k, v := range m {
+			scaled[k] = v * factor
+		}
+		return scaled
+	}
+
+	derated := *res
+	derated.Result.Watts = scale(res.Result.Watts)
+	derated.Result.WattHoursDay = scale(res.Result.WattHoursDay)
+	derated.Result.WattHoursPeriod = scale(res.Result.WattHoursPeriod)
+	derated.Result.WattHours = scale(res.Result.WattHours)
+	if res.Quantiles != nil {
+		derated.Quantiles = make(map[string]*quantileForecast, len(res.Quantiles))
+		for q, f := range res.Quantiles {
+			derated.Quantiles[q] = &quantileForecast{
+				Watts:           scale(f.Watts),
+				WattHoursDay:    scale(f.WattHoursDay),
+				WattHoursPeriod: scale(f.WattHoursPeriod),
+			}
+		}
+	}
+	return &derated
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dayDriftCollector compares the current forecast for today with the
+// forecast for today issued the day before, the simplest signal of how
+// accurate the forecasts are a day ahead
+type dayDriftCollector struct {
+	source *source
+	metric *prometheus.Desc
+}
+
+func newDayDriftCollector(s *source) *dayDriftCollector {
+	return &dayDriftCollector{
+		source: s,
+		metric: prometheus.NewDesc(
+			"forecast_solar_today_vs_yesterday_forecast_kwh",
+			"Current solar harvest forecast for today minus the forecast for today issued the day before",
+			nil,
+			nil,
+		),
+	}
+}
+
+func (c *dayDriftCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.metric
+}
+
+func (c *dayDriftCollector) Collect(ch chan<- prometheus.Metric) {
+	date, wh := c.source.today.get()
+	predictedDate, predicted := c.source.predicted.get()
+	// Nothing to compare before the first day rollover
+	if date.IsZero() || !predictedDate.Equal(date) {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.metric, prometheus.GaugeValue, (wh-predicted)/1000)
+}
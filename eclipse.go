@@ -0,0 +1,320 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// solarEclipses are the times of greatest eclipse of the upcoming solar
+// eclipses in UTC, from the NASA eclipse catalog. Whether and how much the
+// sun is covered at a location is computed from the positions of the sun and
+// the moon around these times.
+var solarEclipses = []time.Time{
+	time.Date(2026, 8, 12, 17, 47, 0, 0, time.UTC), // Total
+	time.Date(2027, 2, 6, 16, 0, 0, 0, time.UTC),   // Annular
+	time.Date(2027, 8, 2, 10, 7, 0, 0, time.UTC),   // Total
+	time.Date(2028, 1, 26, 15, 8, 0, 0, time.UTC),  // Annular
+	time.Date(2028, 7, 22, 2, 56, 0, 0, time.UTC),  // Total
+	time.Date(2029, 1, 14, 17, 13, 0, 0, time.UTC), // Partial
+	time.Date(2029, 6, 12, 4, 6, 0, 0, time.UTC),   // Partial
+	time.Date(2029, 7, 11, 15, 37, 0, 0, time.UTC), // Partial
+	time.Date(2029, 12, 5, 15, 3, 0, 0, time.UTC),  // Partial
+	time.Date(2030, 6, 1, 6, 29, 0, 0, time.UTC),   // Annular
+	time.Date(2030, 11, 25, 6, 51, 0, 0, time.UTC), // Total
+	time.Date(2031, 5, 21, 7, 16, 0, 0, time.UTC),  // Annular
+	time.Date(2031, 11, 14, 21, 7, 0, 0, time.UTC), // Hybrid
+	time.Date(2032, 5, 9, 13, 26, 0, 0, time.UTC),  // Annular
+	time.Date(2032, 11, 3, 5, 34, 0, 0, time.UTC),  // Partial
+	time.Date(2033, 3, 30, 18, 2, 0, 0, time.UTC),  // Total
+	time.Date(2033, 9, 23, 13, 54, 0, 0, time.UTC), // Partial
+	time.Date(2034, 3, 20, 10, 18, 0, 0, time.UTC), // Total
+	time.Date(2034, 9, 12, 16, 19, 0, 0, time.UTC), // Annular
+	time.Date(2035, 3, 9, 23, 5, 0, 0, time.UTC),   // Annular
+	time.Date(2035, 9, 2, 1, 56, 0, 0, time.UTC),   // Total
+}
+
+const (
+	// The partial phases of an eclipse last up to about 3.5 hours before and
+	// after the greatest eclipse somewhere on Earth
+	eclipseWindow = 4 * time.Hour
+	eclipseStep   = time.Minute
+
+	earthRadiusKm      = 6371.0
+	moonRadiusKm       = 1737.4
+	sunRadiusKm        = 696000.0
+	astronomicalUnitKm = 149597870.7
+
+	// Difference between terrestrial and universal time in the 2020s
+	deltaT = 69 * time.Second
+)
+
+// eclipseCircumstances is the maximum obscuration of the sun by an eclipse at
+// a location while the sun is up
+type eclipseCircumstances struct {
+	obscuration float64 // Covered fraction of the sun's disk
+	time        time.Time
+}
+
+// localEclipse returns the maximum obscuration of the sun while it is up at
+// the location between from and to, zero if no eclipse is visible
+func localEclipse(latitude, longitude float64, from, to time.Time) eclipseCircumstances {
+	var best eclipseCircumstances
+	for _, greatest := range solarEclipses {
+		start, end := greatest.Add(-eclipseWindow), greatest.Add(eclipseWindow)
+		if !end.After(from) || !start.Before(to) {
+			continue
+		}
+		for t := start; t.Before(end); t = t.Add(eclipseStep) {
+			if t.Before(from) || !t.Before(to) {
+				continue
+			}
+			if o, up := obscuration(t, latitude, longitude); up && o > best.obscuration {
+				best = eclipseCircumstances{obscuration: o, time: t}
+			}
+		}
+	}
+	return best
+}
+
+// obscuration returns the fraction of the sun's disk covered by the moon at
+// t as seen from the location, and whether the sun is above the horizon
+func obscuration(t time.Time, latitude, longitude float64) (float64, bool) {
+	jd := float64(t.UnixNano())/86400e9 + 2440587.5
+	c := (jd + deltaT.Seconds()/86400 - 2451545) / 36525
+
+	// Nutation in longitude and the obliquity of the ecliptic, in degrees
+	omega := (125.04 - 1934.136*c) * degrees
+	nutation := -0.00478 * math.Sin(omega)
+	obliquity := (23.439291 - 0.0130042*c + 0.00256*math.Cos(omega)) * degrees
+
+	sunLon, sunDist := sunEcliptic(c)
+	moonLon, moonLat, moonDist := moonEcliptic(c)
+	sun := scale(eclipticToEquatorial((sunLon+nutation)*degrees, 0, obliquity), sunDist*astronomicalUnitKm)
+	moon := scale(eclipticToEquatorial((moonLon+nutation)*degrees, moonLat*degrees, obliquity), moonDist)
+
+	// Position of the observer in the same frame, rotated by the sidereal
+	// time of the location
+	siderealTime := (280.46061837 + 360.98564736629*(jd-2451545) + longitude) * degrees
+	lat := latitude * degrees
+	up := [3]float64{math.Cos(lat) * math.Cos(siderealTime), math.Cos(lat) * math.Sin(siderealTime), math.Sin(lat)}
+	observer := scale(up, earthRadiusKm)
+
+	sun, moon = sub(sun, observer), sub(moon, observer)
+	sunDistance, moonDistance := norm(sun), norm(moon)
+	if dot(sun, up)/sunDistance <= 0 {
+		return 0, false
+	}
+
+	separation := math.Acos(math.Max(-1, math.Min(1, dot(sun, moon)/sunDistance/moonDistance)))
+	return diskOverlap(math.Asin(sunRadiusKm/sunDistance), math.Asin(moonRadiusKm/moonDistance), separation), true
+}
+
+// diskOverlap returns the fraction of a disk of radius r1 covered by a disk of
+// radius r2 at a distance d between their centers
+func diskOverlap(r1, r2, d float64) float64 {
+	switch {
+	case d >= r1+r2:
+		return 0
+	case d <= math.Abs(r1-r2):
+		if r2 >= r1 {
+			return 1
+		}
+		return r2 * r2 / (r1 * r1)
+	}
+	a := r1*r1*math.Acos((d*d+r1*r1-r2*r2)/(2*d*r1)) +
+		r2*r2*math.Acos((d*d+r2*r2-r1*r1)/(2*d*r2)) -
+		0.5*math.Sqrt((-d+r1+r2)*(d+r1-r2)*(d-r1+r2)*(d+r1+r2))
+	return a / (math.Pi * r1 * r1)
+}
+
+// sunEcliptic returns the apparent ecliptic longitude of the sun in degrees,
+// without nutation, and its distance in astronomical units, c Julian centuries
+// after J2000 (Meeus, Astronomical Algorithms, chapter 25)
+func sunEcliptic(c float64) (lon, dist float64) {
+	l0 := 280.46646 + 36000.76983*c
+	m := (357.52911 + 35999.05029*c) * degrees
+	center := (1.914602-0.004817*c)*math.Sin(m) + (0.019993-0.000101*c)*math.Sin(2*m) + 0.000289*math.Sin(3*m)
+	e := 0.016708634 - 0.000042037*c
+	v := m + center*degrees
+	dist = 1.000001018 * (1 - e*e) / (1 + e*math.Cos(v))
+	// Corrected for aberration
+	return l0 + center - 0.00569, dist
+}
+
+// moonTerm is a periodic term of the lunar theory: multiples of D, M, M' and
+// F, and the coefficients of longitude and distance
+type moonTerm struct {
+	d, m, mp, f float64
+	lon, dist   float64
+}
+
+// Main periodic terms of the longitude in 1e-6 degrees and distance in meters
+// (Meeus, table 47.A)
+var moonLonTerms = []moonTerm{
+	{0, 0, 1, 0, 6288774, -20905355},
+	{2, 0, -1, 0, 1274027, -3699111},
+	{2, 0, 0, 0, 658314, -2955968},
+	{0, 0, 2, 0, 213618, -569925},
+	{0, 1, 0, 0, -185116, 48888},
+	{0, 0, 0, 2, -114332, -3149},
+	{2, 0, -2, 0, 58793, 246158},
+	{2, -1, -1, 0, 57066, -152138},
+	{2, 0, 1, 0, 53322, -170733},
+	{2, -1, 0, 0, 45758, -204586},
+	{0, 1, -1, 0, -40923, -129620},
+	{1, 0, 0, 0, -34720, 108743},
+	{0, 1, 1, 0, -30383, 104755},
+	{2, 0, 0, -2, 15327, 10321},
+	{0, 0, 1, 2, -12528, 0},
+	{0, 0, 1, -2, 10980, 79661},
+	{4, 0, -1, 0, 10675, -34782},
+	{0, 0, 3, 0, 10034, -23210},
+	{4, 0, -2, 0, 8548, -21636},
+	{2, 1, -1, 0, -7888, 24208},
+	{2, 1, 0, 0, -6766, 30824},
+	{1, 0, -1, 0, -5163, -8379},
+	{1, 1, 0, 0, 4987, -16675},
+	{2, -1, 1, 0, 4036, -12831},
+}
+
+// Main periodic terms of the latitude in 1e-6 degrees (Meeus, table 47.B)
+var moonLatTerms = []moonTerm{
+	{0, 0, 0, 1, 5128122, 0},
+	{0, 0, 1, 1, 280602, 0},
+	{0, 0, 1, -1, 277693, 0},
+	{2, 0, 0, -1, 173237, 0},
+	{2, 0, -1, 1, 55413, 0},
+	{2, 0, -1, -1, 46271, 0},
+	{2, 0, 0, 1, 32573, 0},
+	{0, 0, 2, 1, 17198, 0},
+	{2, 0, 1, -1, 9266, 0},
+	{0, 0, 2, -1, 8822, 0},
+	{2, -1, 0, -1, 8216, 0},
+	{2, 0, -2, -1, 4324, 0},
+	{2, 0, 1, 1, 4200, 0},
+}
+
+// moonEcliptic returns the geocentric ecliptic longitude and latitude of the
+// moon in degrees, without nutation, and its distance in km, c Julian
+// centuries after J2000. The main terms of Meeus, chapter 47, are accurate to
+// about an arc minute.
+func moonEcliptic(c float64) (lon, lat, dist float64) {
+	lp := 218.3164477 + 481267.88123421*c
+	d := (297.8501921 + 445267.1114034*c) * degrees
+	m := (357.5291092 + 35999.0502909*c) * degrees
+	mp := (134.9633964 + 477198.8675055*c) * degrees
+	f := (93.2720950 + 483202.0175233*c) * degrees
+	e := 1 - 0.002516*c - 0.0000074*c*c
+
+	// Terms depending on the anomaly of the sun are scaled by the
+	// decreasing eccentricity of the Earth's orbit
+	eccentricity := func(t moonTerm) float64 {
+		return math.Pow(e, math.Abs(t.m))
+	}
+
+	var sumLon, sumDist, sumLat float64
+	for _, t := range moonLonTerms {
+		arg := t.d*d + t.m*m + t.mp*mp + t.f*f
+		sumLon += t.lon * eccentricity(t) * math.Sin(arg)
+		sumDist += t.dist * eccentricity(t) * math.Cos(arg)
+	}
+	for _, t := range moonLatTerms {
+		sumLat += t.lon * eccentricity(t) * math.Sin(t.d*d+t.m*m+t.mp*mp+t.f*f)
+	}
+
+	// Action of Venus and Jupiter and the flattening of the Earth
+	a1 := (119.75 + 131.849*c) * degrees
+	a2 := (53.09 + 479264.290*c) * degrees
+	a3 := (313.45 + 481266.484*c) * degrees
+	lpr := lp * degrees
+	sumLon += 3958*math.Sin(a1) + 1962*math.Sin(lpr-f) + 318*math.Sin(a2)
+	sumLat += -2235*math.Sin(lpr) + 382*math.Sin(a3) + 175*math.Sin(a1-f) + 175*math.Sin(a1+f) +
+		127*math.Sin(lpr-mp) - 115*math.Sin(lpr+mp)
+
+	return lp + sumLon/1e6, sumLat / 1e6, 385000.56 + sumDist/1000
+}
+
+// eclipticToEquatorial returns the unit vector of ecliptic coordinates in the
+// equatorial frame
+func eclipticToEquatorial(lon, lat, obliquity float64) [3]float64 {
+	return [3]float64{
+		math.Cos(lat) * math.Cos(lon),
+		math.Cos(lat)*math.Sin(lon)*math.Cos(obliquity) - math.Sin(lat)*math.Sin(obliquity),
+		math.Cos(lat)*math.Sin(lon)*math.Sin(obliquity) + math.Sin(lat)*math.Cos(obliquity),
+	}
+}
+
+func scale(v [3]float64, f float64) [3]float64 { return [3]float64{v[0] * f, v[1] * f, v[2] * f} }
+func sub(a, b [3]float64) [3]float64           { return [3]float64{a[0] - b[0], a[1] - b[1], a[2] - b[2]} }
+func dot(a, b [3]float64) float64              { return a[0]*b[0] + a[1]*b[1] + a[2]*b[2] }
+func norm(v [3]float64) float64                { return math.Sqrt(dot(v, v)) }
+
+// eclipseCollector exports the obscuration of the sun by solar eclipses at a
+// location today and tomorrow, which providers usually don't account for
+type eclipseCollector struct {
+	plane       planeGeometry
+	obscuration *prometheus.Desc
+	maximum     *prometheus.Desc
+
+	mu    sync.Mutex
+	cache map[string]eclipseCircumstances // By local date
+}
+
+func newEclipseCollector(plane planeGeometry) *eclipseCollector {
+	return &eclipseCollector{
+		plane: plane,
+		obscuration: prometheus.NewDesc(
+			"forecast_solar_eclipse_obscuration_ratio",
+			"Maximum fraction of the sun's disk covered by a solar eclipse while the sun is up, computed locally",
+			[]string{"day"},
+			nil,
+		),
+		maximum: prometheus.NewDesc(
+			"forecast_solar_eclipse_maximum_timestamp_seconds",
+			"Time of the maximum obscuration of the sun by a solar eclipse, computed locally",
+			[]string{"day"},
+			nil,
+		),
+		cache: map[string]eclipseCircumstances{},
+	}
+}
+
+func (c *eclipseCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.obscuration
+	ch <- c.maximum
+}
+
+func (c *eclipseCollector) Collect(ch chan<- prometheus.Metric) {
+	now := clock()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for i, day := range []string{"today", "tomorrow"} {
+		e := c.circumstances(today.AddDate(0, 0, i))
+		ch <- prometheus.MustNewConstMetric(c.obscuration, prometheus.GaugeValue, e.obscuration, day)
+		if e.obscuration > 0 {
+			ch <- prometheus.MustNewConstMetric(c.maximum, prometheus.GaugeValue, float64(e.time.Unix()), day)
+		}
+	}
+}
+
+// circumstances returns the eclipse of the day starting at midnight, which is
+// computed once per day
+func (c *eclipseCollector) circumstances(midnight time.Time) eclipseCircumstances {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := midnight.Format(time.DateOnly)
+	e, ok := c.cache[key]
+	if !ok {
+		e = localEclipse(c.plane.latitude, c.plane.longitude, midnight, midnight.AddDate(0, 0, 1))
+		for k := range c.cache {
+			if k < key {
+				delete(c.cache, k)
+			}
+		}
+		c.cache[key] = e
+	}
+	return e
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// expiringRegisterer registers collectors that stop exporting their metrics
+// while the forecast of a source is expired, so dashboards show missing data
+// instead of a days-old forecast that looks plausible
+type expiringRegisterer struct {
+	prometheus.Registerer
+	source *source
+}
+
+func (r expiringRegisterer) Register(c prometheus.Collector) error {
+	return r.Registerer.Register(expiringCollector{Collector: c, source: r.source})
+}
+
+func (r expiringRegisterer) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+type expiringCollector struct {
+	prometheus.Collector
+	source *source
+}
+
+func (c expiringCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.source.expired.Load() {
+		return
+	}
+	c.Collector.Collect(ch)
+}
+
+// expiring returns a registerer for the metrics derived from the forecast of
+// the source
+func (s *source) expiring(reg prometheus.Registerer) prometheus.Registerer {
+	return expiringRegisterer{Registerer: reg, source: s}
+}
+
+// checkExpiry expires the forecast after the configured number of
+// consecutive failed polls, and revives it after a successful one
+func (s *source) checkExpiry() {
+	if s.opts.expireAfter <= 0 {
+		return
+	}
+	expired := s.failures >= s.opts.expireAfter
+	if expired == s.expired.Load() {
+		return
+	}
+	if expired {
+		log.Printf("Forecast of %s expired after %d consecutive failed polls, no longer exporting it", s.name, s.failures)
+	} else {
+		log.Printf("Forecast of %s is current again", s.name)
+	}
+	s.expired.Store(expired)
+	s.changed()
+}
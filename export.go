@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// exportHistory implements the export subcommand, writing the history store
+// as table for analysis in e.g. pandas or DuckDB
+func exportHistory(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	var (
+		historyFile = fs.String("history-file", "", "History file to export")
+		format      = fs.String("format", "parquet", "Output format: parquet or csv")
+		data        = fs.String("data", "daily", "Data to export: daily (forecasts by issue day) or hourly (latest hourly forecasts)")
+		output      = fs.String("output", "-", "File to write to, - for stdout")
+	)
+	fs.Parse(args)
+
+	if *historyFile == "" {
+		log.Fatal("-history-file is required")
+	}
+	h, err := openHistoryReadOnly(*historyFile)
+	if err != nil {
+		log.Fatalf("Error opening history: %s", err)
+	}
+
+	var columns []parquetColumn
+	switch *data {
+	case "daily":
+		columns, err = h.dailyColumns()
+	case "hourly":
+		columns = h.hourlyColumns()
+	default:
+		log.Fatalf("Unknown data: %s", *data)
+	}
+	if err != nil {
+		log.Fatalf("Error exporting history: %s", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("Error creating output: %s", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch *format {
+	case "parquet":
+		err = writeParquet(w, columns)
+	case "csv":
+		err = writeCSV(w, columns)
+	default:
+		log.Fatalf("Unknown format: %s", *format)
+	}
+	if err != nil {
+		log.Fatalf("Error writing output: %s", err)
+	}
+}
+
+// dailyColumns returns the daily forecasts as source, issued, day and
+// watt_hours columns
+func (h *historyStore) dailyColumns() ([]parquetColumn, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	source := parquetColumn{name: "source", strings: []string{}}
+	issued := parquetColumn{name: "issued", dates: []time.Time{}}
+	day := parquetColumn{name: "day", dates: []time.Time{}}
+	wh := parquetColumn{name: "watt_hours", doubles: []float64{}}
+
+	for _, name := range sortedKeys(h.Sources) {
+		forecasts := h.Sources[name]
+		for _, issue := range sortedKeys(forecasts) {
+			issuedDate, err := time.Parse(time.DateOnly, issue)
+			if err != nil {
+				return nil, err
+			}
+			for _, d := range sortedKeys(forecasts[issue]) {
+				date, err := time.Parse(time.DateOnly, d)
+				if err != nil {
+					return nil, err
+				}
+				source.strings = append(source.strings, name)
+				issued.dates = append(issued.dates, issuedDate)
+				day.dates = append(day.dates, date)
+				wh.doubles = append(wh.doubles, forecasts[issue][d])
+			}
+		}
+	}
+	return []parquetColumn{source, issued, day, wh}, nil
+}
+
+// hourlyColumns returns the hourly forecasts as source, period and watt_hours
+// columns
+func (h *historyStore) hourlyColumns() []parquetColumn {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	source := parquetColumn{name: "source", strings: []string{}}
+	period := parquetColumn{name: "period_end", strings: []string{}}
+	wh := parquetColumn{name: "watt_hours", doubles: []float64{}}
+
+	for _, name := range sortedKeys(h.Hourly) {
+		for _, p := range sortedKeys(h.Hourly[name]) {
+			source.strings = append(source.strings, name)
+			period.strings = append(period.strings, p)
+			wh.doubles = append(wh.doubles, h.Hourly[name][p])
+		}
+	}
+	return []parquetColumn{source, period, wh}
+}
+
+// writeCSV writes the columns as CSV with a header
+func writeCSV(w io.Writer, columns []parquetColumn) error {
+	c := csv.NewWriter(w)
+	header := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = col.name
+	}
+	c.Write(header)
+
+	rows, _ := columns[0].encode()
+	for r := 0; r < rows; r++ {
+		record := make([]string, len(columns))
+		for i, col := range columns {
+			switch {
+			case col.strings != nil:
+				record[i] = col.strings[r]
+			case col.dates != nil:
+				record[i] = col.dates[r].Format(time.DateOnly)
+			default:
+				record[i] = strconv.FormatFloat(col.doubles[r], 'f', -1, 64)
+			}
+		}
+		c.Write(record)
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
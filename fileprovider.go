@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileProvider serves stored API responses instead of calling an API, for
+// developing dashboards offline. A directory of responses is replayed in
+// name order, advancing by one file each poll.
+type fileProvider struct {
+	path       string
+	shiftDates bool // Shift the forecast so its first day is today
+
+	mu   sync.Mutex
+	next int
+}
+
+func (p *fileProvider) fetch(client *http.Client) (*apiResponse, error) {
+	path, err := p.nextFile()
+	if err != nil {
+		return nil, err
+	}
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &apiResponse{}
+	if err := json.Unmarshal(body, res); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %s", path, err)
+	}
+	if invalid := normalizePeriods(res); len(invalid) > 0 {
+		log.Printf("Ignoring invalid periods of %s: %s", path, strings.Join(invalid, ", "))
+	}
+	if p.shiftDates {
+		if err := shiftForecast(res, time.Now()); err != nil {
+			return nil, fmt.Errorf("error shifting %s: %s", path, err)
+		}
+	}
+	return res, nil
+}
+
+// nextFile returns the path itself or the next JSON file of the directory
+func (p *fileProvider) nextFile() (string, error) {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return p.path, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(p.path, "*.json"))
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("no JSON files in %s", p.path)
+	}
+	sort.Strings(files)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	file := files[p.next%len(files)]
+	p.next++
+	return file, nil
+}
+
+// shiftForecast moves all dates of the forecast by whole days, so the first
+// day of the forecast is the day of now
+func shiftForecast(res *apiResponse, now time.Time) error {
+	days := sortedKeys(res.Result.WattHoursDay)
+	if len(days) == 0 {
+		return nil
+	}
+	first, err := time.Parse(time.DateOnly, days[0])
+	if err != nil {
+		return err
+	}
+	today, _ := time.Parse(time.DateOnly, now.Format(time.DateOnly))
+	offset := int(today.Sub(first).Hours() / 24)
+	if offset == 0 {
+		return nil
+	}
+
+	shift := func(m map[string]float64) (map[string]float64, error) {
+		shifted := make(map[string]float64, len(m))
+		for key, v := range m {
+			// Keys are dates, optionally followed by a time
+			day, rest, _ := strings.Cut(key, " ")
+			t, err := time.Parse(time.DateOnly, day)
+			if err != nil {
+				return nil, err
+			}
+			if rest != "" {
+				rest = " " + rest
+			}
+			shifted[t.AddDate(0, 0, offset).Format(time.DateOnly)+rest] = v
+		}
+		return shifted, nil
+	}
+	for _, m := range []*map[string]float64{&res.Result.Watts, &res.Result.WattHoursDay, &res.Result.WattHoursPeriod} {
+		shifted, err := shift(*m)
+		if err != nil {
+			return err
+		}
+		*m = shifted
+	}
+	return nil
+}
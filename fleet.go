@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// site is a plant to forecast. Coordinates and orientation are passed to the
+// providers as given.
+type site struct {
+	Name              string `yaml:"name"`
+	Latitude          string `yaml:"latitude"`
+	Longitude         string `yaml:"longitude"`
+	Declination       string `yaml:"declination"`
+	Azimuth           string `yaml:"azimuth"`
+	Kwp               string `yaml:"kwp"`
+	SolcastResourceID string `yaml:"solcast_resource_id"`
+	APIToken          string `yaml:"api_token"` // Reveals the site on the JSON API
+
+	// API key of the forecast.solar account of the site instead of -api-key,
+	// and the name of the account in metrics and logs
+	APIKey  string `yaml:"api_key"`
+	Account string `yaml:"account"`
+
+	// Obstructions shading the site, or all planes of the site
+	Obstructions []obstruction `yaml:"obstructions"`
+
+	// Shares of the owners of a shared plant in percent
+	Owners map[string]float64 `yaml:"owners"`
+
+	// Factor applied to the forecasts of the site, or all planes of the
+	// site, e.g. 0.92 for aging panels, soiling and cable losses. 0 and 1
+	// leave them as they are.
+	Derating float64 `yaml:"derating"`
+
+	// Damping of the forecasts of forecast.solar in the morning and evening
+	// from 0 to 1 of the site, or all planes of the site, instead of
+	// -damping-morning and -damping-evening
+	DampingMorning *float64 `yaml:"damping_morning"`
+	DampingEvening *float64 `yaml:"damping_evening"`
+
+	// Name of the plane of a site with several planes, polled separately
+	Plane string `yaml:"-"`
+
+	// All planes of the site of a plane, including it, polled in a single
+	// request, see combinePlanes
+	Combined []plane `yaml:"-"`
+}
+
+// key identifies a site, or a plane of a site with several planes
+func (s site) key() string {
+	if s.Plane == "" {
+		return s.Name
+	}
+	return s.Name + "/" + s.Plane
+}
+
+// fleetSite is a site of the fleet file, optionally with several planes such
+// as east and west roofs sharing the coordinates of the site
+type fleetSite struct {
+	site   `yaml:",inline"`
+	Planes []plane `yaml:"planes"`
+}
+
+// plane is one of several planes of a site
+type plane struct {
+	Name              string        `yaml:"name"`
+	Declination       string        `yaml:"declination"`
+	Azimuth           string        `yaml:"azimuth"`
+	Kwp               string        `yaml:"kwp"`
+	SolcastResourceID string        `yaml:"solcast_resource_id"`
+	Obstructions      []obstruction `yaml:"obstructions"`
+	Derating          float64       `yaml:"derating"`
+	DampingMorning    *float64      `yaml:"damping_morning"`
+	DampingEvening    *float64      `yaml:"damping_evening"`
+}
+
+// fleetConfig is the file listing the sites polled in fleet mode
+type fleetConfig struct {
+	Sites []fleetSite `yaml:"sites"`
+}
+
+// splitSourceName splits the name of a source into provider, site and plane,
+// which are empty if not part of the name
+func splitSourceName(name string) (provider, site, plane string) {
+	provider, rest, _ := strings.Cut(name, "/")
+	site, plane, _ = strings.Cut(rest, "/")
+	return provider, site, plane
+}
+
+// loadFleet reads and validates the fleet file at path. Sites with several
+// planes are returned as one site per plane.
+func loadFleet(path string) ([]site, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseFleet(body)
+}
+
+// parseFleet validates the content of a fleet file like loadFleet
+func parseFleet(body []byte) ([]site, error) {
+	var config fleetConfig
+	if err := yaml.Unmarshal(body, &config); err != nil {
+		return nil, err
+	}
+	if len(config.Sites) == 0 {
+		return nil, fmt.Errorf("no sites configured")
+	}
+
+	var sites []site
+	names := map[string]bool{}
+	tokens := map[string]bool{}
+	for i, fs := range config.Sites {
+		s := fs.site
+		if s.Name == "" {
+			return nil, fmt.Errorf("site %d has no name", i+1)
+		}
+		if strings.Contains(s.Name, "/") {
+			return nil, fmt.Errorf("name of site %s must not contain /", s.Name)
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("duplicate site %s", s.Name)
+		}
+		names[s.Name] = true
+		if s.APIToken != "" {
+			if tokens[s.APIToken] {
+				return nil, fmt.Errorf("site %s reuses the API token of another site", s.Name)
+			}
+			tokens[s.APIToken] = true
+		}
+
+		for _, o := range s.Obstructions {
+			if err := o.validate(); err != nil {
+				return nil, fmt.Errorf("site %s: %s", s.Name, err)
+			}
+		}
+		if err := validateOwners(s.Owners); err != nil {
+			return nil, fmt.Errorf("site %s: %s", s.Name, err)
+		}
+		if s.Derating < 0 {
+			return nil, fmt.Errorf("derating of site %s must be positive", s.Name)
+		}
+		if !validDamping(s.DampingMorning) || !validDamping(s.DampingEvening) {
+			return nil, fmt.Errorf("damping of site %s must be between 0 and 1", s.Name)
+		}
+
+		if len(fs.Planes) == 0 {
+			if s.Latitude == "" || s.Longitude == "" || s.Declination == "" || s.Azimuth == "" || s.Kwp == "" {
+				return nil, fmt.Errorf("site %s requires latitude, longitude, declination, azimuth and kwp", s.Name)
+			}
+			sites = append(sites, s)
+			continue
+		}
+
+		if s.Latitude == "" || s.Longitude == "" {
+			return nil, fmt.Errorf("site %s requires latitude and longitude", s.Name)
+		}
+		if s.Declination != "" || s.Azimuth != "" || s.Kwp != "" {
+			return nil, fmt.Errorf("site %s with planes configures declination, azimuth and kwp per plane", s.Name)
+		}
+		planes := map[string]bool{}
+		for j, p := range fs.Planes {
+			if p.Name == "" || strings.Contains(p.Name, "/") {
+				return nil, fmt.Errorf("plane %d of site %s requires a name without /", j+1, s.Name)
+			}
+			if planes[p.Name] {
+				return nil, fmt.Errorf("duplicate plane %s of site %s", p.Name, s.Name)
+			}
+			planes[p.Name] = true
+			if p.Declination == "" || p.Azimuth == "" || p.Kwp == "" {
+				return nil, fmt.Errorf("plane %s of site %s requires declination, azimuth and kwp", p.Name, s.Name)
+			}
+			if p.Derating < 0 {
+				return nil, fmt.Errorf("derating of plane %s of site %s must be positive", p.Name, s.Name)
+			}
+			if !validDamping(p.DampingMorning) || !validDamping(p.DampingEvening) {
+				return nil, fmt.Errorf("damping of plane %s of site %s must be between 0 and 1", p.Name, s.Name)
+			}
+			for _, o := range p.Obstructions {
+				if err := o.validate(); err != nil {
+					return nil, fmt.Errorf("plane %s of site %s: %s", p.Name, s.Name, err)
+				}
+			}
+
+			ps := s
+			ps.Plane, ps.Declination, ps.Azimuth, ps.Kwp = p.Name, p.Declination, p.Azimuth, p.Kwp
+			if p.SolcastResourceID != "" {
+				ps.SolcastResourceID = p.SolcastResourceID
+			}
+			if p.Derating != 0 {
+				ps.Derating = p.Derating
+			}
+			if p.DampingMorning != nil {
+				ps.DampingMorning = p.DampingMorning
+			}
+			if p.DampingEvening != nil {
+				ps.DampingEvening = p.DampingEvening
+			}
+			ps.Obstructions = append(append([]obstruction(nil), s.Obstructions...), p.Obstructions...)
+			sites = append(sites, ps)
+		}
+	}
+	return sites, nil
+}
+
+// combinePlanes has the planes of each site polled in a single request of the
+// multi-plane API of forecast.solar, which returns the sum of the planes. Each
+// plane keeps its source and series, serving its share of the sum, and the
+// requests of the planes are coalesced as they are the same. Sites with
+// shaded planes or planes derated or damped differently are kept apart, as
+// the shade, the derating and the damping apply to a plane.
+func combinePlanes(sites []site) []site {
+	shaded := map[string]bool{}
+	settings := map[string]string{}
+	planes := map[string][]plane{}
+	for _, s := range sites {
+		if s.Plane == "" {
+			continue
+		}
+		if len(s.Obstructions) > 0 {
+			shaded[s.Name] = true
+		}
+		current := fmt.Sprint(s.Derating, formatDamping(s.DampingMorning), formatDamping(s.DampingEvening))
+		if previous, ok := settings[s.Name]; ok && previous != current {
+			shaded[s.Name] = true
+		}
+		settings[s.Name] = current
+		planes[s.Name] = append(planes[s.Name], plane{Name: s.Plane, Declination: s.Declination, Azimuth: s.Azimuth, Kwp: s.Kwp})
+	}
+
+	combined := make([]site, 0, len(sites))
+	for _, s := range sites {
+		if s.Plane != "" && !shaded[s.Name] && len(planes[s.Name]) > 1 {
+			s.Combined = planes[s.Name]
+		}
+		combined = append(combined, s)
+	}
+	return combined
+}
+
+// validDamping returns whether a damping factor is unset or between 0 and 1
+func validDamping(d *float64) bool {
+	return d == nil || (*d >= 0 && *d <= 1)
+}
+
+// formatDamping formats a damping factor for the API, empty if unset
+func formatDamping(d *float64) string {
+	if d == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*d, 'f', -1, 64)
+}
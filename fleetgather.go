@@ -0,0 +1,177 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// fleetCacheMaxAge bounds how long the gathered metrics of a source are
+// reused, as some collectors such as the history depend on the current day
+const fleetCacheMaxAge = time.Minute
+
+// fleetGatherer gathers the metrics of the sources of a fleet from a registry
+// per source, reusing the gathered families of a source until it changes.
+// Gathering the labeled metrics of all sites on every scrape is what makes
+// scrapes of large fleets slow, while they only change when a site is polled.
+type fleetGatherer struct {
+	base prometheus.Gatherer // Metrics not belonging to a source
+
+	mu      sync.Mutex
+	entries []*fleetEntry
+}
+
+type fleetEntry struct {
+	source   *source
+	registry *prometheus.Registry
+
+	// Guarded by fleetGatherer.mu
+	volatile   []prometheus.Collector // Collected on every scrape
+	generation uint64
+	gathered   time.Time
+	families   []*dto.MetricFamily
+}
+
+func newFleetGatherer(base prometheus.Gatherer) *fleetGatherer {
+	return &fleetGatherer{base: base}
+}
+
+// add returns the registerers of the metrics of a source, which get the given
+// labels: one for metrics that only change when the source changes, and one
+// for metrics depending on the time such as the data age
+func (g *fleetGatherer) add(s *source, labels prometheus.Labels) (reg, volatile prometheus.Registerer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	e := &fleetEntry{source: s, registry: prometheus.NewRegistry()}
+	g.entries = append(g.entries, e)
+	return prometheus.WrapRegistererWith(labels, e.registry), prometheus.WrapRegistererWith(labels, volatileRegisterer{g, e})
+}
+
+// volatileRegisterer adds the collectors of metrics depending on the time to
+// an entry. They are collected by one registry per scrape for all sources, as
+// gathering a registry per source allocates its buffers for every source.
+type volatileRegisterer struct {
+	g *fleetGatherer
+	e *fleetEntry
+}
+
+func (r volatileRegisterer) Register(c prometheus.Collector) error {
+	r.g.mu.Lock()
+	defer r.g.mu.Unlock()
+	r.e.volatile = append(r.e.volatile, c)
+	return nil
+}
+
+func (r volatileRegisterer) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (r volatileRegisterer) Unregister(c prometheus.Collector) bool {
+	r.g.mu.Lock()
+	defer r.g.mu.Unlock()
+	for i, v := range r.e.volatile {
+		if v == c {
+			r.e.volatile = append(r.e.volatile[:i:i], r.e.volatile[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// collectorList collects several collectors as one unchecked collector, the
+// registry gathering it still rejects inconsistent and duplicate metrics
+type collectorList []prometheus.Collector
+
+func (cs collectorList) Describe(chan<- *prometheus.Desc) {}
+
+func (cs collectorList) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range cs {
+		c.Collect(ch)
+	}
+}
+
+// remove drops the metrics of a source
+func (g *fleetGatherer) remove(s *source) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for i, e := range g.entries {
+		if e.source == s {
+			g.entries = append(g.entries[:i:i], g.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// Gather merges the metrics of the base gatherer and all sources, gathering
+// a source again only if it changed since the last scrape. The returned
+// metrics are shared between scrapes and must not be modified.
+func (g *fleetGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.base.Gather()
+	var errs prometheus.MultiError
+	errs.Append(err)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	var volatile collectorList
+	for _, e := range g.entries {
+		volatile = append(volatile, e.volatile...)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(volatile)
+	gathered, err := reg.Gather()
+	errs.Append(err)
+
+	// The families of the base gatherer and the volatile metrics are gathered
+	// for this scrape and can be extended in place
+	byName := make(map[string]*dto.MetricFamily, len(families)+len(gathered))
+	for _, mf := range families {
+		byName[mf.GetName()] = mf
+	}
+	for _, mf := range gathered {
+		if merged, ok := byName[mf.GetName()]; ok {
+			merged.Metric = append(merged.Metric, mf.Metric...)
+			continue
+		}
+		byName[mf.GetName()] = mf
+		families = append(families, mf)
+	}
+	for _, e := range g.entries {
+		if gen := e.source.generation.Load(); gen != e.generation || now.Sub(e.gathered) > fleetCacheMaxAge {
+			gathered, err := e.registry.Gather()
+			errs.Append(err)
+			e.families, e.generation, e.gathered = gathered, gen, now
+		}
+
+		for _, mf := range e.families {
+			merged, ok := byName[mf.GetName()]
+			if !ok {
+				// Copy the family, the cached one must not grow
+				merged = &dto.MetricFamily{
+					Name:   mf.Name,
+					Help:   mf.Help,
+					Type:   mf.Type,
+					Metric: make([]*dto.Metric, 0, len(mf.Metric)*len(g.entries)),
+				}
+				byName[mf.GetName()] = merged
+				families = append(families, merged)
+			}
+			merged.Metric = append(merged.Metric, mf.Metric...)
+		}
+	}
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].GetName() < families[j].GetName()
+	})
+	return families, errs.MaybeUnwrap()
+}
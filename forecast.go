@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	promVersion "github.com/prometheus/common/version"
+)
+
+// apiResponse is a forecast in the format of the forecast.solar API. Other
+// providers convert their forecasts to it, so all metrics are derived the same.
+type apiResponse struct {
+	Result struct {
+		Watts           map[string]float64 `json:"watts"`
+		WattHoursDay    map[string]float64 `json:"watt_hours_day"`
+		WattHoursPeriod map[string]float64 `json:"watt_hours_period"`
+		WattHours       map[string]float64 `json:"watt_hours,omitempty"` // Cumulative per day
+	} `json:"result"`
+	Message struct {
+		Info struct {
+			Place    string  `json:"place"`
+			Timezone string  `json:"timezone"`
+			Distance float64 `json:"distance"`
+		} `json:"info"`
+		RateLimit *rateLimit `json:"ratelimit,omitempty"`
+	} `json:"message"`
+
+	// Weather forecast, only returned by weather providers
+	Weather *weatherForecast `json:"weather,omitempty"`
+
+	// Forecasts by quantile, e.g. "0.1" for the 10th percentile, only
+	// returned by probabilistic providers
+	Quantiles map[string]*quantileForecast `json:"quantiles,omitempty"`
+}
+
+// quantileForecast is the forecast of a quantile, like the result of the
+// forecast.solar API
+type quantileForecast struct {
+	Watts           map[string]float64 `json:"watts"`
+	WattHoursDay    map[string]float64 `json:"watt_hours_day"`
+	WattHoursPeriod map[string]float64 `json:"watt_hours_period"`
+}
+
+func newQuantileForecast() *quantileForecast {
+	return &quantileForecast{
+		Watts:           map[string]float64{},
+		WattHoursDay:    map[string]float64{},
+		WattHoursPeriod: map[string]float64{},
+	}
+}
+
+// provider retrieves forecasts from a forecast service
+type provider interface {
+	fetch(client *http.Client) (*apiResponse, error)
+}
+
+// statusError is returned by providers when the API responds with an
+// unexpected HTTP status
+type statusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status %s", e.Status)
+}
+
+// maintenanceError is returned by providers when the API announces
+// maintenance, until the announced end if known
+type maintenanceError struct {
+	Text  string
+	Until time.Time
+}
+
+func (e *maintenanceError) Error() string {
+	return "maintenance: " + e.Text
+}
+
+// newStatusError returns the error of an unexpected response status,
+// detecting maintenance announced by forecast.solar with 503 and a message
+// mentioning it. The end is taken from the Retry-After header.
+func newStatusError(r *http.Response) error {
+	if r.StatusCode == http.StatusServiceUnavailable {
+		var body struct {
+			Message struct {
+				Text string `json:"text"`
+			} `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&body)
+		if strings.Contains(strings.ToLower(body.Message.Text), "maintenance") {
+			return &maintenanceError{Text: body.Message.Text, Until: retryAfter(r.Header.Get("Retry-After"), clock())}
+		}
+	}
+	return &statusError{StatusCode: r.StatusCode, Status: r.Status}
+}
+
+// retryAfter parses a Retry-After header in seconds or as HTTP date, returning
+// the zero time if it is missing or invalid
+func retryAfter(v string, now time.Time) time.Time {
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return now.Add(time.Duration(seconds) * time.Second)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t
+	}
+	return time.Time{}
+}
+
+// doRequest performs the request. Errors only mention the host, as API keys
+// may be part of the path or query.
+func doRequest(client *http.Client, req *http.Request) (*http.Response, error) {
+	r, err := client.Do(req)
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL.Host, urlErr.Err)
+	}
+	return r, err
+}
+
+// timeoutTransport limits upstream requests including reading the response
+// body to a timeout via the request context
+type timeoutTransport struct {
+	next    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	r, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	r.Body = &cancelOnClose{ReadCloser: r.Body, cancel: cancel}
+	return r, nil
+}
+
+// userAgentTransport identifies the exporter to the APIs
+type userAgentTransport struct {
+	next      http.RoundTripper
+	userAgent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// newAPIClient returns the client of requests to the APIs via the transport,
+// logged on the debug level, identifying the exporter and timing out after
+// timeout
+func newAPIClient(transport http.RoundTripper, timeout time.Duration) *http.Client {
+	transport = &debugTransport{next: transport}
+	transport = &userAgentTransport{
+		next:      transport,
+		userAgent: fmt.Sprintf("forecast_solar_exporter/%s (+https://github.com/chr4/forecast_solar_exporter)", promVersion.Version),
+	}
+	return &http.Client{Transport: &timeoutTransport{next: transport, timeout: timeout}}
+}
+
+// cancelOnClose cancels the context of a request once its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// errNotModified is returned by providers if the forecast did not change
+// since the last request
+var errNotModified = errors.New("not modified")
+
+// validators are the cache validators of the last response, sent with the
+// next request so an unchanged forecast is not transferred again
+type validators struct {
+	mu           sync.Mutex
+	etag         string
+	lastModified string
+}
+
+// getJSON is the package level getJSON with a conditional request, returning
+// errNotModified if the server responds 304 Not Modified
+func (c *validators) getJSON(client *http.Client, req *http.Request, v interface{}) error {
+	c.mu.Lock()
+	if c.etag != "" {
+		req.Header.Set("If-None-Match", c.etag)
+	}
+	if c.lastModified != "" {
+		req.Header.Set("If-Modified-Since", c.lastModified)
+	}
+	c.mu.Unlock()
+
+	r, err := doRequest(client, req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode == http.StatusNotModified {
+		return errNotModified
+	}
+	if r.StatusCode != 200 {
+		return newStatusError(r)
+	}
+	if err := decodeJSON(r, v); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.etag, c.lastModified = r.Header.Get("ETag"), r.Header.Get("Last-Modified")
+	c.mu.Unlock()
+	return nil
+}
+
+// reset forgets the validators, so the next request retrieves the forecast
+// even if it did not change
+func (c *validators) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.etag, c.lastModified = "", ""
+}
+
+// getJSON performs the request and decodes the JSON response into v
+func getJSON(client *http.Client, req *http.Request, v interface{}) error {
+	r, err := doRequest(client, req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != 200 {
+		return newStatusError(r)
+	}
+
+	if err := decodeJSON(r, v); err != nil {
+		return err
+	}
+	return nil
+}
+
+type forecastCollector struct {
+	metric *prometheus.Desc
+
+	mu   sync.Mutex
+	Date time.Time
+	Kwh  float64
+}
+
+func (c *forecastCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.metric
+}
+
+func (c *forecastCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Nothing to export before the first forecast
+	if c.Date.IsZero() {
+		return
+	}
+	s := prometheus.NewMetricWithTimestamp(c.Date, prometheus.MustNewConstMetric(c.metric, prometheus.GaugeValue, c.Kwh))
+	ch <- s
+}
+
+func (c *forecastCollector) set(date time.Time, kwh float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Date = date
+	c.Kwh = kwh
+}
+
+func (c *forecastCollector) get() (time.Time, float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.Date, c.Kwh
+}
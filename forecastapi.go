@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// forecastResponse is the forecast of a source as served by /api/v1/forecast
+type forecastResponse struct {
+	Retrieved      *time.Time `json:"retrieved"`
+	DataAgeSeconds *float64   `json:"data_age_seconds"`
+	Place          string     `json:"place,omitempty"`
+	Timezone       string     `json:"timezone,omitempty"`
+	DistanceKm     float64    `json:"distance_km"`
+
+	WattHoursDay    map[string]float64 `json:"watt_hours_day"`
+	Watts           map[string]float64 `json:"watts"`
+	WattHoursPeriod map[string]float64 `json:"watt_hours_period"`
+}
+
+// newForecastResponse returns the last forecast of a source
+func newForecastResponse(s *source) forecastResponse {
+	f := forecastResponse{
+		WattHoursDay:    map[string]float64{},
+		Watts:           map[string]float64{},
+		WattHoursPeriod: map[string]float64{},
+	}
+	if last := s.lastSuccess.Load(); last != 0 {
+		retrieved := time.Unix(0, last)
+		age := time.Since(retrieved).Seconds()
+		f.Retrieved, f.DataAgeSeconds = &retrieved, &age
+	}
+	if res := s.forecast.Load(); res != nil {
+		f.Place = res.Message.Info.Place
+		f.Timezone = res.Message.Info.Timezone
+		f.DistanceKm = res.Message.Info.Distance
+		f.WattHoursDay = res.Result.WattHoursDay
+		f.Watts = res.Result.Watts
+		f.WattHoursPeriod = res.Result.WattHoursPeriod
+	}
+	return f
+}
+
+// newForecastResponseAsOf returns the forecast of a source served at t, as
+// recorded in the history
+func newForecastResponseAsOf(history *historyStore, s *source, t time.Time) forecastResponse {
+	f := forecastResponse{
+		WattHoursDay:    map[string]float64{},
+		Watts:           map[string]float64{},
+		WattHoursPeriod: map[string]float64{},
+	}
+	if rev, ok := history.asOf(s.name, t); ok {
+		age := t.Sub(rev.Time).Seconds()
+		f.Retrieved, f.DataAgeSeconds = &rev.Time, &age
+		f.WattHoursDay = rev.WattHoursDay
+		f.Watts = rev.Watts
+		f.WattHoursPeriod = rev.WattHoursPeriod
+	}
+	return f
+}
+
+// forecastHandler serves the last forecast of each source as JSON, or of a
+// single source with the source parameter. Sources without forecast yet are
+// included with null retrieved and data_age_seconds. With as_of, the forecasts
+// served at that time are looked up in the history.
+func forecastHandler(sources *sourceSet, history *historyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.FormValue("source")
+
+		var asOf time.Time
+		if v := r.FormValue("as_of"); v != "" {
+			if history == nil {
+				http.Error(w, "as_of requires -history-file", http.StatusBadRequest)
+				return
+			}
+			var err error
+			if asOf, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid as_of %q, expected RFC 3339 time such as 2024-05-01T06:00:00Z", v), http.StatusBadRequest)
+				return
+			}
+		}
+
+		result := map[string]forecastResponse{}
+		for _, s := range sources.all() {
+			if name != "" && name != s.name || !visible(r, s.name) {
+				continue
+			}
+			if asOf.IsZero() {
+				result[s.name] = newForecastResponse(s)
+			} else {
+				result[s.name] = newForecastResponseAsOf(history, s, asOf)
+			}
+		}
+		if name != "" && len(result) == 0 {
+			http.Error(w, fmt.Sprintf("Unknown source %q", name), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// rateLimit is the request budget reported by the forecast.solar API, which
+// is shared by all requests with the same API key or from the same address
+type rateLimit struct {
+	Zone      string `json:"zone"`   // E.g. "IP 192.0.2.1" on the public plan
+	Period    int    `json:"period"` // Seconds
+	Limit     int    `json:"limit"`
+	Remaining int    `json:"remaining"`
+}
+
+// evenDelay returns the delay between polls of sources sharing the budget
+// that spreads them evenly across the whole budget
+func (l *rateLimit) evenDelay(sharing int) time.Duration {
+	if l.Limit <= 0 {
+		return 0
+	}
+	return time.Duration(l.Period) * time.Second * time.Duration(sharing) / time.Duration(l.Limit)
+}
+
+// delay returns the delay between polls of sources sharing the budget that
+// spreads them evenly across the remaining budget, waiting for a whole period
+// once it is used up
+func (l *rateLimit) delay(sharing int) time.Duration {
+	period := time.Duration(l.Period) * time.Second
+	if l.Remaining <= 0 {
+		return period
+	}
+	delay := period * time.Duration(sharing) / time.Duration(l.Remaining)
+	if even := l.evenDelay(sharing); delay < even {
+		delay = even
+	}
+	if delay > period {
+		delay = period
+	}
+	return delay
+}
+
+// shareRateLimit tells the forecast.solar sources how many sources share the
+// rate limit of the address or key, which is all sources of the same account.
+// Sources with the same request coalesce their polls and count once.
+func shareRateLimit(sources []*source) {
+	byAccount := map[string][]*source{}
+	for _, s := range sources {
+		if p, ok := s.provider.(*forecastSolar); ok {
+			byAccount[p.apiKey] = append(byAccount[p.apiKey], s)
+		}
+	}
+	for _, shared := range byAccount {
+		n := distinctRequests(shared)
+		for _, s := range shared {
+			s.rateLimitShare.Store(int64(n))
+		}
+	}
+}
+
+// ownAccount returns whether a source polls forecast.solar with the API key
+// of its site, which has its own rate limit apart from the address and
+// -api-key shared by the other sources and peers
+func ownAccount(s *source) bool {
+	p, ok := s.provider.(*forecastSolar)
+	return ok && p.apiKey != ""
+}
+
+// forecastSolar retrieves estimates from the forecast.solar API
+type forecastSolar struct {
+	url    string
+	apiKey string // Of the site, empty for -api-key or the public plan
+
+	// forecast.solar only updates the estimates every 15 minutes or so,
+	// unchanged ones are not transferred again
+	validators
+}
+
+func (p *forecastSolar) fetch(client *http.Client) (*apiResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &apiResponse{}
+	if err := p.getJSON(client, req, res); err != nil {
+		return nil, err
+	}
+	if invalid := normalizePeriods(res); len(invalid) > 0 {
+		log.Printf("Ignoring invalid periods of forecast.solar: %s", strings.Join(invalid, ", "))
+	}
+	return res, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// geocodeResult is the location an address resolves to
+type geocodeResult struct {
+	Latitude    string `json:"lat"`
+	Longitude   string `json:"lon"`
+	DisplayName string `json:"display_name"`
+}
+
+// geocode resolves an address to coordinates with the search API of
+// Nominatim, or a compatible service at baseURL
+func geocode(client *http.Client, baseURL, address string) (geocodeResult, error) {
+	u := strings.TrimSuffix(baseURL, "/") + "/search?" + url.Values{
+		"q":      {address},
+		"format": {"jsonv2"},
+		"limit":  {"1"},
+	}.Encode()
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return geocodeResult{}, err
+	}
+	r, err := doRequest(client, req)
+	if err != nil {
+		return geocodeResult{}, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return geocodeResult{}, fmt.Errorf("unexpected status %s", r.Status)
+	}
+
+	var results []geocodeResult
+	if err := json.NewDecoder(r.Body).Decode(&results); err != nil {
+		return geocodeResult{}, fmt.Errorf("error decoding JSON: %s", err)
+	}
+	if len(results) == 0 {
+		return geocodeResult{}, fmt.Errorf("address %q not found", address)
+	}
+	return results[0], nil
+}
+
+// newAddressInfo returns the info metric of the location an address resolved
+// to, so fat-fingered addresses can be spotted
+func newAddressInfo(address string, res geocodeResult) prometheus.Gauge {
+	g := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "forecast_solar_address_info",
+		Help: "Location the configured address resolved to on startup",
+		ConstLabels: prometheus.Labels{
+			"address":      address,
+			"display_name": res.DisplayName,
+			"latitude":     res.Latitude,
+			"longitude":    res.Longitude,
+		},
+	})
+	g.Set(1)
+	return g
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// graphiteWriter pushes the last forecast of every source to Graphite via the
+// plaintext protocol in an interval. Like InfluxDB, carbon accepts points in
+// the future, so the forecasted power curve is written with the timestamps it
+// applies to.
+type graphiteWriter struct {
+	address string
+	prefix  string
+	timeout time.Duration
+	sources *sourceSet
+}
+
+// graphiteEscaper replaces the characters that would split or break a
+// Graphite path in names of sources
+var graphiteEscaper = strings.NewReplacer(".", "_", " ", "_", "/", ".")
+
+// push writes the power and energy per period and the energy per day of all
+// sources, returning the number of datapoints written
+func (w *graphiteWriter) push(ctx context.Context) (int, error) {
+	var body bytes.Buffer
+	n := 0
+	for _, s := range w.sources.all() {
+		res := s.forecast.Load()
+		if res == nil {
+			continue
+		}
+		path := graphiteEscaper.Replace(s.name)
+		if w.prefix != "" {
+			path = w.prefix + "." + path
+		}
+		written, err := writeGraphite(&body, path, res)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %s", s.name, err)
+		}
+		n += written
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	dialer := net.Dialer{Timeout: w.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", w.address)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if err := conn.SetWriteDeadline(time.Now().Add(w.timeout)); err != nil {
+		return 0, err
+	}
+	if _, err := body.WriteTo(conn); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// writeGraphite writes a forecast as plaintext lines below path, e.g.
+// forecast_solar.watts, returning the number of lines written
+func writeGraphite(body *bytes.Buffer, path string, res *apiResponse) (int, error) {
+	// Periods are given in local time of the plant
+	loc := res.location()
+
+	periods := make([]string, 0, len(res.Result.Watts))
+	for period := range res.Result.Watts {
+		periods = append(periods, period)
+	}
+	sort.Strings(periods)
+	n := 0
+	for _, period := range periods {
+		t, err := time.ParseInLocation(time.DateTime, period, loc)
+		if err != nil {
+			return 0, fmt.Errorf("invalid period %q: %s", period, err)
+		}
+		fmt.Fprintf(body, "%s.watts %s %d\n", path, strconv.FormatFloat(res.Result.Watts[period], 'f', -1, 64), t.Unix())
+		n++
+		if wh, ok := res.Result.WattHoursPeriod[period]; ok {
+			fmt.Fprintf(body, "%s.watt_hours_period %s %d\n", path, strconv.FormatFloat(wh, 'f', -1, 64), t.Unix())
+			n++
+		}
+	}
+	for date, wh := range res.Result.WattHoursDay {
+		t, err := time.ParseInLocation(time.DateOnly, date, loc)
+		if err != nil {
+			return 0, fmt.Errorf("invalid date %q: %s", date, err)
+		}
+		fmt.Fprintf(body, "%s.watt_hours_day %s %d\n", path, strconv.FormatFloat(wh, 'f', -1, 64), t.Unix())
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// healthcheck requests /-/healthy of a running exporter and exits with 0 if
+// it is healthy and 1 otherwise, for container health checks in images
+// without curl or wget
+func healthcheck(args []string) {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	var (
+		listenAddr = fs.String("listen-address", ":9111", "The address the exporter listens on, or unix:/path/to/socket")
+		timeout    = fs.Duration("timeout", 5*time.Second, "Timeout of the health check")
+	)
+	fs.Parse(args)
+
+	if err := checkHealth(*listenAddr, *timeout); err != nil {
+		fmt.Fprintf(os.Stderr, "Unhealthy: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// checkHealth requests /-/healthy at the listen address of the exporter
+func checkHealth(listenAddr string, timeout time.Duration) error {
+	client := &http.Client{Timeout: timeout}
+	url := "http://localhost/-/healthy"
+	if path, ok := strings.CutPrefix(listenAddr, "unix:"); ok {
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		}
+	} else {
+		host, port, err := net.SplitHostPort(listenAddr)
+		if err != nil {
+			return err
+		}
+		// Listening on all interfaces includes the loopback interface
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			host = "localhost"
+		}
+		url = "http://" + net.JoinHostPort(host, port) + "/-/healthy"
+	}
+
+	r, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", r.Status)
+	}
+	return nil
+}
@@ -0,0 +1,940 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Interval of applying the retention tiers and saving the journal to the
+// history store
+const historyMaintenanceInterval = time.Hour
+
+// Size of the journal below which it is not saved to the store unless the
+// retention changed the store. Larger journals are saved once they exceed the
+// store, so each byte of the store is rewritten about once per byte appended,
+// which keeps the wear of flash storage low without an encoding of its own.
+const historyJournalMin = 1 << 20
+
+// historyStore records the daily forecasts of each source by the day they
+// were issued on, so forecasts issued on previous days can be compared with
+// the latest one
+type historyStore struct {
+	mu       sync.Mutex
+	path     string
+	compress bool
+	size     int // Size of the persisted store in bytes
+
+	// Days to keep all issued and hourly forecasts, and days to keep the
+	// final daily forecasts after that, 0 keeps them forever
+	rawRetention   int
+	dailyRetention int
+	// Revisions kept per source, the oldest are dropped first, 0 keeps all
+	// of them within the raw retention
+	maxRevisions int
+
+	readOnly         bool // Loaded for reading only, changes are not persisted
+	journalSize      int  // Of changes appended since the store was saved
+	revisionsTrimmed bool // Rewrite the revisions file on the next maintenance
+
+	// Forecasted watt hours by forecast day, by issue day, by source
+	Sources map[string]map[string]map[string]float64 `json:"sources"`
+
+	// Latest forecasted watt hours by period end, by source
+	Hourly map[string]map[string]float64 `json:"hourly,omitempty"`
+
+	// Actually produced watt hours by day, if the actual production is known
+	Actual map[string]float64 `json:"actual,omitempty"`
+
+	// Forecasted watt hours by day as frozen at the end of the day, by source
+	Final map[string]map[string]float64 `json:"final,omitempty"`
+
+	// Forecasts in the order they were retrieved, by source, to look up what
+	// was served at a given time. Unchanged forecasts are not recorded again.
+	// They are appended to their own file, so recording one doesn't rewrite
+	// the store.
+	Revisions map[string][]forecastRevision `json:"-"`
+
+	// Days the exporter did not run on, so their accuracy is unknown
+	Unknown map[string]bool `json:"unknown,omitempty"`
+}
+
+// forecastRevision is a forecast of a source as retrieved at a time
+type forecastRevision struct {
+	Time            time.Time          `json:"time"`
+	WattHoursDay    map[string]float64 `json:"watt_hours_day"`
+	Watts           map[string]float64 `json:"watts"`
+	WattHoursPeriod map[string]float64 `json:"watt_hours_period"`
+}
+
+// historyRevision is a line of the revisions file
+type historyRevision struct {
+	Source string `json:"source"`
+	forecastRevision
+}
+
+// historyChange is a line of the journal, holding the changes of the store
+// since it was saved last, so recording a forecast doesn't rewrite the store
+type historyChange struct {
+	Source       string             `json:"source,omitempty"`
+	Issued       string             `json:"issued,omitempty"`
+	WattHoursDay map[string]float64 `json:"watt_hours_day,omitempty"` // Issued on Issued
+	Hourly       map[string]float64 `json:"hourly,omitempty"`         // Changed periods only
+	Actual       map[string]float64 `json:"actual,omitempty"`
+	Final        map[string]float64 `json:"final,omitempty"`
+}
+
+// newHistory returns an empty history store persisted at path
+func newHistory(path string, compress bool) *historyStore {
+	return &historyStore{
+		path:      path,
+		compress:  compress,
+		Sources:   map[string]map[string]map[string]float64{},
+		Hourly:    map[string]map[string]float64{},
+		Actual:    map[string]float64{},
+		Final:     map[string]map[string]float64{},
+		Revisions: map[string][]forecastRevision{},
+		Unknown:   map[string]bool{},
+	}
+}
+
+// openHistory loads the history store from path, starting with an empty one
+// if the file does not exist yet, and applies the changes of its journal.
+// Compressed stores are detected when loading, compress only selects the
+// format of subsequent saves.
+func openHistory(path string, compress bool) (*historyStore, error) {
+	h := newHistory(path, compress)
+	if err := h.load(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// openHistoryReadOnly loads the history store from path like openHistory,
+// without ever writing its files, e.g. to export it. Changes are kept in
+// memory. An empty path returns an empty store.
+func openHistoryReadOnly(path string) (*historyStore, error) {
+	h := newHistory(path, false)
+	h.readOnly = true
+	if path == "" {
+		return h, nil
+	}
+	if err := h.load(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// load reads the store and its journals from the files
+func (h *historyStore) load() error {
+	body, err := os.ReadFile(h.path)
+	if os.IsNotExist(err) {
+		return h.loadJournals()
+	}
+	if err != nil {
+		return err
+	}
+	h.size = len(body)
+
+	// Gzip magic number
+	if bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		if body, err = io.ReadAll(r); err != nil {
+			return err
+		}
+	}
+	if err := json.Unmarshal(body, h); err != nil {
+		return err
+	}
+	if h.Hourly == nil {
+		h.Hourly = map[string]map[string]float64{}
+	}
+	if h.Actual == nil {
+		h.Actual = map[string]float64{}
+	}
+	if h.Final == nil {
+		h.Final = map[string]map[string]float64{}
+	}
+	if h.Unknown == nil {
+		h.Unknown = map[string]bool{}
+	}
+
+	// Stores of previous versions contain the revisions, which are moved to
+	// the revisions file
+	if _, err := os.Stat(h.revisionsPath()); os.IsNotExist(err) {
+		var legacy struct {
+			Revisions map[string][]forecastRevision `json:"revisions"`
+		}
+		if err := json.Unmarshal(body, &legacy); err != nil {
+			return err
+		}
+		if len(legacy.Revisions) > 0 {
+			h.Revisions = legacy.Revisions
+			if err := h.writeRevisions(); err != nil {
+				return err
+			}
+		}
+	}
+	return h.loadJournals()
+}
+
+// loadJournals reads the revisions and the changes of the journal
+func (h *historyStore) loadJournals() error {
+	if err := h.loadRevisions(); err != nil {
+		return err
+	}
+	return loadJSONLines(h.journalPath(), !h.readOnly, func(line []byte) error {
+		var c historyChange
+		if err := json.Unmarshal(line, &c); err != nil {
+			return err
+		}
+		h.apply(c)
+		h.journalSize += len(line)
+		return nil
+	})
+}
+
+// journalPath returns the path of the journal, a JSON lines file next to the
+// store holding the changes since it was saved
+func (h *historyStore) journalPath() string {
+	return h.path + ".journal"
+}
+
+// apply applies a change of the journal to the store, the caller must hold
+// the lock
+func (h *historyStore) apply(c historyChange) {
+	if c.WattHoursDay != nil {
+		if h.Sources[c.Source] == nil {
+			h.Sources[c.Source] = map[string]map[string]float64{}
+		}
+		h.Sources[c.Source][c.Issued] = c.WattHoursDay
+	}
+	if len(c.Hourly) > 0 && h.Hourly[c.Source] == nil {
+		h.Hourly[c.Source] = map[string]float64{}
+	}
+	for period, wh := range c.Hourly {
+		h.Hourly[c.Source][period] = wh
+	}
+	for day, wh := range c.Actual {
+		h.Actual[day] = wh
+	}
+	if len(c.Final) > 0 && h.Final[c.Source] == nil {
+		h.Final[c.Source] = map[string]float64{}
+	}
+	for day, wh := range c.Final {
+		h.Final[c.Source][day] = wh
+	}
+}
+
+// commit applies a change and appends it to the journal, the caller must hold
+// the lock
+func (h *historyStore) commit(c historyChange) error {
+	h.apply(c)
+	if h.readOnly {
+		return nil
+	}
+	n, err := appendJSONLine(h.journalPath(), c)
+	h.journalSize += n
+	return err
+}
+
+// revisionsPath returns the path of the revisions file, a JSON lines file
+// next to the store
+func (h *historyStore) revisionsPath() string {
+	return h.path + ".revisions"
+}
+
+// loadRevisions reads the revisions file if it exists
+func (h *historyStore) loadRevisions() error {
+	err := loadJSONLines(h.revisionsPath(), !h.readOnly, func(line []byte) error {
+		var rev historyRevision
+		if err := json.Unmarshal(line, &rev); err != nil {
+			return err
+		}
+		h.Revisions[rev.Source] = append(h.Revisions[rev.Source], rev.forecastRevision)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for source := range h.Revisions {
+		h.trimRevisions(source)
+	}
+	return nil
+}
+
+// loadJSONLines passes each line of a JSON lines file to decode, if the file
+// exists. A last line cut off while appending, e.g. by a crash or a full disk,
+// is skipped and with repair truncated, so lines are appended after the
+// complete ones.
+func loadJSONLines(path string, repair bool, decode func(line []byte) error) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	truncate := func(offset int64) error {
+		log.Printf("Skipping incomplete last line of %s", path)
+		if !repair {
+			return nil
+		}
+		return os.Truncate(path, offset)
+	}
+	reader := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				return truncate(offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := decode(line); err != nil {
+			if _, last := reader.Peek(1); last == io.EOF {
+				return truncate(offset)
+			}
+			return fmt.Errorf("error decoding %s: %s", path, err)
+		}
+		offset += int64(len(line))
+	}
+}
+
+// appendJSONLine appends v as line to a JSON lines file, returning the bytes
+// written
+func appendJSONLine(path string, v any) (int, error) {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	n, err := f.Write(append(line, '\n'))
+	if err != nil {
+		f.Close()
+		return n, err
+	}
+	return n, f.Close()
+}
+
+// trimRevisions drops the oldest revisions of a source beyond the maximum,
+// the revisions file is rewritten on the next maintenance. The caller must
+// hold the lock.
+func (h *historyStore) trimRevisions(source string) {
+	revs := h.Revisions[source]
+	if h.maxRevisions <= 0 || len(revs) <= h.maxRevisions {
+		return
+	}
+	h.Revisions[source] = append([]forecastRevision(nil), revs[len(revs)-h.maxRevisions:]...)
+	h.revisionsTrimmed = true
+}
+
+// writeRevisions rewrites the revisions file after revisions were pruned,
+// the caller must hold the lock
+func (h *historyStore) writeRevisions() error {
+	if h.readOnly {
+		return nil
+	}
+	var body []byte
+	for _, source := range sortedKeys(h.Revisions) {
+		for _, rev := range h.Revisions[source] {
+			line, err := json.Marshal(historyRevision{Source: source, forecastRevision: rev})
+			if err != nil {
+				return err
+			}
+			body = append(append(body, line...), '\n')
+		}
+	}
+	return writeFileAtomic(h.revisionsPath(), body)
+}
+
+// setRetention configures the retention tiers and the revisions kept per
+// source, applied on the next maintenance
+func (h *historyStore) setRetention(rawDays, dailyDays, maxRevisions int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.rawRetention = rawDays
+	h.dailyRetention = dailyDays
+	h.maxRevisions = maxRevisions
+	for source := range h.Revisions {
+		h.trimRevisions(source)
+	}
+}
+
+// run maintains the store until ctx is cancelled, see maintain
+func (h *historyStore) run(ctx context.Context) {
+	for {
+		if err := h.maintain(clock()); err != nil {
+			log.Printf("Error maintaining history: %s", err)
+		}
+		if !sleep(ctx, historyMaintenanceInterval) {
+			return
+		}
+	}
+}
+
+// maintain applies the retention tiers, rewrites the revisions file if
+// revisions were dropped and saves the store if it changed or its journal
+// grew large
+func (h *historyStore) maintain(now time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	pruned, err := h.prune(now)
+	if err != nil {
+		return err
+	}
+	if h.revisionsTrimmed {
+		if err := h.writeRevisions(); err != nil {
+			return err
+		}
+		h.revisionsTrimmed = false
+	}
+	threshold := h.size
+	if threshold < historyJournalMin {
+		threshold = historyJournalMin
+	}
+	if !pruned && h.journalSize <= threshold {
+		return nil
+	}
+	return h.save()
+}
+
+// record stores the daily and hourly forecast of a source issued on the given
+// day, replacing earlier forecasts of the same day, and its revision retrieved
+// now. Changes are appended to the journal, a changed revision to the
+// revisions file.
+func (h *historyStore) record(source, issued string, res *apiResponse) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c := historyChange{Source: source, Issued: issued}
+	if !reflect.DeepEqual(h.Sources[source][issued], res.Result.WattHoursDay) {
+		c.WattHoursDay = copyValues(res.Result.WattHoursDay)
+	}
+	for period, wh := range res.Result.WattHoursPeriod {
+		if previous, ok := h.Hourly[source][period]; !ok || previous != wh {
+			if c.Hourly == nil {
+				c.Hourly = map[string]float64{}
+			}
+			c.Hourly[period] = wh
+		}
+	}
+	if c.WattHoursDay != nil || c.Hourly != nil {
+		if err := h.commit(c); err != nil {
+			return err
+		}
+	}
+
+	// The maps of the response are changed by later processing
+	rev := forecastRevision{
+		Time:            clock().UTC(),
+		WattHoursDay:    copyValues(res.Result.WattHoursDay),
+		Watts:           copyValues(res.Result.Watts),
+		WattHoursPeriod: copyValues(res.Result.WattHoursPeriod),
+	}
+	revs := h.Revisions[source]
+	if n := len(revs); n == 0 || !revs[n-1].sameForecast(rev) {
+		h.Revisions[source] = append(revs, rev)
+		h.trimRevisions(source)
+		if h.readOnly {
+			return nil
+		}
+		if _, err := appendJSONLine(h.revisionsPath(), historyRevision{Source: source, forecastRevision: rev}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyValues returns a copy of the values by day or period, nil for nil
+func copyValues(m map[string]float64) map[string]float64 {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]float64, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// recordActual stores the energy actually produced on a completed day
+func (h *historyStore) recordActual(day string, wh float64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.commit(historyChange{Actual: map[string]float64{day: wh}})
+}
+
+// recordFinal stores the forecast of a source for a day as frozen at the end
+// of the day
+func (h *historyStore) recordFinal(source, day string, wh float64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.commit(historyChange{Source: source, Final: map[string]float64{day: wh}})
+}
+
+// actuals returns the actual production of each day between from and to,
+// both inclusive
+func (h *historyStore) actuals(from, to string) map[string]float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	result := map[string]float64{}
+	for day, wh := range h.Actual {
+		if day >= from && day <= to {
+			result[day] = wh
+		}
+	}
+	return result
+}
+
+// unknownDays returns the days between from and to, both inclusive, the
+// exporter did not run on
+func (h *historyStore) unknownDays(from, to string) map[string]bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	result := map[string]bool{}
+	for day := range h.Unknown {
+		if day >= from && day <= to {
+			result[day] = true
+		}
+	}
+	return result
+}
+
+// reconcile marks the days between the last day recorded and today as
+// unknown after the exporter did not run for a day or more, so they are left
+// out of accuracy statistics instead of being compared with forecasts issued
+// before the gap. It returns the days marked.
+func (h *historyStore) reconcile(now time.Time) ([]string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var last string
+	for _, forecasts := range h.Sources {
+		for issued := range forecasts {
+			if issued > last {
+				last = issued
+			}
+		}
+	}
+	for day := range h.Actual {
+		if day > last {
+			last = day
+		}
+	}
+	for _, days := range h.Final {
+		for day := range days {
+			if day > last {
+				last = day
+			}
+		}
+	}
+	for day := range h.Unknown {
+		if day > last {
+			last = day
+		}
+	}
+	// Nothing was recorded yet
+	if last == "" {
+		return nil, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, last, now.Location())
+	if err != nil {
+		return nil, fmt.Errorf("invalid day %q: %s", last, err)
+	}
+
+	// The actual production of the day the exporter stopped on was not
+	// completed either, if it is recorded at all
+	var marked []string
+	today := now.Format(time.DateOnly)
+	if _, ok := h.Actual[last]; !ok && len(h.Actual) > 0 && !h.Unknown[last] && last < today {
+		h.Unknown[last] = true
+		marked = append(marked, last)
+	}
+	for d := t.AddDate(0, 0, 1); d.Format(time.DateOnly) < today; d = d.AddDate(0, 0, 1) {
+		day := d.Format(time.DateOnly)
+		h.Unknown[day] = true
+		marked = append(marked, day)
+	}
+	if len(marked) == 0 {
+		return nil, nil
+	}
+	return marked, h.save()
+}
+
+// prune applies the retention tiers: forecasts issued before the raw
+// retention are downsampled to the latest forecast of each day, hourly
+// forecasts and revisions are dropped. It returns whether the store changed,
+// the revisions file is rewritten right away. The caller must hold the lock.
+func (h *historyStore) prune(now time.Time) (bool, error) {
+	changed, revisionsPruned := false, false
+	if h.rawRetention > 0 {
+		cutoff := now.AddDate(0, 0, -h.rawRetention).Format(time.DateOnly)
+		for _, forecasts := range h.Sources {
+			// Latest issue of each day
+			latest := map[string]string{}
+			for issued, forecast := range forecasts {
+				for day := range forecast {
+					if issued > latest[day] {
+						latest[day] = issued
+					}
+				}
+			}
+			for issued, forecast := range forecasts {
+				if issued >= cutoff {
+					continue
+				}
+				for day := range forecast {
+					if latest[day] != issued {
+						delete(forecast, day)
+						changed = true
+					}
+				}
+				if len(forecast) == 0 {
+					delete(forecasts, issued)
+					changed = true
+				}
+			}
+		}
+
+		for _, periods := range h.Hourly {
+			for period := range periods {
+				// Periods are "YYYY-MM-DD hh:mm:ss", compare the date
+				if len(period) >= 10 && period[:10] < cutoff {
+					delete(periods, period)
+					changed = true
+				}
+			}
+		}
+
+		for source, revs := range h.Revisions {
+			i := sort.Search(len(revs), func(i int) bool { return revs[i].Time.Format(time.DateOnly) >= cutoff })
+			if i == len(revs) {
+				delete(h.Revisions, source)
+				revisionsPruned = true
+			} else if i > 0 {
+				h.Revisions[source] = append([]forecastRevision(nil), revs[i:]...)
+				revisionsPruned = true
+			}
+		}
+	}
+
+	if h.dailyRetention > 0 {
+		cutoff := now.AddDate(0, 0, -h.rawRetention-h.dailyRetention).Format(time.DateOnly)
+		for _, forecasts := range h.Sources {
+			for issued := range forecasts {
+				if issued < cutoff {
+					delete(forecasts, issued)
+					changed = true
+				}
+			}
+		}
+		for day := range h.Actual {
+			if day < cutoff {
+				delete(h.Actual, day)
+				changed = true
+			}
+		}
+		for _, days := range h.Final {
+			for day := range days {
+				if day < cutoff {
+					delete(days, day)
+					changed = true
+				}
+			}
+		}
+		for day := range h.Unknown {
+			if day < cutoff {
+				delete(h.Unknown, day)
+				changed = true
+			}
+		}
+	}
+
+	if revisionsPruned {
+		if err := h.writeRevisions(); err != nil {
+			return changed, err
+		}
+	}
+	return changed, nil
+}
+
+// backfill stores past forecasts of a source as issued on the day they are
+// for, keeping forecasts already recorded. It returns the number of days added.
+func (h *historyStore) backfill(source string, forecast map[string]float64) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.Sources[source] == nil {
+		h.Sources[source] = map[string]map[string]float64{}
+	}
+
+	added := 0
+	for day, wh := range forecast {
+		if _, ok := h.Sources[source][day][day]; ok {
+			continue
+		}
+		if h.Sources[source][day] == nil {
+			h.Sources[source][day] = map[string]float64{}
+		}
+		h.Sources[source][day][day] = wh
+		added++
+	}
+
+	if added == 0 {
+		return 0, nil
+	}
+	return added, h.save()
+}
+
+// save persists the store and removes the journal, the caller must hold the
+// lock
+func (h *historyStore) save() error {
+	if h.readOnly {
+		return nil
+	}
+	body, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+
+	if h.compress {
+		var buf bytes.Buffer
+		w, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		w.Write(body)
+		if err := w.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+
+	if err := writeFileAtomic(h.path, body); err != nil {
+		return err
+	}
+	h.size = len(body)
+	// Changes of the journal are in the store now, applying them again
+	// after a crash before the removal doesn't change it
+	if err := os.Remove(h.journalPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	h.journalSize = 0
+	return nil
+}
+
+// register registers metrics about the size of the store
+func (h *historyStore) register(reg prometheus.Registerer) {
+	reg.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "forecast_solar_history_store_bytes",
+			Help: "Size of the persisted history store",
+		},
+		func() float64 {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			return float64(h.size + h.journalSize)
+		},
+	))
+	reg.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "forecast_solar_history_store_forecasts",
+			Help: "Number of daily forecasts in the history store",
+		},
+		func() float64 {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+
+			n := 0
+			for _, issues := range h.Sources {
+				for _, forecast := range issues {
+					n += len(forecast)
+				}
+			}
+			return float64(n)
+		},
+	))
+}
+
+// sameForecast returns whether two revisions contain the same forecast
+func (r forecastRevision) sameForecast(o forecastRevision) bool {
+	return reflect.DeepEqual(r.WattHoursDay, o.WattHoursDay) &&
+		reflect.DeepEqual(r.Watts, o.Watts) &&
+		reflect.DeepEqual(r.WattHoursPeriod, o.WattHoursPeriod)
+}
+
+// asOf returns the last forecast of a source retrieved at or before t, false
+// if there was none
+func (h *historyStore) asOf(source string, t time.Time) (forecastRevision, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	revs := h.Revisions[source]
+	i := sort.Search(len(revs), func(i int) bool { return revs[i].Time.After(t) })
+	if i == 0 {
+		return forecastRevision{}, false
+	}
+	return revs[i-1], true
+}
+
+// latest returns the latest forecast of each day between from and to, both
+// inclusive, by source. Forecasts frozen at the end of the day take
+// precedence.
+func (h *historyStore) latest(from, to string) map[string]map[string]float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	result := map[string]map[string]float64{}
+	for source, forecasts := range h.Sources {
+		days := map[string]float64{}
+		latestIssue := map[string]string{}
+		for issued, forecast := range forecasts {
+			for day, wh := range forecast {
+				if day < from || day > to || issued > day || issued < latestIssue[day] {
+					continue
+				}
+				days[day] = wh
+				latestIssue[day] = issued
+			}
+		}
+		for day, wh := range h.Final[source] {
+			if day >= from && day <= to {
+				days[day] = wh
+			}
+		}
+		result[source] = days
+	}
+	return result
+}
+
+// ServeHTTP returns the latest forecast of each day as JSON, limited by the
+// from and to parameters, defaulting to the last 30 days
+func (h *historyStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	to := r.FormValue("to")
+	if to == "" {
+		to = clock().Format(time.DateOnly)
+	}
+	from := r.FormValue("from")
+	if from == "" {
+		from = clock().AddDate(0, 0, -30).Format(time.DateOnly)
+	}
+	for _, day := range []string{from, to} {
+		if _, err := time.Parse(time.DateOnly, day); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid date %q, expected YYYY-MM-DD", day), http.StatusBadRequest)
+			return
+		}
+	}
+
+	latest := h.latest(from, to)
+	for source := range latest {
+		if !visible(r, source) {
+			delete(latest, source)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(latest)
+}
+
+// historyCollector compares the latest forecast of a source for today with
+// the forecasts for today issued on previous days
+type historyCollector struct {
+	history *historyStore
+	source  string
+	today   *prometheus.Desc
+	change  *prometheus.Desc
+}
+
+func newHistoryCollector(history *historyStore, source string) *historyCollector {
+	return &historyCollector{
+		history: history,
+		source:  source,
+		today: prometheus.NewDesc(
+			"forecast_solar_history_today",
+			"Solar harvest forecast for today as issued the given number of days ago",
+			[]string{"days_ago"},
+			nil,
+		),
+		change: prometheus.NewDesc(
+			"forecast_solar_history_today_change",
+			"Change of the latest solar harvest forecast for today compared to the one issued the given number of days ago",
+			[]string{"days_ago"},
+			nil,
+		),
+	}
+}
+
+func (c *historyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.today
+	ch <- c.change
+}
+
+func (c *historyCollector) Collect(ch chan<- prometheus.Metric) {
+	c.history.mu.Lock()
+	defer c.history.mu.Unlock()
+
+	forecasts := c.history.Sources[c.source]
+
+	// The latest issue day is today
+	issued := make([]string, 0, len(forecasts))
+	for day := range forecasts {
+		issued = append(issued, day)
+	}
+	if len(issued) == 0 {
+		return
+	}
+	sort.Strings(issued)
+	today := issued[len(issued)-1]
+	latest, ok := forecasts[today][today]
+	if !ok {
+		return
+	}
+
+	t, err := time.Parse(time.DateOnly, today)
+	if err != nil {
+		return
+	}
+	for _, day := range issued[:len(issued)-1] {
+		old, ok := forecasts[day][today]
+		if !ok {
+			continue
+		}
+		d, err := time.Parse(time.DateOnly, day)
+		if err != nil {
+			continue
+		}
+		daysAgo := strconv.Itoa(int(t.Sub(d).Hours() / 24))
+
+		ch <- prometheus.MustNewConstMetric(c.today, prometheus.GaugeValue, old, daysAgo)
+		ch <- prometheus.MustNewConstMetric(c.change, prometheus.GaugeValue, latest-old, daysAgo)
+	}
+}
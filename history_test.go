@@ -0,0 +1,212 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testHistoryForecast returns a forecast of two days with the given energy
+// in every hour
+func testHistoryForecast(wh float64) *apiResponse {
+	res := syntheticForecast(48, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	for period := range res.Result.WattHoursPeriod {
+		res.Result.WattHoursPeriod[period] = wh
+	}
+	res.Result.WattHoursDay = map[string]float64{"2024-05-01": 24 * wh, "2024-05-02": 24 * wh}
+	return res
+}
+
+// Recording a forecast appends to the journal and the revisions file instead
+// of saving the store, reopening applies them
+func TestHistoryJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+	h, err := openHistory(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.record("a", "2024-05-01", testHistoryForecast(100)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("got the store saved on recording, want only the journal")
+	}
+	journal, err := os.ReadFile(h.journalPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	revisions, err := os.ReadFile(h.revisionsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An unchanged forecast appends nothing
+	if err := h.record("a", "2024-05-01", testHistoryForecast(100)); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.ReadFile(h.journalPath()); len(again) != len(journal) {
+		t.Errorf("got the journal grown from %d to %d bytes by an unchanged forecast", len(journal), len(again))
+	}
+	if again, _ := os.ReadFile(h.revisionsPath()); len(again) != len(revisions) {
+		t.Errorf("got the revisions grown from %d to %d bytes by an unchanged forecast", len(revisions), len(again))
+	}
+
+	reopened, err := openHistory(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reopened.Sources, h.Sources) {
+		t.Errorf("got forecasts %v after reopening, want %v", reopened.Sources, h.Sources)
+	}
+	if !reflect.DeepEqual(reopened.Hourly, h.Hourly) {
+		t.Error("got other hourly forecasts after reopening")
+	}
+	if n := len(reopened.Revisions["a"]); n != 1 {
+		t.Errorf("got %d revisions after reopening, want 1", n)
+	}
+}
+
+// The journal is saved to the store once it outgrows it, or when the
+// retention changed the store
+func TestHistoryMaintain(t *testing.T) {
+	now := clock
+	defer func() { clock = now }()
+	clock = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+
+	path := filepath.Join(t.TempDir(), "history.json")
+	h, err := openHistory(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.record("a", "2024-05-01", testHistoryForecast(100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.maintain(clock()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("got a small journal saved to the store")
+	}
+
+	// Forecasts issued before the raw retention are downsampled
+	h.setRetention(1, 0, 0)
+	if err := h.maintain(clock().AddDate(0, 0, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("got no store saved after pruning: %s", err)
+	}
+	if _, err := os.Stat(h.journalPath()); !os.IsNotExist(err) {
+		t.Error("got the journal kept after saving the store")
+	}
+	reopened, err := openHistory(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reopened.Sources, h.Sources) {
+		t.Errorf("got forecasts %v after reopening, want %v", reopened.Sources, h.Sources)
+	}
+	if len(reopened.Hourly["a"]) != 0 || len(reopened.Revisions["a"]) != 0 {
+		t.Error("got hourly forecasts or revisions kept beyond the raw retention")
+	}
+}
+
+// Only the configured number of revisions is kept per source
+func TestHistoryMaxRevisions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+	h, err := openHistory(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.setRetention(0, 0, 2)
+	for _, wh := range []float64{100, 200, 300} {
+		if err := h.record("a", "2024-05-01", testHistoryForecast(wh)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	revs := h.Revisions["a"]
+	if len(revs) != 2 || revs[1].WattHoursDay["2024-05-01"] != 24*300 {
+		t.Fatalf("got %d revisions, want the last 2", len(revs))
+	}
+	if err := h.maintain(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := newHistory(path, false)
+	if err := reopened.load(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(reopened.Revisions["a"]); n != 2 {
+		t.Errorf("got %d revisions in the rewritten file, want 2", n)
+	}
+}
+
+// A last line cut off by a crash is skipped and truncated, so later lines are
+// appended after the complete ones
+func TestHistoryTruncatedLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+	h, err := openHistory(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.record("a", "2024-05-01", testHistoryForecast(100)); err != nil {
+		t.Fatal(err)
+	}
+	complete, err := os.ReadFile(h.revisionsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(h.revisionsPath(), append(append([]byte(nil), complete...), `{"source":"a","ti`...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read-only stores skip it without repairing the file
+	if _, err := openHistoryReadOnly(path); err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := os.ReadFile(h.revisionsPath()); len(body) == len(complete) {
+		t.Error("got the revisions file truncated by a read-only store")
+	}
+
+	h, err = openHistory(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := os.ReadFile(h.revisionsPath()); string(body) != string(complete) {
+		t.Errorf("got the revisions file %q, want the complete lines only", body)
+	}
+	if err := h.record("a", "2024-05-01", testHistoryForecast(200)); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := openHistory(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(reopened.Revisions["a"]); n != 2 {
+		t.Errorf("got %d revisions, want 2", n)
+	}
+}
+
+// Read-only stores keep changes in memory without writing any file
+func TestHistoryReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	h, err := openHistoryReadOnly(filepath.Join(dir, "history.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.record("a", "2024-05-01", testHistoryForecast(100)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.backfill("a", map[string]float64{"2024-04-30": 1000}); err != nil {
+		t.Fatal(err)
+	}
+	h.setRetention(1, 0, 1)
+	if err := h.maintain(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) > 0 {
+		t.Errorf("got %d files written by a read-only store, want none", len(files))
+	}
+}
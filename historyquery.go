@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// historyAggregations are the functions supported by the history query
+var historyAggregations = map[string]func(values []float64) float64{
+	"sum": func(values []float64) float64 {
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	},
+	"avg": func(values []float64) float64 {
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	},
+	"min": func(values []float64) float64 {
+		min := math.Inf(1)
+		for _, v := range values {
+			min = math.Min(min, v)
+		}
+		return min
+	},
+	"max": func(values []float64) float64 {
+		max := math.Inf(-1)
+		for _, v := range values {
+			max = math.Max(max, v)
+		}
+		return max
+	},
+	"count": func(values []float64) float64 {
+		return float64(len(values))
+	},
+}
+
+// historyBuckets map a day to the start of the bucket it is aggregated in
+var historyBuckets = map[string]func(day time.Time) time.Time{
+	"": func(day time.Time) time.Time {
+		return time.Time{}
+	},
+	"day": func(day time.Time) time.Time {
+		return day
+	},
+	"week": func(day time.Time) time.Time {
+		// Weeks start on Monday
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	},
+	"month": func(day time.Time) time.Time {
+		return day.AddDate(0, 0, 1-day.Day())
+	},
+	"year": func(day time.Time) time.Time {
+		return day.AddDate(0, 0, 1-day.YearDay())
+	},
+}
+
+// historyQueryHandler aggregates the latest forecasts of each day between
+// from and to with func (sum, avg, min, max or count), optionally by day,
+// week, month or year, for a source or all sources. Values are watt hours.
+func historyQueryHandler(h *historyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		to := r.FormValue("to")
+		if to == "" {
+			to = time.Now().Format(time.DateOnly)
+		}
+		from := r.FormValue("from")
+		if from == "" {
+			from = time.Now().AddDate(0, 0, -30).Format(time.DateOnly)
+		}
+		for _, day := range []string{from, to} {
+			if _, err := time.Parse(time.DateOnly, day); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid date %q, expected YYYY-MM-DD", day), http.StatusBadRequest)
+				return
+			}
+		}
+
+		name := r.FormValue("func")
+		if name == "" {
+			name = "sum"
+		}
+		aggregate, ok := historyAggregations[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("Unknown func %q, expected sum, avg, min, max or count", name), http.StatusBadRequest)
+			return
+		}
+		by := r.FormValue("by")
+		bucket, ok := historyBuckets[by]
+		if !ok {
+			http.Error(w, fmt.Sprintf("Unknown by %q, expected day, week, month or year", by), http.StatusBadRequest)
+			return
+		}
+
+		result := map[string]interface{}{}
+		for source, days := range h.latest(from, to) {
+			if s := r.FormValue("source"); s != "" && s != source || !visible(r, source) {
+				continue
+			}
+
+			values := map[time.Time][]float64{}
+			for day, wh := range days {
+				t, _ := time.Parse(time.DateOnly, day)
+				values[bucket(t)] = append(values[bucket(t)], wh)
+			}
+
+			if by == "" {
+				if v, ok := values[time.Time{}]; ok {
+					result[source] = aggregate(v)
+				}
+				continue
+			}
+			buckets := map[string]float64{}
+			for start, v := range values {
+				buckets[start.Format(time.DateOnly)] = aggregate(v)
+			}
+			result[source] = buckets
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
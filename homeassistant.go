@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Source of the statistics in Home Assistant, the prefix of their IDs
+const homeAssistantSource = "forecast_solar_exporter"
+
+// homeAssistantWriter imports the forecasted energy per hour into Home
+// Assistant as external long-term statistics via its WebSocket API, so the
+// forecast curve can be shown next to the production without an integration.
+type homeAssistantWriter struct {
+	url     string
+	token   string
+	timeout time.Duration
+
+	// Imported cumulative energy by hour by source, as Home Assistant expects
+	// the sum to continue where the previous import left off
+	mu   sync.Mutex
+	sums map[string]map[time.Time]float64
+}
+
+func newHomeAssistantWriter(baseURL, token string, timeout time.Duration) *homeAssistantWriter {
+	u := strings.TrimSuffix(baseURL, "/") + "/api/websocket"
+	u = strings.Replace(strings.Replace(u, "https://", "wss://", 1), "http://", "ws://", 1)
+	return &homeAssistantWriter{
+		url:     u,
+		token:   token,
+		timeout: timeout,
+		sums:    map[string]map[time.Time]float64{},
+	}
+}
+
+// homeAssistantStatisticID returns the ID of the statistic of a source, e.g.
+// forecast_solar_exporter:forecast_solar_home_east
+func homeAssistantStatisticID(source string) string {
+	id := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r - 'A' + 'a'
+		}
+		return '_'
+	}, source)
+	for strings.Contains(id, "__") {
+		id = strings.ReplaceAll(id, "__", "_")
+	}
+	return homeAssistantSource + ":" + strings.Trim(id, "_")
+}
+
+type homeAssistantStat struct {
+	Start time.Time `json:"start"`
+	State float64   `json:"state"`
+	Sum   float64   `json:"sum"`
+}
+
+// hourlyStats sums the energy per period into the hours the periods end in,
+// with the sum continuing from the previous import
+func (w *homeAssistantWriter) hourlyStats(source string, res *apiResponse) ([]homeAssistantStat, error) {
+	// Periods are given in local time of the plant
+	loc := res.location()
+
+	hours := map[time.Time]float64{}
+	for period, wh := range res.Result.WattHoursPeriod {
+		t, err := time.ParseInLocation(time.DateTime, period, loc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid period %q: %s", period, err)
+		}
+		// Periods ending on the full hour belong to the hour before
+		hours[t.Add(-time.Nanosecond).Truncate(time.Hour)] += wh
+	}
+	starts := make([]time.Time, 0, len(hours))
+	for start := range hours {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+	if len(starts) == 0 {
+		return nil, nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// The sum grows over time, so the largest one before the import is the
+	// last one. The hours of the past two days are kept for the next import.
+	var sum float64
+	sums := map[time.Time]float64{}
+	for hour, s := range w.sums[source] {
+		if hour.Before(starts[0]) && hour.After(starts[0].Add(-48*time.Hour)) {
+			sums[hour] = s
+			sum = math.Max(sum, s)
+		}
+	}
+	stats := make([]homeAssistantStat, 0, len(starts))
+	for _, start := range starts {
+		kwh := hours[start] / 1000
+		sum += kwh
+		sums[start] = sum
+		stats = append(stats, homeAssistantStat{Start: start, State: kwh, Sum: sum})
+	}
+	w.sums[source] = sums
+	return stats, nil
+}
+
+// write imports the forecasted energy per hour of a source as statistic,
+// returning the number of hours imported
+func (w *homeAssistantWriter) write(source string, res *apiResponse) (int, error) {
+	stats, err := w.hourlyStats(source, res)
+	if err != nil || len(stats) == 0 {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+	defer cancel()
+	conn, r, err := websocket.DefaultDialer.DialContext(ctx, w.url, nil)
+	if err != nil {
+		if r != nil {
+			return 0, fmt.Errorf("connecting: unexpected status %s", r.Status)
+		}
+		return 0, fmt.Errorf("connecting: %s", err)
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetReadDeadline(deadline)
+	conn.SetWriteDeadline(deadline)
+
+	// Home Assistant asks for the access token first
+	var msg struct {
+		Type    string `json:"type"`
+		ID      int    `json:"id"`
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+		Error   struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := conn.ReadJSON(&msg); err != nil {
+		return 0, fmt.Errorf("reading: %s", err)
+	}
+	if err := conn.WriteJSON(map[string]string{"type": "auth", "access_token": w.token}); err != nil {
+		return 0, fmt.Errorf("authenticating: %s", err)
+	}
+	if err := conn.ReadJSON(&msg); err != nil {
+		return 0, fmt.Errorf("authenticating: %s", err)
+	}
+	if msg.Type != "auth_ok" {
+		return 0, fmt.Errorf("authentication failed: %s", msg.Message)
+	}
+
+	id := homeAssistantStatisticID(source)
+	if err := conn.WriteJSON(map[string]interface{}{
+		"id":   1,
+		"type": "recorder/import_statistics",
+		"metadata": map[string]interface{}{
+			"statistic_id":        id,
+			"source":              homeAssistantSource,
+			"name":                "Solar forecast " + source,
+			"unit_of_measurement": "kWh",
+			"has_mean":            false,
+			"has_sum":             true,
+		},
+		"stats": stats,
+	}); err != nil {
+		return 0, fmt.Errorf("importing statistics: %s", err)
+	}
+	// Nothing is subscribed to, so the next message is the result
+	if err := conn.ReadJSON(&msg); err != nil {
+		return 0, fmt.Errorf("importing statistics: %s", err)
+	}
+	if !msg.Success {
+		return 0, fmt.Errorf("importing statistics %s failed: %s", id, msg.Error.Message)
+	}
+	return len(stats), nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// hourlyForecast holds the forecasted energy per hour of today and tomorrow
+type hourlyForecast struct {
+	mu     sync.Mutex
+	energy map[string]map[int]float64 // Watt hours by hour of the day, by day
+}
+
+// update replaces the hourly energy with the watt_hours_period values of an
+// API response, days are assigned to today and tomorrow in order. Periods are
+// in the local time of the plant at loc.
+func (f *hourlyForecast) update(periods map[string]float64, days []string, loc *time.Location) error {
+	dayNames := map[string]string{}
+	for i, date := range days {
+		switch i {
+		case 0:
+			dayNames[date] = "today"
+		case 1:
+			dayNames[date] = "tomorrow"
+		}
+	}
+
+	energy := map[string]map[int]float64{}
+	for period, wh := range periods {
+		t, err := time.ParseInLocation(time.DateTime, period, loc)
+		if err != nil {
+			return fmt.Errorf("invalid period %q: %s", period, err)
+		}
+
+		// Values are for the period ending at the given time
+		start := t.Add(-time.Second)
+		day, ok := dayNames[start.Format(time.DateOnly)]
+		if !ok {
+			continue
+		}
+		if energy[day] == nil {
+			energy[day] = map[int]float64{}
+		}
+		energy[day][start.Hour()] += wh
+	}
+
+	f.mu.Lock()
+	f.energy = energy
+	f.mu.Unlock()
+	return nil
+}
+
+// hourlyCollector exports the forecasted energy per hour with an hour label
+// instead of timestamps
+type hourlyCollector struct {
+	forecast *hourlyForecast
+	metric   *prometheus.Desc
+}
+
+func newHourlyCollector(forecast *hourlyForecast) *hourlyCollector {
+	return &hourlyCollector{
+		forecast: forecast,
+		metric: prometheus.NewDesc(
+			"forecast_solar_energy_kwh",
+			"Solar harvest forecast for the hour starting at the given hour of the day",
+			[]string{"day", "hour"},
+			nil,
+		),
+	}
+}
+
+func (c *hourlyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.metric
+}
+
+func (c *hourlyCollector) Collect(ch chan<- prometheus.Metric) {
+	c.forecast.mu.Lock()
+	defer c.forecast.mu.Unlock()
+
+	for day, hours := range c.forecast.energy {
+		for hour, wh := range hours {
+			ch <- prometheus.MustNewConstMetric(c.metric, prometheus.GaugeValue, wh/1000, day, strconv.Itoa(hour))
+		}
+	}
+}
+
+// dayPart is a named range of hours, starting at Start and ending before End
+type dayPart struct {
+	Name       string
+	Start, End int
+}
+
+// parseDayParts parses day parts in the format name=start-end[,...], e.g.
+// morning=6-12,afternoon=12-18
+func parseDayParts(s string) ([]dayPart, error) {
+	var parts []dayPart
+	for _, part := range strings.Split(s, ",") {
+		name, hours, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			return nil, fmt.Errorf("expected name=start-end, got %q", part)
+		}
+		start, end, found := strings.Cut(hours, "-")
+		if !found {
+			return nil, fmt.Errorf("expected start-end hours for %s, got %q", name, hours)
+		}
+
+		p := dayPart{Name: name}
+		var err error
+		if p.Start, err = strconv.Atoi(start); err != nil {
+			return nil, fmt.Errorf("invalid start hour for %s: %s", name, err)
+		}
+		if p.End, err = strconv.Atoi(end); err != nil {
+			return nil, fmt.Errorf("invalid end hour for %s: %s", name, err)
+		}
+		if p.Start < 0 || p.End > 24 || p.Start >= p.End {
+			return nil, fmt.Errorf("invalid hours for %s: %s", name, hours)
+		}
+		parts = append(parts, p)
+	}
+	return parts, nil
+}
+
+// dayPartsCollector exports the forecasted energy summed up per day part
+type dayPartsCollector struct {
+	forecast *hourlyForecast
+	parts    []dayPart
+	metric   *prometheus.Desc
+}
+
+func newDayPartsCollector(forecast *hourlyForecast, parts []dayPart) *dayPartsCollector {
+	return &dayPartsCollector{
+		forecast: forecast,
+		parts:    parts,
+		metric: prometheus.NewDesc(
+			"forecast_solar_day_part_kwh",
+			"Solar harvest forecast for the given part of the day",
+			[]string{"day", "part"},
+			nil,
+		),
+	}
+}
+
+func (c *dayPartsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.metric
+}
+
+func (c *dayPartsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.forecast.mu.Lock()
+	defer c.forecast.mu.Unlock()
+
+	for day, hours := range c.forecast.energy {
+		for _, part := range c.parts {
+			var wh float64
+			for hour := part.Start; hour < part.End; hour++ {
+				wh += hours[hour]
+			}
+			ch <- prometheus.MustNewConstMetric(c.metric, prometheus.GaugeValue, wh/1000, day, part.Name)
+		}
+	}
+}
+
+// hourlyPowerCollector exports the average forecasted power per hour, the
+// primary metric of the power metric set
+type hourlyPowerCollector struct {
+	forecast *hourlyForecast
+	metric   *prometheus.Desc
+}
+
+func newHourlyPowerCollector(forecast *hourlyForecast) *hourlyPowerCollector {
+	return &hourlyPowerCollector{
+		forecast: forecast,
+		metric: prometheus.NewDesc(
+			"forecast_solar_power_watts",
+			"Average forecasted power in the hour starting at the given hour of the day",
+			[]string{"day", "hour"},
+			nil,
+		),
+	}
+}
+
+func (c *hourlyPowerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.metric
+}
+
+func (c *hourlyPowerCollector) Collect(ch chan<- prometheus.Metric) {
+	c.forecast.mu.Lock()
+	defer c.forecast.mu.Unlock()
+
+	// The energy of an hour in Wh is its average power in W
+	for day, hours := range c.forecast.energy {
+		for hour, wh := range hours {
+			ch <- prometheus.MustNewConstMetric(c.metric, prometheus.GaugeValue, wh, day, strconv.Itoa(hour))
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// influxWriter writes forecasts to InfluxDB v2 via the line protocol. Unlike
+// Prometheus, InfluxDB accepts points in the future, so the forecasted power
+// curve is written with the timestamps it applies to.
+type influxWriter struct {
+	client *http.Client
+	url    string
+	token  string
+}
+
+func newInfluxWriter(client *http.Client, baseURL, org, bucket, token string) *influxWriter {
+	query := url.Values{}
+	query.Set("org", org)
+	query.Set("bucket", bucket)
+	query.Set("precision", "s")
+	return &influxWriter{
+		client: client,
+		url:    strings.TrimSuffix(baseURL, "/") + "/api/v2/write?" + query.Encode(),
+		token:  token,
+	}
+}
+
+// tagEscaper escapes tag values in the line protocol
+var tagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// write writes the power and energy per period as forecast_solar points and
+// the daily energy as forecast_solar_daily points, tagged with the source,
+// returning the number of points written
+func (w *influxWriter) write(name string, res *apiResponse) (int, error) {
+	// Periods are given in local time of the plant
+	loc := res.location()
+	tags := "source=" + tagEscaper.Replace(name)
+
+	var body bytes.Buffer
+	periods := make([]string, 0, len(res.Result.Watts))
+	for period := range res.Result.Watts {
+		periods = append(periods, period)
+	}
+	sort.Strings(periods)
+	for _, period := range periods {
+		t, err := time.ParseInLocation(time.DateTime, period, loc)
+		if err != nil {
+			return 0, fmt.Errorf("invalid period %q: %s", period, err)
+		}
+		fields := "watts=" + strconv.FormatFloat(res.Result.Watts[period], 'f', -1, 64)
+		if wh, ok := res.Result.WattHoursPeriod[period]; ok {
+			fields += ",watt_hours_period=" + strconv.FormatFloat(wh, 'f', -1, 64)
+		}
+		fmt.Fprintf(&body, "forecast_solar,%s %s %d\n", tags, fields, t.Unix())
+	}
+	for date, wh := range res.Result.WattHoursDay {
+		t, err := time.ParseInLocation(time.DateOnly, date, loc)
+		if err != nil {
+			return 0, fmt.Errorf("invalid date %q: %s", date, err)
+		}
+		fmt.Fprintf(&body, "forecast_solar_daily,%s watt_hours=%s %d\n", tags, strconv.FormatFloat(wh, 'f', -1, 64), t.Unix())
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.url, &body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Token "+w.token)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	r, err := doRequest(w.client, req)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusNoContent {
+		msg, _ := io.ReadAll(io.LimitReader(r.Body, 1024))
+		return 0, fmt.Errorf("unexpected status %s: %s", r.Status, bytes.TrimSpace(msg))
+	}
+	return len(periods) + len(res.Result.WattHoursDay), nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// sanitizeLabelName turns an arbitrary string into a valid Prometheus label name
+func sanitizeLabelName(name string) string {
+	name = invalidLabelChars.ReplaceAllString(name, "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// readDownwardAPI reads pod metadata from a Kubernetes downward API volume.
+// Every file becomes a label named after the file, the "labels" file is split
+// into one "label_<name>" label per pod label, like kube-state-metrics does.
+func readDownwardAPI(dir string) (prometheus.Labels, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := prometheus.Labels{}
+	for _, entry := range entries {
+		// Skip the ..data and ..timestamp entries Kubernetes uses for atomic updates
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if fi.IsDir() {
+			continue
+		}
+
+		switch entry.Name() {
+		case "annotations":
+			// Annotations tend to be large and are not useful as labels
+			continue
+		case "labels":
+			podLabels, err := readDownwardAPIMap(path)
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range podLabels {
+				labels["label_"+sanitizeLabelName(k)] = v
+			}
+		default:
+			value, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			labels[sanitizeLabelName(entry.Name())] = strings.TrimSpace(string(value))
+		}
+	}
+
+	return labels, nil
+}
+
+// readDownwardAPIMap parses a downward API file in key="value" format
+func readDownwardAPIMap(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid line in %s: %s", path, line)
+		}
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s in %s: %s", key, path, err)
+		}
+		m[key] = unquoted
+	}
+
+	return m, scanner.Err()
+}
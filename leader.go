@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Directory of the credentials of the service account of a pod
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Format of the times of a lease
+const leaseTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// errStandby is returned when polling the API while another replica leads
+var errStandby = errors.New("standby, another replica leads")
+
+// lease is a Kubernetes Lease of the coordination.k8s.io/v1 API
+type lease struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		HolderIdentity       string `json:"holderIdentity,omitempty"`
+		LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+		AcquireTime          string `json:"acquireTime,omitempty"`
+		RenewTime            string `json:"renewTime,omitempty"`
+		LeaseTransitions     int    `json:"leaseTransitions"`
+	} `json:"spec"`
+}
+
+// leaderElection elects one of several replicas as leader via a Kubernetes
+// Lease, so only the leader polls the APIs while the others stand by and take
+// over once the leader stops renewing the lease
+type leaderElection struct {
+	client    *http.Client // Authenticates the API server with the CA of the cluster
+	url       string       // Of the leases of the namespace
+	tokenPath string       // Token of the service account
+	name      string
+	namespace string
+	identity  string
+	duration  time.Duration
+
+	leader atomic.Bool
+
+	// The lease of another holder expires once it wasn't renewed for its
+	// duration since it was observed, regardless of the clock of the holder
+	observed   string
+	observedAt time.Time
+	renewedAt  time.Time // Last renewal of the lease by this replica
+
+	metric prometheus.GaugeFunc
+}
+
+// newLeaderElection returns the election of the lease name, in the namespace
+// of the pod if namespace is empty, with the credentials of the service
+// account of the pod
+func newLeaderElection(name, namespace, identity string, duration time.Duration) (*leaderElection, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in Kubernetes, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	if namespace == "" {
+		ns, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, err
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+	if identity == "" {
+		var err error
+		if identity, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+	}
+	tlsConfig, err := apiTLSConfig(serviceAccountDir+"/ca.crt", "", "", false)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &leaderElection{
+		client:    &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+		url:       fmt.Sprintf("https://%s/apis/coordination.k8s.io/v1/namespaces/%s/leases", net.JoinHostPort(host, port), url.PathEscape(namespace)),
+		tokenPath: serviceAccountDir + "/token",
+		name:      name,
+		namespace: namespace,
+		identity:  identity,
+		duration:  duration,
+	}
+	e.metric = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "forecast_solar_leader",
+		Help:        "Whether this replica holds the lease and polls the APIs",
+		ConstLabels: prometheus.Labels{"lease": name},
+	}, func() float64 {
+		if e.leading() {
+			return 1
+		}
+		return 0
+	})
+	return e, nil
+}
+
+// leading returns whether this replica leads
+func (e *leaderElection) leading() bool {
+	return e.leader.Load()
+}
+
+// run tries to acquire or renew the lease three times per lease duration
+// until ctx is cancelled
+func (e *leaderElection) run(ctx context.Context) {
+	for sleep(ctx, e.duration/3) {
+		e.step()
+	}
+}
+
+// step tries to acquire or renew the lease once. The leader steps down once
+// it failed to renew the lease for two thirds of its duration, before another
+// replica may take over.
+func (e *leaderElection) step() {
+	leading, err := e.tryAcquireOrRenew()
+	if err != nil {
+		log.Printf("Error renewing lease %s/%s: %s", e.namespace, e.name, err)
+		leading = e.leading() && clock().Sub(e.renewedAt) < e.duration*2/3
+	}
+	if leading != e.leader.Swap(leading) {
+		if leading {
+			log.Printf("Acquired lease %s/%s, polling the APIs as leader", e.namespace, e.name)
+		} else {
+			log.Printf("Lost lease %s/%s, standing by", e.namespace, e.name)
+		}
+	}
+}
+
+// tryAcquireOrRenew returns whether this replica holds the lease after
+// trying to acquire or renew it
+func (e *leaderElection) tryAcquireOrRenew() (bool, error) {
+	now := clock()
+	req, err := http.NewRequest(http.MethodGet, e.url+"/"+url.PathEscape(e.name), nil)
+	if err != nil {
+		return false, err
+	}
+	var l lease
+	err = e.do(req, &l)
+	var statusErr *statusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		l.APIVersion, l.Kind = "coordination.k8s.io/v1", "Lease"
+		l.Metadata.Name, l.Metadata.Namespace = e.name, e.namespace
+		l.Spec.HolderIdentity = e.identity
+		l.Spec.LeaseDurationSeconds = int(e.duration.Seconds())
+		l.Spec.AcquireTime = now.Format(leaseTimeFormat)
+		l.Spec.RenewTime = l.Spec.AcquireTime
+		return e.write(http.MethodPost, e.url, &l, now)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if observed := l.Spec.HolderIdentity + " " + l.Spec.RenewTime; observed != e.observed {
+		e.observed, e.observedAt = observed, now
+	}
+	duration := time.Duration(l.Spec.LeaseDurationSeconds) * time.Second
+	if l.Spec.HolderIdentity != "" && l.Spec.HolderIdentity != e.identity && now.Before(e.observedAt.Add(duration)) {
+		return false, nil
+	}
+
+	if l.Spec.HolderIdentity != e.identity {
+		l.Spec.HolderIdentity = e.identity
+		l.Spec.AcquireTime = now.Format(leaseTimeFormat)
+		l.Spec.LeaseTransitions++
+	}
+	l.Spec.LeaseDurationSeconds = int(e.duration.Seconds())
+	l.Spec.RenewTime = now.Format(leaseTimeFormat)
+	return e.write(http.MethodPut, e.url+"/"+url.PathEscape(e.name), &l, now)
+}
+
+// write creates or updates the lease, which fails with a conflict if
+// another replica changed it in the meantime
+func (e *leaderElection) write(method, u string, l *lease, now time.Time) (bool, error) {
+	body, err := json.Marshal(l)
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	err = e.do(req, l)
+	var statusErr *statusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusConflict {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	e.renewedAt = now
+	return true, nil
+}
+
+// do sends a request authenticated with the token of the service account,
+// read on every request as Kubernetes rotates it
+func (e *leaderElection) do(req *http.Request, v interface{}) error {
+	token, err := os.ReadFile(e.tokenPath)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	r, err := doRequest(e.client, req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK && r.StatusCode != http.StatusCreated {
+		return newStatusError(r)
+	}
+	return json.NewDecoder(r.Body).Decode(v)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLeaseServer serves a single Lease like the Kubernetes API server,
+// rejecting writes of outdated versions with a conflict
+type testLeaseServer struct {
+	mu      sync.Mutex
+	lease   *lease
+	version int
+	fail    bool // Answer all requests with an error
+}
+
+func (s *testLeaseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer token" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.fail {
+		http.Error(w, "Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	var l lease
+	switch r.Method {
+	case http.MethodGet:
+		if s.lease == nil {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(s.lease)
+		return
+	case http.MethodPost:
+		if s.lease != nil {
+			http.Error(w, "Conflict", http.StatusConflict)
+			return
+		}
+	case http.MethodPut:
+		if !strings.HasSuffix(r.URL.Path, "/exporter") {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+	}
+	if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.lease != nil && l.Metadata.ResourceVersion != s.lease.Metadata.ResourceVersion {
+		http.Error(w, "Conflict", http.StatusConflict)
+		return
+	}
+	s.version++
+	l.Metadata.ResourceVersion = strconv.Itoa(s.version)
+	s.lease = &l
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s.lease)
+}
+
+func (s *testLeaseServer) holder() (string, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lease.Spec.HolderIdentity, s.lease.Spec.LeaseTransitions
+}
+
+// testReplica returns the election of a replica using the lease of srv
+func testReplica(t *testing.T, srv *httptest.Server, identity string) *leaderElection {
+	token := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(token, []byte("token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return &leaderElection{
+		client:    srv.Client(),
+		url:       srv.URL + "/leases",
+		tokenPath: token,
+		name:      "exporter",
+		namespace: "default",
+		identity:  identity,
+		duration:  15 * time.Second,
+	}
+}
+
+func TestLeaderElection(t *testing.T) {
+	previous := clock
+	defer func() { clock = previous }()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock = func() time.Time { return now }
+
+	leases := &testLeaseServer{}
+	srv := httptest.NewServer(leases)
+	defer srv.Close()
+	a, b := testReplica(t, srv, "a"), testReplica(t, srv, "b")
+
+	// The first replica creates the lease, the other one stands by while it
+	// is renewed
+	a.step()
+	b.step()
+	if !a.leading() || b.leading() {
+		t.Fatalf("got a leading %t and b leading %t, want a only", a.leading(), b.leading())
+	}
+	now = now.Add(10 * time.Second)
+	a.step()
+	b.step()
+	if !a.leading() || b.leading() {
+		t.Fatalf("got a leading %t and b leading %t after renewing, want a only", a.leading(), b.leading())
+	}
+
+	// Once the leader stops renewing, the lease expires its duration after
+	// the other replica observed the last renewal
+	now = now.Add(10 * time.Second)
+	b.step()
+	if b.leading() {
+		t.Fatal("got b leading before the lease expired")
+	}
+	now = now.Add(6 * time.Second)
+	b.step()
+	if !b.leading() {
+		t.Fatal("got b standing by after the lease expired")
+	}
+	a.step()
+	if a.leading() {
+		t.Error("got a still leading after b took over")
+	}
+	if holder, transitions := leases.holder(); holder != "b" || transitions != 1 {
+		t.Errorf("got the lease held by %s after %d transitions, want b after 1", holder, transitions)
+	}
+
+	// The leader steps down after failing to renew the lease for two thirds
+	// of its duration, before another replica may take over
+	leases.mu.Lock()
+	leases.fail = true
+	leases.mu.Unlock()
+	now = now.Add(5 * time.Second)
+	b.step()
+	if !b.leading() {
+		t.Error("got b stepping down after failing to renew once")
+	}
+	now = now.Add(6 * time.Second)
+	b.step()
+	if b.leading() {
+		t.Error("got b still leading after failing to renew for two thirds of the lease")
+	}
+}
+
+// Replicas writing the lease at the same time conflict, only one leads
+func TestLeaderElectionConflict(t *testing.T) {
+	leases := &testLeaseServer{}
+	srv := httptest.NewServer(leases)
+	defer srv.Close()
+	a, b := testReplica(t, srv, "a"), testReplica(t, srv, "b")
+
+	// Both read the missing lease before either creates it
+	l := &lease{}
+	l.Spec.HolderIdentity = "a"
+	if ok, err := a.write(http.MethodPost, a.url, l, clock()); !ok || err != nil {
+		t.Fatalf("got %t, %v creating the lease, want true", ok, err)
+	}
+	l = &lease{}
+	l.Spec.HolderIdentity = "b"
+	if ok, err := b.write(http.MethodPost, b.url, l, clock()); ok || err != nil {
+		t.Errorf("got %t, %v creating the lease again, want a conflict", ok, err)
+	}
+}
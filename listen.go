@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// First file descriptor passed by systemd socket activation
+const listenFDsStart = 3
+
+// Handler sets of a listen address: all endpoints, or only the metrics and
+// the health checks, e.g. for a LAN port while the admin endpoints stay on
+// localhost
+var listenHandlers = []string{"all", "metrics"}
+
+// listenAddress is an address to listen on with the handlers it serves
+type listenAddress struct {
+	address  string
+	handlers string
+}
+
+// listenFlag is a repeatable flag collecting listen addresses given as
+// address or address=handlers. The default is replaced by the first address.
+type listenFlag struct {
+	addresses []listenAddress
+	set       bool
+}
+
+func (f *listenFlag) String() string {
+	values := make([]string, 0, len(f.addresses))
+	for _, a := range f.addresses {
+		if a.handlers == "all" {
+			values = append(values, a.address)
+		} else {
+			values = append(values, a.address+"="+a.handlers)
+		}
+	}
+	return strings.Join(values, " ")
+}
+
+func (f *listenFlag) Set(value string) error {
+	address, handlers, found := strings.Cut(value, "=")
+	if !found {
+		handlers = "all"
+	}
+	if address == "" || !contains(listenHandlers, handlers) {
+		return fmt.Errorf("expected address or address=handlers with handlers one of %s, got %q", strings.Join(listenHandlers, ", "), value)
+	}
+	if !f.set {
+		f.addresses, f.set = nil, true
+	}
+	f.addresses = append(f.addresses, listenAddress{address: address, handlers: handlers})
+	return nil
+}
+
+// listen returns the listener for HTTP requests: the socket passed by systemd
+// socket activation if any, otherwise a Unix socket for addresses like
+// unix:/run/forecast_solar_exporter.sock, otherwise a TCP socket
+func listen(addr string) (net.Listener, error) {
+	l, err := systemdListener()
+	if err != nil || l != nil {
+		return l, err
+	}
+
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// Remove the socket left behind by a previous run
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// systemdListener returns the socket passed by systemd socket activation, or
+// nil if the exporter was not socket activated
+func systemdListener() (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds == 0 {
+		return nil, nil
+	}
+	if fds > 1 {
+		return nil, fmt.Errorf("expected one socket from systemd, got %d", fds)
+	}
+
+	// Don't pass the sockets on to child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(listenFDsStart, "systemd socket")
+	defer f.Close()
+	return net.FileListener(f)
+}
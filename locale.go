@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// localeFormat describes how numbers, dates and times are formatted for
+// humans, e.g. in reports
+type localeFormat struct {
+	decimal string // Decimal separator
+	date    string // Layout of dates
+	time    string // Layout of times of day
+}
+
+// isoLocale is used without -locale: ISO 8601 dates and 24h times
+var isoLocale = &localeFormat{decimal: ".", date: "2006-01-02", time: "15:04"}
+
+// locales are the supported locales by language or language and region
+var locales = map[string]*localeFormat{
+	"en":    {decimal: ".", date: "01/02/2006", time: "3:04 PM"},
+	"en-GB": {decimal: ".", date: "02/01/2006", time: "15:04"},
+	"en-IE": {decimal: ".", date: "02/01/2006", time: "15:04"},
+	"de":    {decimal: ",", date: "02.01.2006", time: "15:04"},
+	"fr":    {decimal: ",", date: "02/01/2006", time: "15:04"},
+	"it":    {decimal: ",", date: "02/01/2006", time: "15:04"},
+	"es":    {decimal: ",", date: "02/01/2006", time: "15:04"},
+	"pt":    {decimal: ",", date: "02/01/2006", time: "15:04"},
+	"nl":    {decimal: ",", date: "02-01-2006", time: "15:04"},
+	"da":    {decimal: ",", date: "02.01.2006", time: "15.04"},
+	"nb":    {decimal: ",", date: "02.01.2006", time: "15:04"},
+	"sv":    {decimal: ",", date: "2006-01-02", time: "15:04"},
+	"fi":    {decimal: ",", date: "2.1.2006", time: "15.04"},
+	"pl":    {decimal: ",", date: "02.01.2006", time: "15:04"},
+	"cs":    {decimal: ",", date: "2. 1. 2006", time: "15:04"},
+}
+
+// parseLocale looks up a locale such as de, de-AT or de_AT.UTF-8, falling
+// back to the language. An empty locale selects ISO formats.
+func parseLocale(name string) (*localeFormat, error) {
+	if name == "" {
+		return isoLocale, nil
+	}
+	name, _, _ = strings.Cut(name, ".")
+	name = strings.ReplaceAll(name, "_", "-")
+	if l, ok := locales[name]; ok {
+		return l, nil
+	}
+	lang, _, _ := strings.Cut(name, "-")
+	if l, ok := locales[strings.ToLower(lang)]; ok {
+		return l, nil
+	}
+	return nil, fmt.Errorf("unsupported locale %q", name)
+}
+
+// number formats v with the given number of decimals
+func (l *localeFormat) number(v float64, decimals int) string {
+	return strings.Replace(strconv.FormatFloat(v, 'f', decimals, 64), ".", l.decimal, 1)
+}
+
+// day formats a date given as YYYY-MM-DD, returning it unchanged if invalid
+func (l *localeFormat) day(day string) string {
+	t, err := time.Parse(time.DateOnly, day)
+	if err != nil {
+		return day
+	}
+	return t.Format(l.date)
+}
+
+// dateTime formats a point in time
+func (l *localeFormat) dateTime(t time.Time) string {
+	return t.Format(l.date + " " + l.time)
+}
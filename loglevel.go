@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Levels of -log.level, debug adds the requests to the APIs
+var logLevels = []string{"info", "debug"}
+
+// debugLogging enables debug messages, changed at runtime via /-/log-level
+var debugLogging atomic.Bool
+
+// debugf logs a message if debug logging is enabled
+func debugf(format string, args ...interface{}) {
+	if debugLogging.Load() {
+		log.Printf("DEBUG: "+format, args...)
+	}
+}
+
+// logLevel returns the current log level
+func logLevel() string {
+	if debugLogging.Load() {
+		return "debug"
+	}
+	return "info"
+}
+
+// secrets are redacted from debug messages, such as the API keys in the URLs
+// of forecast.solar
+var secrets struct {
+	sync.Mutex
+	values []string
+}
+
+func addSecret(value string) {
+	if value == "" {
+		return
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	if !contains(secrets.values, value) {
+		secrets.values = append(secrets.values, value)
+	}
+}
+
+func redactSecrets(s string) string {
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, v := range secrets.values {
+		s = strings.ReplaceAll(s, v, "<secret>")
+	}
+	return s
+}
+
+// debugTransport logs the requests to the APIs with their status, duration
+// and the remaining rate limit while debug logging is enabled
+type debugTransport struct {
+	next http.RoundTripper
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !debugLogging.Load() {
+		return t.next.RoundTrip(req)
+	}
+
+	start := time.Now()
+	r, err := t.next.RoundTrip(req)
+	url := redactSecrets(req.URL.String())
+	if err != nil {
+		debugf("%s %s failed after %s: %s", req.Method, url, time.Since(start).Round(time.Millisecond), redactSecrets(err.Error()))
+		return nil, err
+	}
+	var limits string
+	for _, h := range []string{"X-Ratelimit-Limit", "X-Ratelimit-Remaining", "Retry-After"} {
+		if v := r.Header.Get(h); v != "" {
+			limits += ", " + h + "=" + v
+		}
+	}
+	debugf("%s %s: %s in %s%s", req.Method, url, r.Status, time.Since(start).Round(time.Millisecond), limits)
+	return r, nil
+}
+
+// logLevelHandler shows the log level, and changes it on POST or PUT, e.g. to
+// enable debug logging while investigating throttling without a restart
+func logLevelHandler(audit *auditLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost, http.MethodPut:
+			level := r.FormValue("level")
+			if !contains(logLevels, level) {
+				http.Error(w, "Parameter level must be one of "+strings.Join(logLevels, ", "), http.StatusBadRequest)
+				return
+			}
+			if previous := logLevel(); previous != level {
+				debugLogging.Store(level == "debug")
+				log.Printf("Log level set to %s by %s", level, r.RemoteAddr)
+				if audit != nil {
+					if err := audit.record(r, "log-level", level); err != nil {
+						log.Printf("Error writing audit log: %s", err)
+					}
+				}
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST, PUT")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		fmt.Fprintf(w, "log-level: %s\n", logLevel())
+	}
+}
@@ -1,154 +1,1618 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/http/pprof"
+	"net/url"
 	"os"
-	"sort"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	texttemplate "text/template"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/model"
 	promVersion "github.com/prometheus/common/version"
+	"golang.org/x/crypto/acme/autocert"
 )
 
-type apiResponse struct {
-	Result struct {
-		WattHoursDay map[string]int `json:"watt_hours_day"`
-	} `json:"result"`
-}
+// gatherer gathers all metrics, including the sources of a fleet
+var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
 
 func init() {
 	promVersion.Version = "0.1.0"
 	prometheus.MustRegister(promVersion.NewCollector("forecast_solar_exporter"))
 }
 
-type forecastCollector struct {
-	metric *prometheus.Desc
-	Date   time.Time
-	Kwh    float64
+// compassToAPIAzimuth converts a compass bearing (N = 0, E = 90, S = 180, W = 270)
+// to the azimuth convention used by forecast.solar (S = 0, W = 90, E = -90, N = 180)
+func compassToAPIAzimuth(bearing string) (string, error) {
+	b, err := strconv.ParseFloat(bearing, 64)
+	if err != nil {
+		return "", err
+	}
+	az, err := compassToAPIBearing(b)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatFloat(az, 'f', -1, 64), nil
 }
 
-func (c *forecastCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- c.metric
+// compassToAPIBearing converts a compass bearing to the azimuth convention
+// used by forecast.solar, as numbers
+func compassToAPIBearing(b float64) (float64, error) {
+	if b < 0 || b > 360 {
+		return 0, fmt.Errorf("compass bearing must be between 0 and 360, got %g", b)
+	}
+	az := b - 180
+	if az == -180 {
+		az = 180
+	}
+	return az, nil
 }
 
-func (c *forecastCollector) Collect(ch chan<- prometheus.Metric) {
-	s := prometheus.NewMetricWithTimestamp(c.Date, prometheus.MustNewConstMetric(c.metric, prometheus.GaugeValue, c.Kwh))
-	ch <- s
+func main() {
+	// Without a command, the exporter serves the metrics as it always did
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve", "query", "check-config":
+		serve(command, args)
+	case "version":
+		fmt.Printf("%s\n", promVersion.Print("forecast_solar_exporter"))
+	case "size-battery":
+		sizeBattery(args)
+	case "export":
+		exportHistory(args)
+	case "backfill":
+		backfill(args)
+	case "bench":
+		benchmark(args)
+	case "healthcheck":
+		healthcheck(args)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q, expected serve, query, check-config, version, size-battery, export, backfill, bench or healthcheck\n", command)
+		os.Exit(2)
+	}
 }
 
-func main() {
+// Time to complete requests in flight on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// serve runs the exporter. The query command polls once and prints the
+// forecast, check-config validates the configuration and exits.
+func serve(command string, args []string) {
 	var (
-		listenAddr   = flag.String("listen-address", ":9111", "The address to listen on for HTTP requests.")
-		latitude     = flag.String("latitude", "54.9", "Latitude of your location")
-		longitude    = flag.String("longitude", "25.3", "Longitude of your location")
-		declination  = flag.String("declination", "45", "Solar plane declination, 0 = horizontal, 90 = vertical")
-		az           = flag.String("az", "0", "Solar plane azimuth, West = 90, South = 0, East = -90")
-		kwp          = flag.String("kWp", "10", "Solar plane max. peak power in kilo watt")
-		pollInterval = flag.Int("poll-interval", 3600, "Interval in seconds between polls.")
-		showVersion  = flag.Bool("version", false, "Print version information and exit.")
+		latitude       = flag.String("latitude", "54.9", "Latitude of your location")
+		longitude      = flag.String("longitude", "25.3", "Longitude of your location")
+		address        = flag.String("address", "", "Address of your location, resolved to -latitude and -longitude on startup, e.g. \"Musterstraße 1, Berlin\"")
+		geocodingURL   = flag.String("geocoding.url", "https://nominatim.openstreetmap.org", "URL of the Nominatim compatible geocoding service resolving -address")
+		declination    = flag.String("declination", "45", "Solar plane declination, 0 = horizontal, 90 = vertical")
+		az             = flag.String("az", "0", "Solar plane azimuth, West = 90, South = 0, East = -90 (see -azimuth-convention)")
+		azConvention   = flag.String("azimuth-convention", "api", "Convention of -az and -reference.az, either api (South = 0) or compass (North = 0, South = 180)")
+		kwp            = flag.String("kWp", "10", "Solar plane max. peak power in kilo watt")
+		dampingMorning = flag.Float64("damping-morning", 0, "Damping factor for the morning, 0 = no damping, 1 = full damping")
+		dampingEvening = flag.Float64("damping-evening", 0, "Damping factor for the evening, 0 = no damping, 1 = full damping")
+		derating       = flag.Float64("derating", 1, "Factor applied to all forecasts of the plane, e.g. 0.92 for aging panels, soiling and cable losses")
+		apiTimeFormat  = flag.String("api.time-format", "", "Format of the times in forecast.solar responses: utc or iso8601, local time if empty")
+		pollInterval   = flag.Int("poll-interval", 3600, "Interval in seconds between polls.")
+		providers      = flag.String("provider", "forecast.solar", "Comma separated list of forecast providers to poll: forecast.solar, solcast, open-meteo, file")
+		apiKey         = flag.String("api-key", "", "API key for forecast.solar paid plans")
+		apiRateLimit   = flag.Int("api.rate-limit", 0, "Requests per hour to forecast.solar shared by all planes and sites, polls are skipped beyond. 0 for the 12 requests of the public plan without -api-key and no limit with one, -1 for no limit")
+		solcastKey     = flag.String("solcast.api-key", "", "API key for the Solcast provider")
+		solcastSite    = flag.String("solcast.resource-id", "", "Rooftop site resource ID for the Solcast provider")
+		solcastPoll    = flag.Int("solcast.poll-interval", 10800, "Interval in seconds between polls of the Solcast provider, mind the daily API limit.")
+		openMeteoLoss  = flag.Float64("open-meteo.system-loss", 0.14, "System losses applied to the Open-Meteo irradiance forecast, 0.14 = 14%")
+		gustThreshold  = flag.Float64("wind.gust-threshold", 0, "Wind gust speed in m/s from which forecast_solar_secure_loose_equipment recommends securing loose equipment, 0 to disable")
+		gustWindow     = flag.Int("wind.window-hours", 12, "Number of coming hours in which gusts reaching -wind.gust-threshold are considered")
+		filePath       = flag.String("file.path", "", "Stored API response, or directory of responses replayed in name order one per poll, served by the file provider")
+		fileShift      = flag.Bool("file.shift-dates", true, "Shift the dates of stored responses so their first day is today")
+		collectionMode = flag.String("collection-mode", "background", "When to poll the providers: background (in a loop every poll interval) or scrape (when metrics are scraped and the data is older than the poll interval)")
+		apiURL         = flag.String("api-url", "https://api.forecast.solar", "Base URL of the forecast.solar API, e.g. of a mirror or gateway")
+		apiTimeout     = flag.Int("api-timeout", 10, "Timeout in seconds of requests to the APIs, including reading the response")
+		recordDir      = flag.String("record-dir", "", "Directory to record all API responses in, for replaying them with -replay")
+		replayDir      = flag.String("replay", "", "Directory of responses recorded with -record-dir to feed through the exporter instead of polling, on a simulated clock")
+		replaySpeed    = flag.Float64("replay.speed", 3600, "Speed of the simulated clock of -replay relative to the recording, 0 replays without delay")
+		proxyURL       = flag.String("proxy-url", "", "Proxy for requests to the APIs, e.g. http://proxy:3128, overrides HTTP_PROXY, HTTPS_PROXY and NO_PROXY")
+		apiCAFile      = flag.String("api.tls.ca-file", "", "PEM bundle of CAs to trust for requests to the APIs in addition to the system roots, e.g. of a TLS intercepting proxy")
+		apiCertFile    = flag.String("api.tls.cert-file", "", "PEM client certificate for requests to the APIs")
+		apiKeyFile     = flag.String("api.tls.key-file", "", "PEM key of the client certificate")
+		apiInsecure    = flag.Bool("api.tls.insecure-skip-verify", false, "Skip verifying the certificates of the APIs, insecure")
+		energyUnit     = flag.String("unit", "", "Unit of all energy metrics: wh (suffix _watthours) or kwh (suffix _kwh), by default the daily forecasts are exported in Wh without suffix and the others in kWh")
+		metricSet      = flag.String("metric-set", "all", "Metrics to export: all, energy (leaves out power) or power (leaves out energy, adds forecast_solar_power_watts per hour)")
+		namePrefix     = flag.String("metric-prefix", defaultMetricPrefix, "Prefix of the names of all exported metrics, e.g. to tell them from the ones of other forecasting tools")
+		usageFile      = flag.String("usage-file", "", "File to persist the monthly usage served by /api/v1/usage to, kept in memory only without")
+		cacheFile      = flag.String("cache-file", "", "File to persist the last forecasts to, loaded on startup.")
+		powerAhead     = flag.Int("power-ahead-hours", 12, "Export the forecasted power 1 to this many hours from now as forecast_solar_power_watts_ahead, 0 to disable")
+		bestWindowFlag = flag.String("best-window.durations", "", "Comma separated durations of loads to export the recommended start of as forecast_solar_best_window_start_seconds, e.g. 2h,4h")
+		bestWindowLoad = flag.Float64("best-window.load", 0, "Power of the loads in watts, with -tariff.price the best window is the one of the cheapest energy from the grid, 0 for the sunniest window")
+		hourlyEnergy   = flag.Bool("hourly-energy", false, "Export the forecast per hour as forecast_solar_energy_kwh with day and hour labels.")
+		dayPartsFlag   = flag.String("day-parts", "", "Export the forecast per part of the day as forecast_solar_day_part_kwh, e.g. morning=6-12,afternoon=12-18,evening=18-22")
+		tariffPrice    = flag.Float64("tariff.price", 0, "Electricity price per kWh, enables forecast_solar_self_use_savings")
+		tariffFeedIn   = flag.Float64("tariff.feed-in", 0, "Feed-in tariff per kWh, enables forecast_solar_feed_in_revenue")
+		tariffCurrency = flag.String("tariff.currency", "EUR", "Currency of the prices, exported as currency label")
+		co2Intensity   = flag.Float64("co2.intensity", 0, "Carbon intensity of the grid in g CO2eq per kWh, enables forecast_solar_co2_avoided_kg. Fallback of -co2.electricitymaps.zone until its first retrieval.")
+		co2Zone        = flag.String("co2.electricitymaps.zone", "", "Zone to retrieve the carbon intensity of from Electricity Maps, e.g. DE")
+		co2Token       = flag.String("co2.electricitymaps.token", "", "API token of Electricity Maps")
+		co2Interval    = flag.Int("co2.electricitymaps.interval", 60, "Interval in minutes between retrievals of the carbon intensity")
+		exportLimit    = flag.Float64("export-limit", -1, "Grid export limit in watts, 0 for zero-export systems. Enables forecast_solar_{self_use,export,curtailed}_kwh, -1 disables.")
+		loadProfile    = flag.String("load-profile", "0", "Household load in watts used with -export-limit, either constant or per hour range like 0-7=200,7-22=450,22-24=200")
+		tiltCandidates = flag.String("tilt.candidates", "", "Comma separated tilts an adjustable mount supports, enables forecast_solar_optimal_tilt_* metrics")
+		tiltWindow     = flag.Int("tilt.window-days", 30, "Number of days to find the optimal tilt for")
+		nightMode      = flag.Bool("night-mode", false, "Pause polls between sunset and sunrise, computed locally from the coordinates, as the forecast doesn't change at night")
+		eclipses       = flag.Bool("eclipses", false, "Export how much of the sun is covered by solar eclipses today and tomorrow at the coordinates, computed locally")
+		sunFlag        = flag.Bool("sun-events", false, "Export the seconds until the next sunrise, sunset and solar noon, and the day length and solar noon of today, computed locally")
+		actualURL      = flag.String("actual.url", "", "URL to retrieve the energy actually produced today in watt hours from, either a Prometheus server queried with -actual.query or a JSON endpoint read with -actual.json-field. Enables forecast_solar_error_* metrics.")
+		actualQuery    = flag.String("actual.query", "", "PromQL query returning the energy produced today in watt hours")
+		actualField    = flag.String("actual.json-field", "", "Dot separated path to the energy produced today in watt hours in the JSON document, e.g. Body.Data.DAY_ENERGY.Values.1")
+		actualPoll     = flag.Int("actual.poll-interval", 300, "Interval in seconds between retrievals of the actual production.")
+		communityURL   = flag.String("community.url", "", "URL of a community aggregation service to share the anonymized forecast accuracy with, requires -actual.url. Exports the accuracy of the region as forecast_solar_community_* metrics.")
+		communityGridD = flag.Float64("community.grid-degrees", 1, "Size in degrees of the grid cell shared as location with the community")
+		communityEps   = flag.Float64("community.epsilon", 1, "Privacy budget of each shared sample, smaller values add more noise")
+		referenceSite  = flag.String("reference.site", "", "Name of a reference plant to export the forecast of as forecast_solar_reference_{today,tomorrow}, for comparison")
+		referenceLat   = flag.String("reference.latitude", "", "Latitude of the reference plant")
+		referenceLon   = flag.String("reference.longitude", "", "Longitude of the reference plant")
+		referenceDec   = flag.String("reference.declination", "45", "Solar plane declination of the reference plant")
+		referenceAz    = flag.String("reference.az", "0", "Solar plane azimuth of the reference plant, West = 90, South = 0, East = -90 (see -azimuth-convention)")
+		referenceKwp   = flag.String("reference.kWp", "10", "Max. peak power of the reference plant in kilo watt")
+		mqttBroker     = flag.String("mqtt.broker", "", "MQTT broker to publish the forecasts to, e.g. tcp://localhost:1883")
+		mqttClientID   = flag.String("mqtt.client-id", "forecast_solar_exporter", "MQTT client ID")
+		mqttUsername   = flag.String("mqtt.username", "", "MQTT username")
+		mqttPassword   = flag.String("mqtt.password", "", "MQTT password")
+		mqttTopic      = flag.String("mqtt.topic-prefix", "forecast_solar", "Prefix of the MQTT topics to publish to")
+		mqttDiscovery  = flag.String("mqtt.discovery-prefix", "homeassistant", "Home Assistant MQTT discovery prefix, empty to disable discovery")
+		influxURL      = flag.String("influxdb.url", "", "URL of an InfluxDB v2 server to write the forecasts to with future timestamps, e.g. http://localhost:8086")
+		influxOrg      = flag.String("influxdb.org", "", "InfluxDB organization")
+		influxBucket   = flag.String("influxdb.bucket", "forecast_solar", "InfluxDB bucket")
+		influxToken    = flag.String("influxdb.token", "", "InfluxDB API token with write access to the bucket")
+		haURL          = flag.String("homeassistant.url", "", "URL of Home Assistant to import the forecasts into as long-term statistics, e.g. http://homeassistant.local:8123")
+		haToken        = flag.String("homeassistant.token", "", "Long-lived access token of Home Assistant, of an administrator")
+		remoteWriteURL = flag.String("remote-write.url", "", "Prometheus remote write endpoint to push the metrics to, e.g. for sites without inbound connectivity")
+		remoteWriteInt = flag.Int("remote-write.interval", 60, "Interval in seconds between remote write pushes.")
+		remoteWriteTok = flag.String("remote-write.bearer-token", "", "Bearer token for the remote write endpoint")
+		remoteWriteUsr = flag.String("remote-write.username", "", "Basic auth username for the remote write endpoint")
+		remoteWritePw  = flag.String("remote-write.password", "", "Basic auth password for the remote write endpoint")
+		graphiteAddr   = flag.String("graphite.address", "", "Graphite host:port to push the forecasts to via the plaintext protocol, e.g. localhost:2003")
+		graphitePrefix = flag.String("graphite.prefix", "forecast_solar", "Prefix of the Graphite paths")
+		graphiteInt    = flag.Int("graphite.interval", 60, "Interval in seconds between pushes to Graphite.")
+		statsdAddr     = flag.String("statsd.address", "", "StatsD host:port to send the daily forecasts and the current forecasted power to via UDP, e.g. localhost:8125")
+		statsdPrefix   = flag.String("statsd.prefix", "forecast_solar", "Prefix of the StatsD metric names")
+		statsdTags     = flag.Bool("statsd.tags", false, "Send the source as DogStatsD tag instead of as part of the metric names")
+		statsdInt      = flag.Int("statsd.interval", 60, "Interval in seconds between sends to StatsD.")
+		consulURL      = flag.String("consul.url", "", "URL of the local Consul agent to register the exporter as service in, e.g. http://localhost:8500")
+		consulService  = flag.String("consul.service", "forecast_solar_exporter", "Name of the Consul service")
+		consulTags     = flag.String("consul.tags", "", "Comma-separated tags of the Consul service")
+		consulAddress  = flag.String("consul.address", "", "Address of the Consul service, the address of the agent's node if empty")
+		consulToken    = flag.String("consul.token", "", "ACL token of the Consul agent")
+		pushgateway    = flag.String("pushgateway.url", "", "Pushgateway to push the metrics to, e.g. http://localhost:9091")
+		pushJob        = flag.String("pushgateway.job", "forecast_solar", "Job label of the pushed metrics")
+		pushInterval   = flag.Int("pushgateway.interval", 60, "Interval in seconds between pushes to the Pushgateway.")
+		pushOnceFlag   = flag.Bool("pushgateway.once", false, "Poll once, push the metrics to the Pushgateway and exit, e.g. when run from cron")
+		textfileDir    = flag.String("textfile.directory", "", "Write the forecast metrics to forecast_solar.prom in this directory for node_exporter's textfile collector instead of serving them via HTTP")
+		textfileInt    = flag.Int("textfile.interval", 0, "Interval in seconds between textfile writes, 0 polls once, writes the file and exits")
+		otlpEndpoint   = flag.String("otlp.endpoint", "", "OTLP/HTTP metrics endpoint of an OpenTelemetry collector to push the metrics to, e.g. http://localhost:4318/v1/metrics")
+		otlpInterval   = flag.Int("otlp.interval", 60, "Interval in seconds between OTLP pushes.")
+		fleetFile      = flag.String("fleet.file", "", "YAML file listing sites to poll instead of the plane given by flags, metrics get a site label")
+		fleetWorkers   = flag.Int("fleet.workers", 4, "Number of workers polling the sites of the fleet")
+		fleetWatch     = flag.Bool("fleet.watch", false, "Reload the fleet when the fleet file changes, e.g. as Kubernetes updates a mounted ConfigMap")
+		historyFile    = flag.String("history-file", "", "File to record daily forecasts in, enabling forecast_solar_history_* metrics.")
+		historyRaw     = flag.Int("history.raw-retention-days", 90, "Days to keep all issued and hourly forecasts in the history, older ones are downsampled to the final forecast of each day. 0 keeps everything.")
+		historyDaily   = flag.Int("history.retention-days", 0, "Days to keep downsampled daily forecasts after the raw retention, 0 keeps them forever")
+		historyRevs    = flag.Int("history.max-revisions", 500, "Forecasts retrieved to keep per source for looking up what was served at a time, the oldest are dropped first. 0 keeps all within the raw retention.")
+		historyGzip    = flag.Bool("history.compress", false, "Compress the history file with gzip. Existing files are read in either format.")
+		once           = flag.Bool("once", false, "Poll once, print the forecast to stdout and exit, non-zero if a poll failed")
+		onceOutput     = flag.String("once.output", "table", "Output format of -once: table, json or metrics")
+		dryRun         = flag.Bool("dry-run", false, "Poll once, print the metrics as they would be exposed to stdout and exit, same as -once -once.output metrics")
+		localeFlag     = flag.String("locale", "", "Locale of numbers, dates and times in reports, e.g. de or en-GB, defaults to ISO 8601 dates and decimal points")
+		reportLang     = flag.String("report.language", "", "Language of the report: en, de, nl or fr, defaults to the language of -locale if supported, English otherwise")
+		reportTmplDir  = flag.String("report.template-dir", "", "Directory with report.html and report.md overriding the built-in report templates")
+		reportSMTP     = flag.String("report.smtp-server", "", "SMTP server as host:port to email the weekly report through every Monday, requires -history-file")
+		reportSMTPUser = flag.String("report.smtp-username", "", "Username for the SMTP server")
+		reportSMTPPass = flag.String("report.smtp-password", "", "Password for the SMTP server")
+		reportFrom     = flag.String("report.email-from", "", "Sender address of the weekly report")
+		peerURLs       = flag.String("peer.urls", "", "Comma separated URLs of other exporters polling the same forecast.solar account to share forecasts and the rate limit with, e.g. http://pv2:9111")
+		peerToken      = flag.String("peer.token", "", "Token the peers authenticate with, the same on all of them")
+		leaseName      = flag.String("leader-election.lease", "", "Name of the Kubernetes Lease replicas elect a leader with, only the leader polls the APIs")
+		leaseNamespace = flag.String("leader-election.namespace", "", "Namespace of the lease, the one of the pod if empty")
+		leaseIdentity  = flag.String("leader-election.identity", "", "Identity of this replica in the lease, the host name, i.e. the pod name, if empty")
+		leaseDuration  = flag.Int("leader-election.lease-duration", 15, "Seconds without renewal after which another replica takes over the lease")
+		peerReplicas   = flag.Bool("peer.replicas", false, "The peers are replicas of this exporter with the same configuration, e.g. for high availability, sharing the forecasts of all providers")
+		reportTo       = flag.String("report.email-to", "", "Comma separated recipients of the weekly report")
+		adminToken     = flag.String("web.admin-token", "", "Bearer token required to change state via admin endpoints such as /-/read-only, reading is always allowed")
+		adminAllow     = flag.String("web.admin-allow", "", "Comma separated networks or addresses allowed to change state via admin endpoints, e.g. 127.0.0.1,192.168.1.0/24")
+		adminOpen      = flag.Bool("web.admin-unauthenticated", false, "Allow all clients to change state via admin endpoints without -web.admin-token and -web.admin-allow, e.g. behind an authenticating proxy")
+		auditLogFile   = flag.String("audit-log-file", "", "File to append admin actions to as JSON lines, served by /api/v1/audit")
+		metricsPath    = flag.String("web.telemetry-path", "/metrics", "Path under which to expose the metrics")
+		logLevelFlag   = flag.String("log.level", "info", "Log level, info or debug, which logs the requests to the APIs. Can be changed at runtime via /-/log-level")
+		readTimeout    = flag.Int("web.read-timeout", 10, "Timeout in seconds for reading requests including the body, 0 = no timeout")
+		writeTimeout   = flag.Int("web.write-timeout", 60, "Timeout in seconds from the end of reading a request to the end of writing the response, 0 = no timeout")
+		idleTimeout    = flag.Int("web.idle-timeout", 120, "Timeout in seconds for idle keep-alive connections, 0 = -web.read-timeout")
+		maxHeaderBytes = flag.Int("web.max-header-bytes", 65536, "Maximum size in bytes of the request headers")
+		acmeHosts      = flag.String("web.acme.hosts", "", "Comma separated host names to obtain certificates for via ACME, e.g. Let's Encrypt, serving HTTPS on all listen addresses but loopback ones and Unix sockets")
+		acmeCacheDir   = flag.String("web.acme.cache-dir", "", "Directory to store the ACME account and certificates in across restarts, required with -web.acme.hosts")
+		acmeEmail      = flag.String("web.acme.email", "", "Contact email of the ACME account, for notices about expiring certificates")
+		acmeDirectory  = flag.String("web.acme.directory-url", "", "Directory URL of the ACME CA, e.g. https://acme-staging-v02.api.letsencrypt.org/directory for testing, Let's Encrypt if empty")
+		acmeHTTPAddr   = flag.String("web.acme.http-address", "", "Address to answer HTTP-01 challenges and redirect to HTTPS on, e.g. :80. Only TLS-ALPN-01 challenges on port 443 are answered if empty")
+		enablePprof    = flag.Bool("web.enable-pprof", false, "Serve the runtime profiling data of net/http/pprof under /debug/pprof/, requires -web.admin-token")
+		enableSettings = flag.Bool("web.enable-settings", false, "Serve /settings to edit the fleet file in the browser, requires -fleet.file and -web.admin-token")
+		openMetrics    = flag.Bool("web.enable-openmetrics", false, "Serve the metrics in the OpenMetrics format to scrapers accepting it")
+		webhookSecret  = flag.String("webhook.secret", "", "Secret of HMAC signed forecasts pushed to /api/v1/webhook, enables the webhook")
+		chaosFlag      = flag.Bool("chaos.enable", false, "Enable /-/chaos to make upstream requests fail or return canned payloads, for testing only")
+		readOnlyFlag   = flag.Bool("read-only", false, "Start in read-only mode, serving the last forecast without calling the API. Can be toggled via /-/read-only.")
+		quotaBehavior  = flag.String("quota-exhausted.behavior", "keep", "What to do after sustained 429 responses: keep (serve stale data, keep polling), read-only (serve stale data, stop polling) or fallback (poll -quota-exhausted.fallback-provider until the quota is reset)")
+		quotaFallback  = flag.String("quota-exhausted.fallback-provider", "", "Provider polled instead of forecast.solar while its quota is exhausted with the fallback behavior: solcast or open-meteo")
+		quotaAfter     = flag.Int("quota-exhausted.after", 3, "Number of consecutive 429 responses after which the quota is considered exhausted")
+		pollJitter     = flag.Int("poll-jitter", 0, "Maximum random delay in seconds added to the first poll and every poll interval, to spread the polls of many exporters")
+		pollTimeout    = flag.Int("poll-timeout", 60, "Deadline in seconds of a poll including all its requests, 0 for none")
+		pollAdaptive   = flag.Bool("poll-adaptive", false, "Spread the polls of forecast.solar evenly across the remaining rate limit reported by the API instead of polling every poll interval")
+		expireAfter    = flag.Int("expire-after", 0, "Number of consecutive failed polls after which the forecasts are no longer exported, 0 to keep exporting the last forecast")
+		backoffMax     = flag.Int("poll-backoff-max", 21600, "Maximum interval in seconds between polls after consecutive errors, which back off exponentially from the poll interval")
+		startupCheck   = flag.String("startup-check", "warn", "Validate the parameters against the API check endpoint on startup and when reloading the fleet: fail, warn or off")
+		downwardAPIDir = flag.String("kubernetes.downward-api-dir", "", "Directory of a Kubernetes downward API volume to export as forecast_solar_kubernetes_info labels.")
+		goMetrics      = flag.Bool("collector.go", true, "Export the go_* metrics of the Go runtime")
+		processMetrics = flag.Bool("collector.process", true, "Export the process_* metrics such as CPU and memory usage")
+		buildInfo      = flag.Bool("collector.build-info", true, "Export the build information as go_build_info and forecast_solar_exporter_build_info")
+		updateCheck    = flag.Int("update-check.interval", 0, "Interval in hours between checks of GitHub for a newer release of the exporter, exported as forecast_solar_exporter_update_available, 0 disables the check")
+		alertmanager   = flag.String("alertmanager.url", "", "Alertmanager URL to create silences on days with a forecast below -alertmanager.silence-below, e.g. http://localhost:9093")
+		silenceBelow   = flag.Float64("alertmanager.silence-below", 5, "Forecast for today in kWh below which low production alerts are silenced until midnight")
+		notifyURL      = flag.String("notify.url", "", "Webhook URL to POST to when the forecast of a site crosses the threshold of a -notify.rule")
+		notifyTemplate = flag.String("notify.template", "", "Go template of the body of notifications, e.g. '{{.Site}}: {{.Kwh}} kWh {{.Day}}', JSON by default")
+		showVersion    = flag.Bool("version", false, "Print version information and exit.")
 	)
 
-	flag.Parse()
+	resourceAttributes := keyValueFlag{}
+	flag.Var(resourceAttributes, "resource-attribute", "Resource attribute key=value to add to target_info, can be repeated. Also read from OTEL_RESOURCE_ATTRIBUTES.")
+
+	otlpHeaders := keyValueFlag{}
+	flag.Var(otlpHeaders, "otlp.header", "Header key=value to send with OTLP pushes, e.g. for authentication, can be repeated")
+
+	pushGrouping := keyValueFlag{}
+	flag.Var(pushGrouping, "pushgateway.grouping", "Grouping label key=value of the pushed metrics, can be repeated")
+
+	tariffFlag := keyValueFlag{}
+	flag.Var(tariffFlag, "tariff-window", "Tariff window name=HH:MM-HH:MM to highlight in the web UI, e.g. night=22:00-06:00, can be repeated")
+	priceWindows := keyValueFlag{}
+	flag.Var(priceWindows, "tariff.price-window", "Electricity price per kWh during a tariff window, name=price, e.g. night=0.22, can be repeated")
+	feedInWindows := keyValueFlag{}
+	flag.Var(feedInWindows, "tariff.feed-in-window", "Feed-in tariff per kWh during a tariff window, name=price, can be repeated")
+
+	constLabelsFlag := keyValueFlag{}
+	flag.Var(constLabelsFlag, "label", "Label key=value to add to all exported metrics, e.g. array=garage, can be repeated")
+
+	requestCostFlag := keyValueFlag{}
+	flag.Var(requestCostFlag, "api.request-cost", "Estimated cost of a request to a provider as provider=cost for paid plans, e.g. solcast=1 for Solcast credits, can be repeated")
+
+	providerConcurrency := keyValueFlag{}
+	flag.Var(providerConcurrency, "fleet.provider-concurrency", "Maximum concurrent polls of a provider in fleet mode as provider=limit, can be repeated")
+
+	silenceMatchers := keyValueFlag{}
+	var obstructions obstructionFlag
+	ownersFlag := keyValueFlag{}
+	flag.Var(silenceMatchers, "alertmanager.silence-matcher", "Label name=value matching the low production alerts to silence, e.g. alertname=SolarProductionLow, can be repeated")
+	flag.Var(ownersFlag, "owner", "Share of an owner of a shared plant as name=percent, e.g. alice=40, can be repeated")
+	flag.Var(&obstructions, "obstruction", "Obstruction shading the plane as height,bearing,distance[,width] in m and degrees, e.g. a chimney, can be repeated")
+
+	var notifyRules notifyRuleFlag
+	notifyHeaders := keyValueFlag{}
+	flag.Var(&notifyRules, "notify.rule", "Threshold of the forecast in kWh to notify -notify.url of, e.g. tomorrow<5 or today>=20, can be repeated")
+	flag.Var(notifyHeaders, "notify.header", "Header key=value to send with notifications, e.g. for authentication, can be repeated")
+	listenAddrs := &listenFlag{addresses: []listenAddress{{address: ":9111", handlers: "all"}}}
+	flag.Var(listenAddrs, "listen-address", "The address to listen on for HTTP requests, or unix:/path/to/socket, optionally with the handlers to serve, all or metrics, e.g. 192.168.1.5:9111=metrics. Can be repeated. The first one is replaced by the socket passed by systemd socket activation.")
+	secretFiles := registerSecretFileFlags()
+
+	flag.CommandLine.Parse(args)
+	if err := loadSecretFiles(secretFiles); err != nil {
+		log.Fatalf("Error reading secret: %s", err)
+	}
+	for name := range secretFlags {
+		addSecret(flag.Lookup(name).Value.String())
+	}
+	if !contains(logLevels, *logLevelFlag) {
+		log.Fatalf("Unknown log level %s, expected one of %s", *logLevelFlag, strings.Join(logLevels, ", "))
+	}
+	debugLogging.Store(*logLevelFlag == "debug")
+	if command == "query" {
+		*once = true
+	}
+	if *dryRun {
+		*once, *onceOutput = true, "metrics"
+	}
 
 	if *showVersion {
 		fmt.Printf("%s\n", promVersion.Print("forecast_solar_exporter"))
 		os.Exit(0)
 	}
 
-	today := &forecastCollector{
-		metric: prometheus.NewDesc(
-			"forecast_solar_today",
-			"Solar harvest forecast for today",
-			nil,
-			nil,
-		),
-	}
-	tomorrow := &forecastCollector{
-		metric: prometheus.NewDesc(
-			"forecast_solar_tomorrow",
-			"Solar harvest forecast for tomorrow",
-			nil,
-			nil,
-		),
-	}
-
-	// Register the summary and the histogram with Prometheus's default registry
-	prometheus.MustRegister(today)
-	prometheus.MustRegister(tomorrow)
-
-	// Add Go module build info
-	prometheus.MustRegister(collectors.NewBuildInfoCollector())
-
-	// Poll loop
-	go func() {
-		for {
-			// Use anonymous function so we can defer nicely
-			func() {
-				defer time.Sleep(time.Duration(*pollInterval) * time.Second)
-				res := &apiResponse{}
-
-				var client = &http.Client{Timeout: 10 * time.Second}
-				url := fmt.Sprintf("https://api.forecast.solar/estimate/%s/%s/%s/%s/%s", *latitude, *longitude, *declination, *az, *kwp)
-
-				r, err := client.Get(url)
-				if err != nil {
-					log.Printf("Error getting URL: %s", err)
-					return
-				}
-				defer r.Body.Close()
+	switch *azConvention {
+	case "api":
+	case "compass":
+		converted, err := compassToAPIAzimuth(*az)
+		if err != nil {
+			log.Fatalf("Error converting azimuth: %s", err)
+		}
+		log.Printf("Using API azimuth %s for compass bearing %s", converted, *az)
+		*az = converted
+		if *referenceSite != "" {
+			if *referenceAz, err = compassToAPIAzimuth(*referenceAz); err != nil {
+				log.Fatalf("Error converting azimuth of the reference plant: %s", err)
+			}
+		}
+		for i := range obstructions {
+			if obstructions[i].Bearing, err = compassToAPIBearing(obstructions[i].Bearing); err != nil {
+				log.Fatalf("Error converting bearing of obstruction: %s", err)
+			}
+		}
+	default:
+		log.Fatalf("Unknown azimuth convention: %s", *azConvention)
+	}
 
-				if r.StatusCode != 200 {
-					log.Printf("Error while requesting URL: %s", r.Status)
-					return
-				}
+	if *collectionMode != "background" && *collectionMode != "scrape" {
+		log.Fatalf("Unknown collection mode: %s", *collectionMode)
+	}
+
+	if *metricSet != "all" && *metricSet != "energy" && *metricSet != "power" {
+		log.Fatalf("Unknown metric set: %s", *metricSet)
+	}
+
+	if *energyUnit != "" && *energyUnit != "wh" && *energyUnit != "kwh" {
+		log.Fatalf("Unknown energy unit: %s", *energyUnit)
+	}
+
+	if *quotaBehavior != "keep" && *quotaBehavior != "read-only" && *quotaBehavior != "fallback" {
+		log.Fatalf("Unknown quota exhausted behavior: %s", *quotaBehavior)
+	}
+	if (*quotaBehavior == "fallback") != (*quotaFallback != "") {
+		log.Fatal("-quota-exhausted.behavior fallback requires -quota-exhausted.fallback-provider and vice versa")
+	}
+	if *quotaFallback != "" && *quotaFallback != "solcast" && *quotaFallback != "open-meteo" {
+		log.Fatalf("Unknown fallback provider %s, expected solcast or open-meteo", *quotaFallback)
+	}
+
+	if *dampingMorning < 0 || *dampingMorning > 1 || *dampingEvening < 0 || *dampingEvening > 1 {
+		log.Fatal("Damping factors must be between 0 and 1")
+	}
+	if *derating <= 0 {
+		log.Fatal("-derating must be positive")
+	}
+
+	query := url.Values{}
+	if *apiTimeFormat != "" {
+		if !contains(apiTimeFormats, *apiTimeFormat) {
+			log.Fatalf("Invalid -api.time-format %q, expected %s", *apiTimeFormat, strings.Join(apiTimeFormats, " or "))
+		}
+		query.Set("time", *apiTimeFormat)
+	}
+
+	if *readTimeout < 0 || *writeTimeout < 0 || *idleTimeout < 0 {
+		log.Fatal("-web.read-timeout, -web.write-timeout and -web.idle-timeout must not be negative")
+	}
+	if *maxHeaderBytes <= 0 {
+		log.Fatal("-web.max-header-bytes must be positive")
+	}
+	if *acmeHosts != "" && *acmeCacheDir == "" {
+		log.Fatal("-web.acme.hosts requires -web.acme.cache-dir to keep the certificates across restarts")
+	}
+	// Scrapes wait for the polls in collection mode scrape
+	if *collectionMode == "scrape" && *writeTimeout > 0 && *writeTimeout <= *apiTimeout {
+		log.Printf("Warning: -web.write-timeout %ds doesn't leave time for polls timing out after -api-timeout %ds during scrapes", *writeTimeout, *apiTimeout)
+	}
+	if *apiTimeout <= 0 {
+		log.Fatal("-api-timeout must be positive")
+	}
+	if *pollTimeout < 0 {
+		log.Fatal("-poll-timeout must not be negative")
+	}
+	base, err := newAPITransport(*proxyURL, *apiCAFile, *apiCertFile, *apiKeyFile, *apiInsecure)
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+	if *apiInsecure {
+		log.Println("WARNING: Certificates of the APIs are not verified")
+	}
+	var transport http.RoundTripper = base
+	var chaos *chaosTransport
+	if *chaosFlag {
+		log.Println("WARNING: Chaos injection via /-/chaos is enabled")
+		chaos = &chaosTransport{next: transport}
+		transport = chaos
+	}
+	client := newAPIClient(transport, time.Duration(*apiTimeout)*time.Second)
+
+	// The address is resolved once, the coordinates are logged so they can be
+	// configured directly
+	if *address != "" {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "latitude" || f.Name == "longitude" || f.Name == "fleet.file" {
+				log.Fatalf("-address can't be combined with -%s", f.Name)
+			}
+		})
+		res, err := geocode(client, *geocodingURL, *address)
+		if err != nil {
+			log.Fatalf("Error resolving address: %s", err)
+		}
+		log.Printf("Resolved address %q to %s, latitude %s, longitude %s", *address, res.DisplayName, res.Latitude, res.Longitude)
+		*latitude, *longitude = res.Latitude, res.Longitude
+		prometheus.MustRegister(newAddressInfo(*address, res))
+	}
+
+	// Paid plans put the API key in front of the endpoint
+	apiBase := strings.TrimSuffix(*apiURL, "/") + "/"
+	if *apiKey != "" {
+		apiBase += *apiKey + "/"
+	}
+
+	providerNames := strings.Split(*providers, ",")
+	switch *startupCheck {
+	case "off":
+	case "fail", "warn":
+		// Read-only mode never calls the API
+		if !contains(providerNames, "forecast.solar") || *fleetFile != "" || *readOnlyFlag {
+			break
+		}
+		url := fmt.Sprintf("%scheck/%s/%s/%s/%s/%s", apiBase, *latitude, *longitude, *declination, *az, *kwp)
+		if err := checkParameters(client, url); err != nil {
+			if *startupCheck == "fail" {
+				log.Fatalf("Error validating parameters: %s", err)
+			}
+			log.Printf("WARNING: Error validating parameters, polls will likely fail: %s", err)
+		}
+	default:
+		log.Fatalf("Unknown startup check mode: %s", *startupCheck)
+	}
+
+	// Log settings differing from the defaults to simplify debugging deployments
+	config := currentConfig()
+	if len(config.Changed) == 0 {
+		log.Println("Starting with default settings")
+	} else {
+		changed := make([]string, 0, len(config.Changed))
+		for _, name := range config.Changed {
+			setting := fmt.Sprintf("%s=%q", name, config.Settings[name])
+			if source := config.Sources[name]; source != "command line" {
+				setting += " (" + source + ")"
+			}
+			changed = append(changed, setting)
+		}
+		log.Printf("Starting with non-default settings: %s", strings.Join(changed, " "))
+	}
+	for _, name := range sortedKeys(config.Environment) {
+		log.Printf("Environment variable %s=%q is set", name, config.Environment[name])
+	}
+
+	var readOnly atomic.Bool
+	readOnly.Store(*readOnlyFlag)
+	prometheus.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "forecast_solar_read_only",
+			Help: "Whether the exporter is in read-only mode and does not call the API",
+		},
+		func() float64 {
+			if readOnly.Load() {
+				return 1
+			}
+			return 0
+		},
+	))
+
+	// Add Go module build info. The default collectors can be disabled to keep
+	// scrapes small, e.g. over metered connections.
+	if *buildInfo {
+		prometheus.MustRegister(collectors.NewBuildInfoCollector())
+	} else {
+		prometheus.Unregister(promVersion.NewCollector("forecast_solar_exporter"))
+	}
+	if !*goMetrics {
+		prometheus.Unregister(collectors.NewGoCollector())
+	}
+	if !*processMetrics {
+		prometheus.Unregister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
+
+	// Add resource attributes following the OpenTelemetry conventions,
+	// attributes given as flags take precedence over the environment
+	targetLabels := prometheus.Labels{
+		"geo_location_lat": *latitude,
+		"geo_location_lon": *longitude,
+	}
+	if env := os.Getenv("OTEL_RESOURCE_ATTRIBUTES"); env != "" {
+		for _, attribute := range strings.Split(env, ",") {
+			k, v, found := strings.Cut(attribute, "=")
+			if !found {
+				log.Fatalf("Invalid attribute in OTEL_RESOURCE_ATTRIBUTES: %s", attribute)
+			}
+			v = strings.TrimSpace(v)
+			if unescaped, err := url.PathUnescape(v); err == nil {
+				v = unescaped
+			}
+			targetLabels[sanitizeLabelName(strings.TrimSpace(k))] = v
+		}
+	}
+	for k, v := range resourceAttributes {
+		targetLabels[sanitizeLabelName(k)] = v
+	}
+	targetInfo := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "target_info",
+		Help:        "Target metadata",
+		ConstLabels: targetLabels,
+	})
+	targetInfo.Set(1)
+	prometheus.MustRegister(targetInfo)
+
+	// Add pod metadata when running in Kubernetes
+	if *downwardAPIDir != "" {
+		labels, err := readDownwardAPI(*downwardAPIDir)
+		if err != nil {
+			log.Fatalf("Error reading downward API: %s", err)
+		}
+		kubernetesInfo := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "forecast_solar_kubernetes_info",
+			Help:        "Kubernetes pod metadata read from the downward API",
+			ConstLabels: labels,
+		})
+		kubernetesInfo.Set(1)
+		prometheus.MustRegister(kubernetesInfo)
+	}
 
-				if err := json.NewDecoder(r.Body).Decode(res); err != nil {
-					log.Printf("Error decoding JSON: %s", err)
-					return
+	opts := &sourceOptions{
+		readOnly:      &readOnly,
+		quotaBehavior: *quotaBehavior,
+		quotaAfter:    *quotaAfter,
+		backoffMax:    time.Duration(*backoffMax) * time.Second,
+		expireAfter:   *expireAfter,
+		jitter:        time.Duration(*pollJitter) * time.Second,
+		pollTimeout:   time.Duration(*pollTimeout) * time.Second,
+		adaptive:      *pollAdaptive,
+		recordDir:     *recordDir,
+		gustThreshold: *gustThreshold,
+		gustWindow:    time.Duration(*gustWindow) * time.Hour,
+		requests:      newRequestGroup(),
+	}
+	prometheus.MustRegister(opts.requests.coalesced)
+	rateLimit := *apiRateLimit
+	if rateLimit == 0 && *apiKey == "" {
+		rateLimit = publicPlanRequests
+	}
+	if rateLimit > 0 && contains(providerNames, "forecast.solar") {
+		opts.limiter = newTokenBucket(rateLimit)
+		opts.limiter.register(prometheus.DefaultRegisterer)
+	}
+	if *apiKey != "" && contains(providerNames, "forecast.solar") {
+		opts.account = newAPIAccount(nil)
+		prometheus.MustRegister(opts.account)
+	}
+	// Sites of other accounts bring their own API keys
+	siteAccounts := newAPIAccounts()
+	prometheus.MustRegister(siteAccounts)
+	if *leaseName != "" {
+		if *leaseDuration < 3 {
+			log.Fatal("-leader-election.lease-duration must be at least 3 seconds")
+		}
+		e, err := newLeaderElection(*leaseName, *leaseNamespace, *leaseIdentity, time.Duration(*leaseDuration)*time.Second)
+		if err != nil {
+			log.Fatalf("Error configuring leader election: %s", err)
+		}
+		prometheus.MustRegister(e.metric)
+		opts.leader = e
+		// The leader is known before the first polls
+		e.step()
+	}
+	if *peerReplicas && *peerURLs == "" {
+		log.Fatal("-peer.replicas requires -peer.urls")
+	}
+	if *peerURLs != "" || *peerToken != "" {
+		var urls []string
+		if *peerURLs != "" {
+			urls = strings.Split(*peerURLs, ",")
+		}
+		if err := validatePeers(urls, *peerToken); err != nil {
+			log.Fatalf("Error: %s", err)
+		}
+		opts.peers = newPeerGroup(client, urls, *peerToken, *peerReplicas)
+		opts.peers.register(prometheus.DefaultRegisterer)
+	}
+	if *recordDir != "" {
+		if err := os.MkdirAll(*recordDir, 0o755); err != nil {
+			log.Fatalf("Error creating record directory: %s", err)
+		}
+	}
+
+	// Outputs the forecasts are written to after every poll, and outputs
+	// pushing the metrics in an interval
+	opts.outputs = newOutputs()
+	opts.outputs.register(prometheus.DefaultRegisterer)
+	var publisher *mqttPublisher
+	if *mqttBroker != "" {
+		publisher = newMQTTPublisher(*mqttBroker, *mqttClientID, *mqttUsername, *mqttPassword, *mqttTopic, *mqttDiscovery)
+		opts.outputs.add("mqtt", publisher)
+	}
+	if *influxURL != "" {
+		opts.outputs.add("influxdb", newInfluxWriter(client, *influxURL, *influxOrg, *influxBucket, *influxToken))
+	}
+	if *haURL != "" {
+		if *haToken == "" {
+			log.Fatalf("-homeassistant.url requires -homeassistant.token")
+		}
+		opts.outputs.add("homeassistant", newHomeAssistantWriter(*haURL, *haToken, time.Duration(*apiTimeout)*time.Second))
+	}
+	if *historyFile != "" {
+		var err error
+		opts.history, err = openHistory(*historyFile, *historyGzip)
+		if err != nil {
+			log.Fatalf("Error opening history: %s", err)
+		}
+		opts.history.setRetention(*historyRaw, *historyDaily, *historyRevs)
+		opts.history.register(prometheus.DefaultRegisterer)
+		if *replayDir == "" {
+			if days, err := opts.history.reconcile(time.Now()); err != nil {
+				log.Printf("Error reconciling history: %s", err)
+			} else if len(days) > 0 {
+				log.Printf("Exporter did not run from %s to %s, marking %d days as unknown in the history", days[0], days[len(days)-1], len(days))
+			}
+		}
+	}
+	if *cacheFile != "" {
+		var err error
+		opts.cache, err = openCache(*cacheFile)
+		if err != nil {
+			log.Fatalf("Error opening cache: %s", err)
+		}
+	}
+
+	usage, err := openUsage(*usageFile)
+	if err != nil {
+		log.Fatalf("Error opening usage file: %s", err)
+	}
+	opts.usage = usage
+
+	var dayParts []dayPart
+	if *dayPartsFlag != "" {
+		var err error
+		dayParts, err = parseDayParts(*dayPartsFlag)
+		if err != nil {
+			log.Fatalf("Error parsing day parts: %s", err)
+		}
+	}
+
+	load, err := parseLoadProfile(*loadProfile)
+	if err != nil {
+		log.Fatalf("Error parsing load profile: %s", err)
+	}
+
+	var tilts []float64
+	if *tiltCandidates != "" {
+		for _, tilt := range strings.Split(*tiltCandidates, ",") {
+			t, err := strconv.ParseFloat(strings.TrimSpace(tilt), 64)
+			if err != nil {
+				log.Fatalf("Invalid tilt %q: %s", tilt, err)
+			}
+			tilts = append(tilts, t)
+		}
+	}
+
+	siteOwners, err := parseOwners(ownersFlag)
+	if err != nil {
+		log.Fatalf("Error parsing owners: %s", err)
+	}
+
+	// Without a fleet file, the plane is configured by flags
+	sites := []site{{
+		Latitude:          *latitude,
+		Longitude:         *longitude,
+		Declination:       *declination,
+		Azimuth:           *az,
+		Kwp:               *kwp,
+		SolcastResourceID: *solcastSite,
+		Obstructions:      obstructions,
+		Owners:            siteOwners,
+		Derating:          *derating,
+	}}
+	// prepareSites converts the sites of the fleet file for polling
+	prepareSites := func(sites []site) ([]site, error) {
+		if err := validateTenants(sites, *adminToken); err != nil {
+			return nil, err
+		}
+		var err error
+		if *azConvention == "compass" {
+			for i := range sites {
+				if sites[i].Azimuth, err = compassToAPIAzimuth(sites[i].Azimuth); err != nil {
+					return nil, fmt.Errorf("converting azimuth of site %s: %s", sites[i].Name, err)
 				}
+				for j := range sites[i].Obstructions {
+					o := &sites[i].Obstructions[j]
+					if o.Bearing, err = compassToAPIBearing(o.Bearing); err != nil {
+						return nil, fmt.Errorf("converting bearing of obstruction of site %s: %s", sites[i].key(), err)
+					}
+				}
+			}
+		}
+		// The planes of a site are retrieved in a single request on paid
+		// plans, if no other provider needs them apart
+		if *apiKey != "" && len(providerNames) == 1 && providerNames[0] == "forecast.solar" {
+			sites = combinePlanes(sites)
+		}
+		return sites, nil
+	}
+	// loadSites loads the fleet file, on startup and when reloading
+	loadSites := func() ([]site, error) {
+		sites, err := loadFleet(*fleetFile)
+		if err != nil {
+			return nil, err
+		}
+		return prepareSites(sites)
+	}
 
-				// Hack to make sure first entry is today, second is tomorrow
-				sortedForecast := make([]string, 0, len(res.Result.WattHoursDay))
-				for date := range res.Result.WattHoursDay {
-					sortedForecast = append(sortedForecast, date)
+	var pool *pollPool
+	var fleet *fleetGatherer
+	if *fleetFile != "" {
+		var err error
+		sites, err = loadSites()
+		if err != nil {
+			// Misspelled keys are the usual cause of missing ones
+			if command == "check-config" {
+				unknown, _ := checkFleetKeys(*fleetFile)
+				for _, u := range unknown {
+					fmt.Fprintln(os.Stderr, u)
 				}
-				sort.Strings(sortedForecast)
+			}
+			log.Fatalf("Error loading fleet: %s", err)
+		}
+		if *actualURL != "" {
+			log.Fatal("-actual.url is not supported in fleet mode")
+		}
 
-				for i, date := range sortedForecast {
-					t, err := time.Parse(time.DateOnly, date)
-					if err != nil {
-						log.Printf("Error parsing date: %s", err)
-						return
-					}
-					kwh := res.Result.WattHoursDay[date]
-
-					if i == 0 {
-						today.Date = t
-						today.Kwh = float64(kwh)
-					} else if i == 1 {
-						tomorrow.Date = t
-						tomorrow.Kwh = float64(kwh)
-
-					} else {
-						log.Println("Error: Unexpected entry")
-						return
+		limits := map[string]int{}
+		for provider, limit := range providerConcurrency {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n < 1 {
+				log.Fatalf("Invalid concurrency limit of %s: %s", provider, limit)
+			}
+			limits[provider] = n
+		}
+		if *fleetWorkers < 1 {
+			log.Fatal("-fleet.workers must be at least 1")
+		}
+		pool = newPollPool(*fleetWorkers, limits)
+		pool.register(prometheus.DefaultRegisterer)
+		fleet = newFleetGatherer(prometheus.DefaultGatherer)
+		gatherer = fleet
+		log.Printf("Polling %d sites with %d workers", len(sites), *fleetWorkers)
+	}
+
+	var actual *actualProduction
+	if *actualURL != "" {
+		var src actualSource
+		switch {
+		case *actualQuery != "" && *actualField == "":
+			src = &promQLSource{url: *actualURL, query: *actualQuery}
+		case *actualField != "" && *actualQuery == "":
+			src = &jsonSource{url: *actualURL, field: *actualField}
+		default:
+			log.Fatal("-actual.url requires either -actual.query or -actual.json-field")
+		}
+		actual = newActualProduction(src, time.Duration(*actualPoll)*time.Second, opts.history)
+		prometheus.MustRegister(actual)
+	}
+
+	locale, err := parseLocale(*localeFlag)
+	if err != nil {
+		log.Fatalf("Error parsing locale: %s", err)
+	}
+	lang := *reportLang
+	if lang == "" {
+		lang = "en"
+		if l, _, _ := strings.Cut(strings.ReplaceAll(*localeFlag, "_", "-"), "-"); reportTranslations[strings.ToLower(l)] != nil {
+			lang = strings.ToLower(l)
+		}
+	}
+	reportTemplates, err := newReportTemplates(locale, lang, *reportTmplDir)
+	if err != nil {
+		log.Fatalf("Error loading report templates: %s", err)
+	}
+
+	admin, err := newAdminGuard(*adminToken, *adminAllow, *adminOpen)
+	if err != nil {
+		log.Fatalf("Error configuring the admin endpoints: %s", err)
+	}
+	// Profiles reveal the command line including secrets given as flags
+	if *enablePprof && *adminToken == "" {
+		log.Fatal("-web.enable-pprof requires -web.admin-token")
+	}
+
+	var audit *auditLog
+	if *auditLogFile != "" {
+		if audit, err = openAuditLog(*auditLogFile); err != nil {
+			log.Fatalf("Error opening audit log: %s", err)
+		}
+		opts.audit = audit
+	}
+
+	tariffs, err := parseTariffWindows(tariffFlag)
+	if err != nil {
+		log.Fatalf("Error parsing tariff windows: %s", err)
+	}
+	price, err := parseTariffSchedule(*tariffPrice, priceWindows, tariffs)
+	if err != nil {
+		log.Fatalf("Error parsing electricity price: %s", err)
+	}
+	feedIn, err := parseTariffSchedule(*tariffFeedIn, feedInWindows, tariffs)
+	if err != nil {
+		log.Fatalf("Error parsing feed-in tariff: %s", err)
+	}
+	bestWindows, err := parseBestWindows(*bestWindowFlag)
+	if err != nil {
+		log.Fatalf("Error parsing -best-window.durations: %s", err)
+	}
+	if *bestWindowLoad < 0 {
+		log.Fatal("-best-window.load must not be negative")
+	}
+	var carbon *carbonIntensity
+	if *co2Intensity < 0 {
+		log.Fatal("-co2.intensity must not be negative")
+	}
+	if *co2Zone != "" {
+		if *co2Token == "" {
+			log.Fatal("-co2.electricitymaps.zone requires -co2.electricitymaps.token")
+		}
+		if *co2Interval < 1 {
+			log.Fatal("-co2.electricitymaps.interval must be positive")
+		}
+		carbon = newCarbonIntensity(client, *co2Intensity, *co2Token, *co2Zone, time.Duration(*co2Interval)*time.Minute)
+	} else if *co2Intensity > 0 {
+		carbon = newCarbonIntensity(client, *co2Intensity, "", "", 0)
+	}
+	if carbon != nil {
+		carbon.register(prometheus.DefaultRegisterer)
+	}
+	requestCosts, err := parseRequestCosts(requestCostFlag)
+	if err != nil {
+		log.Fatalf("Error parsing request costs: %s", err)
+	}
+
+	dampingDefaults := map[string]*float64{"damping_morning": dampingMorning, "damping_evening": dampingEvening}
+
+	// siteBase returns the base URL of the forecast.solar API of a site
+	siteBase := func(site site) string {
+		if site.APIKey != "" {
+			return strings.TrimSuffix(*apiURL, "/") + "/" + site.APIKey + "/"
+		}
+		return apiBase
+	}
+	// checkSite validates the planes of a site against the check endpoint,
+	// like on startup
+	checkSite := func(site site) error {
+		url := fmt.Sprintf("%scheck/%s/%s/%s/%s/%s", siteBase(site), site.Latitude, site.Longitude, site.Declination, site.Azimuth, site.Kwp)
+		if err := checkParameters(client, url); err != nil {
+			if *startupCheck == "fail" {
+				return err
+			}
+			log.Printf("WARNING: Error validating parameters of site %s, polls will likely fail: %s", site.key(), err)
+		}
+		return nil
+	}
+
+	// estimateURL returns the URL of the forecast of a site by forecast.solar
+	estimateURL := func(site site) string {
+		// Damping is optional, only add the query parameters when set. The
+		// flags are the defaults of sites and planes of the fleet file.
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = v
+		}
+		for param, damping := range map[string]*float64{"damping_morning": site.DampingMorning, "damping_evening": site.DampingEvening} {
+			if damping == nil {
+				damping = dampingDefaults[param]
+			}
+			if *damping > 0 {
+				q.Set(param, formatDamping(damping))
+			}
+		}
+
+		base := siteBase(site)
+		url := fmt.Sprintf("%sestimate/%s/%s/%s/%s/%s", base, site.Latitude, site.Longitude, site.Declination, site.Azimuth, site.Kwp)
+		if len(site.Combined) > 0 {
+			url = fmt.Sprintf("%sestimate/%s/%s", base, site.Latitude, site.Longitude)
+			for _, p := range site.Combined {
+				url += fmt.Sprintf("/%s/%s/%s", p.Declination, p.Azimuth, p.Kwp)
+			}
+		}
+		if len(q) > 0 {
+			url += "?" + q.Encode()
+		}
+		return url
+	}
+
+	// newSiteProvider creates the provider for a site and its poll interval
+	newSiteProvider := func(providerName string, site site) (provider, time.Duration, error) {
+		switch providerName {
+		case "forecast.solar":
+			return &forecastSolar{url: estimateURL(site), apiKey: site.APIKey}, time.Duration(*pollInterval) * time.Second, nil
+		case "solcast":
+			if *solcastKey == "" || site.SolcastResourceID == "" {
+				return nil, 0, fmt.Errorf("the Solcast provider requires -solcast.api-key and -solcast.resource-id, or solcast_resource_id per site in fleet mode")
+			}
+			return newSolcast(site.SolcastResourceID, *solcastKey), time.Duration(*solcastPoll) * time.Second, nil
+		case "open-meteo":
+			peakPower, err := strconv.ParseFloat(site.Kwp, 64)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid peak power %s: %s", site.Kwp, err)
+			}
+			return newOpenMeteo(site.Latitude, site.Longitude, site.Declination, site.Azimuth, peakPower, *openMeteoLoss), time.Duration(*pollInterval) * time.Second, nil
+		case "file":
+			if *filePath == "" {
+				return nil, 0, fmt.Errorf("the file provider requires -file.path")
+			}
+			return &fileProvider{path: *filePath, shiftDates: *fileShift}, time.Duration(*pollInterval) * time.Second, nil
+		default:
+			return nil, 0, fmt.Errorf("unknown provider: %s", providerName)
+		}
+	}
+
+	// newSiteSource creates the source of a provider for a site, without
+	// registering or polling it yet
+	newSiteSource := func(providerName string, site site) (*source, error) {
+		name := providerName
+		if pool != nil {
+			name = providerName + "/" + site.key()
+		}
+		if *sunFlag || *eclipses || tilts != nil || *nightMode || len(site.Obstructions) > 0 {
+			if _, err := parsePlaneGeometry(site.Latitude, site.Longitude, site.Declination, site.Azimuth); err != nil {
+				return nil, err
+			}
+		}
+
+		p, interval, err := newSiteProvider(providerName, site)
+		if err != nil {
+			return nil, err
+		}
+		s := newSource(name, p, interval, opts)
+		if providerName == "forecast.solar" && site.APIKey != "" {
+			s.account = siteAccounts.get(site)
+			addSecret(site.APIKey)
+		}
+		// Backfill the history from the history endpoint of paid plans once
+		// a day, along with the polls
+		if providerName == "forecast.solar" && opts.history != nil && *apiKey != "" && pool == nil && *replayDir == "" {
+			s.historyEndpoint = &forecastSolar{url: fmt.Sprintf("%shistory/%s/%s/%s/%s/%s", apiBase, *latitude, *longitude, *declination, *az, *kwp)}
+		}
+		if providerName == "forecast.solar" && *quotaFallback != "" {
+			if s.fallback, _, err = newSiteProvider(*quotaFallback, site); err != nil {
+				return nil, fmt.Errorf("fallback provider: %s", err)
+			}
+		}
+
+		s.requestCost = requestCosts[providerName]
+		s.derating = site.Derating
+		if len(site.Obstructions) > 0 {
+			plane, _ := parsePlaneGeometry(site.Latitude, site.Longitude, site.Declination, site.Azimuth)
+			s.shading = &shadingMask{latitude: plane.latitude, longitude: plane.longitude, obstructions: site.Obstructions}
+		}
+		if *nightMode {
+			plane, _ := parsePlaneGeometry(site.Latitude, site.Longitude, site.Declination, site.Azimuth)
+			s.nightLocation = &plane
+		}
+		if providerName == "forecast.solar" && len(site.Combined) > 0 {
+			if s.share, err = newPlaneShare(site); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	}
+
+	// startSource registers the metrics of a source and schedules its polls
+	// in fleet mode
+	startSource := func(s *source, providerName string, site site, initialDelay time.Duration) {
+		// All metrics of a source carry the provider label, so providers
+		// can be compared side by side, and the site label in fleet mode
+		labels := prometheus.Labels{"provider": providerName}
+		if pool != nil {
+			labels["site"] = site.Name
+		}
+		if site.Plane != "" {
+			labels["plane"] = site.Plane
+		}
+		plane, _ := parsePlaneGeometry(site.Latitude, site.Longitude, site.Declination, site.Azimuth)
+
+		// Fleets gather the metrics of each site separately, so they are
+		// only gathered again when the site was polled
+		reg := prometheus.WrapRegistererWith(labels, prometheus.DefaultRegisterer)
+		volatile := reg
+		if fleet != nil {
+			reg, volatile = fleet.add(s, labels)
+		}
+		s.registerDataAge(volatile)
+		s.expiring(volatile).MustRegister(newCumulativeCollector(s))
+		if *powerAhead > 0 {
+			s.expiring(volatile).MustRegister(newAheadCollector(s, *powerAhead))
+		}
+		s.expiring(volatile).MustRegister(newQuantileCollector(s, *powerAhead))
+		if len(bestWindows) > 0 {
+			s.expiring(volatile).MustRegister(newBestWindowCollector(s, bestWindows, *bestWindowLoad, price))
+		}
+		forecasts := s.expiring(reg)
+		if *sunFlag {
+			volatile.MustRegister(newSunCollector(plane))
+		}
+		if *eclipses {
+			volatile.MustRegister(newEclipseCollector(plane))
+		}
+		s.register(reg)
+		reg.MustRegister(newPlaneInfo(site))
+		if *hourlyEnergy {
+			forecasts.MustRegister(newHourlyCollector(s.hourly))
+		}
+		if *metricSet == "power" {
+			forecasts.MustRegister(newHourlyPowerCollector(s.hourly))
+		}
+		if dayParts != nil {
+			forecasts.MustRegister(newDayPartsCollector(s.hourly, dayParts))
+		}
+		if *exportLimit >= 0 {
+			forecasts.MustRegister(newCurtailmentCollector(s.hourly, *exportLimit, load))
+		}
+		if carbon != nil {
+			forecasts.MustRegister(newCarbonCollector(s, carbon))
+		}
+		if price.enabled() || feedIn.enabled() {
+			forecasts.MustRegister(newTariffCollector(s.hourly, load, *exportLimit, price, feedIn, *tariffCurrency))
+		}
+		if opts.history != nil {
+			reg.MustRegister(newHistoryCollector(opts.history, s.name))
+		}
+		if actual != nil {
+			reg.MustRegister(newErrorCollector(actual, s.previous))
+		}
+		if tilts != nil {
+			forecasts.MustRegister(newTiltCollector(plane, tilts, *tiltWindow, s.today, s.tomorrow))
+		}
+
+		if pool != nil {
+			pool.add(s, providerName, initialDelay)
+		}
+	}
+
+	var sources []*source
+	var reloader *fleetReloader
+	if fleet != nil {
+		reloader = &fleetReloader{
+			path:      *fleetFile,
+			client:    client,
+			load:      loadSites,
+			check:     checkSite,
+			readOnly:  &readOnly,
+			providers: providerNames,
+			newSource: newSiteSource,
+			start:     startSource,
+			stop: func(s *source) {
+				pool.remove(s)
+				fleet.remove(s)
+			},
+		}
+		if *startupCheck == "off" || !contains(providerNames, "forecast.solar") {
+			reloader.check = nil
+		}
+	}
+	for _, site := range sites {
+		for _, providerName := range providerNames {
+			s, err := newSiteSource(providerName, site)
+			if err != nil {
+				log.Fatalf("Error: %s", err)
+			}
+			var initialDelay time.Duration
+			if pool != nil {
+				initialDelay = s.loadCache()
+			}
+			startSource(s, providerName, site, initialDelay)
+			if reloader != nil {
+				reloader.add(site, s)
+			}
+			sources = append(sources, s)
+		}
+	}
+
+	if *referenceSite != "" {
+		if *referenceLat == "" || *referenceLon == "" {
+			log.Fatal("-reference.site requires -reference.latitude and -reference.longitude")
+		}
+		url := fmt.Sprintf("%sestimate/%s/%s/%s/%s/%s", apiBase, *referenceLat, *referenceLon, *referenceDec, *referenceAz, *referenceKwp)
+		s := newReferenceSource(*referenceSite, url, time.Duration(*pollInterval)*time.Second, opts)
+		prometheus.MustRegister(s.today, s.tomorrow)
+		if pool != nil {
+			pool.add(s, "forecast.solar", s.loadCache())
+		}
+		sources = append(sources, s)
+	}
+
+	shareRateLimit(sources)
+
+	if *metricSet != "all" {
+		gatherer = withMetricSet(gatherer, *metricSet)
+	}
+	if *energyUnit != "" {
+		gatherer = withEnergyUnit(gatherer, *energyUnit)
+	}
+	if len(constLabelsFlag) > 0 {
+		labels, err := parseConstLabels(constLabelsFlag)
+		if err != nil {
+			log.Fatalf("Error parsing labels: %s", err)
+		}
+		gatherer = withConstLabels(gatherer, labels)
+	}
+	if *namePrefix != defaultMetricPrefix {
+		if !model.IsValidMetricName(model.LabelValue(*namePrefix + "_today")) {
+			log.Fatalf("Invalid metric prefix %q", *namePrefix)
+		}
+		metricPrefix = *namePrefix
+		gatherer = withMetricPrefix(gatherer, metricPrefix)
+	}
+
+	if !strings.HasPrefix(*metricsPath, "/") || *metricsPath == "/" {
+		log.Fatal("-web.telemetry-path must start with / and not be the web UI at /")
+	}
+	if *pushOnceFlag && *pushgateway == "" {
+		log.Fatal("-pushgateway.once requires -pushgateway.url")
+	}
+	if *reportSMTP != "" {
+		if opts.history == nil {
+			log.Fatal("-report.smtp-server requires -history-file")
+		}
+		if *reportFrom == "" || *reportTo == "" {
+			log.Fatal("-report.smtp-server requires -report.email-from and -report.email-to")
+		}
+	}
+
+	if command == "check-config" {
+		problems := checkSites(sites)
+		if *fleetFile != "" {
+			unknown, err := checkFleetKeys(*fleetFile)
+			if err != nil {
+				log.Fatalf("Error checking fleet: %s", err)
+			}
+			problems = append(problems, unknown...)
+		}
+		if len(problems) > 0 {
+			for _, p := range problems {
+				fmt.Fprintln(os.Stderr, p)
+			}
+			fmt.Fprintf(os.Stderr, "Configuration is invalid, %d problems\n", len(problems))
+			os.Exit(1)
+		}
+		fmt.Printf("Configuration is valid, %d sources\n", len(sources))
+		return
+	}
+
+	// Polls and the servers stop on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if opts.leader != nil {
+		go opts.leader.run(ctx)
+	}
+	if carbon != nil && *co2Zone != "" {
+		go carbon.run(ctx)
+	}
+	if opts.history != nil {
+		go opts.history.run(ctx)
+	}
+
+	if publisher != nil {
+		for _, s := range sources {
+			publisher.addSource(s.name)
+		}
+		publisher.connect()
+	}
+
+	if *otlpEndpoint != "" {
+		e := &otlpExporter{
+			client:   client,
+			gatherer: gatherer,
+			url:      *otlpEndpoint,
+			headers:  otlpHeaders,
+			resource: targetLabels,
+			start:    time.Now(),
+		}
+		opts.outputs.addPush("otlp", e, time.Duration(*otlpInterval)*time.Second)
+	}
+
+	if *pushgateway != "" {
+		pusher := newPusher(client, *pushgateway, *pushJob, pushGrouping)
+		if *pushOnceFlag {
+			if err := pushOnce(ctx, pusher, client, sources); err != nil {
+				log.Fatalf("Error: %s", err)
+			}
+			return
+		}
+		opts.outputs.addPush("pushgateway", gatewaySink{pusher}, time.Duration(*pushInterval)*time.Second)
+	}
+
+	if *once {
+		if err := queryOnce(ctx, os.Stdout, client, sources, gatherer, *onceOutput, locale); err != nil {
+			log.Fatalf("Error: %s", err)
+		}
+		return
+	}
+
+	if *textfileDir != "" {
+		if *textfileInt == 0 {
+			pollErr := pollOnce(ctx, client, sources)
+			if err := writeTextfile(*textfileDir); err != nil {
+				log.Fatalf("Error writing textfile: %s", err)
+			}
+			if pollErr != nil {
+				log.Fatalf("Error: %s", pollErr)
+			}
+			return
+		}
+		go runTextfile(ctx, *textfileDir, time.Duration(*textfileInt)*time.Second)
+	}
+
+	// Poll loops, fleets share a pool of workers. In scrape mode, sources are
+	// polled by the gatherer of /metrics instead.
+	switch {
+	case *replayDir != "":
+		recordings, err := loadRecordings(*replayDir)
+		if err != nil {
+			log.Fatalf("Error loading recordings: %s", err)
+		}
+		if len(recordings) == 0 {
+			log.Fatalf("No recordings in %s", *replayDir)
+		}
+		log.Printf("Replaying %d recorded responses from %s on", len(recordings), recordings[0].Time.Format(time.RFC3339))
+		c := &replayClock{speed: *replaySpeed}
+		c.set(recordings[0].Time)
+		clock = c.now
+		go replay(recordings, sources, c)
+	case *collectionMode == "scrape":
+		gatherer = newScrapeGatherer(ctx, client, gatherer, sources, pool)
+	case pool != nil:
+		pool.start(ctx, client)
+	default:
+		for _, s := range sources {
+			go newScheduler(s, client).run(ctx, s.loadCache())
+		}
+	}
+
+	// Sources change when the fleet is reloaded
+	set := newSourceSet(sources)
+	if opts.peers != nil {
+		opts.peers.sources = set
+		go opts.peers.run(ctx)
+	}
+	if fleet != nil {
+		prometheus.MustRegister(newSiteTotalCollector(set))
+	}
+	owners := newOwnerShares(set, sites)
+	prometheus.MustRegister(newOwnerCollector(owners))
+	if *replayDir == "" {
+		go runDayEnd(ctx, set, actual, opts.history)
+	}
+	go runSystemdNotify(set, *collectionMode != "scrape" && *replayDir == "")
+
+	if actual != nil {
+		go actual.run(ctx, client)
+	}
+
+	// The anonymized accuracy is shared with the community once a day
+	if *communityURL != "" && *replayDir == "" {
+		if actual == nil {
+			log.Fatal("-community.url requires -actual.url")
+		}
+		if *communityGridD < 0.1 || *communityEps <= 0 {
+			log.Fatal("-community.grid-degrees must be at least 0.1 and -community.epsilon positive")
+		}
+		lat, _ := strconv.ParseFloat(*latitude, 64)
+		lon, _ := strconv.ParseFloat(*longitude, 64)
+		c := newCommunitySharer(client, *communityURL, lat, lon, *communityGridD, *communityEps, sources, actual)
+		c.register(prometheus.DefaultRegisterer)
+		go c.run(ctx)
+	}
+
+	if *reportSMTP != "" {
+		m := &reportMailer{
+			history:   opts.history,
+			templates: reportTemplates,
+			addr:      *reportSMTP,
+			username:  *reportSMTPUser,
+			password:  *reportSMTPPass,
+			from:      *reportFrom,
+			to:        strings.Split(*reportTo, ","),
+		}
+		go m.run(ctx)
+	}
+
+	if *updateCheck > 0 {
+		u := newUpdateChecker(client, time.Duration(*updateCheck)*time.Hour)
+		prometheus.MustRegister(u.available)
+		go u.run(ctx)
+	}
+
+	if *alertmanager != "" && *replayDir == "" {
+		if len(silenceMatchers) == 0 {
+			log.Fatalf("-alertmanager.url requires at least one -alertmanager.silence-matcher")
+		}
+		go newAlertSilencer(client, *alertmanager, silenceMatchers, *silenceBelow, set).run(ctx)
+	}
+
+	if *notifyURL != "" && *replayDir == "" {
+		if len(notifyRules) == 0 {
+			log.Fatalf("-notify.url requires at least one -notify.rule")
+		}
+		var tmpl *texttemplate.Template
+		if *notifyTemplate != "" {
+			if tmpl, err = texttemplate.New("notify").Parse(*notifyTemplate); err != nil {
+				log.Fatalf("Error parsing -notify.template: %s", err)
+			}
+		}
+		go newNotifier(client, *notifyURL, notifyHeaders, tmpl, notifyRules, set).run(ctx)
+	}
+
+	if *remoteWriteURL != "" {
+		w := &remoteWriter{
+			client:      client,
+			gatherer:    gatherer,
+			url:         *remoteWriteURL,
+			bearerToken: *remoteWriteTok,
+			username:    *remoteWriteUsr,
+			password:    *remoteWritePw,
+		}
+		opts.outputs.addPush("remote-write", w, time.Duration(*remoteWriteInt)*time.Second)
+	}
+
+	if *graphiteAddr != "" {
+		w := &graphiteWriter{
+			address: *graphiteAddr,
+			prefix:  strings.Trim(*graphitePrefix, "."),
+			timeout: time.Duration(*apiTimeout) * time.Second,
+			sources: set,
+		}
+		opts.outputs.addPush("graphite", w, time.Duration(*graphiteInt)*time.Second)
+	}
+
+	if *statsdAddr != "" {
+		prefix := strings.Trim(*statsdPrefix, ".")
+		if prefix != "" {
+			prefix += "."
+		}
+		w := &statsdWriter{
+			address: *statsdAddr,
+			prefix:  prefix,
+			tags:    *statsdTags,
+			sources: set,
+		}
+		opts.outputs.addPush("statsd", w, time.Duration(*statsdInt)*time.Second)
+	}
+	opts.outputs.start(ctx)
+
+	// The textfile collector replaces the HTTP listener
+	if *textfileDir != "" {
+		<-ctx.Done()
+		log.Printf("Shutting down")
+		return
+	}
+
+	// Site tokens restrict the JSON API of fleets to the site
+	tenants := newTenantGuard(sites, *adminToken)
+
+	// Fleets polled in the background can be reloaded while running
+	if reloader != nil && pool != nil && *collectionMode != "scrape" && *replayDir == "" {
+		reloader.sources = set
+		reloader.tenants = tenants
+		reloader.owners = owners
+		go reloader.reloadOnSignal()
+		if *fleetWatch {
+			if err := reloader.watch(); err != nil {
+				log.Fatalf("Error watching fleet file: %s", err)
+			}
+		}
+	} else {
+		reloader = nil
+	}
+	var settings *settingsPage
+	if *enableSettings {
+		if reloader == nil || *adminToken == "" {
+			log.Fatal("-web.enable-settings requires -fleet.file polled in the background and -web.admin-token")
+		}
+		settings = &settingsPage{
+			path:     *fleetFile,
+			guard:    admin,
+			reloader: reloader,
+			audit:    audit,
+			prepare:  prepareSites,
+			request: func(s site) string {
+				if !contains(providerNames, "forecast.solar") {
+					return ""
+				}
+				// The key of a site may be new to the fleet and not yet
+				// registered as secret
+				url := redactSecrets(estimateURL(s))
+				for _, key := range []string{s.APIKey, *apiKey} {
+					if key != "" {
+						url = strings.ReplaceAll(url, key, "<secret>")
 					}
 				}
+				return url
+			},
+		}
+	}
 
+	// Expose the registered metrics via HTTP. The mux is not the default one,
+	// which net/http/pprof registers its handlers on.
+	metrics := promhttp.HandlerFor(
+		gatherer,
+		promhttp.HandlerOpts{EnableOpenMetrics: *openMetrics},
+	)
+	healthy := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "OK")
+	}
+	// Ready once the first polls are done, sources polled on scrapes are
+	// polled by the first scrape
+	ready := func(w http.ResponseWriter, r *http.Request) {
+		if *collectionMode != "scrape" && !firstPollsDone(set.all()) {
+			http.Error(w, "Waiting for the first polls", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "OK")
+	}
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle(*metricsPath, metrics)
+	metricsMux.HandleFunc("/-/healthy", healthy)
+	metricsMux.HandleFunc("/-/ready", ready)
+
+	if admin.unauthenticated {
+		log.Printf("Warning: admin endpoints accept changes from all clients")
+	} else if !admin.configured() {
+		log.Printf("Admin endpoints deny all changes, set -web.admin-token, -web.admin-allow or -web.admin-unauthenticated")
+	}
+	mux := http.NewServeMux()
+	mux.Handle(*metricsPath, metrics)
+	mux.Handle(strings.TrimSuffix(*metricsPath, "/")+"/docs", metricDocsHandler(gatherer, *metricsPath))
+	mux.Handle("/prometheus/recording-rules.yaml", recordingRulesHandler(recordingRules(metricPrefix, *energyUnit, *metricSet != "power", pool != nil, actual != nil)))
+	mux.HandleFunc("/-/healthy", healthy)
+	mux.HandleFunc("/-/ready", ready)
+	mux.Handle("/-/read-only", admin.wrap(readOnlyHandler(&readOnly, audit)))
+	mux.Handle("/-/log-level", admin.wrap(logLevelHandler(audit)))
+	// Changing the fleet via HTTP requires the admin token, as it rewrites the
+	// fleet file and triggers polls, SIGHUP reloads without
+	if reloader != nil && *adminToken != "" {
+		mux.Handle("/-/reload", admin.wrap(reloadHandler(reloader, audit)))
+		mux.Handle("/api/v1/planes", admin.wrap(planesHandler(reloader, audit)))
+	}
+	if settings != nil {
+		mux.Handle("/settings", settings)
+	}
+	if opts.peers != nil {
+		mux.Handle("/api/v1/peer", opts.peers)
+	}
+	if audit != nil {
+		mux.Handle("/api/v1/audit", tenants.wrap(audit, true))
+	}
+	if chaos != nil {
+		mux.Handle("/-/chaos", admin.wrap(chaosHandler(chaos, audit)))
+	}
+	mux.Handle("/api/v1/config", admin.require(http.HandlerFunc(configHandler)))
+	mux.Handle("/api/v1/forecast", tenants.wrap(forecastHandler(set, opts.history), false))
+	mux.Handle("/api/v1/usage", tenants.wrap(usage, false))
+	mux.Handle("/api/v1/owners", tenants.wrap(owners, false))
+	if *webhookSecret != "" {
+		mux.Handle("/api/v1/webhook", webhookHandler(*webhookSecret, set))
+	}
+	mux.Handle("/", tenants.wrap(&webUI{
+		sources:     set,
+		metricsPath: *metricsPath,
+		locale:      locale,
+		history:     opts.history != nil,
+		settings:    settings != nil,
+		tariffs:     tariffs,
+	}, false))
+	if opts.history != nil {
+		mux.Handle("/api/v1/history", tenants.wrap(opts.history, false))
+		mux.Handle("/api/v1/history/query", tenants.wrap(historyQueryHandler(opts.history), false))
+		mux.Handle("/reports/latest", tenants.wrap(reportHandler(opts.history, reportTemplates), true))
+	}
+	if *enablePprof {
+		mux.Handle("/debug/pprof/", admin.require(http.HandlerFunc(pprof.Index)))
+		mux.Handle("/debug/pprof/cmdline", admin.require(http.HandlerFunc(pprof.Cmdline)))
+		mux.Handle("/debug/pprof/profile", admin.require(http.HandlerFunc(pprof.Profile)))
+		mux.Handle("/debug/pprof/symbol", admin.require(http.HandlerFunc(pprof.Symbol)))
+		mux.Handle("/debug/pprof/trace", admin.require(http.HandlerFunc(pprof.Trace)))
+	}
+	// All addresses are listened on before serving, so a taken port fails
+	// the start
+	listeners := make([]net.Listener, len(listenAddrs.addresses))
+	for i, a := range listenAddrs.addresses {
+		l, err := listen(a.address)
+		if err != nil {
+			log.Fatalf("Error listening on %s: %s", a.address, err)
+		}
+		listeners[i] = l
+	}
+	waitConsul := func(context.Context) {}
+	if *consulURL != "" {
+		c, err := newConsulService(client, *consulURL, *consulToken, *consulService, *consulTags, *consulAddress, listeners[0].Addr(), *metricsPath)
+		if err != nil {
+			log.Fatalf("Error registering in Consul: %s", err)
+		}
+		waitConsul = c.wait
+		go c.run(ctx)
+	}
+	errs := make(chan error, len(listeners)+1)
+	var servers []*http.Server
+	var acmeManager *autocert.Manager
+	if *acmeHosts != "" {
+		acmeManager = newACMEManager(*acmeHosts, *acmeCacheDir, *acmeEmail, *acmeDirectory)
+		if *acmeHTTPAddr != "" {
+			server := &http.Server{
+				Addr:        *acmeHTTPAddr,
+				Handler:     acmeManager.HTTPHandler(nil),
+				ReadTimeout: time.Duration(*readTimeout) * time.Second,
+			}
+			servers = append(servers, server)
+			go func() {
+				errs <- server.ListenAndServe()
 			}()
 		}
-	}()
+	}
+	for i, l := range listeners {
+		handler := http.Handler(mux)
+		if listenAddrs.addresses[i].handlers == "metrics" {
+			handler = metricsMux
+		}
+		// Hung clients must not pin connections forever, e.g. on small
+		// devices
+		server := &http.Server{
+			Handler:        handler,
+			ReadTimeout:    time.Duration(*readTimeout) * time.Second,
+			WriteTimeout:   time.Duration(*writeTimeout) * time.Second,
+			IdleTimeout:    time.Duration(*idleTimeout) * time.Second,
+			MaxHeaderBytes: *maxHeaderBytes,
+		}
+		servers = append(servers, server)
+		if acmeManager != nil && servesTLS(l) {
+			server.TLSConfig = acmeManager.TLSConfig()
+			go func(l net.Listener) {
+				errs <- server.ServeTLS(l, "", "")
+			}(l)
+			continue
+		}
+		go func(l net.Listener) {
+			errs <- server.Serve(l)
+		}(l)
+	}
+	select {
+	case err := <-errs:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
 
-	// Expose the registered metrics via HTTP
-	http.Handle("/metrics", promhttp.HandlerFor(
-		prometheus.DefaultGatherer,
-		promhttp.HandlerOpts{},
-	))
-	log.Fatal(http.ListenAndServe(*listenAddr, nil))
+	// A second signal exits right away
+	stop()
+	log.Printf("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error shutting down server: %s", err)
+		}
+	}
+	waitConsul(shutdownCtx)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	htmltemplate "html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metricDoc documents an exported metric
+type metricDoc struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Help    string   `json:"help"`
+	Unit    string   `json:"unit,omitempty"`
+	Labels  []string `json:"labels"`
+	Series  int      `json:"series"`
+	Example string   `json:"example,omitempty"` // First series as in the exposition format
+}
+
+// metricUnits maps metric name suffixes to their units, longest first
+var metricUnits = []struct{ suffix, unit string }{
+	{"_watts_per_square_meter", "W/m²"},
+	{"_meters_per_second", "m/s"},
+	{"_watts_ahead", "W"},
+	{"_watts", "W"},
+	{"_seconds_total", "seconds"},
+	{"_seconds", "seconds"},
+	{"_kwh", "kWh"},
+	{"_watthours", "Wh"},
+	{"_kilometers", "km"},
+	{"_degrees", "degrees"},
+	{"_bytes", "bytes"},
+	{"_ratio", "ratio"},
+	{"_percent", "percent"},
+}
+
+// metricDocs documents the metrics currently exported by g, so the names,
+// labels and example values follow the configuration
+func metricDocs(g prometheus.Gatherer) ([]metricDoc, error) {
+	families, err := g.Gather()
+	if err != nil && len(families) == 0 {
+		return nil, err
+	}
+
+	docs := make([]metricDoc, 0, len(families))
+	for _, mf := range families {
+		doc := metricDoc{
+			Name:   mf.GetName(),
+			Type:   strings.ToLower(mf.GetType().String()),
+			Help:   mf.GetHelp(),
+			Labels: []string{},
+			Series: len(mf.Metric),
+		}
+		for _, u := range metricUnits {
+			if strings.HasSuffix(doc.Name, u.suffix) {
+				doc.Unit = u.unit
+				break
+			}
+		}
+
+		labels := map[string]bool{}
+		for _, m := range mf.Metric {
+			for _, l := range m.Label {
+				labels[l.GetName()] = true
+			}
+		}
+		doc.Labels = append(doc.Labels, sortedKeys(labels)...)
+
+		if len(mf.Metric) > 0 {
+			doc.Example = exampleSeries(doc.Name, mf.GetType(), mf.Metric[0])
+		}
+		docs = append(docs, doc)
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Name < docs[j].Name })
+	return docs, nil
+}
+
+// exampleSeries formats a series like the exposition format. Histograms and
+// summaries are represented by their sample count.
+func exampleSeries(name string, t dto.MetricType, m *dto.Metric) string {
+	var value float64
+	switch t {
+	case dto.MetricType_COUNTER:
+		value = m.GetCounter().GetValue()
+	case dto.MetricType_GAUGE:
+		value = m.GetGauge().GetValue()
+	case dto.MetricType_UNTYPED:
+		value = m.GetUntyped().GetValue()
+	case dto.MetricType_HISTOGRAM:
+		name += "_count"
+		value = float64(m.GetHistogram().GetSampleCount())
+	case dto.MetricType_SUMMARY:
+		name += "_count"
+		value = float64(m.GetSummary().GetSampleCount())
+	}
+
+	labels := make([]string, 0, len(m.Label))
+	for _, l := range m.Label {
+		labels = append(labels, l.GetName()+"="+strconv.Quote(l.GetValue()))
+	}
+	if len(labels) > 0 {
+		name += "{" + strings.Join(labels, ",") + "}"
+	}
+	return name + " " + strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// metricDocsHandler serves the documentation of the exported metrics as HTML,
+// or as JSON with format=json
+func metricDocsHandler(g prometheus.Gatherer, metricsPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		docs, err := metricDocs(g)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		switch r.FormValue("format") {
+		case "", "html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			metricDocsTemplate.Execute(w, struct {
+				MetricsPath string
+				Metrics     []metricDoc
+			}{metricsPath, docs})
+		case "json":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(docs)
+		default:
+			http.Error(w, "Unknown format, expected html or json", http.StatusBadRequest)
+		}
+	}
+}
+
+const metricDocsHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Exported metrics</title>
+<style>
+body { font-family: sans-serif; max-width: 70em; margin: 2em auto; padding: 0 1em; }
+table { border-collapse: collapse; }
+th, td { padding: .2em .8em; border-bottom: 1px solid #ccc; text-align: left; vertical-align: top; }
+td.n { text-align: right; }
+code { word-break: break-all; }
+</style>
+</head>
+<body>
+<h1>Exported metrics</h1>
+<p>Metrics currently exported under <a href="{{.MetricsPath}}">{{.MetricsPath}}</a> with the running configuration, also available <a href="?format=json">as JSON</a>.</p>
+<table>
+<tr><th>Name</th><th>Type</th><th>Unit</th><th>Labels</th><th>Series</th><th>Help and example</th></tr>
+{{range .Metrics}}<tr><td><code>{{.Name}}</code></td><td>{{.Type}}</td><td>{{.Unit}}</td><td>{{range $i, $l := .Labels}}{{if $i}}, {{end}}{{$l}}{{end}}</td><td class="n">{{.Series}}</td><td>{{.Help}}{{with .Example}}<br><code>{{.}}</code>{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`
+
+var metricDocsTemplate = htmltemplate.Must(htmltemplate.New("docs").Parse(metricDocsHTML))
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// defaultMetricPrefix is the prefix all metrics of the exporter are
+// registered with
+const defaultMetricPrefix = "forecast_solar"
+
+// metricPrefix is the prefix of the exported metrics, set by -metric-prefix
+var metricPrefix = defaultMetricPrefix
+
+// withMetricPrefix renames the metrics registered with the default prefix to
+// start with prefix instead. Families are copied, as the fleet gatherer
+// shares them between scrapes.
+func withMetricPrefix(g prometheus.Gatherer, prefix string) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := g.Gather()
+		for i, mf := range families {
+			rest, ok := strings.CutPrefix(mf.GetName(), defaultMetricPrefix+"_")
+			if !ok {
+				continue
+			}
+			name := prefix + "_" + rest
+			families[i] = &dto.MetricFamily{
+				Name:   &name,
+				Help:   mf.Help,
+				Type:   mf.Type,
+				Metric: mf.Metric,
+			}
+		}
+		return families, err
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Daily energy forecasts, which are exported in Wh without unit suffix for
+// historical reasons
+var dailyEnergyMetrics = map[string]bool{
+	"forecast_solar_today":                true,
+	"forecast_solar_tomorrow":             true,
+	"forecast_solar_today_final":          true,
+	"forecast_solar_reference_today":      true,
+	"forecast_solar_reference_tomorrow":   true,
+	"forecast_solar_history_today":        true,
+	"forecast_solar_history_today_change": true,
+}
+
+// inMetricSet returns whether a metric belongs to the metric set: energy
+// leaves out power metrics, power leaves out energy metrics. Metrics of
+// neither, such as the sun events or the API statistics, are in every set.
+func inMetricSet(name, set string) bool {
+	energy := strings.HasSuffix(name, "_kwh") || dailyEnergyMetrics[name]
+	power := strings.HasSuffix(name, "_watts") || strings.HasSuffix(name, "_watts_ahead")
+	switch set {
+	case "energy":
+		return !power
+	case "power":
+		return !energy
+	}
+	return true
+}
+
+// withMetricSet drops the metrics not in the metric set
+func withMetricSet(g prometheus.Gatherer, set string) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := g.Gather()
+		kept := make([]*dto.MetricFamily, 0, len(families))
+		for _, mf := range families {
+			if inMetricSet(mf.GetName(), set) {
+				kept = append(kept, mf)
+			}
+		}
+		return kept, err
+	})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	promVersion "github.com/prometheus/common/version"
+)
+
+// mqttPublisher publishes the forecasts of all sources as retained messages,
+// optionally announcing them via Home Assistant MQTT discovery
+type mqttPublisher struct {
+	client          mqtt.Client
+	topicPrefix     string
+	discoveryPrefix string
+
+	mu      sync.Mutex
+	sources []string
+	last    map[string]*apiResponse // Republished on reconnect
+}
+
+func newMQTTPublisher(broker, clientID, username, password, topicPrefix, discoveryPrefix string) *mqttPublisher {
+	p := &mqttPublisher{
+		topicPrefix:     strings.TrimSuffix(topicPrefix, "/"),
+		discoveryPrefix: strings.TrimSuffix(discoveryPrefix, "/"),
+		last:            map[string]*apiResponse{},
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetUsername(username).
+		SetPassword(password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetWill(p.topicPrefix+"/status", "offline", 1, true).
+		SetOnConnectHandler(p.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("Error: lost connection to MQTT broker: %s", err)
+		})
+	p.client = mqtt.NewClient(opts)
+	return p
+}
+
+// connect connects to the broker in the background, sources have to be added
+// before to be announced
+func (p *mqttPublisher) connect() {
+	// With SetConnectRetry the token only completes once connected, so don't
+	// block startup on an unavailable broker
+	p.client.Connect()
+}
+
+// addSource registers a source to announce via discovery
+func (p *mqttPublisher) addSource(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sources = append(p.sources, name)
+}
+
+// onConnect announces availability and the sensors on every (re)connect, as
+// Home Assistant may have been restarted in between
+func (p *mqttPublisher) onConnect(_ mqtt.Client) {
+	log.Printf("Connected to MQTT broker")
+	p.send(p.topicPrefix+"/status", "online")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, res := range p.last {
+		for _, token := range p.sendForecast(name, res) {
+			logPublishError(p.sourceTopic(name, ""), token)
+		}
+	}
+
+	if p.discoveryPrefix == "" {
+		return
+	}
+	for _, name := range p.sources {
+		for _, day := range []string{"today", "tomorrow"} {
+			id := "forecast_solar_" + sanitizeLabelName(name) + "_" + day
+			config := map[string]interface{}{
+				"name":                  fmt.Sprintf("Solar forecast %s (%s)", day, name),
+				"unique_id":             id,
+				"object_id":             id,
+				"state_topic":           p.sourceTopic(name, day),
+				"json_attributes_topic": p.sourceTopic(name, day+"/hourly"),
+				"availability_topic":    p.topicPrefix + "/status",
+				"unit_of_measurement":   "kWh",
+				"device_class":          "energy",
+				"icon":                  "mdi:solar-power",
+				"device": map[string]interface{}{
+					"identifiers":  []string{"forecast_solar_exporter"},
+					"name":         "Solar forecast",
+					"manufacturer": "forecast_solar_exporter",
+					"sw_version":   promVersion.Version,
+				},
+			}
+			body, err := json.Marshal(config)
+			if err != nil {
+				log.Printf("Error encoding MQTT discovery config: %s", err)
+				continue
+			}
+			p.send(p.discoveryPrefix+"/sensor/"+id+"/config", string(body))
+		}
+	}
+}
+
+// sourceTopic returns the topic of a value of a source
+func (p *mqttPublisher) sourceTopic(name, suffix string) string {
+	return p.topicPrefix + "/" + strings.ReplaceAll(name, " ", "_") + "/" + suffix
+}
+
+// write publishes the forecast of a source if connected, returning the
+// number of messages sent. Otherwise it is published on connect.
+func (p *mqttPublisher) write(name string, res *apiResponse) (int, error) {
+	p.mu.Lock()
+	p.last[name] = res
+	var tokens []mqtt.Token
+	if p.client.IsConnected() {
+		tokens = p.sendForecast(name, res)
+	}
+	p.mu.Unlock()
+
+	for _, token := range tokens {
+		if !token.WaitTimeout(10 * time.Second) {
+			return 0, fmt.Errorf("timeout publishing the forecast of %s", name)
+		}
+		if err := token.Error(); err != nil {
+			return 0, err
+		}
+	}
+	return len(tokens), nil
+}
+
+// sendForecast publishes the daily forecasts of a source in kWh, and the
+// hourly forecasts of each day as JSON object of watt hours by period end in
+// local time of the plant
+func (p *mqttPublisher) sendForecast(name string, res *apiResponse) (tokens []mqtt.Token) {
+	for i, date := range forecastDays(res) {
+		day := []string{"today", "tomorrow"}[i]
+		tokens = append(tokens, p.client.Publish(p.sourceTopic(name, day), 1, true, strconv.FormatFloat(res.Result.WattHoursDay[date]/1000, 'f', 3, 64)))
+
+		hourly := map[string]float64{}
+		for period, wh := range res.Result.WattHoursPeriod {
+			t, err := time.ParseInLocation(time.DateTime, period, res.location())
+			if err != nil {
+				continue
+			}
+			// Periods end at their key, midnight belongs to the previous day
+			if t.Add(-time.Second).Format(time.DateOnly) == date {
+				hourly[period] = wh
+			}
+		}
+		body, err := json.Marshal(hourly)
+		if err != nil {
+			log.Printf("Error encoding hourly forecast: %s", err)
+			continue
+		}
+		tokens = append(tokens, p.client.Publish(p.sourceTopic(name, day+"/hourly"), 1, true, string(body)))
+	}
+	return tokens
+}
+
+func (p *mqttPublisher) send(topic, payload string) {
+	logPublishError(topic, p.client.Publish(topic, 1, true, payload))
+}
+
+// logPublishError logs in the background if publishing to topic fails
+func logPublishError(topic string, token mqtt.Token) {
+	go func() {
+		if token.WaitTimeout(10*time.Second) && token.Error() != nil {
+			log.Printf("Error publishing to %s: %s", topic, token.Error())
+		}
+	}()
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Interval between checks of the forecasts against the notification rules
+const notifyCheckInterval = time.Minute
+
+// notifyRule is a threshold of the forecast for today or tomorrow, such as
+// tomorrow<5 for less than 5 kWh tomorrow
+type notifyRule struct {
+	day       string // today or tomorrow
+	op        string
+	threshold float64 // kWh
+}
+
+func (r notifyRule) String() string {
+	return r.day + r.op + strconv.FormatFloat(r.threshold, 'f', -1, 64)
+}
+
+// matches returns whether a forecast in kWh meets the rule
+func (r notifyRule) matches(kwh float64) bool {
+	switch r.op {
+	case "<":
+		return kwh < r.threshold
+	case "<=":
+		return kwh <= r.threshold
+	case ">":
+		return kwh > r.threshold
+	default:
+		return kwh >= r.threshold
+	}
+}
+
+func parseNotifyRule(value string) (notifyRule, error) {
+	value = strings.ReplaceAll(value, " ", "")
+	for _, day := range []string{"today", "tomorrow"} {
+		rest, ok := strings.CutPrefix(value, day)
+		if !ok {
+			continue
+		}
+		// Operators of two characters first, as < is a prefix of <=
+		for _, op := range []string{"<=", ">=", "<", ">"} {
+			if v, ok := strings.CutPrefix(rest, op); ok {
+				threshold, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					return notifyRule{}, fmt.Errorf("invalid threshold in %q", value)
+				}
+				return notifyRule{day: day, op: op, threshold: threshold}, nil
+			}
+		}
+	}
+	return notifyRule{}, fmt.Errorf("expected today or tomorrow, <, <=, > or >= and a threshold in kWh, got %q", value)
+}
+
+// notifyRuleFlag is a repeatable flag collecting notification rules
+type notifyRuleFlag []notifyRule
+
+func (f *notifyRuleFlag) String() string {
+	values := make([]string, 0, len(*f))
+	for _, r := range *f {
+		values = append(values, r.String())
+	}
+	return strings.Join(values, " ")
+}
+
+func (f *notifyRuleFlag) Set(value string) error {
+	r, err := parseNotifyRule(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, r)
+	return nil
+}
+
+// notification is the data of a webhook notification, sent as JSON unless a
+// template is given
+type notification struct {
+	Provider string  `json:"provider"`
+	Site     string  `json:"site,omitempty"`
+	Day      string  `json:"day"`
+	Date     string  `json:"date"`
+	Kwh      float64 `json:"kwh"`
+	Rule     string  `json:"rule"`
+}
+
+// notifier posts to a webhook when the forecast of a site for today or
+// tomorrow, summed over its planes, crosses the threshold of a rule, e.g. to
+// trigger a heating controller directly. A rule notifies once when it starts
+// to match on a day, and again only after it stopped matching in between.
+type notifier struct {
+	client   *http.Client
+	url      string
+	headers  map[string]string
+	template *texttemplate.Template // Of the body, JSON if nil
+	rules    []notifyRule
+	sources  *sourceSet
+
+	matching map[string]string // Date the rule matched on by rule and site
+}
+
+func newNotifier(client *http.Client, url string, headers map[string]string, tmpl *texttemplate.Template, rules []notifyRule, sources *sourceSet) *notifier {
+	return &notifier{
+		client:   client,
+		url:      url,
+		headers:  headers,
+		template: tmpl,
+		rules:    rules,
+		sources:  sources,
+		matching: map[string]string{},
+	}
+}
+
+// run checks the forecasts until ctx is cancelled
+func (n *notifier) run(ctx context.Context) {
+	for {
+		n.check(clock())
+		if !sleep(ctx, notifyCheckInterval) {
+			return
+		}
+	}
+}
+
+// check notifies the rules that started to match the forecasts
+func (n *notifier) check(now time.Time) {
+	dates := map[string]string{
+		"today":    now.Format(time.DateOnly),
+		"tomorrow": now.AddDate(0, 0, 1).Format(time.DateOnly),
+	}
+	totals := map[string][]siteTotal{
+		"today":    siteTotals(n.sources.all()),
+		"tomorrow": siteTotalsOf(n.sources.all(), func(s *source) *forecastCollector { return s.tomorrow }),
+	}
+
+	for _, rule := range n.rules {
+		for _, t := range totals[rule.day] {
+			date := t.date.Format(time.DateOnly)
+			if date != dates[rule.day] {
+				continue
+			}
+			key := rule.String() + " " + t.provider + "/" + t.site
+			if !rule.matches(t.wh / 1000) {
+				delete(n.matching, key)
+				continue
+			}
+			if n.matching[key] == date {
+				continue
+			}
+
+			name := t.provider
+			if t.site != "" {
+				name += "/" + t.site
+			}
+			err := n.send(notification{Provider: t.provider, Site: t.site, Day: rule.day, Date: date, Kwh: t.wh / 1000, Rule: rule.String()})
+			if err != nil {
+				log.Printf("Error sending notification of %s for %s: %s", rule, name, err)
+				continue
+			}
+			n.matching[key] = date
+			log.Printf("Sent notification of %s, the forecast of %s for %s is %.1f kWh", rule, name, rule.day, t.wh/1000)
+		}
+	}
+}
+
+// send posts a notification to the webhook
+func (n *notifier) send(msg notification) error {
+	var body bytes.Buffer
+	if n.template != nil {
+		if err := n.template.Execute(&body, msg); err != nil {
+			return err
+		}
+	} else {
+		// Keep the operators of the rules readable
+		enc := json.NewEncoder(&body)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(msg); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, n.url, &body)
+	if err != nil {
+		return err
+	}
+	if n.template == nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range n.headers {
+		req.Header.Set(k, v)
+	}
+
+	r, err := doRequest(n.client, req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(r.Body, 1024))
+		return fmt.Errorf("unexpected status %s: %s", r.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
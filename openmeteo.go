@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type openMeteoResponse struct {
+	Timezone string `json:"timezone"`
+	Hourly   struct {
+		Time                   []string  `json:"time"`
+		GlobalTiltedIrradiance []float64 `json:"global_tilted_irradiance"`
+		ShortwaveRadiation     []float64 `json:"shortwave_radiation"`
+		UVIndex                []float64 `json:"uv_index"`
+		WindGusts              []float64 `json:"wind_gusts_10m"`
+	} `json:"hourly"`
+}
+
+// openMeteo computes forecasts from the global tilted irradiance forecast of
+// Open-Meteo, which needs no API key
+type openMeteo struct {
+	url  string
+	kwp  float64
+	loss float64 // System losses, e.g. 0.14 for 14%
+}
+
+func newOpenMeteo(latitude, longitude, declination, az string, kwp, loss float64) *openMeteo {
+	query := url.Values{}
+	query.Set("latitude", latitude)
+	query.Set("longitude", longitude)
+	// Open-Meteo uses the same azimuth convention as forecast.solar
+	query.Set("tilt", declination)
+	query.Set("azimuth", az)
+	query.Set("hourly", "global_tilted_irradiance,shortwave_radiation,uv_index,wind_gusts_10m")
+	query.Set("wind_speed_unit", "ms")
+	query.Set("forecast_days", "2")
+	query.Set("timezone", "auto")
+
+	return &openMeteo{
+		url:  "https://api.open-meteo.com/v1/forecast?" + query.Encode(),
+		kwp:  kwp,
+		loss: loss,
+	}
+}
+
+func (p *openMeteo) fetch(client *http.Client) (*apiResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	forecast := &openMeteoResponse{}
+	if err := getJSON(client, req, forecast); err != nil {
+		return nil, err
+	}
+	for _, values := range [][]float64{forecast.Hourly.GlobalTiltedIrradiance, forecast.Hourly.ShortwaveRadiation, forecast.Hourly.UVIndex, forecast.Hourly.WindGusts} {
+		if len(values) != len(forecast.Hourly.Time) {
+			return nil, fmt.Errorf("got %d times but %d values", len(forecast.Hourly.Time), len(values))
+		}
+	}
+
+	res := &apiResponse{}
+	res.Result.Watts = map[string]float64{}
+	res.Result.WattHoursDay = map[string]float64{}
+	res.Result.WattHoursPeriod = map[string]float64{}
+	res.Message.Info.Timezone = forecast.Timezone
+	res.Weather = &weatherForecast{
+		UVIndex:         map[string]float64{},
+		Irradiance:      map[string]float64{},
+		PlaneIrradiance: map[string]float64{},
+		WindGusts:       map[string]float64{},
+	}
+
+	var today string
+	for i, ts := range forecast.Hourly.Time {
+		t, err := time.Parse("2006-01-02T15:04", ts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time %q: %s", ts, err)
+		}
+
+		// Irradiance is the mean of the preceding hour, in the local time of
+		// the location. The forecast starts at midnight of today, so the first
+		// hour belongs to the previous day.
+		if i == 0 {
+			today = t.Format(time.DateOnly)
+		}
+		day := t.Add(-time.Second).Format(time.DateOnly)
+		if day < today {
+			continue
+		}
+
+		// At standard test conditions of 1000 W/m² the plane yields its peak power
+		watts := forecast.Hourly.GlobalTiltedIrradiance[i] * p.kwp * (1 - p.loss)
+		period := t.Format(time.DateTime)
+		res.Result.Watts[period] = watts
+		res.Result.WattHoursPeriod[period] = watts
+		res.Result.WattHoursDay[day] += watts
+		res.Weather.UVIndex[period] = forecast.Hourly.UVIndex[i]
+		res.Weather.Irradiance[period] = forecast.Hourly.ShortwaveRadiation[i]
+		res.Weather.PlaneIrradiance[period] = forecast.Hourly.GlobalTiltedIrradiance[i]
+		res.Weather.WindGusts[period] = forecast.Hourly.WindGusts[i]
+	}
+
+	return res, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	promVersion "github.com/prometheus/common/version"
+)
+
+// otlpExporter pushes all gathered metrics to an OpenTelemetry collector via
+// OTLP/HTTP with JSON encoding
+type otlpExporter struct {
+	client   *http.Client
+	gatherer prometheus.Gatherer
+	url      string
+	headers  map[string]string
+	resource map[string]string
+
+	start time.Time // Start of cumulative sums
+}
+
+// OTLP JSON messages, 64 bit integers are encoded as strings
+type (
+	otlpKeyValue struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	}
+	otlpDataPoint struct {
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano,omitempty"`
+		TimeUnixNano      string         `json:"timeUnixNano"`
+		AsDouble          *float64       `json:"asDouble,omitempty"`
+
+		// Histograms and summaries
+		Count          string         `json:"count,omitempty"`
+		Sum            *float64       `json:"sum,omitempty"`
+		BucketCounts   []string       `json:"bucketCounts,omitempty"`
+		ExplicitBounds []float64      `json:"explicitBounds,omitempty"`
+		QuantileValues []otlpQuantile `json:"quantileValues,omitempty"`
+	}
+	otlpQuantile struct {
+		Quantile float64 `json:"quantile"`
+		Value    float64 `json:"value"`
+	}
+	otlpPoints struct {
+		DataPoints []otlpDataPoint `json:"dataPoints"`
+
+		// Sums and histograms
+		AggregationTemporality int  `json:"aggregationTemporality,omitempty"`
+		IsMonotonic            bool `json:"isMonotonic,omitempty"`
+	}
+	otlpMetric struct {
+		Name        string      `json:"name"`
+		Description string      `json:"description,omitempty"`
+		Gauge       *otlpPoints `json:"gauge,omitempty"`
+		Sum         *otlpPoints `json:"sum,omitempty"`
+		Histogram   *otlpPoints `json:"histogram,omitempty"`
+		Summary     *otlpPoints `json:"summary,omitempty"`
+	}
+)
+
+// Cumulative aggregation temporality
+const otlpCumulative = 2
+
+func otlpAttributes(labels map[string]string) []otlpKeyValue {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attributes := make([]otlpKeyValue, len(keys))
+	for i, k := range keys {
+		attributes[i].Key = k
+		attributes[i].Value.StringValue = labels[k]
+	}
+	return attributes
+}
+
+// push pushes all gathered metrics, the data points are not counted as
+// datapoints of the output
+func (e *otlpExporter) push(ctx context.Context) (int, error) {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		return 0, err
+	}
+
+	resource := map[string]string{"service.name": "forecast_solar_exporter"}
+	for k, v := range e.resource {
+		resource[k] = v
+	}
+
+	request := map[string]interface{}{
+		"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": otlpAttributes(resource)},
+			"scopeMetrics": []interface{}{map[string]interface{}{
+				"scope":   map[string]string{"name": "forecast_solar_exporter", "version": promVersion.Version},
+				"metrics": e.convert(families, time.Now()),
+			}},
+		}},
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	r, err := doRequest(e.client, req)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(r.Body, 1024))
+		return 0, fmt.Errorf("unexpected status %s: %s", r.Status, bytes.TrimSpace(msg))
+	}
+	return 0, nil
+}
+
+// convert converts the metric families to OTLP metrics, skipping NaN values
+// which can't be encoded in JSON
+func (e *otlpExporter) convert(families []*dto.MetricFamily, now time.Time) []otlpMetric {
+	timestamp := strconv.FormatInt(now.UnixNano(), 10)
+	start := strconv.FormatInt(e.start.UnixNano(), 10)
+
+	metrics := []otlpMetric{}
+	for _, mf := range families {
+		metric := otlpMetric{Name: mf.GetName(), Description: mf.GetHelp()}
+		var points []otlpDataPoint
+		for _, m := range mf.Metric {
+			labels := map[string]string{}
+			for _, l := range m.Label {
+				labels[l.GetName()] = l.GetValue()
+			}
+			p := otlpDataPoint{Attributes: otlpAttributes(labels), TimeUnixNano: timestamp}
+
+			var value float64
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				value = m.GetCounter().GetValue()
+				p.StartTimeUnixNano = start
+			case dto.MetricType_GAUGE:
+				value = m.GetGauge().GetValue()
+			case dto.MetricType_UNTYPED:
+				value = m.GetUntyped().GetValue()
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				sum := h.GetSampleSum()
+				p.StartTimeUnixNano = start
+				p.Count = strconv.FormatUint(h.GetSampleCount(), 10)
+				p.Sum = &sum
+
+				// OTLP bucket counts are not cumulative and include +Inf
+				var previous uint64
+				for _, b := range h.Bucket {
+					p.ExplicitBounds = append(p.ExplicitBounds, b.GetUpperBound())
+					p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(b.GetCumulativeCount()-previous, 10))
+					previous = b.GetCumulativeCount()
+				}
+				p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(h.GetSampleCount()-previous, 10))
+				points = append(points, p)
+				continue
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				sum := s.GetSampleSum()
+				p.StartTimeUnixNano = start
+				p.Count = strconv.FormatUint(s.GetSampleCount(), 10)
+				p.Sum = &sum
+				for _, q := range s.Quantile {
+					if !math.IsNaN(q.GetValue()) {
+						p.QuantileValues = append(p.QuantileValues, otlpQuantile{Quantile: q.GetQuantile(), Value: q.GetValue()})
+					}
+				}
+				points = append(points, p)
+				continue
+			}
+
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			p.AsDouble = &value
+			points = append(points, p)
+		}
+		if len(points) == 0 {
+			continue
+		}
+
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			metric.Sum = &otlpPoints{DataPoints: points, AggregationTemporality: otlpCumulative, IsMonotonic: true}
+		case dto.MetricType_HISTOGRAM:
+			metric.Histogram = &otlpPoints{DataPoints: points, AggregationTemporality: otlpCumulative}
+		case dto.MetricType_SUMMARY:
+			metric.Summary = &otlpPoints{DataPoints: points}
+		default:
+			metric.Gauge = &otlpPoints{DataPoints: points}
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sink is an output every retrieved forecast is written to, such as MQTT or
+// InfluxDB
+type sink interface {
+	// write writes the forecast of a source, returning the number of
+	// datapoints written
+	write(source string, res *apiResponse) (int, error)
+}
+
+// pushSink is an output pushing the metrics or the last forecasts in an
+// interval instead, such as remote write or Graphite
+type pushSink interface {
+	// push pushes once, returning the number of datapoints pushed if the
+	// output counts them
+	push(ctx context.Context) (int, error)
+}
+
+// pushOutput is an enabled push sink and its interval
+type pushOutput struct {
+	name     string
+	sink     pushSink
+	interval time.Duration
+}
+
+// outputs fans the forecasts out to the enabled sinks concurrently, so a slow
+// output doesn't delay the others, and runs the push sinks
+type outputs struct {
+	names  []string
+	sinks  []sink
+	pushes []pushOutput
+
+	datapoints *prometheus.CounterVec
+	errors     *prometheus.CounterVec
+}
+
+func newOutputs() *outputs {
+	return &outputs{
+		datapoints: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "forecast_solar_output_datapoints_total",
+			Help: "Number of datapoints written to an output",
+		}, []string{"output"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "forecast_solar_output_errors_total",
+			Help: "Number of failed writes or pushes to an output",
+		}, []string{"output"}),
+	}
+}
+
+// add enables a sink
+func (o *outputs) add(name string, s sink) {
+	o.names = append(o.names, name)
+	o.sinks = append(o.sinks, s)
+	o.datapoints.WithLabelValues(name)
+	o.errors.WithLabelValues(name)
+}
+
+// addPush enables a push sink, pushing every interval once started
+func (o *outputs) addPush(name string, s pushSink, interval time.Duration) {
+	o.pushes = append(o.pushes, pushOutput{name, s, interval})
+	o.errors.WithLabelValues(name)
+}
+
+// start runs the push sinks until ctx is cancelled
+func (o *outputs) start(ctx context.Context) {
+	for _, p := range o.pushes {
+		go o.run(ctx, p)
+	}
+}
+
+// run pushes in the interval of the push sink, starting after the first
+// interval to give the sources time for their first poll
+func (o *outputs) run(ctx context.Context, p pushOutput) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		n, err := p.sink.push(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			o.failed(p.name)
+			log.Printf("Error pushing to %s: %s", p.name, err)
+			continue
+		}
+		if n > 0 {
+			o.datapoints.WithLabelValues(p.name).Add(float64(n))
+		}
+	}
+}
+
+func (o *outputs) register(reg prometheus.Registerer) {
+	reg.MustRegister(o.datapoints, o.errors)
+}
+
+// write writes the forecast of a source to all sinks, returning the number
+// of datapoints written
+func (o *outputs) write(source string, res *apiResponse) int {
+	var wg sync.WaitGroup
+	written := make([]int, len(o.sinks))
+	for i, s := range o.sinks {
+		wg.Add(1)
+		go func(i int, s sink) {
+			defer wg.Done()
+			n, err := s.write(source, res)
+			if err != nil {
+				o.failed(o.names[i])
+				log.Printf("Error writing forecast of %s to %s: %s", source, o.names[i], err)
+				return
+			}
+			written[i] = n
+			o.datapoints.WithLabelValues(o.names[i]).Add(float64(n))
+		}(i, s)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, n := range written {
+		total += n
+	}
+	return total
+}
+
+// failed records a failed write or push to an output
+func (o *outputs) failed(name string) {
+	o.errors.WithLabelValues(name).Inc()
+}
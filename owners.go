@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// parseOwners parses the shares of the owners of a plant in percent, which
+// must add up to 100
+func parseOwners(owners map[string]string) (map[string]float64, error) {
+	parsed := map[string]float64{}
+	for owner, share := range owners {
+		v, err := strconv.ParseFloat(share, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid share of %s: %s", owner, share)
+		}
+		parsed[owner] = v
+	}
+	return parsed, validateOwners(parsed)
+}
+
+func validateOwners(owners map[string]float64) error {
+	if len(owners) == 0 {
+		return nil
+	}
+	var sum float64
+	for owner, share := range owners {
+		if owner == "" || share <= 0 {
+			return fmt.Errorf("owners require a name and a positive share")
+		}
+		sum += share
+	}
+	if math.Abs(sum-100) > 0.01 {
+		return fmt.Errorf("shares of the owners add up to %g%%, not 100%%", sum)
+	}
+	return nil
+}
+
+// ownerShares allocates the forecasts of shared plants, such as balcony plants
+// of several households or community solar, to their owners by share
+type ownerShares struct {
+	sources *sourceSet
+
+	mu     sync.RWMutex
+	shares map[string]map[string]float64 // Share in percent by owner by site
+}
+
+func newOwnerShares(sources *sourceSet, sites []site) *ownerShares {
+	o := &ownerShares{sources: sources}
+	o.update(sites)
+	return o
+}
+
+// update replaces the shares by the ones of the sites, e.g. after reloading
+// the fleet
+func (o *ownerShares) update(sites []site) {
+	shares := map[string]map[string]float64{}
+	for _, s := range sites {
+		if len(s.Owners) > 0 {
+			shares[s.Name] = s.Owners
+		}
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.shares = shares
+}
+
+// ownerAllocation is the share of an owner in the forecasts of a site by a
+// provider
+type ownerAllocation struct {
+	Owner       string   `json:"owner"`
+	Provider    string   `json:"provider"`
+	Site        string   `json:"site,omitempty"`
+	Share       float64  `json:"share_percent"`
+	TodayKwh    *float64 `json:"today_kwh"`
+	TomorrowKwh *float64 `json:"tomorrow_kwh"`
+}
+
+// allocations returns the shares of the owners in the forecasts of today and
+// tomorrow, summed over the planes of each site. Forecasts not retrieved yet
+// are nil.
+func (o *ownerShares) allocations() []ownerAllocation {
+	o.mu.RLock()
+	shares := o.shares
+	o.mu.RUnlock()
+
+	sources := o.sources.all()
+	tomorrow := map[[2]string]float64{}
+	for _, t := range siteTotalsOf(sources, func(s *source) *forecastCollector { return s.tomorrow }) {
+		tomorrow[[2]string{t.provider, t.site}] = t.wh
+	}
+
+	var result []ownerAllocation
+	for _, t := range siteTotals(sources) {
+		owners := shares[t.site]
+		for _, owner := range sortedKeys(owners) {
+			share := owners[owner]
+			today := t.wh / 1000 * share / 100
+			a := ownerAllocation{Owner: owner, Provider: t.provider, Site: t.site, Share: share, TodayKwh: &today}
+			if wh, ok := tomorrow[[2]string{t.provider, t.site}]; ok {
+				v := wh / 1000 * share / 100
+				a.TomorrowKwh = &v
+			}
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+// ServeHTTP serves the allocations visible to the client as JSON
+func (o *ownerShares) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	allocations := []ownerAllocation{}
+	for _, a := range o.allocations() {
+		if visible(r, a.Provider+"/"+a.Site) {
+			allocations = append(allocations, a)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(allocations)
+}
+
+// ownerCollector exports the forecasts allocated to the owners
+type ownerCollector struct {
+	owners   *ownerShares
+	today    *prometheus.Desc
+	tomorrow *prometheus.Desc
+}
+
+func newOwnerCollector(owners *ownerShares) *ownerCollector {
+	return &ownerCollector{
+		owners: owners,
+		today: prometheus.NewDesc(
+			"forecast_solar_owner_today_kwh",
+			"Share of the owner in the solar harvest forecast for today",
+			[]string{"provider", "site", "owner"},
+			nil,
+		),
+		tomorrow: prometheus.NewDesc(
+			"forecast_solar_owner_tomorrow_kwh",
+			"Share of the owner in the solar harvest forecast for tomorrow",
+			[]string{"provider", "site", "owner"},
+			nil,
+		),
+	}
+}
+
+func (c *ownerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.today
+	ch <- c.tomorrow
+}
+
+func (c *ownerCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, a := range c.owners.allocations() {
+		ch <- prometheus.MustNewConstMetric(c.today, prometheus.GaugeValue, *a.TodayKwh, a.Provider, a.Site, a.Owner)
+		if a.TomorrowKwh != nil {
+			ch <- prometheus.MustNewConstMetric(c.tomorrow, prometheus.GaugeValue, *a.TomorrowKwh, a.Provider, a.Site, a.Owner)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+)
+
+// Minimal Parquet writer for exporting the history: a single row group of
+// required columns, PLAIN encoded and uncompressed, which any reader supports.
+
+// Parquet physical and converted types
+const (
+	parquetInt32     = 1
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetUTF8 = 0
+	parquetDate = 6
+)
+
+// parquetColumn is a column of a Parquet file, set exactly one of the slices
+type parquetColumn struct {
+	name    string
+	strings []string
+	dates   []time.Time
+	doubles []float64
+}
+
+func (c *parquetColumn) types() (physical, converted int32) {
+	switch {
+	case c.strings != nil:
+		return parquetByteArray, parquetUTF8
+	case c.dates != nil:
+		return parquetInt32, parquetDate
+	}
+	return parquetDouble, -1
+}
+
+// encode returns the number of values and the PLAIN encoded values
+func (c *parquetColumn) encode() (int, []byte) {
+	var b []byte
+	switch {
+	case c.strings != nil:
+		for _, s := range c.strings {
+			b = binary.LittleEndian.AppendUint32(b, uint32(len(s)))
+			b = append(b, s...)
+		}
+		return len(c.strings), b
+	case c.dates != nil:
+		for _, t := range c.dates {
+			days := t.Unix() / 86400
+			b = binary.LittleEndian.AppendUint32(b, uint32(int32(days)))
+		}
+		return len(c.dates), b
+	}
+	for _, f := range c.doubles {
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(f))
+	}
+	return len(c.doubles), b
+}
+
+// writeParquet writes the columns, which must have the same length, as
+// Parquet file
+func writeParquet(w io.Writer, columns []parquetColumn) error {
+	var file bytes.Buffer
+	file.WriteString("PAR1")
+
+	rows := 0
+	var chunks [][]byte
+	var groupSize int64
+	for _, c := range columns {
+		n, values := c.encode()
+		rows = n
+
+		var header thriftWriter
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(len(values)))
+		header.i32(3, int32(len(values)))
+		header.beginStruct(5)
+		header.i32(1, int32(n))
+		header.i32(2, 0) // PLAIN
+		header.i32(3, 3) // RLE
+		header.i32(4, 3) // RLE
+		header.endStruct()
+		header.stop()
+
+		offset := int64(file.Len())
+		file.Write(header.buf.Bytes())
+		file.Write(values)
+		size := int64(header.buf.Len() + len(values))
+		groupSize += size
+
+		physical, _ := c.types()
+		var chunk thriftWriter
+		chunk.i64(2, offset)
+		chunk.beginStruct(3)
+		chunk.i32(1, physical)
+		chunk.listI32(2, []int32{0})
+		chunk.listStrings(3, []string{c.name})
+		chunk.i32(4, 0) // UNCOMPRESSED
+		chunk.i64(5, int64(n))
+		chunk.i64(6, size)
+		chunk.i64(7, size)
+		chunk.i64(9, offset)
+		chunk.endStruct()
+		chunk.stop()
+		chunks = append(chunks, chunk.buf.Bytes())
+	}
+
+	var meta thriftWriter
+	meta.i32(1, 1)
+	meta.beginList(2, len(columns)+1)
+	meta.beginElement()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(columns)))
+	meta.stop()
+	meta.endElement()
+	for _, c := range columns {
+		physical, converted := c.types()
+		meta.beginElement()
+		meta.i32(1, physical)
+		meta.i32(3, 0) // REQUIRED
+		meta.binary(4, c.name)
+		if converted >= 0 {
+			meta.i32(6, converted)
+		}
+		meta.stop()
+		meta.endElement()
+	}
+	meta.i64(3, int64(rows))
+	meta.beginList(4, 1)
+	meta.beginElement()
+	meta.beginList(1, len(chunks))
+	for _, chunk := range chunks {
+		meta.buf.Write(chunk)
+	}
+	meta.i64(2, groupSize)
+	meta.i64(3, int64(rows))
+	meta.stop()
+	meta.endElement()
+	meta.binary(6, "forecast_solar_exporter")
+	meta.stop()
+
+	file.Write(meta.buf.Bytes())
+	file.Write(binary.LittleEndian.AppendUint32(nil, uint32(meta.buf.Len())))
+	file.WriteString("PAR1")
+
+	_, err := w.Write(file.Bytes())
+	return err
+}
+
+// thriftWriter encodes structs in the Thrift compact protocol used by the
+// Parquet metadata
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last []int16 // Last field ID of the enclosing structs
+	id   int16   // Last field ID of the current struct
+}
+
+// Thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.id; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(int64(id))
+	}
+	t.id = id
+}
+
+func (t *thriftWriter) varint(v int64) {
+	t.buf.Write(binary.AppendVarint(nil, v))
+}
+
+func (t *thriftWriter) uvarint(v uint64) {
+	t.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.uvarint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+func (t *thriftWriter) listHeader(size int, typ byte) {
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | typ)
+	} else {
+		t.buf.WriteByte(0xf0 | typ)
+		t.uvarint(uint64(size))
+	}
+}
+
+func (t *thriftWriter) listI32(id int16, values []int32) {
+	t.field(id, thriftList)
+	t.listHeader(len(values), thriftI32)
+	for _, v := range values {
+		t.varint(int64(v))
+	}
+}
+
+func (t *thriftWriter) listStrings(id int16, values []string) {
+	t.field(id, thriftList)
+	t.listHeader(len(values), thriftBinary)
+	for _, v := range values {
+		t.uvarint(uint64(len(v)))
+		t.buf.WriteString(v)
+	}
+}
+
+// beginList starts a list of structs, each written between beginElement and
+// endElement, the elements have to be terminated with stop
+func (t *thriftWriter) beginList(id int16, size int) {
+	t.field(id, thriftList)
+	t.listHeader(size, thriftStruct)
+}
+
+func (t *thriftWriter) beginElement() {
+	t.last = append(t.last, t.id)
+	t.id = 0
+}
+
+func (t *thriftWriter) endElement() {
+	t.id = t.last[len(t.last)-1]
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.beginElement()
+}
+
+func (t *thriftWriter) endStruct() {
+	t.stop()
+	t.endElement()
+}
+
+// stop terminates the current struct
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// CI reads the file with a reference reader, see .github/workflows/test.yml
+var parquetOut = flag.String("parquet.out", "", "Keep the Parquet file of TestWriteParquet at this path")
+
+// thriftReader decodes structs of the Thrift compact protocol into maps by
+// field ID, lists into slices, integers into int64 and binaries into strings
+type thriftReader struct {
+	b []byte
+	p int
+}
+
+func (r *thriftReader) byte() byte {
+	v := r.b[r.p]
+	r.p++
+	return v
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b[r.p:])
+	if n <= 0 {
+		panic(fmt.Sprintf("invalid varint at %d", r.p))
+	}
+	r.p += n
+	return v
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case 1, 2:
+		return typ == 1
+	case 3:
+		return int64(int8(r.byte()))
+	case 4, thriftI32, thriftI64:
+		v, n := binary.Varint(r.b[r.p:])
+		r.p += n
+		return v
+	case thriftBinary:
+		n := int(r.uvarint())
+		s := string(r.b[r.p : r.p+n])
+		r.p += n
+		return s
+	case thriftList:
+		h := r.byte()
+		size, elem := int(h>>4), h&0x0f
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = r.value(elem)
+		}
+		return list
+	case thriftStruct:
+		return r.structure()
+	}
+	panic(fmt.Sprintf("unsupported type %d at %d", typ, r.p))
+}
+
+func (r *thriftReader) structure() map[int16]interface{} {
+	fields := map[int16]interface{}{}
+	var id int16
+	for {
+		h := r.byte()
+		if h == 0 {
+			return fields
+		}
+		if delta := int16(h >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, n := binary.Varint(r.b[r.p:])
+			r.p += n
+			id = int16(v)
+		}
+		fields[id] = r.value(h & 0x0f)
+	}
+}
+
+func TestWriteParquet(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	columns := []parquetColumn{
+		{name: "source", strings: []string{"home/east", "home/west", "barn"}},
+		{name: "day", dates: []time.Time{day("2023-06-01"), day("2023-06-02"), day("1969-12-31")}},
+		{name: "watt_hours", doubles: []float64{12345.5, 0, math.MaxFloat64}},
+	}
+
+	var buf bytes.Buffer
+	if err := writeParquet(&buf, columns); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+	if *parquetOut != "" {
+		if err := os.WriteFile(*parquetOut, file, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bytes.HasPrefix(file, []byte("PAR1")) || !bytes.HasSuffix(file, []byte("PAR1")) {
+		t.Fatal("missing magic bytes")
+	}
+	size := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footer := &thriftReader{b: file[len(file)-8-size : len(file)-8]}
+	meta := footer.structure()
+	if footer.p != size {
+		t.Fatalf("footer decoded %d of %d bytes", footer.p, size)
+	}
+	if meta[3] != int64(3) {
+		t.Errorf("got %v rows, want 3", meta[3])
+	}
+
+	// The schema is the root followed by the columns
+	schema := meta[2].([]interface{})
+	if len(schema) != len(columns)+1 || schema[0].(map[int16]interface{})[5] != int64(len(columns)) {
+		t.Fatalf("invalid schema root %v", schema)
+	}
+	for i, c := range columns {
+		element := schema[i+1].(map[int16]interface{})
+		physical, _ := c.types()
+		if element[4] != c.name || element[1] != int64(physical) {
+			t.Errorf("got schema element %v for column %s", element, c.name)
+		}
+	}
+
+	groups := meta[4].([]interface{})
+	if len(groups) != 1 {
+		t.Fatalf("got %d row groups, want 1", len(groups))
+	}
+	chunks := groups[0].(map[int16]interface{})[1].([]interface{})
+	if len(chunks) != len(columns) {
+		t.Fatalf("got %d column chunks, want %d", len(chunks), len(columns))
+	}
+	for i, c := range columns {
+		chunk := chunks[i].(map[int16]interface{})[3].(map[int16]interface{})
+		offset := int(chunk[9].(int64))
+		page := &thriftReader{b: file[offset:]}
+		header := page.structure()
+		if header[1] != int64(0) {
+			t.Errorf("column %s: got page type %v, want DATA_PAGE", c.name, header[1])
+		}
+		if chunk[6] != int64(page.p)+header[3].(int64) {
+			t.Errorf("column %s: chunk size %v doesn't match its page", c.name, chunk[6])
+		}
+		values := page.b[page.p : page.p+int(header[3].(int64))]
+
+		// Decode the PLAIN encoded values back into a column
+		got := parquetColumn{name: c.name}
+		for len(values) > 0 {
+			switch {
+			case c.strings != nil:
+				n := binary.LittleEndian.Uint32(values)
+				got.strings = append(got.strings, string(values[4:4+n]))
+				values = values[4+n:]
+			case c.dates != nil:
+				days := int32(binary.LittleEndian.Uint32(values))
+				got.dates = append(got.dates, time.Unix(int64(days)*86400, 0).UTC())
+				values = values[4:]
+			default:
+				got.doubles = append(got.doubles, math.Float64frombits(binary.LittleEndian.Uint64(values)))
+				values = values[8:]
+			}
+		}
+		if !reflect.DeepEqual(got, c) {
+			t.Errorf("column %s: got %v, want %v", c.name, got, c)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// payloadStats exports the size of the responses of the API and the duration
+// of decoding them, to follow their cost as the API adds fields or planes are
+// added
+type payloadStats struct {
+	size   prometheus.Histogram
+	decode prometheus.Histogram
+}
+
+func newPayloadStats() *payloadStats {
+	return &payloadStats{
+		size: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "forecast_solar_api_response_size_bytes",
+			Help:    "Size of the response bodies of the API",
+			Buckets: prometheus.ExponentialBuckets(1024, 4, 8),
+		}),
+		decode: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "forecast_solar_api_decode_duration_seconds",
+			Help:    "Duration of decoding the JSON responses of the API",
+			Buckets: prometheus.ExponentialBuckets(.0001, 4, 8),
+		}),
+	}
+}
+
+// client returns a copy of the client whose responses report their size and
+// decode duration to the stats
+func (p *payloadStats) client(c *http.Client) *http.Client {
+	next := c.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	observed := *c
+	observed.Transport = &payloadTransport{next: next, stats: p}
+	return &observed
+}
+
+type payloadTransport struct {
+	next  http.RoundTripper
+	stats *payloadStats
+}
+
+func (t *payloadTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = &payloadBody{ReadCloser: r.Body, stats: t.stats}
+	return r, nil
+}
+
+// payloadBody marks response bodies whose decoding is observed
+type payloadBody struct {
+	io.ReadCloser
+	stats *payloadStats
+}
+
+// decodeJSON reads the response body and decodes it into v. The body is read
+// before decoding, so the observed decode duration leaves out the transfer.
+func decodeJSON(r *http.Response, v interface{}) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("error decoding JSON: %s", err)
+	}
+	if b, ok := r.Body.(*payloadBody); ok {
+		b.stats.size.Observe(float64(len(body)))
+		b.stats.decode.Observe(time.Since(start).Seconds())
+	}
+	return nil
+}
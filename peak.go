@@ -0,0 +1,75 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// peakCollector exports the maximum of the forecasted power of today and
+// tomorrow and when it is expected
+type peakCollector struct {
+	source *source
+	power  *prometheus.Desc
+	time   *prometheus.Desc
+}
+
+func newPeakCollector(s *source) *peakCollector {
+	return &peakCollector{
+		source: s,
+		power: prometheus.NewDesc(
+			"forecast_solar_peak_power_watts",
+			"Maximum forecasted power of the day",
+			[]string{"day"},
+			nil,
+		),
+		time: prometheus.NewDesc(
+			"forecast_solar_peak_time_seconds",
+			"Unix timestamp the maximum forecasted power of the day is expected at",
+			[]string{"day"},
+			nil,
+		),
+	}
+}
+
+func (c *peakCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.power
+	ch <- c.time
+}
+
+func (c *peakCollector) Collect(ch chan<- prometheus.Metric) {
+	res := c.source.forecast.Load()
+	if res == nil {
+		return
+	}
+	for day, p := range peaks(res.Result.Watts, weatherDays(res), res.location()) {
+		ch <- prometheus.MustNewConstMetric(c.power, prometheus.GaugeValue, p.watts, day)
+		ch <- prometheus.MustNewConstMetric(c.time, prometheus.GaugeValue, float64(p.time.Unix()), day)
+	}
+}
+
+type peak struct {
+	time  time.Time
+	watts float64
+}
+
+// peaks returns the earliest maximum of the power per named day. Days without
+// any power forecasted have no peak. Times are in the local time of the plant
+// at loc.
+func peaks(watts map[string]float64, days map[string]string, loc *time.Location) map[string]peak {
+	result := map[string]peak{}
+	for period, w := range watts {
+		t, err := time.ParseInLocation(time.DateTime, period, loc)
+		if err != nil {
+			continue
+		}
+		day, ok := days[t.Format(time.DateOnly)]
+		if !ok || w <= 0 {
+			continue
+		}
+		if p, ok := result[day]; !ok || w > p.watts || (w == p.watts && t.Before(p.time)) {
+			result[day] = peak{time: t, watts: w}
+		}
+	}
+	return result
+}
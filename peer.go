@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Interval between exchanges of the number of sources with the peers
+const peerSyncInterval = time.Minute
+
+// peerGroup coordinates exporters polling the same forecast.solar account,
+// e.g. several instances in one household. Before polling, a source asks the
+// peers for a recent forecast of the same request, and the sources of the
+// peers are counted when spreading polls across the shared rate limit, so the
+// instances don't exceed it collectively.
+type peerGroup struct {
+	client  *http.Client
+	urls    []string
+	token   string
+	sources *sourceSet
+
+	// The peers are replicas with the same configuration, e.g. for high
+	// availability, sharing the forecasts of all providers and polling each
+	// request once among them
+	replicas bool
+
+	mu          sync.Mutex
+	peerSources map[string]int // forecast.solar sources by peer
+
+	up       *prometheus.GaugeVec
+	received prometheus.Counter
+}
+
+// peerState is what an exporter tells its peers
+type peerState struct {
+	Sources  int           `json:"sources"`
+	Forecast *peerForecast `json:"forecast,omitempty"`
+}
+
+// peerForecast is a forecast as retrieved from the API by a source itself
+type peerForecast struct {
+	Time     time.Time    `json:"time"`
+	Forecast *apiResponse `json:"forecast"`
+}
+
+func newPeerGroup(client *http.Client, urls []string, token string, replicas bool) *peerGroup {
+	g := &peerGroup{
+		client:      client,
+		token:       token,
+		replicas:    replicas,
+		peerSources: map[string]int{},
+		up: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "forecast_solar_peer_up",
+			Help: "Whether the peer answered the last request",
+		}, []string{"peer"}),
+		received: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "forecast_solar_peer_forecasts_total",
+			Help: "Number of polls served by a recent forecast of a peer instead of the API",
+		}),
+	}
+	for _, u := range urls {
+		g.urls = append(g.urls, strings.TrimSuffix(u, "/"))
+	}
+	return g
+}
+
+func (g *peerGroup) register(reg prometheus.Registerer) {
+	reg.MustRegister(g.up, g.received)
+	reg.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "forecast_solar_peer_sources",
+			Help: "Number of forecast.solar sources of the peers sharing the rate limit",
+		},
+		func() float64 { return float64(g.remoteSources()) },
+	))
+}
+
+// requestKey identifies the request of a forecast.solar source. The URL
+// contains the API key, so only requests of the same account match.
+func requestKey(p provider) string {
+	fs, ok := p.(*forecastSolar)
+	if !ok {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(fs.url))
+	return hex.EncodeToString(sum[:16])
+}
+
+// replicaKey identifies the request of a source of any provider polling an
+// API, which replicas with the same configuration share
+func replicaKey(p provider) string {
+	var request string
+	switch p := p.(type) {
+	case *forecastSolar:
+		return requestKey(p)
+	case *solcast:
+		request = p.url + " " + p.apiKey
+	case *openMeteo:
+		// The forecast is derived from the weather locally
+		request = fmt.Sprintf("%s %g %g", p.url, p.kwp, p.loss)
+	default:
+		return ""
+	}
+	sum := sha256.Sum256([]byte(request))
+	return hex.EncodeToString(sum[:16])
+}
+
+// key identifies the request of a source shared with the peers
+func (g *peerGroup) key(p provider) string {
+	if g.replicas {
+		return replicaKey(p)
+	}
+	return requestKey(p)
+}
+
+// localSources returns the number of forecast.solar sources of this exporter,
+// counting sources coalescing their polls once
+func (g *peerGroup) localSources() int {
+	var shared []*source
+	for _, s := range g.sources.all() {
+		if !ownAccount(s) {
+			shared = append(shared, s)
+		}
+	}
+	return distinctRequests(shared)
+}
+
+// remoteSources returns the number of forecast.solar sources of all peers
+func (g *peerGroup) remoteSources() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	n := 0
+	for _, sources := range g.peerSources {
+		n += sources
+	}
+	return n
+}
+
+// state requests the state of a peer, with the forecast of the request if
+// key is given
+func (g *peerGroup) state(peer, key string) (*peerState, error) {
+	u := peer + "/api/v1/peer"
+	if key != "" {
+		u += "?" + url.Values{"request": {key}}.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+g.token)
+
+	var state peerState
+	err = getJSON(g.client, req, &state)
+	if err != nil {
+		g.up.WithLabelValues(peer).Set(0)
+		return nil, err
+	}
+	g.up.WithLabelValues(peer).Set(1)
+	g.mu.Lock()
+	g.peerSources[peer] = state.Sources
+	g.mu.Unlock()
+	return &state, nil
+}
+
+// lookup returns the most recent forecast of the request retrieved by a peer
+// within maxAge, or nil if there is none
+func (g *peerGroup) lookup(key string, maxAge time.Duration) *apiResponse {
+	var latest *peerForecast
+	for _, peer := range g.urls {
+		state, err := g.state(peer, key)
+		if err != nil {
+			log.Printf("Error requesting forecast of peer %s: %s", peer, err)
+			continue
+		}
+		if f := state.Forecast; f != nil && f.Forecast != nil && clock().Sub(f.Time) < maxAge && (latest == nil || f.Time.After(latest.Time)) {
+			latest = f
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+	g.received.Inc()
+
+	// The rate limit was reported to the peer back then, the next poll of
+	// the API reports the current one
+	res := *latest.Forecast
+	res.Message.RateLimit = nil
+	return &res
+}
+
+// run exchanges the number of sources with the peers until ctx is cancelled
+func (g *peerGroup) run(ctx context.Context) {
+	for {
+		for _, peer := range g.urls {
+			if _, err := g.state(peer, ""); err != nil {
+				log.Printf("Error requesting state of peer %s: %s", peer, err)
+			}
+		}
+		if !sleep(ctx, peerSyncInterval) {
+			return
+		}
+	}
+}
+
+// ServeHTTP tells a peer the number of sources and the forecast of the
+// request given by the request parameter, if retrieved by a source of this
+// exporter itself. Forecasts received from peers are not passed on, so they
+// can't circulate without ever being retrieved again.
+func (g *peerGroup) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !(&adminGuard{token: g.token}).allowed(r) {
+		log.Printf("Denied %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	state := peerState{Sources: g.localSources()}
+	if key := r.FormValue("request"); key != "" {
+		for _, s := range g.sources.all() {
+			if g.key(s.provider) != key {
+				continue
+			}
+			if f := s.retrieved.Load(); f != nil && (state.Forecast == nil || f.Time.After(state.Forecast.Time)) {
+				state.Forecast = f
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// validatePeers checks the peer URLs and token
+func validatePeers(urls []string, token string) error {
+	if token == "" {
+		return fmt.Errorf("-peer.urls requires -peer.token")
+	}
+	for _, u := range urls {
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid peer URL %q", u)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testPeer returns the peer group of an exporter with a source of the given
+// forecast.solar request, served over HTTP
+func testPeer(t *testing.T, url string, urls ...string) (*peerGroup, *source, *httptest.Server) {
+	g := newPeerGroup(nil, urls, "secret", false)
+	opts := &sourceOptions{readOnly: &atomic.Bool{}, quotaBehavior: "keep", quotaAfter: 3, backoffMax: time.Hour, outputs: newOutputs(), peers: g}
+	s := newSource("forecast.solar/site", &forecastSolar{url: url}, time.Hour, opts)
+	g.sources = newSourceSet([]*source{s})
+	srv := httptest.NewServer(g)
+	t.Cleanup(srv.Close)
+	g.client = srv.Client()
+	return g, s, srv
+}
+
+func TestPeerForecast(t *testing.T) {
+	const url = "https://api.forecast.solar/estimate/54.9/25.3/45/0/10"
+	_, a, srvA := testPeer(t, url)
+	b, _, _ := testPeer(t, url, srvA.URL)
+	key := requestKey(a.provider)
+
+	// Without a forecast retrieved by the peer, the API is polled
+	if res := b.lookup(key, time.Hour); res != nil {
+		t.Error("got a forecast of a peer that didn't retrieve one")
+	}
+	if n := b.remoteSources(); n != 1 {
+		t.Errorf("got %d sources of the peers, want 1", n)
+	}
+
+	res := syntheticForecast(48, time.Now())
+	res.Message.RateLimit = &rateLimit{Period: 3600, Limit: 12, Remaining: 5}
+	a.retrieved.Store(&peerForecast{Time: clock().Add(-time.Minute), Forecast: res})
+	got := b.lookup(key, time.Hour)
+	if got == nil {
+		t.Fatal("got no forecast of the peer")
+	}
+	if got.Message.RateLimit != nil {
+		t.Error("got the rate limit reported to the peer back then")
+	}
+	if len(got.Result.Watts) != len(res.Result.Watts) {
+		t.Errorf("got %d periods, want %d", len(got.Result.Watts), len(res.Result.Watts))
+	}
+
+	// Forecasts older than maxAge and of other requests are not served
+	if res := b.lookup(key, 30*time.Second); res != nil {
+		t.Error("got a forecast of the peer older than the maximum age")
+	}
+	if res := b.lookup(requestKey(&forecastSolar{url: url + "?damping=0.5"}), time.Hour); res != nil {
+		t.Error("got a forecast of the peer of another request")
+	}
+}
+
+// Forecasts received from peers are not passed on, so they can't circulate
+// without ever being retrieved again
+func TestPeerForecastNotPassedOn(t *testing.T) {
+	const url = "https://api.forecast.solar/estimate/54.9/25.3/45/0/10"
+	_, a, srvA := testPeer(t, url)
+	b, sb, srvB := testPeer(t, url, srvA.URL)
+	c, _, _ := testPeer(t, url, srvB.URL)
+	a.retrieved.Store(&peerForecast{Time: clock(), Forecast: syntheticForecast(48, time.Now())})
+
+	res, err := sb.fetch(nil)
+	if err != nil || res == nil {
+		t.Fatalf("got %v, %v polling with a peer that has a forecast, want its forecast", res, err)
+	}
+	if sb.retrieved.Load() != nil {
+		t.Error("got the forecast of the peer kept as retrieved by the source")
+	}
+	if res := c.lookup(b.key(sb.provider), time.Hour); res != nil {
+		t.Error("got the forecast of a peer passed on by another peer")
+	}
+}
+
+func TestPeerToken(t *testing.T) {
+	const url = "https://api.forecast.solar/estimate/54.9/25.3/45/0/10"
+	_, a, srvA := testPeer(t, url)
+	a.retrieved.Store(&peerForecast{Time: clock(), Forecast: syntheticForecast(48, time.Now())})
+	b, _, _ := testPeer(t, url, srvA.URL)
+	b.token = "wrong"
+
+	if res := b.lookup(requestKey(a.provider), time.Hour); res != nil {
+		t.Error("got a forecast of a peer with the wrong token")
+	}
+	if n := b.remoteSources(); n != 0 {
+		t.Errorf("got %d sources of a peer denying the request, want 0", n)
+	}
+}
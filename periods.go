@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// locations caches the time zones of the plants by name, as loading them
+// reads the time zone database
+var locations sync.Map
+
+// location returns the time zone of the plant, which the periods of the
+// forecast are in, or the time zone of the exporter if the provider doesn't
+// tell
+func (res *apiResponse) location() *time.Location {
+	name := res.Message.Info.Timezone
+	if name == "" {
+		return time.Local
+	}
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.Local
+	}
+	locations.Store(name, loc)
+	return loc
+}
+
+// Formats of the time parameter of the forecast.solar API besides the default
+// local time
+var apiTimeFormats = []string{"utc", "iso8601"}
+
+// parsePeriod parses a key of the forecast maps in any format the
+// forecast.solar API returns depending on its time parameter: local time,
+// ISO 8601 with offset, e.g. in UTC, or Unix seconds. Keys without offset are
+// in loc.
+func parsePeriod(key string, loc *time.Location) (time.Time, error) {
+	key = strings.TrimSpace(key)
+	if seconds, err := strconv.ParseInt(key, 10, 64); err == nil {
+		return time.Unix(seconds, 0).In(loc), nil
+	}
+	if t, err := time.Parse(time.RFC3339, key); err == nil {
+		return t.In(loc), nil
+	}
+	for _, layout := range []string{time.DateTime, "2006-01-02T15:04:05", "2006-01-02 15:04", time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, key, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid period %q", key)
+}
+
+// forecastDays returns the dates of today and tomorrow of the daily energy of
+// a forecast, which may cover more days, e.g. with paid plans. Today is the
+// date at the plant, or the first day if the forecast lacks it, e.g. one
+// issued before midnight. Tomorrow is missing if the forecast ends today, and
+// both are if it ends before today, e.g. an old cached one.
+func forecastDays(res *apiResponse) []string {
+	days := sortedKeys(res.Result.WattHoursDay)
+	today := clock().In(res.location()).Format(time.DateOnly)
+	if len(days) > 0 && days[len(days)-1] < today {
+		return nil
+	}
+	for i, date := range days {
+		if date == today {
+			days = days[i:]
+			break
+		}
+	}
+	if len(days) > 2 {
+		days = days[:2]
+	}
+	return days
+}
+
+// normalizePeriods rewrites the keys of the forecast maps to the local time
+// of the plant in the default format, such as 2024-05-01 12:00:00, and those
+// of the daily energy to dates, which the rest of the exporter expects. Keys
+// that map to the same local time, as the hour repeated when daylight saving
+// time ends, are merged. Invalid keys are dropped instead of failing the
+// whole forecast, and returned once each.
+func normalizePeriods(res *apiResponse) []string {
+	loc := res.location()
+
+	var invalid []string
+	normalize := func(m map[string]float64, layout string, merge func(a, b float64) float64) map[string]float64 {
+		if m == nil {
+			return nil
+		}
+		normalized := make(map[string]float64, len(m))
+		for key, v := range m {
+			t, err := parsePeriod(key, loc)
+			if err != nil {
+				if !contains(invalid, key) {
+					invalid = append(invalid, key)
+				}
+				continue
+			}
+			k := t.Format(layout)
+			if prev, ok := normalized[k]; ok {
+				v = merge(prev, v)
+			}
+			normalized[k] = v
+		}
+		return normalized
+	}
+	larger := func(a, b float64) float64 {
+		if a > b {
+			return a
+		}
+		return b
+	}
+	sum := func(a, b float64) float64 { return a + b }
+
+	res.Result.Watts = normalize(res.Result.Watts, time.DateTime, larger)
+	res.Result.WattHoursPeriod = normalize(res.Result.WattHoursPeriod, time.DateTime, sum)
+	res.Result.WattHours = normalize(res.Result.WattHours, time.DateTime, larger)
+	res.Result.WattHoursDay = normalize(res.Result.WattHoursDay, time.DateOnly, sum)
+	return invalid
+}
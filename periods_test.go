@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// testForecast returns a forecast of a plant in Berlin with ISO 8601 periods
+// in UTC, as returned with the time parameter utc
+func testForecast() *apiResponse {
+	res := &apiResponse{}
+	res.Message.Info.Timezone = "Europe/Berlin"
+	res.Result.Watts = map[string]float64{
+		"2024-05-01T04:00:00Z": 0,
+		"2024-05-01T10:00:00Z": 5000,
+		"2024-05-01T18:00:00Z": 0,
+	}
+	res.Result.WattHoursPeriod = map[string]float64{
+		"2024-05-01T10:00:00Z": 4000,
+		"2024-05-01T11:00:00Z": 3000,
+	}
+	res.Result.WattHoursDay = map[string]float64{
+		"2024-05-01": 7000,
+		"2024-05-02": 9000,
+	}
+	return res
+}
+
+// Periods are in the local time of the plant regardless of the time zone of
+// the exporter
+func TestPeriodsInPlantLocation(t *testing.T) {
+	local, now := time.Local, clock
+	defer func() { time.Local, clock = local, now }()
+	clock = func() time.Time { return time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC) }
+	var err error
+	if time.Local, err = time.LoadLocation("America/New_York"); err != nil {
+		t.Fatal(err)
+	}
+
+	res := testForecast()
+	if invalid := normalizePeriods(res); len(invalid) > 0 {
+		t.Fatalf("got invalid periods %v", invalid)
+	}
+	if _, ok := res.Result.Watts["2024-05-01 12:00:00"]; !ok {
+		t.Fatalf("got periods %v, want them in CEST", sortedKeys(res.Result.Watts))
+	}
+	loc := res.location()
+
+	peak := peaks(res.Result.Watts, weatherDays(res), loc)["today"]
+	if want := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC); !peak.time.Equal(want) {
+		t.Errorf("got peak at %s, want %s", peak.time, want)
+	}
+
+	if w, ok := newPowerCurve(res.Result.Watts, loc).at(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)); !ok || w != 5000 {
+		t.Errorf("got power %g, want 5000", w)
+	}
+
+	var hourly hourlyForecast
+	if err := hourly.update(res.Result.WattHoursPeriod, sortedKeys(res.Result.WattHoursDay), loc); err != nil {
+		t.Fatal(err)
+	}
+	if wh := hourly.energy["today"][11]; wh != 4000 {
+		t.Errorf("got %g Wh in the hour from 11:00 CEST, want 4000", wh)
+	}
+
+	if day, hour, ok := weatherHour("2024-05-01 12:00:00", weatherDays(res), loc); !ok || day != "today" || hour != 11 {
+		t.Errorf("got weather hour %s %d, want today 11", day, hour)
+	}
+
+	// 23:30 UTC is already the next day in Berlin, but not in New York
+	if _, _, ok := producedAt(res.Result.WattHoursPeriod, time.Date(2024, 5, 1, 23, 30, 0, 0, time.UTC), loc); ok {
+		t.Error("got energy produced on May 2nd in Berlin, want none")
+	}
+	if soFar, total, ok := producedAt(res.Result.WattHoursPeriod, time.Date(2024, 5, 1, 21, 0, 0, 0, time.UTC), loc); !ok || soFar != 7000 || total != 7000 {
+		t.Errorf("got %g of %g Wh produced, want 7000 of 7000", soFar, total)
+	}
+}
+
+func TestForecastDays(t *testing.T) {
+	now := clock
+	defer func() { clock = now }()
+	// 23:30 UTC is already May 2nd in Berlin
+	clock = func() time.Time { return time.Date(2024, 5, 1, 23, 30, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name string
+		days []string
+		want []string
+	}{
+		{"today and tomorrow", []string{"2024-05-02", "2024-05-03"}, []string{"2024-05-02", "2024-05-03"}},
+		{"paid plan", []string{"2024-05-01", "2024-05-02", "2024-05-03", "2024-05-04"}, []string{"2024-05-02", "2024-05-03"}},
+		{"ends today", []string{"2024-05-01", "2024-05-02"}, []string{"2024-05-02"}},
+		{"without today", []string{"2024-05-03", "2024-05-04", "2024-05-05"}, []string{"2024-05-03", "2024-05-04"}},
+		{"ended yesterday", []string{"2024-04-30", "2024-05-01"}, nil},
+		{"empty", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &apiResponse{}
+			res.Message.Info.Timezone = "Europe/Berlin"
+			res.Result.WattHoursDay = map[string]float64{}
+			for _, d := range tt.days {
+				res.Result.WattHoursDay[d] = 1000
+			}
+			got := forecastDays(res)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newPlaneInfo returns the info metric describing the configured plane of a
+// site, so forecasts can be joined with the physical parameters of the plane.
+// Planes of sites with several planes get their name from the plane label of
+// the source.
+func newPlaneInfo(s site) *prometheus.GaugeVec {
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "forecast_solar_plane_info",
+		Help: "Configured plane, with the azimuth in the convention of the API",
+	}, []string{"kwp", "declination", "azimuth"})
+	g.WithLabelValues(s.Kwp, s.Declination, s.Azimuth).Set(1)
+	return g
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"gopkg.in/yaml.v3"
+)
+
+// errNotInFleet is returned when removing a site or plane the fleet file
+// doesn't list
+var errNotInFleet = errors.New("not in fleet file")
+
+// planesHandler adds and removes sites and planes at runtime, for provisioning
+// systems creating sites while the exporter keeps serving scrapes. POST adds
+// the site given as JSON or YAML body like in the fleet file, or only its
+// planes if the site exists. DELETE removes the site given by the site
+// parameter, or its plane given by the plane parameter. The fleet file is
+// changed accordingly and the fleet reloaded, keeping the previous file if the
+// reload fails.
+func planesHandler(f *fleetReloader, audit *auditLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var edit func(fleet []byte) ([]byte, error)
+		var change string
+		switch r.Method {
+		case http.MethodPost:
+			body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var added yaml.Node
+			if err := yaml.Unmarshal(body, &added); err != nil || len(added.Content) == 0 || added.Content[0].Kind != yaml.MappingNode {
+				http.Error(w, "Body must be a site of the fleet file as JSON or YAML object", http.StatusBadRequest)
+				return
+			}
+			site := added.Content[0]
+			edit = func(fleet []byte) ([]byte, error) { return addFleetSite(fleet, site) }
+			change = "added " + mappingValue(site, "name").Value
+		case http.MethodDelete:
+			site, plane := r.FormValue("site"), r.FormValue("plane")
+			if site == "" {
+				http.Error(w, "Parameter site is required", http.StatusBadRequest)
+				return
+			}
+			edit = func(fleet []byte) ([]byte, error) { return removeFleetSite(fleet, site, plane) }
+			change = "removed site " + site
+			if plane != "" {
+				change = "removed plane " + site + "/" + plane
+			}
+		default:
+			w.Header().Set("Allow", "POST, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		summary, err := f.rewrite(edit)
+		if err != nil {
+			log.Printf("Error changing fleet, keeping the previous one: %s", err)
+			status := http.StatusUnprocessableEntity
+			if errors.Is(err, errNotInFleet) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, "Error changing fleet, keeping the previous one:\n"+err.Error(), status)
+			return
+		}
+		log.Printf("Reloaded fleet by %s, %s: %s", r.RemoteAddr, change, summary)
+		if audit != nil {
+			if err := audit.record(r, "planes", change+", "+summary); err != nil {
+				log.Printf("Error writing audit log: %s", err)
+			}
+		}
+		fmt.Fprintf(w, "Reloaded fleet: %s\n", summary)
+	}
+}
+
+// mappingValue returns the value of a key of a YAML mapping, or an empty node
+// if it has none
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return &yaml.Node{}
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return &yaml.Node{}
+}
+
+// fleetSites returns the document of a fleet file and the sequence of its
+// sites, which keeps the comments of the file when changed
+func fleetSites(fleet []byte) (*yaml.Node, *yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(fleet, &doc); err != nil {
+		return nil, nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil, fmt.Errorf("fleet file has no sites")
+	}
+	sites := mappingValue(doc.Content[0], "sites")
+	if sites.Kind != yaml.SequenceNode {
+		return nil, nil, fmt.Errorf("fleet file has no sites")
+	}
+	return &doc, sites, nil
+}
+
+func encodeFleet(doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// blockStyle writes nodes in block style, as sent as JSON they are in flow
+// style with quoted keys
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+// addFleetSite appends a site to a fleet file. If the site exists, only its
+// planes are added to it.
+func addFleetSite(fleet []byte, added *yaml.Node) ([]byte, error) {
+	doc, sites, err := fleetSites(fleet)
+	if err != nil {
+		return nil, err
+	}
+	name := mappingValue(added, "name").Value
+	if name == "" {
+		return nil, fmt.Errorf("site has no name")
+	}
+	blockStyle(added)
+
+	for _, s := range sites.Content {
+		if mappingValue(s, "name").Value != name {
+			continue
+		}
+		planes, existing := mappingValue(added, "planes"), mappingValue(s, "planes")
+		if len(added.Content) != 4 || planes.Kind != yaml.SequenceNode || existing.Kind != yaml.SequenceNode {
+			return nil, fmt.Errorf("site %s exists, only planes can be added to a site with planes", name)
+		}
+		existing.Content = append(existing.Content, planes.Content...)
+		return encodeFleet(doc)
+	}
+	sites.Content = append(sites.Content, added)
+	return encodeFleet(doc)
+}
+
+// removeFleetSite removes a site from a fleet file, or only the given plane
+// of it
+func removeFleetSite(fleet []byte, name, plane string) ([]byte, error) {
+	doc, sites, err := fleetSites(fleet)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, s := range sites.Content {
+		if mappingValue(s, "name").Value != name {
+			continue
+		}
+		if plane == "" {
+			sites.Content = append(sites.Content[:i:i], sites.Content[i+1:]...)
+			return encodeFleet(doc)
+		}
+		planes := mappingValue(s, "planes")
+		for j, p := range planes.Content {
+			if mappingValue(p, "name").Value == plane {
+				planes.Content = append(planes.Content[:j:j], planes.Content[j+1:]...)
+				return encodeFleet(doc)
+			}
+		}
+		return nil, fmt.Errorf("plane %s of site %s %w", plane, name, errNotInFleet)
+	}
+	return nil, fmt.Errorf("site %s %w", name, errNotInFleet)
+}
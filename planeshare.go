@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Interval the sun position is sampled in to split the energy of a period
+const planeShareStep = 10 * time.Minute
+
+// planeShare splits the forecast of the planes of a site retrieved in a single
+// request of the multi-plane API, which only returns their sum, into the share
+// of one plane. The share is the part of the plane in the clear sky irradiance
+// on all planes, weighted by their peak power, so a plane facing east gets most
+// of the morning and a plane facing west most of the evening.
+type planeShare struct {
+	latitude, longitude float64
+	planes              []sharedPlane
+	index               int // Of the plane the forecast is split for
+}
+
+type sharedPlane struct {
+	declination, azimuth, kwp float64
+}
+
+// newPlaneShare returns the share of the plane of a site in the forecast of
+// the combined planes of the site
+func newPlaneShare(s site) (*planeShare, error) {
+	p := &planeShare{index: -1}
+	for i, c := range s.Combined {
+		g, err := parsePlaneGeometry(s.Latitude, s.Longitude, c.Declination, c.Azimuth)
+		if err != nil {
+			return nil, fmt.Errorf("plane %s: %s", c.Name, err)
+		}
+		kwp, err := strconv.ParseFloat(c.Kwp, 64)
+		if err != nil {
+			return nil, fmt.Errorf("plane %s: invalid peak power %q: %s", c.Name, c.Kwp, err)
+		}
+		p.latitude, p.longitude = g.latitude, g.longitude
+		p.planes = append(p.planes, sharedPlane{declination: g.declination, azimuth: g.azimuth, kwp: kwp})
+		if c.Name == s.Plane {
+			p.index = i
+		}
+	}
+	if p.index < 0 {
+		return nil, fmt.Errorf("plane %s is not one of the combined planes", s.Plane)
+	}
+	return p, nil
+}
+
+// peakShare returns the share of the plane in the peak power of all planes,
+// the share while the sun is down
+func (p *planeShare) peakShare() float64 {
+	var total float64
+	for _, pl := range p.planes {
+		total += pl.kwp
+	}
+	if total == 0 {
+		return 0
+	}
+	return p.planes[p.index].kwp / total
+}
+
+// irradiance returns the irradiance on the plane and on all planes at t,
+// weighted by their peak power
+func (p *planeShare) irradiance(t time.Time) (own, total float64) {
+	elevation, azimuth := solarPosition(t, p.latitude, p.longitude)
+	for i, pl := range p.planes {
+		v := pl.kwp * clearSkyIrradiance(elevation, azimuth, pl.declination, pl.azimuth)
+		total += v
+		if i == p.index {
+			own = v
+		}
+	}
+	return own, total
+}
+
+// at returns the share of the plane in the power at t
+func (p *planeShare) at(t time.Time) float64 {
+	own, total := p.irradiance(t)
+	if total == 0 {
+		return p.peakShare()
+	}
+	return own / total
+}
+
+// between returns the share of the plane in the energy between start and end
+func (p *planeShare) between(start, end time.Time) float64 {
+	var own, total float64
+	for t := start.Add(planeShareStep / 2); t.Before(end); t = t.Add(planeShareStep) {
+		o, all := p.irradiance(t)
+		own += o
+		total += all
+	}
+	if total == 0 {
+		return p.peakShare()
+	}
+	return own / total
+}
+
+// apply returns a copy of the forecast with the share of the plane: the power
+// at each time, the energy per period and per day, and the energy cumulated
+// over the day, also of the quantiles. Periods are in the local time of the
+// plant.
+func (p *planeShare) apply(res *apiResponse) *apiResponse {
+	split := *res
+	loc := res.location()
+
+	split.Result.Watts = make(map[string]float64, len(res.Result.Watts))
+	for period, w := range res.Result.Watts {
+		share := p.peakShare()
+		if t, err := time.ParseInLocation(time.DateTime, period, loc); err == nil {
+			share = p.at(t)
+		}
+		split.Result.Watts[period] = w * share
+	}
+
+	// Periods end at the given time and start at the end of the previous one
+	// of the day, or an hour earlier
+	periods := sortedKeys(res.Result.WattHoursPeriod)
+	split.Result.WattHoursPeriod = make(map[string]float64, len(periods))
+	var previous time.Time
+	for _, period := range periods {
+		wh := res.Result.WattHoursPeriod[period]
+		end, err := time.ParseInLocation(time.DateTime, period, loc)
+		if err != nil {
+			split.Result.WattHoursPeriod[period] = wh * p.peakShare()
+			continue
+		}
+		start := end.Add(-time.Hour)
+		if !previous.IsZero() && previous.Format(time.DateOnly) == end.Format(time.DateOnly) {
+			start = previous
+		}
+		previous = end
+		split.Result.WattHoursPeriod[period] = wh * p.between(start, end)
+	}
+
+	split.Result.WattHoursDay = make(map[string]float64, len(res.Result.WattHoursDay))
+	for day, wh := range res.Result.WattHoursDay {
+		share := p.peakShare()
+		if start, err := time.ParseInLocation(time.DateOnly, day, loc); err == nil {
+			share = p.between(start, start.AddDate(0, 0, 1))
+		}
+		split.Result.WattHoursDay[day] = wh * share
+	}
+
+	if res.Result.WattHours != nil {
+		split.Result.WattHours = make(map[string]float64, len(res.Result.WattHours))
+		for period, wh := range res.Result.WattHours {
+			share := p.peakShare()
+			if end, err := time.ParseInLocation(time.DateTime, period, loc); err == nil {
+				start := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, loc)
+				share = p.between(start, end)
+			}
+			split.Result.WattHours[period] = wh * share
+		}
+	}
+
+	// Quantiles are split alike
+	if res.Quantiles != nil {
+		split.Quantiles = make(map[string]*quantileForecast, len(res.Quantiles))
+		for q, f := range res.Quantiles {
+			var r apiResponse
+			r.Result.Watts, r.Result.WattHoursDay, r.Result.WattHoursPeriod = f.Watts, f.WattHoursDay, f.WattHoursPeriod
+			r.Message.Info.Timezone = res.Message.Info.Timezone
+			s := p.apply(&r)
+			split.Quantiles[q] = &quantileForecast{Watts: s.Result.Watts, WattHoursDay: s.Result.WattHoursDay, WattHoursPeriod: s.Result.WattHoursPeriod}
+		}
+	}
+	return &split
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// The shares of the planes of a site add up to the forecast of the combined
+// request, with the east plane getting most of the morning
+func TestPlaneShare(t *testing.T) {
+	planes := []plane{
+		{Name: "east", Declination: "30", Azimuth: "-90", Kwp: "5"},
+		{Name: "west", Declination: "30", Azimuth: "90", Kwp: "3"},
+	}
+	res := &apiResponse{}
+	res.Message.Info.Timezone = "Europe/Berlin"
+	res.Result.Watts = map[string]float64{
+		"2024-05-01 08:00:00": 2000,
+		"2024-05-01 13:00:00": 5000,
+		"2024-05-01 18:00:00": 2000,
+		"2024-05-01 23:00:00": 0,
+	}
+	res.Result.WattHoursPeriod = map[string]float64{
+		"2024-05-01 08:00:00": 1500,
+		"2024-05-01 13:00:00": 20000,
+		"2024-05-01 18:00:00": 15000,
+	}
+	res.Result.WattHours = map[string]float64{
+		"2024-05-01 08:00:00": 1500,
+		"2024-05-01 13:00:00": 21500,
+		"2024-05-01 18:00:00": 36500,
+	}
+	res.Result.WattHoursDay = map[string]float64{"2024-05-01": 38000}
+
+	split := map[string]*apiResponse{}
+	for _, p := range planes {
+		share, err := newPlaneShare(site{Latitude: "52.5", Longitude: "13.4", Plane: p.Name, Combined: planes})
+		if err != nil {
+			t.Fatal(err)
+		}
+		split[p.Name] = share.apply(res)
+	}
+
+	for name, values := range map[string]func(*apiResponse) map[string]float64{
+		"watts":             func(r *apiResponse) map[string]float64 { return r.Result.Watts },
+		"watt hours period": func(r *apiResponse) map[string]float64 { return r.Result.WattHoursPeriod },
+		"watt hours":        func(r *apiResponse) map[string]float64 { return r.Result.WattHours },
+		"watt hours day":    func(r *apiResponse) map[string]float64 { return r.Result.WattHoursDay },
+	} {
+		for key, want := range values(res) {
+			if got := values(split["east"])[key] + values(split["west"])[key]; math.Abs(got-want) > 1e-6 {
+				t.Errorf("%s of %s: got a sum of %f, want %f", name, key, got, want)
+			}
+		}
+	}
+	if east, west := split["east"].Result.Watts["2024-05-01 08:00:00"], split["west"].Result.Watts["2024-05-01 08:00:00"]; east <= west {
+		t.Errorf("got %f W for the east plane and %f W for the west plane in the morning", east, west)
+	}
+	if east, west := split["east"].Result.Watts["2024-05-01 18:00:00"], split["west"].Result.Watts["2024-05-01 18:00:00"]; east >= west {
+		t.Errorf("got %f W for the east plane and %f W for the west plane in the evening", east, west)
+	}
+
+	if _, err := newPlaneShare(site{Latitude: "52.5", Longitude: "13.4", Plane: "north", Combined: planes}); err == nil {
+		t.Error("got no error for a plane that is not combined")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolEntry is a source scheduled by a pollPool
+type poolEntry struct {
+	source       *source
+	provider     string
+	initialDelay time.Duration
+	cancel       context.CancelFunc // Stops its scheduler once started
+}
+
+// pollPool polls many sources with a fixed number of workers, optionally
+// limiting the concurrent polls per provider. Every source is scheduled on its
+// own, its polls wait for a free worker.
+type pollPool struct {
+	workers int
+	slots   chan struct{}
+	limits  map[string]chan struct{}
+	waiting atomic.Int64
+
+	mu      sync.Mutex
+	ctx     context.Context // Set once started
+	client  *http.Client
+	entries []*poolEntry
+
+	queueWait   prometheus.Histogram
+	workersBusy prometheus.Gauge
+}
+
+func newPollPool(workers int, limits map[string]int) *pollPool {
+	p := &pollPool{
+		workers: workers,
+		slots:   make(chan struct{}, workers),
+		limits:  map[string]chan struct{}{},
+		queueWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "forecast_solar_pool_queue_wait_seconds",
+			Help:    "Time polls waited in the queue for a worker",
+			Buckets: []float64{.01, .1, 1, 10, 60, 300, 900},
+		}),
+		workersBusy: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "forecast_solar_pool_workers_busy",
+			Help: "Number of workers currently polling",
+		}),
+	}
+	for provider, limit := range limits {
+		p.limits[provider] = make(chan struct{}, limit)
+	}
+	return p
+}
+
+// add schedules a source, polling it first after the initial delay and the
+// poll jitter
+func (p *pollPool) add(s *source, provider string, initialDelay time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e := &poolEntry{source: s, provider: provider, initialDelay: initialDelay}
+	p.entries = append(p.entries, e)
+	if p.ctx != nil {
+		p.schedule(e)
+	}
+}
+
+// remove stops scheduling a source, a poll in progress completes
+func (p *pollPool) remove(s *source) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, e := range p.entries {
+		if e.source == s {
+			if e.cancel != nil {
+				e.cancel()
+			}
+			p.entries = append(p.entries[:i:i], p.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// register registers the metrics of the pool
+func (p *pollPool) register(reg prometheus.Registerer) {
+	reg.MustRegister(p.queueWait, p.workersBusy)
+	reg.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "forecast_solar_pool_queue_length",
+			Help: "Number of polls waiting for a worker",
+		},
+		func() float64 {
+			return float64(p.waiting.Load())
+		},
+	))
+	reg.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "forecast_solar_pool_workers",
+			Help: "Number of workers of the poll pool",
+		},
+		func() float64 {
+			return float64(p.workers)
+		},
+	))
+}
+
+// start schedules the sources added so far and all sources added later
+// until ctx is cancelled
+func (p *pollPool) start(ctx context.Context, client *http.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ctx, p.client = ctx, client
+	for _, e := range p.entries {
+		p.schedule(e)
+	}
+}
+
+// schedule starts the scheduler of an entry, polling via the workers.
+// Requires p.mu.
+func (p *pollPool) schedule(e *poolEntry) {
+	ctx, cancel := context.WithCancel(p.ctx)
+	e.cancel = cancel
+	sc := newScheduler(e.source, p.client)
+	sc.poll = func(ctx context.Context) { p.poll(ctx, e) }
+	go sc.run(ctx, e.initialDelay)
+}
+
+// poll polls the source of an entry once a worker is free
+func (p *pollPool) poll(ctx context.Context, e *poolEntry) {
+	p.run(ctx, e.provider, func(ctx context.Context) {
+		e.source.observedPoll(ctx, p.client)
+	})
+}
+
+// run calls poll of a source of the provider once a worker is free, unless
+// ctx is cancelled before. It waits for the concurrency limit of the provider
+// first, so polls of a provider at its limit don't occupy workers while polls
+// of the others are due.
+func (p *pollPool) run(ctx context.Context, provider string, poll func(context.Context)) {
+	queued := time.Now()
+	p.waiting.Add(1)
+	acquired := func(slot chan struct{}) bool {
+		select {
+		case slot <- struct{}{}:
+			return true
+		case <-ctx.Done():
+			p.waiting.Add(-1)
+			return false
+		}
+	}
+	limit, limited := p.limits[provider]
+	if limited {
+		if !acquired(limit) {
+			return
+		}
+		defer func() { <-limit }()
+	}
+	if !acquired(p.slots) {
+		return
+	}
+	defer func() { <-p.slots }()
+	p.waiting.Add(-1)
+	p.queueWait.Observe(time.Since(queued).Seconds())
+
+	p.workersBusy.Inc()
+	defer p.workersBusy.Dec()
+	poll(ctx)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// productionCollector exports the window of today and tomorrow with power
+// forecasted, as anchors for dashboards and automations
+type productionCollector struct {
+	source *source
+	start  *prometheus.Desc
+	end    *prometheus.Desc
+}
+
+func newProductionCollector(s *source) *productionCollector {
+	return &productionCollector{
+		source: s,
+		start: prometheus.NewDesc(
+			"forecast_solar_production_start_seconds",
+			"Unix timestamp of the first forecast period of the day with power",
+			[]string{"day"},
+			nil,
+		),
+		end: prometheus.NewDesc(
+			"forecast_solar_production_end_seconds",
+			"Unix timestamp of the last forecast period of the day with power",
+			[]string{"day"},
+			nil,
+		),
+	}
+}
+
+func (c *productionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.start
+	ch <- c.end
+}
+
+func (c *productionCollector) Collect(ch chan<- prometheus.Metric) {
+	res := c.source.forecast.Load()
+	if res == nil {
+		return
+	}
+	for day, w := range productionWindows(res.Result.Watts, weatherDays(res), res.location()) {
+		ch <- prometheus.MustNewConstMetric(c.start, prometheus.GaugeValue, float64(w.start.Unix()), day)
+		ch <- prometheus.MustNewConstMetric(c.end, prometheus.GaugeValue, float64(w.end.Unix()), day)
+	}
+}
+
+type productionWindow struct {
+	start, end time.Time
+}
+
+// productionWindows returns the first and last period with power per named
+// day. Days without any power forecasted have no window. Times are in the
+// local time of the plant at loc.
+func productionWindows(watts map[string]float64, days map[string]string, loc *time.Location) map[string]productionWindow {
+	result := map[string]productionWindow{}
+	for period, w := range watts {
+		t, err := time.ParseInLocation(time.DateTime, period, loc)
+		if err != nil {
+			continue
+		}
+		day, ok := days[t.Format(time.DateOnly)]
+		if !ok || w <= 0 {
+			continue
+		}
+		window, ok := result[day]
+		if !ok || t.Before(window.start) {
+			window.start = t
+		}
+		if !ok || t.After(window.end) {
+			window.end = t
+		}
+		result[day] = window
+	}
+	return result
+}
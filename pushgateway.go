@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// withoutTimestamps removes the timestamps of gathered metrics, as the
+// Pushgateway rejects pushes containing them. Metrics with timestamps are
+// copied, as the fleet gatherer shares them between scrapes.
+func withoutTimestamps(g prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := g.Gather()
+		for _, mf := range families {
+			for i, m := range mf.Metric {
+				if m.TimestampMs == nil {
+					continue
+				}
+				mf.Metric[i] = &dto.Metric{
+					Label:     m.Label,
+					Gauge:     m.Gauge,
+					Counter:   m.Counter,
+					Summary:   m.Summary,
+					Untyped:   m.Untyped,
+					Histogram: m.Histogram,
+				}
+			}
+		}
+		return families, err
+	})
+}
+
+// newPusher returns a pusher for the Pushgateway at url
+func newPusher(client *http.Client, url, job string, grouping map[string]string) *push.Pusher {
+	pusher := push.New(url, job).
+		Client(client).
+		Gatherer(withoutTimestamps(gatherer))
+	for name, value := range grouping {
+		pusher = pusher.Grouping(name, value)
+	}
+	return pusher
+}
+
+// gatewaySink pushes the metrics to the Pushgateway as push sink
+type gatewaySink struct {
+	pusher *push.Pusher
+}
+
+func (g gatewaySink) push(ctx context.Context) (int, error) {
+	return 0, g.pusher.PushContext(ctx)
+}
+
+// pushOnce polls all sources once and pushes the metrics, for running the
+// exporter as a one-shot job
+func pushOnce(ctx context.Context, pusher *push.Pusher, client *http.Client, sources []*source) error {
+	pollErr := pollOnce(ctx, client, sources)
+	if err := pusher.PushContext(ctx); err != nil {
+		return err
+	}
+	return pollErr
+}
+
+// pollOnce polls all sources once, returning an error if any poll failed
+func pollOnce(ctx context.Context, client *http.Client, sources []*source) error {
+	failed := 0
+	for _, s := range sources {
+		s.observedPoll(ctx, client)
+		if s.lastSuccess.Load() == 0 {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d polls failed", failed, len(sources))
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// quantileCollector exports the forecasts of probabilistic providers by
+// quantile, e.g. the 10th and 90th percentiles as error bars around the
+// median
+type quantileCollector struct {
+	source *source
+	hours  int // Hours ahead to export the power of, 0 for none
+	energy *prometheus.Desc
+	ahead  *prometheus.Desc
+}
+
+func newQuantileCollector(s *source, hours int) *quantileCollector {
+	return &quantileCollector{
+		source: s,
+		hours:  hours,
+		energy: prometheus.NewDesc(
+			"forecast_solar_quantile_kwh",
+			"Solar harvest forecast of the day at the given quantile",
+			[]string{"day", "quantile"},
+			nil,
+		),
+		ahead: prometheus.NewDesc(
+			"forecast_solar_power_quantile_watts_ahead",
+			"Forecasted power the given number of hours from now at the given quantile",
+			[]string{"hours", "quantile"},
+			nil,
+		),
+	}
+}
+
+func (c *quantileCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.energy
+	ch <- c.ahead
+}
+
+func (c *quantileCollector) Collect(ch chan<- prometheus.Metric) {
+	res := c.source.forecast.Load()
+	if res == nil || len(res.Quantiles) == 0 {
+		return
+	}
+	days := weatherDays(res)
+	now := clock()
+	for q, f := range res.Quantiles {
+		for date, wh := range f.WattHoursDay {
+			if day, ok := days[date]; ok {
+				ch <- prometheus.MustNewConstMetric(c.energy, prometheus.GaugeValue, wh/1000, day, q)
+			}
+		}
+
+		curve := newPowerCurve(f.Watts, res.location())
+		for h := 1; h <= c.hours; h++ {
+			if w, ok := curve.at(now.Add(time.Duration(h) * time.Hour)); ok {
+				ch <- prometheus.MustNewConstMetric(c.ahead, prometheus.GaugeValue, w, strconv.Itoa(h), q)
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"text/tabwriter"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// queryOnce polls all sources once and prints their forecasts as table or
+// JSON, for cron jobs, scripts and debugging credentials, or the metrics of
+// the gatherer as they would be exposed, to check them before deploying
+func queryOnce(ctx context.Context, w io.Writer, client *http.Client, sources []*source, g prometheus.Gatherer, format string, l *localeFormat) error {
+	pollErr := pollOnce(ctx, client, sources)
+
+	switch format {
+	case "metrics":
+		families, err := g.Gather()
+		if err != nil {
+			return err
+		}
+		enc := expfmt.NewEncoder(w, expfmt.FmtText)
+		for _, mf := range families {
+			if err := enc.Encode(mf); err != nil {
+				return err
+			}
+		}
+	case "json":
+		result := map[string]forecastResponse{}
+		for _, s := range sources {
+			result[s.name] = newForecastResponse(s)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+	case "table":
+		for i, s := range sources {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			printForecast(w, s, l)
+		}
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+	return pollErr
+}
+
+// printForecast prints the forecast of a source as human-readable table
+func printForecast(w io.Writer, s *source, l *localeFormat) {
+	f := newForecastResponse(s)
+	fmt.Fprintf(w, "%s", s.name)
+	if f.Place != "" {
+		fmt.Fprintf(w, " (%s)", f.Place)
+	}
+	fmt.Fprintln(w)
+	if f.Retrieved == nil {
+		fmt.Fprintln(w, "No forecast retrieved")
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Day\tkWh\t")
+	for _, day := range sortedKeys(f.WattHoursDay) {
+		fmt.Fprintf(tw, "%s\t%s\t\n", l.day(day), l.number(f.WattHoursDay[day]/1000, 2))
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(tw, "Period\tW\tWh\t")
+	for _, period := range sortedKeys(f.Watts) {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t\n", period, l.number(f.Watts[period], 0), l.number(f.WattHoursPeriod[period], 0))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Requests per hour of the public plan of forecast.solar
+const publicPlanRequests = 12
+
+// tokenBucket limits the requests to forecast.solar of all sources on the
+// client side, so many planes can't exceed the rate limit and get the address
+// banned temporarily. The bucket holds the requests of an hour and refills
+// continuously.
+type tokenBucket struct {
+	perHour int
+
+	mu      sync.Mutex
+	tokens  float64
+	updated time.Time
+
+	skipped prometheus.Counter
+}
+
+func newTokenBucket(perHour int) *tokenBucket {
+	return &tokenBucket{
+		perHour: perHour,
+		tokens:  float64(perHour),
+		skipped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "forecast_solar_api_rate_limit_skipped_total",
+			Help: "Number of polls skipped as the client-side rate limit of -api.rate-limit was used up",
+		}),
+	}
+}
+
+// take takes a token for a request at now. If none is left, it returns false
+// and the time until the next one.
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	perSecond := float64(b.perHour) / 3600
+	if !b.updated.IsZero() {
+		b.tokens += now.Sub(b.updated).Seconds() * perSecond
+		if b.tokens > float64(b.perHour) {
+			b.tokens = float64(b.perHour)
+		}
+	}
+	b.updated = now
+
+	if b.tokens < 1 {
+		b.skipped.Inc()
+		return false, time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// register registers the metrics of the rate limit
+func (b *tokenBucket) register(reg prometheus.Registerer) {
+	reg.MustRegister(b.skipped)
+	reg.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "forecast_solar_api_rate_limit_requests",
+			Help: "Requests per hour to forecast.solar allowed by the client-side rate limit of -api.rate-limit",
+		},
+		func() float64 { return float64(b.perHour) },
+	))
+}
+
+// clientLimitError is returned when a poll is skipped as the client-side rate
+// limit is used up
+type clientLimitError struct {
+	wait time.Duration // Until the next request is allowed
+}
+
+func (e *clientLimitError) Error() string {
+	return fmt.Sprintf("client-side rate limit used up, next request allowed in %s", e.wait.Round(time.Second))
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newReferenceSource returns a forecast.solar source for a reference plant.
+// Its daily forecasts are exported as forecast_solar_reference_today and
+// forecast_solar_reference_tomorrow with a site label, so they don't mix with
+// the forecasts of the own plant.
+func newReferenceSource(site, url string, interval time.Duration, opts *sourceOptions) *source {
+	s := newSource("reference "+site, &forecastSolar{url: url}, interval, opts)
+	s.today.metric = prometheus.NewDesc(
+		"forecast_solar_reference_today",
+		"Solar harvest forecast for today of the reference site",
+		nil,
+		prometheus.Labels{"site": site},
+	)
+	s.tomorrow.metric = prometheus.NewDesc(
+		"forecast_solar_reference_tomorrow",
+		"Solar harvest forecast for tomorrow of the reference site",
+		nil,
+		prometheus.Labels{"site": site},
+	)
+	return s
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Time to wait for further changes of the fleet file before reloading it, as
+// editors and Kubernetes change files in several steps
+const fleetWatchDebounce = time.Second
+
+// sourceSet holds the polled sources, which change when the fleet is reloaded
+type sourceSet struct {
+	sources atomic.Pointer[[]*source]
+}
+
+func newSourceSet(sources []*source) *sourceSet {
+	set := &sourceSet{}
+	set.sources.Store(&sources)
+	return set
+}
+
+// all returns the current sources, which must not be modified
+func (set *sourceSet) all() []*source {
+	return *set.sources.Load()
+}
+
+func (set *sourceSet) replace(sources []*source) {
+	set.sources.Store(&sources)
+}
+
+// fleetReloader applies changes of the fleet file while running. Added or
+// changed sites are validated against the check endpoint of forecast.solar and
+// the sources of other providers by retrieving their forecasts before
+// anything is swapped in, so a typo keeps the previous fleet running.
+type fleetReloader struct {
+	path      string
+	client    *http.Client
+	load      func() ([]site, error)
+	check     func(site) error // Validates the planes of a site, nil if off
+	readOnly  *atomic.Bool     // Skips the validation, which calls the APIs
+	providers []string
+	sources   *sourceSet
+	tenants   *tenantGuard
+	owners    *ownerShares
+
+	// Create sources without side effects, start and stop their polls and
+	// metrics
+	newSource func(provider string, site site) (*source, error)
+	start     func(s *source, provider string, site site, initialDelay time.Duration)
+	stop      func(s *source)
+
+	// Serializes changes of the fleet file via rewrite
+	fileMu sync.Mutex
+
+	// Sites and planes and their sources by key
+	mu     sync.Mutex
+	sites  map[string]site
+	bySite map[string][]*source
+}
+
+// add records the sources of a site started on startup
+func (f *fleetReloader) add(st site, sources ...*source) {
+	if f.sites == nil {
+		f.sites = map[string]site{}
+		f.bySite = map[string][]*source{}
+	}
+	f.sites[st.key()] = st
+	f.bySite[st.key()] = append(f.bySite[st.key()], sources...)
+}
+
+// reload loads the fleet file and applies the changes if all added and
+// changed sites are valid, otherwise nothing is changed
+func (f *fleetReloader) reload() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sites, err := f.load()
+	if err != nil {
+		return "", err
+	}
+
+	// Retrieve the forecasts of the new sources as canary, they serve them
+	// right away when applied
+	type started struct {
+		source   *source
+		provider string
+		site     site
+		res      *apiResponse
+	}
+	var changed []started
+	var errs []string
+	names := map[string]bool{}
+	// Read-only mode never calls the APIs, so sites can't be validated
+	readOnly := f.readOnly != nil && f.readOnly.Load()
+	for _, st := range sites {
+		names[st.key()] = true
+		if old, ok := f.sites[st.key()]; ok && reflect.DeepEqual(old, st) {
+			continue
+		}
+		if f.check != nil && !readOnly {
+			if err := f.check(st); err != nil {
+				errs = append(errs, fmt.Sprintf("site %s: %s", st.key(), err))
+				continue
+			}
+		}
+		for _, provider := range f.providers {
+			s, err := f.newSource(provider, st)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("site %s: %s", st.key(), err))
+				continue
+			}
+			// Retrieving the forecast of forecast.solar would spend the rate
+			// limit of the polls, its parameters are validated by the check
+			// endpoint
+			if _, ok := s.provider.(*forecastSolar); ok || readOnly {
+				changed = append(changed, started{source: s, provider: provider, site: st})
+				continue
+			}
+			res, err := s.fetch(f.client)
+			var limitErr *clientLimitError
+			if errors.Is(err, errStandby) || errors.As(err, &limitErr) {
+				// Standby replicas and sources out of the client-side rate
+				// limit don't retrieve forecasts, they poll once started
+				changed = append(changed, started{source: s, provider: provider, site: st})
+				continue
+			}
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", s.name, err))
+				continue
+			}
+			changed = append(changed, started{source: s, provider: provider, site: st, res: res})
+		}
+	}
+	if len(errs) > 0 {
+		return "", errors.New(strings.Join(errs, "\n"))
+	}
+
+	// Swap in the new sources
+	replaced := map[*source]bool{}
+	removed := 0
+	for name := range f.sites {
+		if names[name] && reflect.DeepEqual(f.sites[name], siteByKey(sites, name)) {
+			continue
+		}
+		for _, s := range f.bySite[name] {
+			f.stop(s)
+			replaced[s] = true
+		}
+		if !names[name] {
+			removed++
+		}
+		delete(f.sites, name)
+		delete(f.bySite, name)
+	}
+	var next []*source
+	for _, s := range f.sources.all() {
+		if !replaced[s] {
+			next = append(next, s)
+		}
+	}
+	for _, c := range changed {
+		// Poll right away without a canary forecast or if it can't be served
+		delay := time.Duration(0)
+		if c.res != nil {
+			if c.source.handle(c.res) {
+				delay = c.source.interval
+			}
+			c.source.lastAttempt.Store(time.Now().UnixNano())
+		}
+		f.start(c.source, c.provider, c.site, delay)
+		f.add(c.site, c.source)
+		next = append(next, c.source)
+	}
+	shareRateLimit(next)
+	f.sources.replace(next)
+	f.tenants.update(sites)
+	f.owners.update(sites)
+
+	siteNames := map[string]bool{}
+	for _, st := range sites {
+		siteNames[st.Name] = true
+	}
+	return fmt.Sprintf("%d sites, %d sources added or changed, %d sites or planes removed", len(siteNames), len(changed), removed), nil
+}
+
+// rewrite changes the fleet file with edit and reloads the fleet, restoring
+// the previous file if the edit or the reload fails
+func (f *fleetReloader) rewrite(edit func(fleet []byte) ([]byte, error)) (string, error) {
+	f.fileMu.Lock()
+	defer f.fileMu.Unlock()
+
+	previous, err := os.ReadFile(f.path)
+	if err != nil {
+		return "", err
+	}
+	fleet, err := edit(previous)
+	if err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(f.path, fleet); err != nil {
+		return "", err
+	}
+	summary, err := f.reload()
+	if err != nil {
+		if err := writeFileAtomic(f.path, previous); err != nil {
+			log.Printf("Error restoring fleet file: %s", err)
+		}
+		return "", err
+	}
+	return summary, nil
+}
+
+// siteByKey returns the site or plane with the given key, or the zero site
+func siteByKey(sites []site, key string) site {
+	for _, s := range sites {
+		if s.key() == key {
+			return s
+		}
+	}
+	return site{}
+}
+
+// reloadOnSignal reloads the fleet on SIGHUP
+func (f *fleetReloader) reloadOnSignal() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	for range c {
+		summary, err := f.reload()
+		if err != nil {
+			log.Printf("Error reloading fleet, keeping the previous one: %s", err)
+			continue
+		}
+		log.Printf("Reloaded fleet: %s", summary)
+	}
+}
+
+// watch reloads the fleet when the fleet file changes. The directory is
+// watched instead of the file, as editors and Kubernetes ConfigMap updates
+// replace the file rather than writing to it.
+func (f *fleetReloader) watch() error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := w.Add(filepath.Dir(f.path)); err != nil {
+		w.Close()
+		return err
+	}
+	last, err := os.ReadFile(f.path)
+	if err != nil {
+		w.Close()
+		return err
+	}
+
+	go func() {
+		defer w.Close()
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-w.Events:
+				// Any change may replace the file, e.g. the ..data symlink
+				// of a ConfigMap, its contents tell whether it changed
+				debounce = time.After(fleetWatchDebounce)
+			case err := <-w.Errors:
+				log.Printf("Error watching fleet file: %s", err)
+			case <-debounce:
+				debounce = nil
+				last = f.reloadChanged(last)
+			}
+		}
+	}()
+	return nil
+}
+
+// reloadChanged reloads the fleet if the fleet file differs from last,
+// returning its contents
+func (f *fleetReloader) reloadChanged(last []byte) []byte {
+	// Changes via rewrite are reloaded already, or restored if they failed
+	f.fileMu.Lock()
+	defer f.fileMu.Unlock()
+
+	fleet, err := os.ReadFile(f.path)
+	if err != nil {
+		log.Printf("Error reading changed fleet file: %s", err)
+		return last
+	}
+	if bytes.Equal(fleet, last) {
+		return last
+	}
+	summary, err := f.reload()
+	if err != nil {
+		log.Printf("Error reloading changed fleet file, keeping the previous fleet: %s", err)
+		return fleet
+	}
+	log.Printf("Reloaded changed fleet file: %s", summary)
+	return fleet
+}
+
+// reloadHandler reloads the fleet on POST or PUT, reporting the sources that
+// failed validation
+func reloadHandler(f *fleetReloader, audit *auditLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			w.Header().Set("Allow", "POST, PUT")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		summary, err := f.reload()
+		if err != nil {
+			log.Printf("Error reloading fleet, keeping the previous one: %s", err)
+			http.Error(w, "Error reloading fleet, keeping the previous one:\n"+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		log.Printf("Reloaded fleet by %s: %s", r.RemoteAddr, summary)
+		if audit != nil {
+			if err := audit.record(r, "reload", summary); err != nil {
+				log.Printf("Error writing audit log: %s", err)
+			}
+		}
+		fmt.Fprintf(w, "Reloaded fleet: %s\n", summary)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testProvider returns a forecast, or an error
+type testProvider struct {
+	res     *apiResponse
+	err     error
+	fetches int
+}
+
+func (p *testProvider) fetch(client *http.Client) (*apiResponse, error) {
+	p.fetches++
+	return p.res, p.err
+}
+
+// testReloader is a reloader of a fleet polled by forecast.solar and a test
+// provider, recording the sources started and stopped
+type testReloader struct {
+	*fleetReloader
+	sites    []site
+	checked  []string
+	started  map[string]time.Duration // Initial delay by source name
+	stopped  []string
+	provider *testProvider
+	opts     *sourceOptions
+}
+
+func newTestReloader(sites ...site) *testReloader {
+	r := &testReloader{
+		sites:    sites,
+		started:  map[string]time.Duration{},
+		provider: &testProvider{res: syntheticForecast(48, time.Now())},
+		opts:     &sourceOptions{readOnly: &atomic.Bool{}, quotaBehavior: "keep", quotaAfter: 3, backoffMax: time.Hour, outputs: newOutputs()},
+	}
+	r.fleetReloader = &fleetReloader{
+		client: &http.Client{},
+		load:   func() ([]site, error) { return r.sites, nil },
+		check: func(st site) error {
+			r.checked = append(r.checked, st.key())
+			if st.Kwp == "0" {
+				return errors.New("invalid peak power")
+			}
+			return nil
+		},
+		readOnly:  r.opts.readOnly,
+		providers: []string{"forecast.solar", "test"},
+		sources:   newSourceSet(nil),
+		tenants:   newTenantGuard(nil, ""),
+		owners:    newOwnerShares(nil, nil),
+		newSource: func(name string, st site) (*source, error) {
+			var p provider = &forecastSolar{url: "https://api.forecast.solar/estimate/" + st.Latitude + "/" + st.Longitude}
+			if name == "test" {
+				p = r.provider
+			}
+			return newSource(name+"/"+st.key(), p, time.Hour, r.opts), nil
+		},
+		start: func(s *source, name string, st site, initialDelay time.Duration) {
+			r.started[s.name] = initialDelay
+		},
+		stop: func(s *source) { r.stopped = append(r.stopped, s.name) },
+	}
+	return r
+}
+
+// reset forgets the sources started and stopped and the sites checked
+func (r *testReloader) reset() {
+	r.checked, r.started, r.stopped = nil, map[string]time.Duration{}, nil
+	r.provider.fetches = 0
+}
+
+func TestReload(t *testing.T) {
+	barn := site{Name: "barn", Latitude: "54.9", Longitude: "25.3", Declination: "30", Azimuth: "0", Kwp: "5"}
+	shed := site{Name: "shed", Latitude: "54.8", Longitude: "25.2", Declination: "10", Azimuth: "0", Kwp: "2"}
+	r := newTestReloader(barn)
+	if _, err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	// forecast.solar sources are validated by the check endpoint, the others
+	// by retrieving their forecast, which they serve right away
+	if len(r.checked) != 1 || r.provider.fetches != 1 {
+		t.Fatalf("got %d sites checked and %d canary forecasts, want 1 and 1", len(r.checked), r.provider.fetches)
+	}
+	if delay, ok := r.started["forecast.solar/barn"]; !ok || delay != 0 {
+		t.Errorf("got forecast.solar started %t after %s, want right away", ok, delay)
+	}
+	if delay, ok := r.started["test/barn"]; !ok || delay != time.Hour {
+		t.Errorf("got the test provider started %t after %s, want after the interval of the canary", ok, delay)
+	}
+	if n := len(r.sources.all()); n != 2 {
+		t.Errorf("got %d sources, want 2", n)
+	}
+
+	// Unchanged sites keep running, changed ones are replaced
+	r.reset()
+	changed := barn
+	changed.Kwp = "6"
+	r.sites = []site{changed, shed}
+	summary, err := r.reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(summary, "2 sites, 4 sources added or changed, 0 sites or planes removed") {
+		t.Errorf("got summary %q", summary)
+	}
+	if len(r.stopped) != 2 || len(r.started) != 4 {
+		t.Errorf("got %d sources stopped and %d started, want 2 and 4", len(r.stopped), len(r.started))
+	}
+
+	// Removed sites are stopped
+	r.reset()
+	r.sites = []site{shed}
+	if _, err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.stopped) != 2 || len(r.started) != 0 || len(r.checked) != 0 {
+		t.Errorf("got %d sources stopped, %d started and %d sites checked, want 2, 0 and 0", len(r.stopped), len(r.started), len(r.checked))
+	}
+	if n := len(r.sources.all()); n != 2 {
+		t.Errorf("got %d sources, want 2", n)
+	}
+}
+
+// An invalid site keeps the previous fleet running
+func TestReloadInvalid(t *testing.T) {
+	barn := site{Name: "barn", Latitude: "54.9", Longitude: "25.3", Declination: "30", Azimuth: "0", Kwp: "5"}
+	r := newTestReloader(barn)
+	if _, err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	before := r.sources.all()
+
+	for name, invalidate := range map[string]func(){
+		"check":  func() { r.sites = []site{barn, {Name: "shed", Latitude: "54.8", Longitude: "25.2", Kwp: "0"}} },
+		"canary": func() { r.provider.err = errors.New("invalid resource") },
+	} {
+		r.reset()
+		r.sites = []site{barn, {Name: "shed", Latitude: "54.8", Longitude: "25.2", Kwp: "2"}}
+		invalidate()
+		if _, err := r.reload(); err == nil {
+			t.Errorf("%s: got no error reloading an invalid site", name)
+		}
+		if len(r.started) != 0 || len(r.stopped) != 0 {
+			t.Errorf("%s: got %d sources started and %d stopped, want none", name, len(r.started), len(r.stopped))
+		}
+		if after := r.sources.all(); len(after) != len(before) {
+			t.Errorf("%s: got %d sources, want the previous %d", name, len(after), len(before))
+		}
+		r.provider.err = nil
+	}
+}
+
+// Sources that can't retrieve a forecast now start without a canary: in
+// read-only mode, on standby replicas and out of the client-side rate limit
+func TestReloadWithoutCanary(t *testing.T) {
+	barn := site{Name: "barn", Latitude: "54.9", Longitude: "25.3", Declination: "30", Azimuth: "0", Kwp: "5"}
+	tests := map[string]func(r *testReloader){
+		"read-only": func(r *testReloader) { r.opts.readOnly.Store(true) },
+		"standby":   func(r *testReloader) { r.provider.err = errStandby },
+		"limited":   func(r *testReloader) { r.provider.err = &clientLimitError{wait: time.Minute} },
+	}
+	for name, setup := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := newTestReloader(barn)
+			setup(r)
+			if _, err := r.reload(); err != nil {
+				t.Fatal(err)
+			}
+			if delay, ok := r.started["test/barn"]; !ok || delay != 0 {
+				t.Errorf("got the test provider started %t after %s, want right away", ok, delay)
+			}
+			if name == "read-only" && (len(r.checked) != 0 || r.provider.fetches != 0) {
+				t.Errorf("got %d sites checked and %d canary forecasts in read-only mode, want none", len(r.checked), r.provider.fetches)
+			}
+		})
+	}
+}
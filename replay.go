@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// clock returns the current time, which is simulated when replaying
+// recorded responses
+var clock = time.Now
+
+// recordedResponse is an API response recorded for replays
+type recordedResponse struct {
+	Source   string       `json:"source"`
+	Time     time.Time    `json:"time"`
+	Forecast *apiResponse `json:"forecast"`
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// recordResponse writes a response to dir, named so the files sort by time
+func recordResponse(dir, source string, res *apiResponse) error {
+	r := recordedResponse{Source: source, Time: clock().UTC(), Forecast: res}
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s_%s.json", r.Time.Format("20060102T150405.000000000Z"), unsafeFileChars.ReplaceAllString(source, "_"))
+	return writeFileAtomic(filepath.Join(dir, name), body)
+}
+
+// loadRecordings reads all recorded responses of dir, ordered by time
+func loadRecordings(dir string) ([]recordedResponse, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var recordings []recordedResponse
+	for _, file := range files {
+		body, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var r recordedResponse
+		if err := json.Unmarshal(body, &r); err != nil {
+			return nil, fmt.Errorf("error decoding %s: %s", file, err)
+		}
+		if r.Forecast == nil || r.Time.IsZero() {
+			return nil, fmt.Errorf("%s is not a recorded response", file)
+		}
+		recordings = append(recordings, r)
+	}
+	sort.SliceStable(recordings, func(i, j int) bool { return recordings[i].Time.Before(recordings[j].Time) })
+	return recordings, nil
+}
+
+// replayClock simulates time starting at a recorded time, running speed
+// times faster than real time
+type replayClock struct {
+	mu      sync.Mutex
+	virtual time.Time // Simulated time at real
+	real    time.Time
+	speed   float64
+}
+
+func (c *replayClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.virtual.Add(time.Duration(float64(time.Since(c.real)) * c.speed))
+}
+
+func (c *replayClock) set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.virtual, c.real = t, time.Now()
+}
+
+// replay feeds the recordings through the sources of the same name on the
+// simulated clock, speed times faster than recorded. With speed 0, the
+// recordings are replayed without delay.
+func replay(recordings []recordedResponse, sources []*source, c *replayClock) {
+	byName := map[string]*source{}
+	for _, s := range sources {
+		byName[s.name] = s
+	}
+
+	skipped := map[string]bool{}
+	for i, r := range recordings {
+		if i > 0 && c.speed > 0 {
+			time.Sleep(time.Duration(float64(r.Time.Sub(recordings[i-1].Time)) / c.speed))
+		}
+		c.set(r.Time)
+
+		s, ok := byName[r.Source]
+		if !ok {
+			if !skipped[r.Source] {
+				log.Printf("Skipping recordings of unknown source %s", r.Source)
+				skipped[r.Source] = true
+			}
+			continue
+		}
+		s.handle(r.Forecast)
+	}
+	log.Printf("Replayed %d recorded responses", len(recordings))
+}
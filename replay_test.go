@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRecordings(t *testing.T) {
+	previous := clock
+	defer func() { clock = previous }()
+	dir := t.TempDir()
+	begin := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// Recorded out of order, as sources polling concurrently may
+	for i, name := range []string{"forecast.solar/b", "forecast.solar/a", "forecast.solar/a"} {
+		at := begin.Add(time.Duration(2-i) * time.Minute)
+		clock = func() time.Time { return at }
+		if err := recordResponse(dir, name, syntheticForecast(24, begin)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recordings, err := loadRecordings(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recordings) != 3 {
+		t.Fatalf("got %d recordings, want 3", len(recordings))
+	}
+	for i, r := range recordings {
+		if want := begin.Add(time.Duration(i) * time.Minute); !r.Time.Equal(want) {
+			t.Errorf("got recording %d of %s, want %s", i, r.Time, want)
+		}
+	}
+	if recordings[2].Source != "forecast.solar/b" {
+		t.Errorf("got the last recording of %s, want forecast.solar/b", recordings[2].Source)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "other.json"), []byte(`{"result":{}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadRecordings(dir); err == nil {
+		t.Error("got no error loading a file that is not a recording")
+	}
+}
+
+// Without delay, the recordings are fed through the sources in order on the
+// simulated clock, skipping unknown sources
+func TestReplay(t *testing.T) {
+	previous := clock
+	defer func() { clock = previous }()
+	c := &replayClock{}
+	clock = c.now
+
+	opts := &sourceOptions{readOnly: &atomic.Bool{}, quotaBehavior: "keep", quotaAfter: 3, backoffMax: time.Hour, outputs: newOutputs()}
+	a := newSource("forecast.solar/a", &forecastSolar{}, time.Hour, opts)
+	b := newSource("forecast.solar/b", &forecastSolar{}, time.Hour, opts)
+	begin := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	recordings := []recordedResponse{
+		{Source: "forecast.solar/a", Time: begin, Forecast: syntheticForecast(24, begin)},
+		{Source: "forecast.solar/b", Time: begin.Add(time.Hour), Forecast: syntheticForecast(24, begin)},
+		{Source: "forecast.solar/removed", Time: begin.Add(2 * time.Hour), Forecast: syntheticForecast(24, begin)},
+		{Source: "forecast.solar/a", Time: begin.Add(3 * time.Hour), Forecast: syntheticForecast(24, begin)},
+	}
+	replay(recordings, []*source{a, b}, c)
+
+	if got, want := time.Unix(0, a.lastSuccess.Load()), begin.Add(3*time.Hour); !got.Equal(want) {
+		t.Errorf("got the last forecast of a handled at %s, want %s", got, want)
+	}
+	if got, want := time.Unix(0, b.lastSuccess.Load()), begin.Add(time.Hour); !got.Equal(want) {
+		t.Errorf("got the last forecast of b handled at %s, want %s", got, want)
+	}
+	if got, want := clock(), begin.Add(3*time.Hour); !got.Equal(want) {
+		t.Errorf("got the clock at %s after replaying, want %s", got, want)
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Number of weeks the accuracy trend of a report covers
+const reportTrendWeeks = 4
+
+// weeklyReport summarizes the forecasts and actual production of a week
+type weeklyReport struct {
+	From, To  string
+	Generated time.Time
+	Sources   []reportSource
+}
+
+type reportSource struct {
+	Name     string
+	Days     []reportDay
+	Unknown  int // Days left out as the exporter did not run
+	Forecast float64
+	Actual   *float64
+	Best     *reportDay
+	Worst    *reportDay
+	Trend    []reportTrend
+}
+
+type reportDay struct {
+	Day      string
+	Forecast float64
+	Actual   *float64
+}
+
+// reportTrend is the mean absolute error of the forecasts of a week relative
+// to the actual production
+type reportTrend struct {
+	From         string
+	Days         int
+	ErrorPercent float64
+}
+
+// produced is the actual production if known, the forecast otherwise
+func (d reportDay) produced() float64 {
+	if d.Actual != nil {
+		return *d.Actual
+	}
+	return d.Forecast
+}
+
+// weekOf returns the Monday of the week of t
+func weekOf(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// weeklyReport generates the report of the last week completed before now
+func (h *historyStore) weeklyReport(now time.Time) *weeklyReport {
+	from := weekOf(now).AddDate(0, 0, -7)
+	trendFrom := from.AddDate(0, 0, -7*(reportTrendWeeks-1))
+	to := from.AddDate(0, 0, 6)
+
+	r := &weeklyReport{
+		From:      from.Format(time.DateOnly),
+		To:        to.Format(time.DateOnly),
+		Generated: now,
+	}
+	actuals := h.actuals(trendFrom.Format(time.DateOnly), r.To)
+	forecasts := h.latest(trendFrom.Format(time.DateOnly), r.To)
+	unknown := h.unknownDays(trendFrom.Format(time.DateOnly), r.To)
+
+	for _, name := range sortedKeys(forecasts) {
+		s := reportSource{Name: name}
+		for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+			day := d.Format(time.DateOnly)
+			wh, ok := forecasts[name][day]
+			if unknown[day] {
+				s.Unknown++
+				continue
+			}
+			if !ok {
+				continue
+			}
+			rd := reportDay{Day: day, Forecast: wh}
+			if actual, ok := actuals[day]; ok {
+				rd.Actual = &actual
+				if s.Actual == nil {
+					s.Actual = new(float64)
+				}
+				*s.Actual += actual
+			}
+			s.Forecast += wh
+			s.Days = append(s.Days, rd)
+		}
+		if len(s.Days) == 0 {
+			continue
+		}
+
+		ranked := append([]reportDay{}, s.Days...)
+		sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].produced() > ranked[j].produced() })
+		s.Best, s.Worst = &ranked[0], &ranked[len(ranked)-1]
+
+		for week := trendFrom; !week.After(from); week = week.AddDate(0, 0, 7) {
+			t := reportTrend{From: week.Format(time.DateOnly)}
+			for d := week; d.Before(week.AddDate(0, 0, 7)); d = d.AddDate(0, 0, 1) {
+				day := d.Format(time.DateOnly)
+				wh, ok := forecasts[name][day]
+				actual := actuals[day]
+				if !ok || actual <= 0 || unknown[day] {
+					continue
+				}
+				t.ErrorPercent += math.Abs(wh-actual) / actual * 100
+				t.Days++
+			}
+			if t.Days > 0 {
+				t.ErrorPercent /= float64(t.Days)
+				s.Trend = append(s.Trend, t)
+			}
+		}
+
+		r.Sources = append(r.Sources, s)
+	}
+	return r
+}
+
+// reportFuncs are the template functions formatting values in the locale
+// and translating texts to the language of the report
+func (l *localeFormat) reportFuncs(lang string) map[string]interface{} {
+	return map[string]interface{}{
+		"kwh": func(wh interface{}) string {
+			switch v := wh.(type) {
+			case float64:
+				return l.number(v/1000, 1)
+			case *float64:
+				if v != nil {
+					return l.number(*v/1000, 1)
+				}
+			}
+			return "-"
+		},
+		"percent": func(v float64) string {
+			return l.number(v, 1)
+		},
+		"day":      l.day,
+		"datetime": l.dateTime,
+		"t": func(text string) string {
+			return translate(lang, text)
+		},
+		"lang": func() string {
+			return lang
+		},
+	}
+}
+
+const reportMarkdown = `# {{printf (t "Solar forecast report %s to %s") (day .From) (day .To)}}
+{{range .Sources}}
+## {{.Name}}
+
+{{printf (t "Forecast: %s kWh, actual: %s kWh") (kwh .Forecast) (kwh .Actual)}}
+
+{{printf (t "Best day: %s (%s kWh forecast, %s kWh actual)") (day .Best.Day) (kwh .Best.Forecast) (kwh .Best.Actual)}}
+{{printf (t "Worst day: %s (%s kWh forecast, %s kWh actual)") (day .Worst.Day) (kwh .Worst.Forecast) (kwh .Worst.Actual)}}
+{{if .Unknown}}
+{{printf (t "%d days left out as the exporter was not running.") .Unknown}}
+{{end}}
+| {{t "Day"}} | {{t "Forecast (kWh)"}} | {{t "Actual (kWh)"}} |
+| --- | ---: | ---: |
+{{range .Days}}| {{day .Day}} | {{kwh .Forecast}} | {{kwh .Actual}} |
+{{end}}{{if .Trend}}
+{{t "Accuracy trend (mean absolute error)"}}:
+
+| {{t "Week"}} | {{t "Days"}} | {{t "Error (%)"}} |
+| --- | ---: | ---: |
+{{range .Trend}}| {{day .From}} | {{.Days}} | {{percent .ErrorPercent}} |
+{{end}}{{end}}{{else}}
+{{t "No forecasts recorded in this week."}}
+{{end}}
+{{printf (t "Generated %s") (datetime .Generated)}}
+`
+
+const reportHTML = `<!DOCTYPE html>
+<html lang="{{lang}}">
+<head>
+<meta charset="utf-8">
+<title>{{printf (t "Solar forecast report %s to %s") (day .From) (day .To)}}</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 2em auto; }
+table { border-collapse: collapse; }
+th, td { padding: .2em .8em; border-bottom: 1px solid #ccc; }
+td.n { text-align: right; }
+</style>
+</head>
+<body>
+<h1>{{printf (t "Solar forecast report %s to %s") (day .From) (day .To)}}</h1>
+{{range .Sources}}
+<h2>{{.Name}}</h2>
+<p>{{printf (t "Forecast: %s kWh, actual: %s kWh") (kwh .Forecast) (kwh .Actual)}}</p>
+<p>{{printf (t "Best day: %s (%s kWh forecast, %s kWh actual)") (day .Best.Day) (kwh .Best.Forecast) (kwh .Best.Actual)}}<br>
+{{printf (t "Worst day: %s (%s kWh forecast, %s kWh actual)") (day .Worst.Day) (kwh .Worst.Forecast) (kwh .Worst.Actual)}}</p>
+{{if .Unknown}}<p>{{printf (t "%d days left out as the exporter was not running.") .Unknown}}</p>
+{{end}}<table>
+<tr><th>{{t "Day"}}</th><th>{{t "Forecast (kWh)"}}</th><th>{{t "Actual (kWh)"}}</th></tr>
+{{range .Days}}<tr><td>{{day .Day}}</td><td class="n">{{kwh .Forecast}}</td><td class="n">{{kwh .Actual}}</td></tr>
+{{end}}</table>
+{{if .Trend}}<h3>{{t "Accuracy trend (mean absolute error)"}}</h3>
+<table>
+<tr><th>{{t "Week"}}</th><th>{{t "Days"}}</th><th>{{t "Error (%)"}}</th></tr>
+{{range .Trend}}<tr><td>{{day .From}}</td><td class="n">{{.Days}}</td><td class="n">{{percent .ErrorPercent}}</td></tr>
+{{end}}</table>
+{{end}}{{else}}
+<p>{{t "No forecasts recorded in this week."}}</p>
+{{end}}
+<p><small>{{printf (t "Generated %s") (datetime .Generated)}}</small></p>
+</body>
+</html>
+`
+
+// reportTemplates render reports in a locale and language, from the built-in
+// templates or the ones of a template directory
+type reportTemplates struct {
+	locale   *localeFormat
+	lang     string
+	html     *htmltemplate.Template
+	markdown *texttemplate.Template
+}
+
+// newReportTemplates returns the templates of a language. report.html and
+// report.md in dir override the built-in templates if present.
+func newReportTemplates(locale *localeFormat, lang, dir string) (*reportTemplates, error) {
+	if lang != "en" && reportTranslations[lang] == nil {
+		return nil, fmt.Errorf("unsupported report language %q, expected en, %s", lang, strings.Join(sortedKeys(reportTranslations), ", "))
+	}
+
+	htmlText, markdownText := reportHTML, reportMarkdown
+	if dir != "" {
+		for name, text := range map[string]*string{"report.html": &htmlText, "report.md": &markdownText} {
+			body, err := os.ReadFile(filepath.Join(dir, name))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			*text = string(body)
+		}
+	}
+
+	funcs := locale.reportFuncs(lang)
+	html, err := htmltemplate.New("html").Funcs(funcs).Parse(htmlText)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing report.html: %s", err)
+	}
+	markdown, err := texttemplate.New("markdown").Funcs(funcs).Parse(markdownText)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing report.md: %s", err)
+	}
+	return &reportTemplates{locale: locale, lang: lang, html: html, markdown: markdown}, nil
+}
+
+// subject returns the email subject of a report
+func (t *reportTemplates) subject(r *weeklyReport) string {
+	return fmt.Sprintf(translate(t.lang, "Solar forecast report %s to %s"), t.locale.day(r.From), t.locale.day(r.To))
+}
+
+// render writes the report as html or markdown
+func (r *weeklyReport) render(w io.Writer, format string, t *reportTemplates) error {
+	switch format {
+	case "html":
+		return t.html.Execute(w, r)
+	case "markdown":
+		return t.markdown.Execute(w, r)
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// reportHandler serves the report of the last completed week, as HTML or as
+// Markdown with format=markdown
+func reportHandler(h *historyStore, t *reportTemplates) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.FormValue("format")
+		if format == "" {
+			format = "html"
+		}
+
+		var buf bytes.Buffer
+		if err := h.weeklyReport(time.Now()).render(&buf, format, t); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if format == "html" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		}
+		w.Write(buf.Bytes())
+	}
+}
+
+// reportMailer emails the weekly report via SMTP
+type reportMailer struct {
+	history   *historyStore
+	templates *reportTemplates
+	addr      string // host:port of the SMTP server
+	username  string
+	password  string
+	from      string
+	to        []string
+}
+
+// run sends the report of the previous week every Monday at 06:00 until ctx
+// is cancelled
+func (m *reportMailer) run(ctx context.Context) {
+	for {
+		next := weekOf(time.Now()).AddDate(0, 0, 7)
+		next = time.Date(next.Year(), next.Month(), next.Day(), 6, 0, 0, 0, time.Local)
+		if !sleep(ctx, time.Until(next)) {
+			return
+		}
+
+		if err := m.send(m.history.weeklyReport(time.Now())); err != nil {
+			log.Printf("Error sending weekly report: %s", err)
+		}
+	}
+}
+
+func (m *reportMailer) send(r *weeklyReport) error {
+	var body bytes.Buffer
+	if err := r.render(&body, "html", m.templates); err != nil {
+		return err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(m.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.templates.subject(r)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/html; charset=utf-8\r\n\r\n")
+	msg.Write(body.Bytes())
+
+	var auth smtp.Auth
+	if m.username != "" {
+		host, _, _ := strings.Cut(m.addr, ":")
+		auth = smtp.PlainAuth("", m.username, m.password, host)
+	}
+	return smtp.SendMail(m.addr, auth, m.from, m.to, msg.Bytes())
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// revisionCollector follows how the forecasts for today and tomorrow change
+// between polls: the change of the last revision and the drift since the
+// first forecast of the day, as large revisions hint at unstable weather
+type revisionCollector struct {
+	delta *prometheus.Desc
+	drift *prometheus.Desc
+
+	mu    sync.Mutex
+	days  map[string]*dayRevisions // By date
+	names map[string]string        // today or tomorrow by date
+}
+
+// dayRevisions are the forecasts for a day in Wh
+type dayRevisions struct {
+	first float64
+	last  float64
+	delta float64 // Change of the last revision
+}
+
+func newRevisionCollector() *revisionCollector {
+	return &revisionCollector{
+		delta: prometheus.NewDesc(
+			"forecast_solar_revision_delta_kwh",
+			"Change of the solar harvest forecast for the day by its last revision",
+			[]string{"day"},
+			nil,
+		),
+		drift: prometheus.NewDesc(
+			"forecast_solar_revision_drift_kwh",
+			"Change of the solar harvest forecast for the day since its first forecast",
+			[]string{"day"},
+			nil,
+		),
+		days: map[string]*dayRevisions{},
+	}
+}
+
+// update records the forecasts of a response, forgetting past days
+func (c *revisionCollector) update(res *apiResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	names := weatherDays(res)
+	for date := range c.days {
+		if _, ok := names[date]; !ok {
+			delete(c.days, date)
+		}
+	}
+	for date := range names {
+		wh := res.Result.WattHoursDay[date]
+		d, ok := c.days[date]
+		if !ok {
+			c.days[date] = &dayRevisions{first: wh, last: wh}
+			continue
+		}
+		if wh != d.last {
+			d.delta, d.last = wh-d.last, wh
+		}
+	}
+	c.names = names
+}
+
+func (c *revisionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.delta
+	ch <- c.drift
+}
+
+func (c *revisionCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for date, name := range c.names {
+		d := c.days[date]
+		ch <- prometheus.MustNewConstMetric(c.delta, prometheus.GaugeValue, d.delta/1000, name)
+		ch <- prometheus.MustNewConstMetric(c.drift, prometheus.GaugeValue, (d.last-d.first)/1000, name)
+	}
+}
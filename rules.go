@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// recordingRule is a Prometheus recording rule
+type recordingRule struct {
+	Record string `yaml:"record"`
+	Expr   string `yaml:"expr"`
+}
+
+type ruleGroup struct {
+	Name  string          `yaml:"name"`
+	Rules []recordingRule `yaml:"rules"`
+}
+
+type ruleFile struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+// recordingRules returns the recommended recording rules for the metrics
+// exported with the given prefix and energy unit, depending on whether energy
+// metrics are exported, a fleet is polled and the actual production is
+// retrieved. {p} in the rules is the prefix.
+func recordingRules(prefix, unit string, energy, fleet, actual bool) ruleFile {
+	rules := []recordingRule{}
+	if !energy {
+		return ruleFile{Groups: []ruleGroup{{Name: prefix, Rules: rules}}}
+	}
+
+	kwh := func(name string) string { return kwhExpr(name, unit) }
+	rules = append(rules,
+		recordingRule{"{p}:today_kwh", kwh("{p}_today")},
+		recordingRule{"{p}:tomorrow_kwh", kwh("{p}_tomorrow")},
+		recordingRule{"{p}:tomorrow_vs_today:ratio", kwh("{p}_tomorrow") + " / " + kwh("{p}_today") + " > 0"},
+	)
+	if fleet {
+		rules = append(rules,
+			recordingRule{"provider:{p}_total_today_kwh:sum", "sum by (provider) (" + kwh("{p}_total_today_kwh") + ")"},
+			recordingRule{"provider:{p}_remaining_today_kwh:sum", "sum by (provider) (" + kwh("{p}_remaining_today_kwh") + ")"},
+		)
+	}
+	if actual {
+		// The actual production has no provider label
+		rules = append(rules,
+			recordingRule{"{p}:remaining_today_kwh", "clamp_min(" + kwh("{p}_today") + " - ignoring(provider) group_left " + kwh("{p}_actual_kwh") + ", 0)"},
+			recordingRule{"{p}:actual_vs_forecast:ratio", kwh("{p}_actual_kwh") + " / ignoring(provider) group_right " + kwh("{p}_cumulative_today_kwh") + " > 0"},
+			recordingRule{"{p}:accuracy:ratio", kwh("{p}_actual_final_kwh") + " / ignoring(provider) group_right " + kwh("{p}_today_final") + " > 0"},
+		)
+	}
+	for i := range rules {
+		rules[i].Record = strings.ReplaceAll(rules[i].Record, "{p}", prefix)
+		rules[i].Expr = strings.ReplaceAll(rules[i].Expr, "{p}", prefix)
+	}
+	return ruleFile{Groups: []ruleGroup{{Name: prefix, Rules: rules}}}
+}
+
+// recordingRulesHandler serves the recording rules as rule file to load into
+// Prometheus
+func recordingRulesHandler(rules ruleFile) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		enc.Encode(rules)
+	}
+}
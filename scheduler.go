@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+// scheduler polls a source until its context is cancelled: right away, or
+// after holding off while a cached forecast is recent, then whenever the delay
+// the source asks for after every poll passed
+type scheduler struct {
+	source *source
+
+	// Hooks polling the source and returning the delay until the next poll,
+	// by default the poll of the source and its delay including the backoff
+	// after errors and the rate limits
+	poll      func(ctx context.Context)
+	nextDelay func() time.Duration
+
+	// Hooks returning the current time, and a channel receiving the time once
+	// d passed along with a function stopping it, by default of the system
+	// clock. Tests replace them with a fake clock.
+	now   func() time.Time
+	after func(d time.Duration) (<-chan time.Time, func() bool)
+}
+
+func newScheduler(s *source, client *http.Client) *scheduler {
+	return &scheduler{
+		source:    s,
+		poll:      func(ctx context.Context) { s.observedPoll(ctx, client) },
+		nextDelay: s.nextDelay,
+		now:       time.Now,
+		after: func(d time.Duration) (<-chan time.Time, func() bool) {
+			timer := time.NewTimer(d)
+			return timer.C, timer.Stop
+		},
+	}
+}
+
+// run polls the source until ctx is cancelled, after an optional initial
+// delay
+func (sc *scheduler) run(ctx context.Context, initialDelay time.Duration) {
+	s := sc.source
+	if initialDelay > 0 {
+		log.Printf("Cached forecast of %s is recent, next poll in %s", s.name, initialDelay.Round(time.Second))
+	}
+	if delay := initialDelay + s.jitter(); delay > 0 && !sc.wait(ctx, sc.now().Add(delay)) {
+		return
+	}
+
+	var scheduled time.Time
+	for {
+		// Polls starting an interval or more after they were scheduled, as
+		// the previous poll took that long e.g. waiting for a worker, skipped
+		// ticks. Longer delays asked for by the source, such as its backoff,
+		// are no missed ticks.
+		start := sc.now()
+		if behind := start.Sub(scheduled); !scheduled.IsZero() && behind >= s.interval {
+			s.missedTicks.Add(float64(behind / s.interval))
+		}
+
+		sc.poll(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		scheduled = start.Add(sc.nextDelay())
+		if !sc.wait(ctx, scheduled) {
+			return
+		}
+		s.schedulerLag.Set(sc.now().Sub(scheduled).Seconds())
+		s.changed()
+	}
+}
+
+// wait waits until scheduled, returning false if ctx is cancelled first
+func (sc *scheduler) wait(ctx context.Context, scheduled time.Time) bool {
+	d := scheduled.Sub(sc.now())
+	if d <= 0 {
+		// Behind schedule, poll right away
+		return ctx.Err() == nil
+	}
+	c, stop := sc.after(d)
+	defer stop()
+	select {
+	case <-c:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sleep waits for d, returning false if ctx is cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// pollClient returns a copy of the client sending all requests of a poll with
+// its context, limited to the poll timeout
+func (s *source) pollClient(ctx context.Context, client *http.Client) (*http.Client, context.CancelFunc) {
+	cancel := context.CancelFunc(func() {})
+	if s.opts.pollTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.opts.pollTimeout)
+	}
+	return withContext(ctx, client), cancel
+}
+
+// withContext returns a copy of the client sending all requests with ctx
+func withContext(ctx context.Context, client *http.Client) *http.Client {
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	bound := *client
+	bound.Transport = &contextTransport{next: next, ctx: ctx}
+	return &bound
+}
+
+// contextTransport sends requests with the context of the poll they belong
+// to, so its cancellation or deadline aborts them
+type contextTransport struct {
+	next http.RoundTripper
+	ctx  context.Context
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.next.RoundTrip(req.WithContext(t.ctx))
+}
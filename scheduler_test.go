@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// fakeClock is the clock of a scheduler under test, which only advances when
+// the test says so
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+	added  chan struct{} // Signalled when a timer is started
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), added: make(chan struct{}, 1)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) after(d time.Duration) (<-chan time.Time, func() bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.timers = append(c.timers, t)
+	select {
+	case c.added <- struct{}{}:
+	default:
+	}
+	return t.c, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for i, pending := range c.timers {
+			if pending == t {
+				c.timers = append(c.timers[:i:i], c.timers[i+1:]...)
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// advance moves the clock forward, firing the timers due
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.c <- c.now
+	}
+	c.timers = pending
+}
+
+// waitTimer waits until the scheduler waits for a timer, returning how long
+func (c *fakeClock) waitTimer(t *testing.T) time.Duration {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		c.mu.Lock()
+		if len(c.timers) > 0 {
+			d := c.timers[0].at.Sub(c.now)
+			c.mu.Unlock()
+			return d
+		}
+		c.mu.Unlock()
+		select {
+		case <-c.added:
+		case <-timeout:
+			t.Fatal("scheduler didn't wait for a timer")
+		}
+	}
+}
+
+// pending returns the number of running timers
+func (c *fakeClock) pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// testScheduler returns a scheduler of a source polled every interval on a
+// fake clock, recording the start of every poll. The hooks can be replaced by
+// the test.
+func testScheduler(interval time.Duration) (*scheduler, *pollRecorder, *fakeClock) {
+	s := &source{
+		name:         "test",
+		interval:     interval,
+		opts:         &sourceOptions{},
+		schedulerLag: prometheus.NewGauge(prometheus.GaugeOpts{Name: "lag"}),
+		missedTicks:  prometheus.NewCounter(prometheus.CounterOpts{Name: "missed"}),
+	}
+	clock := newFakeClock()
+	rec := &pollRecorder{clock: clock}
+	sc := &scheduler{
+		source:    s,
+		poll:      func(ctx context.Context) { rec.record() },
+		nextDelay: func() time.Duration { return interval },
+		now:       clock.Now,
+		after:     clock.after,
+	}
+	return sc, rec, clock
+}
+
+type pollRecorder struct {
+	clock *fakeClock
+	mu    sync.Mutex
+	polls []time.Time
+}
+
+func (r *pollRecorder) record() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.polls = append(r.polls, r.clock.Now())
+	return len(r.polls)
+}
+
+func (r *pollRecorder) get() []time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]time.Time(nil), r.polls...)
+}
+
+// startScheduler runs the scheduler, returning a function that cancels it
+// and fails the test if it doesn't return within a second
+func startScheduler(t *testing.T, sc *scheduler, initialDelay time.Duration) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sc.run(ctx, initialDelay)
+		close(done)
+	}()
+	return func() {
+		t.Helper()
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("scheduler didn't return after its context was cancelled")
+		}
+	}
+}
+
+func TestSchedulerFirstPoll(t *testing.T) {
+	tests := []struct {
+		name         string
+		initialDelay time.Duration
+	}{
+		{"immediate", 0},
+		{"initial delay", 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, rec, clock := testScheduler(time.Hour)
+			begin := clock.Now()
+			stop := startScheduler(t, sc, tt.initialDelay)
+			defer stop()
+
+			if tt.initialDelay > 0 {
+				if d := clock.waitTimer(t); d != tt.initialDelay {
+					t.Fatalf("waiting %s for the first poll, want %s", d, tt.initialDelay)
+				}
+				if polls := rec.get(); len(polls) != 0 {
+					t.Fatalf("got %d polls before the initial delay, want none", len(polls))
+				}
+				clock.advance(tt.initialDelay)
+			}
+			if d := clock.waitTimer(t); d != time.Hour {
+				t.Errorf("waiting %s for the second poll, want the interval", d)
+			}
+
+			polls := rec.get()
+			if len(polls) != 1 {
+				t.Fatalf("got %d polls, want 1", len(polls))
+			}
+			if d := polls[0].Sub(begin); d != tt.initialDelay {
+				t.Errorf("first poll after %s, want %s", d, tt.initialDelay)
+			}
+		})
+	}
+}
+
+func TestSchedulerBackoff(t *testing.T) {
+	interval := 50 * time.Millisecond
+	sc, rec, clock := testScheduler(interval)
+	// Back off after the first poll, then poll in the interval again
+	delays := []time.Duration{300 * time.Millisecond}
+	sc.nextDelay = func() time.Duration {
+		if len(delays) > 0 {
+			d := delays[0]
+			delays = delays[1:]
+			return d
+		}
+		return interval
+	}
+	stop := startScheduler(t, sc, 0)
+	defer stop()
+
+	for _, want := range []time.Duration{300 * time.Millisecond, interval} {
+		d := clock.waitTimer(t)
+		if d != want {
+			t.Errorf("waiting %s for the next poll, want %s", d, want)
+		}
+		clock.advance(d)
+	}
+	clock.waitTimer(t)
+
+	polls := rec.get()
+	if len(polls) != 3 {
+		t.Fatalf("got %d polls, want 3", len(polls))
+	}
+	if d := polls[1].Sub(polls[0]); d != 300*time.Millisecond {
+		t.Errorf("second poll after %s, want the backoff of 300ms", d)
+	}
+	if d := polls[2].Sub(polls[1]); d != interval {
+		t.Errorf("third poll after %s, want the interval of %s", d, interval)
+	}
+}
+
+func TestSchedulerMissedTicks(t *testing.T) {
+	interval := 50 * time.Millisecond
+	sc, rec, clock := testScheduler(interval)
+	// The first poll takes three and a half intervals
+	sc.poll = func(ctx context.Context) {
+		if rec.record() == 1 {
+			clock.advance(interval * 7 / 2)
+		}
+	}
+	stop := startScheduler(t, sc, 0)
+	defer stop()
+	clock.waitTimer(t)
+
+	if polls := rec.get(); len(polls) != 2 {
+		t.Fatalf("got %d polls, want 2", len(polls))
+	}
+	var m dto.Metric
+	if err := sc.source.missedTicks.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	// The second poll starts two and a half intervals late, right away
+	if got := m.GetCounter().GetValue(); got != 2 {
+		t.Errorf("got %g missed ticks, want 2", got)
+	}
+	if err := sc.source.schedulerLag.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.GetGauge().GetValue(), (interval * 5 / 2).Seconds(); got != want {
+		t.Errorf("got a lag of %gs, want %gs", got, want)
+	}
+}
+
+func TestSchedulerCancelDuringWait(t *testing.T) {
+	sc, rec, clock := testScheduler(time.Hour)
+	stop := startScheduler(t, sc, 0)
+	clock.waitTimer(t)
+	stop()
+
+	if polls := rec.get(); len(polls) != 1 {
+		t.Errorf("got %d polls, want 1", len(polls))
+	}
+	if n := clock.pending(); n != 0 {
+		t.Errorf("got %d timers running after the cancellation, want none", n)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// scrapeGatherer polls sources lazily when metrics are gathered instead of in
+// a background loop, so the freshness of the data follows the scrapes. Each
+// source is polled at most once per poll interval, concurrent scrapes wait
+// for the poll in flight instead of starting another one. Fleets poll via the
+// workers of the pool.
+type scrapeGatherer struct {
+	ctx      context.Context // Of the polls
+	client   *http.Client
+	gatherer prometheus.Gatherer
+	pool     *pollPool // Of fleets, nil otherwise
+	entries  []*scrapeEntry
+}
+
+type scrapeEntry struct {
+	source   *source
+	provider string
+
+	mu        sync.Mutex
+	attempted time.Time     // Start of the last poll, successful or not
+	delay     time.Duration // Until the next poll, backed off after errors
+}
+
+func newScrapeGatherer(ctx context.Context, client *http.Client, gatherer prometheus.Gatherer, sources []*source, pool *pollPool) *scrapeGatherer {
+	g := &scrapeGatherer{ctx: ctx, client: client, gatherer: gatherer, pool: pool}
+	for _, s := range sources {
+		provider, _, _ := splitSourceName(s.name)
+		e := &scrapeEntry{source: s, provider: provider}
+		// Forecasts loaded from the cache count as polled when retrieved
+		s.loadCache()
+		if last := s.lastSuccess.Load(); last != 0 {
+			e.attempted = time.Unix(0, last)
+			e.delay = s.interval
+		}
+		g.entries = append(g.entries, e)
+	}
+	return g
+}
+
+// Gather polls all sources whose data is older than their poll interval in
+// parallel, at most as many as the pool has workers, then gathers the metrics
+func (g *scrapeGatherer) Gather() ([]*dto.MetricFamily, error) {
+	workers := len(g.entries)
+	if g.pool != nil && g.pool.workers < workers {
+		workers = g.pool.workers
+	}
+	entries := make(chan *scrapeEntry)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range entries {
+				g.refresh(e)
+			}
+		}()
+	}
+	for _, e := range g.entries {
+		entries <- e
+	}
+	close(entries)
+	wg.Wait()
+
+	return g.gatherer.Gather()
+}
+
+// refresh polls the source of an entry if its data is older than the delay
+func (g *scrapeGatherer) refresh(e *scrapeEntry) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if time.Since(e.attempted) < e.delay {
+		return
+	}
+	e.attempted = time.Now()
+	poll := func(ctx context.Context) { e.source.observedPoll(ctx, g.client) }
+	if g.pool != nil {
+		g.pool.run(g.ctx, e.provider, poll)
+	} else {
+		poll(g.ctx)
+	}
+	e.delay = e.source.nextDelay()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends a state such as READY=1 to systemd if the exporter runs as
+// a Type=notify service, otherwise it does nothing
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	// Abstract sockets are passed with a leading @
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdWatchdogInterval returns the watchdog timeout of systemd, or 0 if the
+// watchdog is disabled
+func sdWatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.Atoi(os.Getenv("WATCHDOG_USEC"))
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// runSystemdNotify reports readiness to systemd once a forecast is served and
+// pings the watchdog while the poll loops are alive. With checkPolls false,
+// e.g. when polling on scrapes, the watchdog is pinged unconditionally.
+func runSystemdNotify(sources *sourceSet, checkPolls bool) {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	started := time.Now()
+
+	go func() {
+		for !served(sources.all()) {
+			time.Sleep(time.Second)
+		}
+		if err := sdNotify("READY=1"); err != nil {
+			log.Printf("Error notifying systemd: %s", err)
+		}
+	}()
+
+	timeout := sdWatchdogInterval()
+	if timeout == 0 {
+		return
+	}
+	for range time.Tick(timeout / 2) {
+		if checkPolls {
+			if s := hungSource(sources.all(), started); s != nil {
+				log.Printf("Poll loop of %s is hung, no longer pinging the systemd watchdog", s.name)
+				continue
+			}
+		}
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			log.Printf("Error pinging systemd watchdog: %s", err)
+		}
+	}
+}
+
+// served returns whether any source serves a forecast
+func served(sources []*source) bool {
+	for _, s := range sources {
+		if s.lastSuccess.Load() != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// firstPollsDone returns whether every source served a forecast or attempted
+// its first poll, so a site failing to poll doesn't hold up the others
+func firstPollsDone(sources []*source) bool {
+	for _, s := range sources {
+		if s.lastSuccess.Load() == 0 && s.lastAttempt.Load() == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hungSource returns a source that didn't finish a poll for two poll
+// intervals or the interval plus its backoff after errors, counting from
+// started before the first poll
+func hungSource(sources []*source, started time.Time) *source {
+	for _, s := range sources {
+		last := started
+		if attempt := s.lastAttempt.Load(); attempt != 0 && time.Unix(0, attempt).After(started) {
+			last = time.Unix(0, attempt)
+		}
+		limit := 2 * s.interval
+		if delay := time.Duration(s.pollDelay.Load()); s.interval+delay > limit {
+			limit = s.interval + delay
+		}
+		if time.Since(last) > limit {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// settingsPage edits the fleet file in the browser, for administrators using
+// a tablet rather than SSH. Changes are previewed as the resulting requests to
+// forecast.solar and applied via the fleet reload, restoring the previous file
+// if the reload fails.
+type settingsPage struct {
+	path     string
+	guard    *adminGuard
+	reloader *fleetReloader
+	audit    *auditLog
+
+	// Convert the sites of the fleet file like on reload, and return the
+	// URL of the request of a site with the API key redacted
+	prepare func(sites []site) ([]site, error)
+	request func(s site) string
+}
+
+// settingsData is the data of the settings page
+type settingsData struct {
+	Fleet   string
+	Sites   []settingsSite
+	Error   string
+	Applied string
+}
+
+type settingsSite struct {
+	Key     string
+	Request string
+}
+
+// preview validates the content of a fleet file, returning the requests of
+// its sites
+func (p *settingsPage) preview(fleet string) ([]settingsSite, error) {
+	sites, err := parseFleet([]byte(fleet))
+	if err != nil {
+		return nil, err
+	}
+	if sites, err = p.prepare(sites); err != nil {
+		return nil, err
+	}
+	preview := make([]settingsSite, 0, len(sites))
+	for _, s := range sites {
+		preview = append(preview, settingsSite{Key: s.key(), Request: p.request(s)})
+	}
+	return preview, nil
+}
+
+// apply writes the fleet file and reloads the fleet, restoring the previous
+// file if the reload fails
+func (p *settingsPage) apply(fleet string) (string, error) {
+	return p.reloader.rewrite(func([]byte) ([]byte, error) {
+		return []byte(fleet), nil
+	})
+}
+
+// sameOrigin returns whether a request was sent by a page of the exporter
+// itself, as browsers send the credentials of basic authentication along with
+// requests of other sites
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// ServeHTTP renders the fleet file on GET, previews it with action=preview
+// and applies it with action=apply on POST. All requests require the admin
+// token, which browsers ask for as password.
+func (p *settingsPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !p.guard.allowed(r) {
+		log.Printf("Denied %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Basic realm="forecast_solar_exporter"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var data settingsData
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		body, err := os.ReadFile(p.path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data.Fleet = string(body)
+	case http.MethodPost:
+		if !sameOrigin(r) {
+			log.Printf("Denied %s %s from %s: cross-origin request", r.Method, r.URL.Path, r.RemoteAddr)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		data.Fleet = strings.ReplaceAll(r.FormValue("fleet"), "\r\n", "\n")
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var err error
+	data.Sites, err = p.preview(data.Fleet)
+	if err != nil {
+		data.Error = err.Error()
+	} else if r.Method == http.MethodPost && r.FormValue("action") == "apply" {
+		summary, err := p.apply(data.Fleet)
+		if err != nil {
+			log.Printf("Error reloading fleet, keeping the previous one: %s", err)
+			data.Error = "Error reloading fleet, keeping the previous one:\n" + err.Error()
+		} else {
+			log.Printf("Reloaded fleet edited by %s: %s", r.RemoteAddr, summary)
+			if p.audit != nil {
+				if err := p.audit.record(r, "settings", summary); err != nil {
+					log.Printf("Error writing audit log: %s", err)
+				}
+			}
+			data.Applied = summary
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := settingsTemplate.Execute(&buf, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+const settingsHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Settings - Solar forecast</title>
+<style>
+body { font-family: sans-serif; color: #222; max-width: 60em; margin: 2em auto; padding: 0 1em; }
+nav a { margin-right: 1em; color: #222; }
+textarea { width: 100%; box-sizing: border-box; font-family: monospace; font-size: 1em; }
+button { font-size: 1.2em; padding: .4em 1em; margin: .5em 1em .5em 0; }
+table { border-collapse: collapse; }
+th, td { padding: .2em .8em; border-bottom: 1px solid #ccc; text-align: left; }
+td code { word-break: break-all; }
+.error { color: #d0021b; white-space: pre-wrap; }
+.applied { color: #417505; }
+</style>
+</head>
+<body>
+<nav><a href="/">Forecast</a></nav>
+<h1>Settings</h1>
+{{with .Error}}<p class="error">{{.}}</p>
+{{end}}{{with .Applied}}<p class="applied">Reloaded fleet: {{.}}</p>
+{{end}}<form method="post">
+<textarea name="fleet" rows="24" spellcheck="false">{{.Fleet}}</textarea>
+<button name="action" value="preview">Preview</button><button name="action" value="apply">Apply</button>
+</form>
+{{with .Sites}}<h2>Requests</h2>
+<table>
+<tr><th>Site</th><th>forecast.solar request</th></tr>
+{{range .}}<tr><td>{{.Key}}</td><td>{{with .Request}}<code>{{.}}</code>{{else}}-{{end}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`
+
+var settingsTemplate = htmltemplate.Must(htmltemplate.New("settings").Parse(settingsHTML))
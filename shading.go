@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Share of the energy lost while the sun is behind an obstruction: the direct
+// irradiance, which makes up most of it under a clear sky. Diffuse light from
+// the rest of the sky still reaches the plane.
+const shadedLoss = 0.8
+
+// Interval the sun position is sampled in to prorate the shade of a period
+const shadingStep = 5 * time.Minute
+
+// obstruction is an object casting shade on a plane, such as a chimney or a
+// dormer, as seen from the plane
+type obstruction struct {
+	Height   float64 `yaml:"height"`   // Above the plane in m
+	Bearing  float64 `yaml:"bearing"`  // In the convention of the azimuth of the plane
+	Distance float64 `yaml:"distance"` // Horizontal distance in m
+	Width    float64 `yaml:"width"`    // In m, 1 if not set
+}
+
+// parseObstruction parses an obstruction given as height,bearing,distance or
+// height,bearing,distance,width
+func parseObstruction(value string) (obstruction, error) {
+	fields := strings.Split(value, ",")
+	if len(fields) != 3 && len(fields) != 4 {
+		return obstruction{}, fmt.Errorf("expected height,bearing,distance[,width], got %q", value)
+	}
+	var values [4]float64
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil {
+			return obstruction{}, fmt.Errorf("invalid obstruction %q: %s", value, err)
+		}
+		values[i] = v
+	}
+	o := obstruction{Height: values[0], Bearing: values[1], Distance: values[2], Width: values[3]}
+	return o, o.validate()
+}
+
+func (o obstruction) validate() error {
+	if o.Height <= 0 || o.Distance <= 0 || o.Width < 0 {
+		return fmt.Errorf("obstruction requires a positive height and distance")
+	}
+	return nil
+}
+
+// shades returns whether the obstruction hides the sun at the given elevation
+// and azimuth in degrees
+func (o obstruction) shades(elevation, azimuth float64) bool {
+	width := o.Width
+	if width == 0 {
+		width = 1
+	}
+	top := math.Atan2(o.Height, o.Distance) / degrees
+	halfWidth := math.Atan2(width/2, o.Distance) / degrees
+	offset := math.Mod(azimuth-o.Bearing+540, 360) - 180
+	return elevation < top && math.Abs(offset) <= halfWidth
+}
+
+// obstructionFlag is a repeatable flag collecting obstructions
+type obstructionFlag []obstruction
+
+func (f *obstructionFlag) String() string {
+	values := make([]string, 0, len(*f))
+	for _, o := range *f {
+		values = append(values, fmt.Sprintf("%g,%g,%g,%g", o.Height, o.Bearing, o.Distance, o.Width))
+	}
+	return strings.Join(values, " ")
+}
+
+func (f *obstructionFlag) Set(value string) error {
+	o, err := parseObstruction(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, o)
+	return nil
+}
+
+// shadingMask applies the shade of obstructions to the forecasts of a plane,
+// computed locally from the position of the sun
+type shadingMask struct {
+	latitude, longitude float64
+	obstructions        []obstruction
+}
+
+// shaded returns whether the sun is up and hidden by an obstruction at t
+func (m *shadingMask) shaded(t time.Time) bool {
+	elevation, azimuth := solarPosition(t, m.latitude, m.longitude)
+	if elevation <= 0 {
+		return false
+	}
+	for _, o := range m.obstructions {
+		if o.shades(elevation, azimuth) {
+			return true
+		}
+	}
+	return false
+}
+
+// factor returns the share of the energy between start and end remaining in
+// the shade
+func (m *shadingMask) factor(start, end time.Time) float64 {
+	var samples, shaded int
+	for t := start.Add(shadingStep / 2); t.Before(end); t = t.Add(shadingStep) {
+		samples++
+		if m.shaded(t) {
+			shaded++
+		}
+	}
+	if samples == 0 {
+		return 1
+	}
+	return 1 - shadedLoss*float64(shaded)/float64(samples)
+}
+
+// apply returns a copy of the forecast with the shade applied: the power at
+// each time, and the energy per period prorated by the time in shade. The
+// daily and cumulative energy are reduced by the energy lost, also of the
+// quantiles. Periods are in the local time of the plant.
+func (m *shadingMask) apply(res *apiResponse) *apiResponse {
+	shaded := *res
+	loc := res.location()
+
+	shaded.Result.Watts = make(map[string]float64, len(res.Result.Watts))
+	for period, w := range res.Result.Watts {
+		if t, err := time.ParseInLocation(time.DateTime, period, loc); err == nil && m.shaded(t) {
+			w *= 1 - shadedLoss
+		}
+		shaded.Result.Watts[period] = w
+	}
+
+	// Periods end at the given time and start at the end of the previous one
+	// of the day, or an hour earlier
+	periods := sortedKeys(res.Result.WattHoursPeriod)
+	shaded.Result.WattHoursPeriod = make(map[string]float64, len(periods))
+	lost := map[string]float64{} // Energy lost per period
+	var previous time.Time
+	for _, period := range periods {
+		wh := res.Result.WattHoursPeriod[period]
+		end, err := time.ParseInLocation(time.DateTime, period, loc)
+		if err != nil {
+			shaded.Result.WattHoursPeriod[period] = wh
+			continue
+		}
+		start := end.Add(-time.Hour)
+		if !previous.IsZero() && previous.Format(time.DateOnly) == end.Format(time.DateOnly) {
+			start = previous
+		}
+		previous = end
+
+		shaded.Result.WattHoursPeriod[period] = wh * m.factor(start, end)
+		lost[period] = wh - shaded.Result.WattHoursPeriod[period]
+	}
+
+	// Energy lost by day and cumulated over the day by period
+	lostDay := map[string]float64{}
+	lostUntil := map[string]float64{}
+	for _, period := range periods {
+		if len(period) < len(time.DateOnly) {
+			continue
+		}
+		day := period[:len(time.DateOnly)]
+		lostDay[day] += lost[period]
+		lostUntil[period] = lostDay[day]
+	}
+
+	shaded.Result.WattHoursDay = make(map[string]float64, len(res.Result.WattHoursDay))
+	for day, wh := range res.Result.WattHoursDay {
+		shaded.Result.WattHoursDay[day] = math.Max(0, wh-lostDay[day])
+	}
+	if res.Result.WattHours != nil {
+		shaded.Result.WattHours = make(map[string]float64, len(res.Result.WattHours))
+		for period, wh := range res.Result.WattHours {
+			// Lost up to the last period ending at or before this time
+			i := sort.SearchStrings(periods, period)
+			if i == len(periods) || periods[i] != period {
+				i--
+			}
+			var l float64
+			if i >= 0 && lostUntil[periods[i]] > 0 && strings.HasPrefix(period, periods[i][:len(time.DateOnly)]) {
+				l = lostUntil[periods[i]]
+			}
+			shaded.Result.WattHours[period] = math.Max(0, wh-l)
+		}
+	}
+
+	// Quantiles are shaded alike
+	if res.Quantiles != nil {
+		shaded.Quantiles = make(map[string]*quantileForecast, len(res.Quantiles))
+		for q, f := range res.Quantiles {
+			var r apiResponse
+			r.Result.Watts, r.Result.WattHoursDay, r.Result.WattHoursPeriod = f.Watts, f.WattHoursDay, f.WattHoursPeriod
+			s := m.apply(&r)
+			shaded.Quantiles[q] = &quantileForecast{Watts: s.Result.Watts, WattHoursDay: s.Result.WattHoursDay, WattHoursPeriod: s.Result.WattHoursPeriod}
+		}
+	}
+	return &shaded
+}
@@ -0,0 +1,100 @@
+package main
+
+// batteryEfficiency is the round-trip efficiency of the simulated battery,
+// applied when charging
+const batteryEfficiency = 0.9
+
+// energyFlow is the result of a household simulation in watt hours
+type energyFlow struct {
+	Production float64
+	Load       float64
+	SelfUse    float64 // Production used directly by the household
+	Charged    float64 // Production stored in the battery
+	Discharged float64 // Load covered by the battery
+	Export     float64
+	Curtailed  float64 // Production lost to the export limit
+	Import     float64
+}
+
+// add sums up two simulation results
+func (f energyFlow) add(o energyFlow) energyFlow {
+	return energyFlow{
+		Production: f.Production + o.Production,
+		Load:       f.Load + o.Load,
+		SelfUse:    f.SelfUse + o.SelfUse,
+		Charged:    f.Charged + o.Charged,
+		Discharged: f.Discharged + o.Discharged,
+		Export:     f.Export + o.Export,
+		Curtailed:  f.Curtailed + o.Curtailed,
+		Import:     f.Import + o.Import,
+	}
+}
+
+// Autarky is the share of the load covered by production, directly or from
+// the battery
+func (f energyFlow) Autarky() float64 {
+	if f.Load == 0 {
+		return 0
+	}
+	return (f.Load - f.Import) / f.Load
+}
+
+// SelfConsumption is the share of the production used by the household,
+// directly or via the battery
+func (f energyFlow) SelfConsumption() float64 {
+	if f.Production == 0 {
+		return 0
+	}
+	return (f.SelfUse + f.Charged) / f.Production
+}
+
+// simulate runs an hourly simulation of production against load, both in
+// watt hours per hour. Surplus production charges a battery of the given
+// capacity in watt hours, which covers the load when production is short.
+// Surplus that can neither be stored nor exported within the export limit in
+// watts is curtailed.
+func simulate(production, load []float64, capacity, exportLimit float64) energyFlow {
+	var f energyFlow
+	var charge float64
+	for hour := range production {
+		pv, demand := production[hour], load[hour]
+
+		used := pv
+		if used > demand {
+			used = demand
+		}
+		surplus := pv - used
+		deficit := demand - used
+
+		stored := surplus
+		if space := (capacity - charge) / batteryEfficiency; stored > space {
+			stored = space
+		}
+		charge += stored * batteryEfficiency
+		surplus -= stored
+
+		discharged := deficit
+		if discharged > charge {
+			discharged = charge
+		}
+		charge -= discharged
+		deficit -= discharged
+
+		exported := surplus
+		if exported > exportLimit {
+			exported = exportLimit
+		}
+
+		f = f.add(energyFlow{
+			Production: pv,
+			Load:       demand,
+			SelfUse:    used,
+			Charged:    stored,
+			Discharged: discharged,
+			Export:     exported,
+			Curtailed:  surplus - exported,
+			Import:     deficit,
+		})
+	}
+	return f
+}
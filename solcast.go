@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type solcastResponse struct {
+	Forecasts []struct {
+		PvEstimate   float64   `json:"pv_estimate"`
+		PvEstimate10 float64   `json:"pv_estimate10"`
+		PvEstimate90 float64   `json:"pv_estimate90"`
+		PeriodEnd    time.Time `json:"period_end"`
+		Period       string    `json:"period"`
+	} `json:"forecasts"`
+}
+
+// solcast retrieves rooftop site forecasts from the Solcast API
+type solcast struct {
+	url    string
+	apiKey string
+
+	// Solcast only forecasts periods in the future, so the periods of today
+	// that already passed are kept from the previous forecast
+	previous *apiResponse
+}
+
+func newSolcast(resourceID, apiKey string) *solcast {
+	return &solcast{
+		url:    fmt.Sprintf("https://api.solcast.com.au/rooftop_sites/%s/forecasts?format=json&hours=48", resourceID),
+		apiKey: apiKey,
+	}
+}
+
+func (p *solcast) fetch(client *http.Client) (*apiResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+
+	forecast := &solcastResponse{}
+	if err := getJSON(client, req, forecast); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	res, err := forecast.convert(now)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.previous != nil {
+		today := now.Format(time.DateOnly)
+		for period, wh := range p.previous.Result.WattHoursPeriod {
+			if _, ok := res.Result.WattHoursPeriod[period]; ok {
+				continue
+			}
+			t, err := time.ParseInLocation(time.DateTime, period, res.location())
+			if err != nil || t.Add(-time.Second).Format(time.DateOnly) != today {
+				continue
+			}
+			res.Result.Watts[period] = p.previous.Result.Watts[period]
+			res.Result.WattHoursPeriod[period] = wh
+			res.Result.WattHoursDay[today] += wh
+			for q, f := range p.previous.Quantiles {
+				if current := res.Quantiles[q]; current != nil {
+					current.Watts[period] = f.Watts[period]
+					current.WattHoursPeriod[period] = f.WattHoursPeriod[period]
+					current.WattHoursDay[today] += f.WattHoursPeriod[period]
+				}
+			}
+		}
+	}
+	p.previous = res
+
+	return res, nil
+}
+
+// convert maps the forecasts for today and tomorrow to the format of the
+// forecast.solar API, using local time
+func (s *solcastResponse) convert(now time.Time) (*apiResponse, error) {
+	res := &apiResponse{}
+	res.Result.Watts = map[string]float64{}
+	res.Result.WattHoursDay = map[string]float64{}
+	res.Result.WattHoursPeriod = map[string]float64{}
+	// pv_estimate is the median, pv_estimate10 and pv_estimate90 are the
+	// 10th and 90th percentiles
+	res.Quantiles = map[string]*quantileForecast{
+		"0.1": newQuantileForecast(),
+		"0.5": newQuantileForecast(),
+		"0.9": newQuantileForecast(),
+	}
+
+	today := now.Format(time.DateOnly)
+	tomorrow := now.AddDate(0, 0, 1).Format(time.DateOnly)
+
+	for _, f := range s.Forecasts {
+		// Periods are ISO 8601 durations like PT30M
+		d, err := time.ParseDuration(strings.ToLower(strings.TrimPrefix(f.Period, "PT")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid period %q: %s", f.Period, err)
+		}
+
+		end := f.PeriodEnd.In(time.Local)
+		day := end.Add(-time.Second).Format(time.DateOnly)
+		if day != today && day != tomorrow {
+			continue
+		}
+
+		period := end.Format(time.DateTime)
+		watts := f.PvEstimate * 1000
+		res.Result.Watts[period] = watts
+		res.Result.WattHoursPeriod[period] = watts * d.Hours()
+		res.Result.WattHoursDay[day] += watts * d.Hours()
+		for q, kw := range map[string]float64{"0.1": f.PvEstimate10, "0.5": f.PvEstimate, "0.9": f.PvEstimate90} {
+			qf := res.Quantiles[q]
+			qf.Watts[period] = kw * 1000
+			qf.WattHoursPeriod[period] = kw * 1000 * d.Hours()
+			qf.WattHoursDay[day] += kw * 1000 * d.Hours()
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,794 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sourceOptions are the settings shared by all sources
+type sourceOptions struct {
+	readOnly      *atomic.Bool
+	cache         *cacheStore
+	history       *historyStore
+	outputs       *outputs
+	recordDir     string // Directory to record responses in for replays
+	audit         *auditLog
+	usage         *usageStore
+	quotaBehavior string
+	quotaAfter    int
+	backoffMax    time.Duration   // Upper bound of the delay between polls after errors
+	jitter        time.Duration   // Upper bound of the random delay added to polls
+	pollTimeout   time.Duration   // Deadline of a poll including all its requests, 0 for none
+	adaptive      bool            // Whether to derive the poll interval from the rate limit
+	gustThreshold float64         // Wind gust speed in m/s to warn from, 0 disables the warning
+	gustWindow    time.Duration   // How far ahead to look for gusts
+	account       *apiAccount     // Plan of the API key of forecast.solar, optional
+	expireAfter   int             // Consecutive failed polls after which forecasts expire, 0 never
+	peers         *peerGroup      // Exporters polling the same forecast.solar account, optional
+	requests      *requestGroup   // Coalesces polls of the same request by several sources, optional
+	limiter       *tokenBucket    // Client-side rate limit of forecast.solar, optional
+	leader        *leaderElection // Polls the APIs only while leading, optional
+}
+
+// source polls a provider and exports its forecasts
+type source struct {
+	name     string
+	provider provider
+	interval time.Duration
+	opts     *sourceOptions
+
+	// Polled instead of the provider while its quota is exhausted with the
+	// fallback behavior, optional
+	fallback provider
+
+	// History endpoint of paid plans backfilling the history once a day
+	// after successful polls, optional
+	historyEndpoint provider
+	historyPolled   time.Time
+
+	// Plan of the forecast.solar account, of -api-key or the API key of the
+	// site, optional
+	account *apiAccount
+
+	today          *forecastCollector
+	tomorrow       *forecastCollector
+	hourly         *hourlyForecast
+	revisions      *revisionCollector
+	info           *prometheus.GaugeVec
+	distance       *prometheus.GaugeVec
+	quotaExhausted prometheus.Gauge
+	maintenance    prometheus.Gauge
+
+	// Requests to the API and their estimated costs for paid plans
+	requestCost float64 // Per request, exported if set
+	apiRequests prometheus.Counter
+	notModified prometheus.Counter
+	apiCost     prometheus.Counter
+	costToday   dailyTotal
+	payload     *payloadStats
+
+	// Health of the poll loop
+	pollDuration  prometheus.Histogram
+	pollsInFlight prometheus.Gauge
+	schedulerLag  prometheus.Gauge
+	missedTicks   prometheus.Counter
+
+	// Last forecast of the previous day, not exported itself
+	previous *forecastCollector
+
+	// Forecast for today issued the day before, not exported itself
+	predicted *forecastCollector
+
+	// Forecast of the previous day frozen at local midnight
+	final *forecastCollector
+
+	// Last forecast as returned by the provider, served by /api/v1/forecast
+	forecast atomic.Pointer[apiResponse]
+
+	// Last forecast retrieved from the API by the source itself, served to
+	// peers
+	retrieved atomic.Pointer[peerForecast]
+
+	// Time of the last successful poll in Unix nanoseconds, the previous
+	// forecast keeps being served when a poll fails
+	lastSuccess atomic.Int64
+
+	// Time the last poll ended in Unix nanoseconds, successful or not, to
+	// detect hung poll loops
+	lastAttempt atomic.Int64
+
+	// Time of the last forecast pushed via webhook in Unix nanoseconds,
+	// polls are skipped while pushes arrive
+	lastPush atomic.Int64
+
+	// Serializes processing polled and pushed forecasts
+	handleMu sync.Mutex
+
+	// Incremented whenever the metrics of the source may have changed, so
+	// fleetGatherer knows when to gather them again
+	generation atomic.Uint64
+
+	// Number of consecutive 429 responses, only accessed by the poll loop
+	rateLimited int
+
+	// Number of consecutive failed polls, only accessed by the poll loop
+	failures int
+
+	// Number of consecutive failed polls as of the last poll, for the web UI
+	lastFailures atomic.Int64
+
+	// Whether the API rejected the last request with a client error, only
+	// accessed by the poll loop
+	rejected bool
+
+	// Whether the forecast is no longer exported after consecutive failed
+	// polls
+	expired atomic.Bool
+
+	// Delay until the next poll in nanoseconds, as backed off after errors
+	pollDelay atomic.Int64
+
+	// Location to pause polls at night at, nil to poll around the clock
+	nightLocation *planeGeometry
+
+	// Whether polls are paused for the night, only accessed by the poll loop
+	paused bool
+
+	// Number of sources sharing the rate limit of the API of the source
+	rateLimitShare atomic.Int64
+
+	// Delay between polls within the rate limit reported by the API, and
+	// whether a too short poll interval was warned about. Only accessed by
+	// the poll loop.
+	budgetDelay  time.Duration
+	budgetWarned bool
+
+	// Whether the API announced maintenance and its end if known, only
+	// accessed by the poll loop
+	inMaintenance    bool
+	maintenanceUntil time.Time
+
+	// When the client-side rate limit allows the next request after a
+	// skipped poll, only accessed by the poll loop
+	limitedUntil time.Time
+
+	// Share of the plane in the forecasts of the planes of its site polled
+	// in a single request, nil if polled alone
+	share *planeShare
+
+	// Shade of obstructions applied to the forecasts, nil if unshaded
+	shading *shadingMask
+
+	// Factor applied to the forecasts, e.g. for aging panels, optional
+	derating float64
+}
+
+func newSource(name string, p provider, interval time.Duration, opts *sourceOptions) *source {
+	return &source{
+		name:     name,
+		provider: p,
+		interval: interval,
+		opts:     opts,
+		account:  opts.account,
+		today: &forecastCollector{
+			metric: prometheus.NewDesc(
+				"forecast_solar_today",
+				"Solar harvest forecast for today",
+				nil,
+				nil,
+			),
+		},
+		tomorrow: &forecastCollector{
+			metric: prometheus.NewDesc(
+				"forecast_solar_tomorrow",
+				"Solar harvest forecast for tomorrow",
+				nil,
+				nil,
+			),
+		},
+		hourly:    &hourlyForecast{},
+		revisions: newRevisionCollector(),
+		previous:  &forecastCollector{},
+		predicted: &forecastCollector{},
+		final: &forecastCollector{
+			metric: prometheus.NewDesc(
+				"forecast_solar_today_final",
+				"Final solar harvest forecast of the previous day, frozen at local midnight",
+				nil,
+				nil,
+			),
+		},
+		info: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "forecast_solar_info",
+			Help: "Location the configured coordinates resolve to, as reported by the API",
+		}, []string{"place", "timezone"}),
+		distance: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "forecast_solar_location_distance_kilometers",
+			Help: "Distance between the configured coordinates and the location used by the API",
+		}, nil),
+		quotaExhausted: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "forecast_solar_quota_exhausted",
+			Help:        "Whether the API quota is exhausted and the configured behavior is active",
+			ConstLabels: prometheus.Labels{"behavior": opts.quotaBehavior},
+		}),
+		maintenance: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "forecast_solar_upstream_maintenance",
+			Help: "Whether the API announced maintenance on the last poll",
+		}),
+		apiRequests: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "forecast_solar_api_requests_total",
+			Help: "Number of requests to the API, regardless of their outcome",
+		}),
+		notModified: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "forecast_solar_api_not_modified_total",
+			Help: "Number of polls the API answered with 304 Not Modified, keeping the previous forecast",
+		}),
+		apiCost: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "forecast_solar_api_cost_total",
+			Help: "Estimated cost of the requests to the API, in the unit of -api.request-cost",
+		}),
+		payload: newPayloadStats(),
+		pollDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "forecast_solar_poll_duration_seconds",
+			Help:    "Duration of polls including decoding and updating the metrics",
+			Buckets: []float64{.1, .25, .5, 1, 2.5, 5, 10, 30},
+		}),
+		pollsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "forecast_solar_polls_in_flight",
+			Help: "Number of polls currently running",
+		}),
+		schedulerLag: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "forecast_solar_poll_scheduler_lag_seconds",
+			Help: "Delay of the last poll past its scheduled time",
+		}),
+		missedTicks: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "forecast_solar_poll_missed_ticks_total",
+			Help: "Number of polls skipped because the poll loop fell behind by more than one interval",
+		}),
+	}
+}
+
+// register registers the metrics of the source, except for the data age
+func (s *source) register(reg prometheus.Registerer) {
+	forecasts := s.expiring(reg)
+	forecasts.MustRegister(s.today, s.tomorrow)
+	reg.MustRegister(s.final, s.info, s.distance, s.quotaExhausted, s.maintenance)
+	reg.MustRegister(s.pollDuration, s.pollsInFlight, s.schedulerLag, s.missedTicks)
+	forecasts.MustRegister(newWeatherCollector(s, s.opts.gustThreshold, s.opts.gustWindow))
+	forecasts.MustRegister(newPeakCollector(s), newProductionCollector(s), s.revisions)
+	forecasts.MustRegister(newDayDriftCollector(s))
+	reg.MustRegister(s.apiRequests, s.notModified, s.payload.size, s.payload.decode)
+	if s.requestCost > 0 {
+		reg.MustRegister(s.apiCost)
+		reg.MustRegister(prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Name: "forecast_solar_api_cost_today",
+				Help: "Estimated cost of the requests to the API today, in the unit of -api.request-cost",
+			},
+			func() float64 {
+				return s.costToday.get(clock())
+			},
+		))
+	}
+}
+
+// registerDataAge registers the age of the served forecast. Unlike the other
+// metrics, it changes on every scrape.
+func (s *source) registerDataAge(reg prometheus.Registerer) {
+	reg.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "forecast_solar_data_age_seconds",
+			Help: "Seconds since the served forecast was retrieved, NaN if no forecast was retrieved yet",
+		},
+		func() float64 {
+			last := s.lastSuccess.Load()
+			if last == 0 {
+				return math.NaN()
+			}
+			return clock().Sub(time.Unix(0, last)).Seconds()
+		},
+	))
+}
+
+// update updates the metrics from a forecast
+func (s *source) update(res *apiResponse) error {
+	if res.Message.Info.Place != "" {
+		s.info.Reset()
+		s.info.WithLabelValues(res.Message.Info.Place, res.Message.Info.Timezone).Set(1)
+		s.distance.WithLabelValues().Set(res.Message.Info.Distance)
+	}
+
+	// Forecasts of paid plans cover more days than today and tomorrow
+	days := forecastDays(res)
+	for i, date := range days {
+		t, err := time.Parse(time.DateOnly, date)
+		if err != nil {
+			return fmt.Errorf("invalid date %q: %s", date, err)
+		}
+		kwh := res.Result.WattHoursDay[date]
+
+		if i == 0 {
+			if date, wh := s.today.get(); !date.IsZero() && date.Before(t) {
+				s.previous.set(date, wh)
+			}
+			// The forecast for tomorrow became the one for today
+			if date, wh := s.tomorrow.get(); date.Equal(t) {
+				s.predicted.set(date, wh)
+				if s.opts.cache != nil {
+					s.opts.cache.setPredicted(s.name, date, wh)
+				}
+			}
+			s.today.set(t, kwh)
+		} else {
+			s.tomorrow.set(t, kwh)
+		}
+	}
+
+	if err := s.hourly.update(res.Result.WattHoursPeriod, days, res.location()); err != nil {
+		return err
+	}
+	s.revisions.update(res)
+
+	s.forecast.Store(res)
+	s.changed()
+
+	if s.opts.history != nil && len(days) > 0 {
+		if err := s.opts.history.record(s.name, days[0], res); err != nil {
+			log.Printf("Error recording history of %s: %s", s.name, err)
+		}
+	}
+
+	return nil
+}
+
+// loadCache populates the metrics from the cache, returning how long to hold
+// off polling if the cached forecast is recent to avoid burst re-polling on
+// restarts
+func (s *source) loadCache() time.Duration {
+	if s.opts.cache == nil {
+		return 0
+	}
+	if day, ok := s.opts.cache.predicted(s.name); ok {
+		s.predicted.set(day.Date, day.WattHours)
+	}
+	entry, ok := s.opts.cache.get(s.name)
+	if !ok {
+		return 0
+	}
+
+	if err := s.update(entry.Forecast); err != nil {
+		log.Printf("Error loading cache of %s: %s", s.name, err)
+		return 0
+	}
+	s.lastSuccess.Store(entry.Time.UnixNano())
+
+	age := time.Since(entry.Time)
+	log.Printf("Loaded forecast of %s from cache, retrieved %s ago", s.name, age.Round(time.Second))
+	return s.interval - age
+}
+
+// observedPoll polls the provider, recording the duration of the poll. Its
+// requests are aborted once ctx is cancelled or the poll times out.
+func (s *source) observedPoll(ctx context.Context, client *http.Client) {
+	client, cancel := s.pollClient(ctx, client)
+	defer cancel()
+
+	s.pollsInFlight.Inc()
+	s.changed()
+	defer s.changed()
+	defer s.pollsInFlight.Dec()
+
+	start := time.Now()
+	s.poll(client)
+	s.pollDuration.Observe(time.Since(start).Seconds())
+	s.lastFailures.Store(int64(s.failures))
+	s.lastAttempt.Store(time.Now().UnixNano())
+}
+
+// nextDelay returns the delay until the next poll: the poll interval, or
+// after consecutive errors an exponentially growing delay with jitter, capped
+// at the configured maximum, and at least until the end of maintenance
+// announced by the API. The configured poll jitter is added to all of them.
+func (s *source) nextDelay() time.Duration {
+	delay := s.interval
+	if s.opts.adaptive && s.budgetDelay > 0 {
+		delay = s.budgetDelay
+	}
+	if s.failures > 0 {
+		backoff := delay
+		for i := 0; i < s.failures && backoff < s.opts.backoffMax; i++ {
+			backoff *= 2
+		}
+		if backoff > s.opts.backoffMax {
+			backoff = s.opts.backoffMax
+		}
+		// Spread retries of sources failing at once over the upper half
+		if backoff > 0 {
+			backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		}
+		if backoff > delay {
+			delay = backoff
+		}
+	}
+	if s.rejected && s.opts.backoffMax > delay {
+		delay = s.opts.backoffMax
+	}
+	if until := s.limitedUntil.Sub(clock()); until > delay {
+		delay = until
+	}
+	if until := s.maintenanceUntil.Sub(clock()); until > delay {
+		delay = until
+	}
+	delay += s.jitter()
+	s.pollDelay.Store(int64(delay))
+	return delay
+}
+
+// jitter returns a random delay up to the configured poll jitter, so many
+// exporters started at once don't poll the API in the same second
+func (s *source) jitter() time.Duration {
+	if s.opts.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(s.opts.jitter)))
+}
+
+// night returns whether polls are paused for the night as the sun is down at
+// the location of the source, the forecast doesn't change until sunrise. The
+// first forecast is always retrieved.
+func (s *source) night(t time.Time) bool {
+	if s.nightLocation == nil || s.lastSuccess.Load() == 0 {
+		return false
+	}
+	elevation, _ := solarPosition(t, s.nightLocation.latitude, s.nightLocation.longitude)
+	// Sunrise and sunset are when the upper limb of the refracted sun touches
+	// the horizon
+	return elevation < -0.833
+}
+
+// changed marks the metrics of the source as changed
+func (s *source) changed() {
+	s.generation.Add(1)
+}
+
+func (s *source) poll(client *http.Client) {
+	if s.opts.readOnly.Load() {
+		log.Printf("Read-only mode enabled, skipping poll of %s", s.name)
+		return
+	}
+	if last := s.lastPush.Load(); last != 0 && clock().Sub(time.Unix(0, last)) < s.interval {
+		log.Printf("Forecast of %s was pushed recently, skipping poll", s.name)
+		return
+	}
+	if s.night(clock()) {
+		if !s.paused {
+			log.Printf("Sun is down at %s, pausing polls until sunrise", s.name)
+			s.paused = true
+		}
+		return
+	}
+	s.paused = false
+	defer s.checkExpiry()
+
+	res, err := s.fetch(client)
+
+	// Polls skipped by the client-side rate limit are no failures, the next
+	// poll waits for it instead
+	var limitErr *clientLimitError
+	if errors.As(err, &limitErr) {
+		if s.limitedUntil.IsZero() {
+			log.Printf("Skipping poll of %s: %s", s.name, err)
+		}
+		s.limitedUntil = clock().Add(limitErr.wait)
+		return
+	}
+	s.limitedUntil = time.Time{}
+	if errors.Is(err, errStandby) {
+		debugf("Skipping poll of %s: %s", s.name, err)
+		return
+	}
+
+	var statusErr *statusError
+	rateLimited := errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusTooManyRequests
+	notModified := errors.Is(err, errNotModified)
+
+	if err != nil && !notModified {
+		s.failures++
+	}
+
+	// Client errors such as 422 for invalid parameters won't go away by
+	// retrying, so the source retries rarely instead of using up the rate
+	// limit shared with the other sources
+	rejected := errors.As(err, &statusErr) && statusErr.StatusCode >= 400 && statusErr.StatusCode < 500 &&
+		!rateLimited && statusErr.StatusCode != http.StatusRequestTimeout
+	if rejected && !s.rejected {
+		log.Printf("API rejected the request of %s, check its parameters, retrying every %s", s.name, s.opts.backoffMax)
+	}
+	s.rejected = rejected
+
+	// Maintenance is expected, back off until its announced end without
+	// logging it as error on every poll
+	var maintErr *maintenanceError
+	if errors.As(err, &maintErr) {
+		if !s.inMaintenance {
+			if maintErr.Until.IsZero() {
+				log.Printf("API of %s is under maintenance, backing off: %s", s.name, maintErr.Text)
+			} else {
+				log.Printf("API of %s is under maintenance until %s, pausing polls: %s", s.name, maintErr.Until.Format(time.RFC3339), maintErr.Text)
+			}
+		}
+		s.inMaintenance = true
+		s.maintenanceUntil = maintErr.Until
+		s.maintenance.Set(1)
+		return
+	}
+	if s.inMaintenance {
+		log.Printf("Maintenance of the API of %s is over", s.name)
+		s.inMaintenance = false
+		s.maintenanceUntil = time.Time{}
+		s.maintenance.Set(0)
+	}
+
+	if rateLimited {
+		s.rateLimited++
+		log.Printf("Error polling %s: %s (%d consecutive)", s.name, err, s.rateLimited)
+		if s.rateLimited >= s.opts.quotaAfter {
+			if s.rateLimited == s.opts.quotaAfter {
+				log.Printf("API quota of %s exhausted, switching to %s behavior", s.name, s.opts.quotaBehavior)
+			}
+			s.quotaExhausted.Set(1)
+			if s.opts.quotaBehavior == "read-only" && !s.opts.readOnly.Swap(true) && s.opts.audit != nil {
+				if err := s.opts.audit.record(nil, "read-only", "true, API quota of "+s.name+" exhausted"); err != nil {
+					log.Printf("Error writing audit log: %s", err)
+				}
+			}
+			if s.opts.quotaBehavior == "fallback" && s.fallback != nil {
+				s.pollFallback(client)
+			}
+		}
+		return
+	}
+
+	if err != nil && !notModified {
+		log.Printf("Error polling %s: %s (%d consecutive)", s.name, err, s.failures)
+		return
+	}
+
+	if s.rateLimited >= s.opts.quotaAfter {
+		log.Printf("API quota of %s available again", s.name)
+	}
+	s.rateLimited = 0
+	s.quotaExhausted.Set(0)
+
+	// The served forecast is still current
+	if notModified {
+		s.notModified.Inc()
+		s.lastSuccess.Store(clock().UnixNano())
+		s.failures = 0
+		return
+	}
+
+	if s.opts.recordDir != "" {
+		if err := recordResponse(s.opts.recordDir, s.name, res); err != nil {
+			log.Printf("Error recording response of %s: %s", s.name, err)
+		}
+	}
+	if res.Message.RateLimit != nil {
+		s.adapt(res.Message.RateLimit)
+		if s.account != nil {
+			s.account.update(res.Message.RateLimit)
+		}
+	}
+	if !s.handle(res) {
+		// Retrieve the forecast again even if it didn't change
+		if v, ok := s.provider.(interface{ reset() }); ok {
+			v.reset()
+		}
+		s.failures++
+		return
+	}
+	if s.failures > 0 {
+		log.Printf("Polling %s succeeded again after %d failures", s.name, s.failures)
+	}
+	s.failures = 0
+	s.pollHistory(client)
+}
+
+// pollFallback retrieves the forecast from the fallback provider while the
+// quota of the provider is exhausted. Polls continue every poll interval
+// instead of backing off, so the provider is polled again as soon as its
+// quota is reset.
+func (s *source) pollFallback(client *http.Client) {
+	res, err := s.fallback.fetch(s.payload.client(client))
+	if err != nil {
+		log.Printf("Error polling fallback of %s: %s", s.name, err)
+		return
+	}
+	if s.handle(res) {
+		s.failures = 0
+	}
+	// Retrieve the forecast of the provider again once available, even if
+	// it didn't change
+	if v, ok := s.provider.(interface{ reset() }); ok {
+		v.reset()
+	}
+}
+
+// pollHistory backfills the history from the history endpoint once a day.
+// It runs within polls, so it is skipped in read-only mode and while the
+// quota is exhausted, and its request counts against the client-side rate
+// limit.
+func (s *source) pollHistory(client *http.Client) {
+	if s.historyEndpoint == nil || s.opts.history == nil || clock().Sub(s.historyPolled) < 24*time.Hour {
+		return
+	}
+	if s.opts.limiter != nil && !ownAccount(s) {
+		if ok, _ := s.opts.limiter.take(clock()); !ok {
+			return
+		}
+	}
+	s.historyPolled = clock()
+
+	res, err := s.historyEndpoint.fetch(s.payload.client(client))
+	s.apiRequests.Inc()
+	s.apiCost.Add(s.requestCost)
+	s.costToday.add(clock(), s.requestCost)
+	if err != nil {
+		log.Printf("Error retrieving forecast history of %s: %s", s.name, err)
+		return
+	}
+	if added, err := s.opts.history.backfill(s.name, res.Result.WattHoursDay); err != nil {
+		log.Printf("Error recording forecast history of %s: %s", s.name, err)
+	} else if added > 0 {
+		log.Printf("Backfilled %d days of forecast history of %s", added, s.name)
+	}
+}
+
+// fetch retrieves the forecast from the provider, accounting the request
+func (s *source) fetch(client *http.Client) (*apiResponse, error) {
+	// A peer polling the same request recently saves a request to the API.
+	// Peers retrieve them within half of the poll interval, so forecasts
+	// are at most one and a half poll intervals old.
+	key := requestKey(s.provider)
+	if s.opts.requests != nil && key != "" {
+		defer s.opts.requests.lock(key)()
+		if res := s.opts.requests.lookup(key, s.interval/2); res != nil {
+			return res, nil
+		}
+	}
+	peerKey := key
+	if s.opts.peers != nil {
+		peerKey = s.opts.peers.key(s.provider)
+	}
+	if s.opts.peers != nil && peerKey != "" {
+		if res := s.opts.peers.lookup(peerKey, s.interval/2); res != nil {
+			return res, nil
+		}
+	}
+
+	// Standby replicas only take the forecasts of the leader from the peers
+	if s.opts.leader != nil && !s.opts.leader.leading() {
+		return nil, errStandby
+	}
+
+	if _, ok := s.provider.(*forecastSolar); ok && s.opts.limiter != nil && !ownAccount(s) {
+		if ok, wait := s.opts.limiter.take(clock()); !ok {
+			return nil, &clientLimitError{wait: wait}
+		}
+	}
+
+	res, err := s.provider.fetch(s.payload.client(client))
+	if key != "" || peerKey != "" {
+		if err == nil {
+			s.retrieved.Store(&peerForecast{Time: clock(), Forecast: res})
+		} else if prev := s.retrieved.Load(); errors.Is(err, errNotModified) && prev != nil {
+			s.retrieved.Store(&peerForecast{Time: clock(), Forecast: prev.Forecast})
+		}
+		if f := s.retrieved.Load(); s.opts.requests != nil && key != "" && f != nil && (err == nil || errors.Is(err, errNotModified)) {
+			s.opts.requests.record(key, f)
+		}
+	}
+	s.apiRequests.Inc()
+	s.apiCost.Add(s.requestCost)
+	s.costToday.add(clock(), s.requestCost)
+
+	var statusErr *statusError
+	rateLimited := errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusTooManyRequests
+	s.recordUsage(func(r *usageRecord) {
+		r.Requests++
+		r.Cost += s.requestCost
+		if rateLimited {
+			r.RateLimited++
+		}
+	})
+	return res, err
+}
+
+// adapt derives the delay between polls from the rate limit reported by the
+// API, warning once if the poll interval exceeds it
+func (s *source) adapt(l *rateLimit) {
+	sharing := int(s.rateLimitShare.Load())
+	// Replicas poll the same requests once among them
+	if s.opts.peers != nil && !s.opts.peers.replicas && !ownAccount(s) {
+		sharing += s.opts.peers.remoteSources()
+	}
+	if sharing < 1 {
+		sharing = 1
+	}
+
+	delay := l.delay(sharing)
+	if s.opts.adaptive && s.budgetDelay == 0 {
+		log.Printf("Polling %s every %s to stay within the rate limit of %d requests per %ds, %d remaining",
+			s.name, delay.Round(time.Second), l.Limit, l.Period, l.Remaining)
+	}
+	s.budgetDelay = delay
+
+	if even := l.evenDelay(sharing); !s.opts.adaptive && !s.budgetWarned && s.interval < even {
+		log.Printf("Warning: polling %d sources every %s exceeds the rate limit of %d requests per %ds, use a poll interval of at least %s or -poll-adaptive",
+			sharing, s.interval, l.Limit, l.Period, even.Round(time.Second))
+		s.budgetWarned = true
+	}
+}
+
+// handle processes a retrieved forecast: updates the metrics, publishes it
+// to the outputs and caches it. It returns false if the forecast is invalid.
+func (s *source) handle(res *apiResponse) bool {
+	s.handleMu.Lock()
+	defer s.handleMu.Unlock()
+
+	if s.share != nil {
+		res = s.share.apply(res)
+	}
+	if s.shading != nil {
+		res = s.shading.apply(res)
+	}
+	if s.derating != 0 && s.derating != 1 {
+		res = derate(res, s.derating)
+	}
+	if err := s.update(res); err != nil {
+		log.Printf("Error updating forecast of %s: %s", s.name, err)
+		return false
+	}
+
+	if datapoints := s.opts.outputs.write(s.name, res); datapoints > 0 {
+		s.recordUsage(func(r *usageRecord) { r.Datapoints += datapoints })
+	}
+
+	if s.opts.cache != nil {
+		if err := s.opts.cache.put(s.name, res); err != nil {
+			log.Printf("Error writing cache: %s", err)
+		}
+	}
+
+	s.lastSuccess.Store(clock().UnixNano())
+	return true
+}
+
+// push processes a forecast pushed via webhook instead of polled
+func (s *source) push(res *apiResponse) bool {
+	if !s.handle(res) {
+		return false
+	}
+	s.lastPush.Store(clock().UnixNano())
+	return true
+}
+
+// recordUsage updates the usage of the source in the current month
+func (s *source) recordUsage(update func(r *usageRecord)) {
+	if s.opts.usage == nil {
+		return
+	}
+	if err := s.opts.usage.add(s.name, update); err != nil {
+		log.Printf("Error writing usage: %s", err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// statsdWriter sends the forecasts for today and tomorrow and the current
+// forecasted power of every source as StatsD gauges in an interval, e.g. to
+// the Datadog agent. With DogStatsD tags, the source is sent as tag instead of
+// being part of the metric name.
+type statsdWriter struct {
+	address string
+	prefix  string // Including the trailing dot, if any
+	tags    bool   // DogStatsD tags
+	sources *sourceSet
+}
+
+// statsdEscaper replaces the characters of the StatsD protocol in names and
+// tags
+var statsdEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", ",", "_", "#", "_", " ", "_", "\n", "_")
+
+// push sends a packet per source, returning the number of gauges sent
+func (w *statsdWriter) push(ctx context.Context) (int, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", w.address)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	n := 0
+	now := clock()
+	for _, s := range w.sources.all() {
+		res := s.forecast.Load()
+		if res == nil {
+			continue
+		}
+
+		var packet bytes.Buffer
+		gauge := func(name string, value float64) {
+			if w.tags {
+				fmt.Fprintf(&packet, "%s%s:%s|g|#source:%s\n", w.prefix, name, strconv.FormatFloat(value, 'f', -1, 64), statsdEscaper.Replace(s.name))
+			} else {
+				fmt.Fprintf(&packet, "%s%s.%s:%s|g\n", w.prefix, statsdEscaper.Replace(graphiteEscaper.Replace(s.name)), name, strconv.FormatFloat(value, 'f', -1, 64))
+			}
+			n++
+		}
+		if date, wh := s.today.get(); !date.IsZero() {
+			gauge("today_kwh", wh/1000)
+		}
+		if date, wh := s.tomorrow.get(); !date.IsZero() {
+			gauge("tomorrow_kwh", wh/1000)
+		}
+		if watts, ok := newPowerCurve(res.Result.Watts, res.location()).at(now); ok {
+			gauge("power_watts", watts)
+		}
+
+		if _, err := conn.Write(bytes.TrimSuffix(packet.Bytes(), []byte("\n"))); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
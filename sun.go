@@ -0,0 +1,83 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sunCollector exports the time until the next sunrise, sunset and solar noon
+// and the day length and solar noon of today at a location, computed when
+// collected
+type sunCollector struct {
+	plane     planeGeometry
+	until     *prometheus.Desc
+	dayLength *prometheus.Desc
+	noon      *prometheus.Desc
+}
+
+func newSunCollector(plane planeGeometry) *sunCollector {
+	return &sunCollector{
+		plane: plane,
+		until: prometheus.NewDesc(
+			"forecast_solar_sun_event_seconds",
+			"Seconds until the next sunrise, sunset and solar noon, computed locally",
+			[]string{"event"},
+			nil,
+		),
+		dayLength: prometheus.NewDesc(
+			"forecast_solar_day_length_seconds",
+			"Time between sunrise and sunset today, computed locally",
+			nil,
+			nil,
+		),
+		noon: prometheus.NewDesc(
+			"forecast_solar_solar_noon_timestamp_seconds",
+			"Time of solar noon today, computed locally",
+			nil,
+			nil,
+		),
+	}
+}
+
+func (c *sunCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.until
+	ch <- c.dayLength
+	ch <- c.noon
+}
+
+func (c *sunCollector) Collect(ch chan<- prometheus.Metric) {
+	now := clock()
+
+	noon, sunrise, sunset, polar := sunEvents(now, c.plane.latitude, c.plane.longitude)
+	dayLength := sunset.Sub(sunrise).Seconds()
+	if polar {
+		// The sun is either up or down all day
+		dayLength = 0
+		if elevation, _ := solarPosition(noon, c.plane.latitude, c.plane.longitude); elevation > 0 {
+			dayLength = (24 * time.Hour).Seconds()
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(c.dayLength, prometheus.GaugeValue, dayLength)
+	ch <- prometheus.MustNewConstMetric(c.noon, prometheus.GaugeValue, float64(noon.UnixNano())/1e9)
+
+	// The next event is today or tomorrow, or within a few days at the end
+	// of polar day and night
+	next := map[string]time.Time{}
+	for d := 0; d < 3 || (len(next) < 3 && d < 183); d++ {
+		noon, sunrise, sunset, polar := sunEvents(now.AddDate(0, 0, d-1), c.plane.latitude, c.plane.longitude)
+		events := map[string]time.Time{"solar_noon": noon}
+		if !polar {
+			events["sunrise"], events["sunset"] = sunrise, sunset
+		}
+		for event, t := range events {
+			if _, ok := next[event]; !ok && t.After(now) {
+				next[event] = t
+			}
+		}
+	}
+
+	for _, event := range sortedKeys(next) {
+		ch <- prometheus.MustNewConstMetric(c.until, prometheus.GaugeValue, next[event].Sub(now).Seconds(), event)
+	}
+}
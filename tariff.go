@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// tariffWindow is a daily time window of a tariff, e.g. the night tariff or
+// peak pricing, shown in the web UI
+type tariffWindow struct {
+	name       string
+	start, end int // Minutes after midnight, windows can wrap around midnight
+}
+
+// parseTariffWindows parses windows given as name=HH:MM-HH:MM, ordered by name
+func parseTariffWindows(windows map[string]string) ([]tariffWindow, error) {
+	var result []tariffWindow
+	for name, window := range windows {
+		from, to, found := strings.Cut(window, "-")
+		if !found {
+			return nil, fmt.Errorf("invalid window %q of %s, expected HH:MM-HH:MM", window, name)
+		}
+		start, err := parseClock(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start of %s: %s", name, err)
+		}
+		end, err := parseClock(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid end of %s: %s", name, err)
+		}
+		result = append(result, tariffWindow{name: name, start: start, end: end})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].name < result[j].name })
+	return result, nil
+}
+
+// parseClock parses a time of day as minutes after midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// contains returns whether the time of day of t is within the window
+func (w tariffWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// tariffSchedule is a price per kWh, flat or overridden during tariff windows
+// for time-of-use tariffs
+type tariffSchedule struct {
+	flat    float64
+	windows []tariffWindow
+	prices  map[string]float64 // By window name
+}
+
+// parseTariffSchedule parses the prices of tariff windows given as
+// name=price, which must be defined by -tariff-window
+func parseTariffSchedule(flat float64, prices map[string]string, windows []tariffWindow) (tariffSchedule, error) {
+	if flat < 0 {
+		return tariffSchedule{}, fmt.Errorf("invalid price %g", flat)
+	}
+	schedule := tariffSchedule{flat: flat, prices: map[string]float64{}}
+	for name, price := range prices {
+		v, err := strconv.ParseFloat(price, 64)
+		if err != nil || v < 0 {
+			return tariffSchedule{}, fmt.Errorf("invalid price of %s: %s", name, price)
+		}
+		found := false
+		for _, w := range windows {
+			if w.name == name {
+				schedule.windows = append(schedule.windows, w)
+				found = true
+			}
+		}
+		if !found {
+			return tariffSchedule{}, fmt.Errorf("unknown tariff window %s", name)
+		}
+		schedule.prices[name] = v
+	}
+	sort.Slice(schedule.windows, func(i, j int) bool { return schedule.windows[i].name < schedule.windows[j].name })
+	return schedule, nil
+}
+
+// enabled returns whether any price is set
+func (s tariffSchedule) enabled() bool {
+	return s.flat > 0 || len(s.prices) > 0
+}
+
+// at returns the price at the time of day of t, of the first window by name
+// containing it or the flat price
+func (s tariffSchedule) at(t time.Time) float64 {
+	for _, w := range s.windows {
+		if w.contains(t) {
+			return s.prices[w.name]
+		}
+	}
+	return s.flat
+}
+
+// tariffCollector exports the forecasted savings of the energy used by the
+// household instead of bought and the revenue of the energy exported to the
+// grid, given the load profile and the export limit. Prices apply by the
+// hour, at its middle.
+type tariffCollector struct {
+	forecast    *hourlyForecast
+	load        [24]float64 // Watts by hour of the day
+	exportLimit float64     // Watts, negative for no limit
+	price       tariffSchedule
+	feedIn      tariffSchedule
+
+	savings *prometheus.Desc
+	revenue *prometheus.Desc
+}
+
+func newTariffCollector(forecast *hourlyForecast, load [24]float64, exportLimit float64, price, feedIn tariffSchedule, currency string) *tariffCollector {
+	labels := prometheus.Labels{"currency": currency}
+	return &tariffCollector{
+		forecast:    forecast,
+		load:        load,
+		exportLimit: exportLimit,
+		price:       price,
+		feedIn:      feedIn,
+		savings: prometheus.NewDesc(
+			"forecast_solar_self_use_savings",
+			"Forecasted savings of the energy used by the household instead of bought, given the load profile",
+			[]string{"day"},
+			labels,
+		),
+		revenue: prometheus.NewDesc(
+			"forecast_solar_feed_in_revenue",
+			"Forecasted revenue of the energy exported to the grid, given the load profile and export limit",
+			[]string{"day"},
+			labels,
+		),
+	}
+}
+
+func (c *tariffCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.savings
+	ch <- c.revenue
+}
+
+func (c *tariffCollector) Collect(ch chan<- prometheus.Metric) {
+	c.forecast.mu.Lock()
+	defer c.forecast.mu.Unlock()
+
+	limit := c.exportLimit
+	if limit < 0 {
+		limit = math.Inf(1)
+	}
+	for day, hours := range c.forecast.energy {
+		var savings, revenue float64
+		for hour, wh := range hours {
+			f := simulate([]float64{wh}, c.load[hour:hour+1], 0, limit)
+			t := time.Date(2000, 1, 1, hour, 30, 0, 0, time.Local)
+			savings += f.SelfUse / 1000 * c.price.at(t)
+			revenue += f.Export / 1000 * c.feedIn.at(t)
+		}
+		if c.price.enabled() {
+			ch <- prometheus.MustNewConstMetric(c.savings, prometheus.GaugeValue, savings, day)
+		}
+		if c.feedIn.enabled() {
+			ch <- prometheus.MustNewConstMetric(c.revenue, prometheus.GaugeValue, revenue, day)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// tenantKey is the context key of the site a request is restricted to
+type tenantKey struct{}
+
+// tenantGuard restricts the JSON API in fleet mode to the site of the token
+// presented, so customers can read their own forecasts. The admin token
+// reveals all sites.
+type tenantGuard struct {
+	adminToken string
+
+	mu     sync.RWMutex
+	tokens map[string]string // Site by token
+}
+
+// newTenantGuard returns a guard for the API tokens of the sites. The API is
+// open as long as no site has a token.
+func newTenantGuard(sites []site, adminToken string) *tenantGuard {
+	g := &tenantGuard{adminToken: adminToken}
+	g.update(sites)
+	return g
+}
+
+// update replaces the tokens by the ones of the sites, e.g. after reloading
+// the fleet
+func (g *tenantGuard) update(sites []site) {
+	tokens := map[string]string{}
+	for _, s := range sites {
+		if s.APIToken != "" {
+			tokens[s.APIToken] = s.Name
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.tokens = tokens
+}
+
+// validateTenants returns an error if sites have API tokens without the admin
+// token, which the endpoints covering all sites then require
+func validateTenants(sites []site, adminToken string) error {
+	if adminToken != "" {
+		return nil
+	}
+	for _, s := range sites {
+		if s.APIToken != "" {
+			return fmt.Errorf("api_token of site %s requires -web.admin-token", s.Name)
+		}
+	}
+	return nil
+}
+
+// wrap requires a token for the handler if any site has one, passing the site
+// of a site token on in the request context. Handlers of all sites only accept
+// the admin token. Browsers send the token as password of basic
+// authentication.
+func (g *tenantGuard) wrap(h http.Handler, allSites bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.mu.RLock()
+		tokens := g.tokens
+		g.mu.RUnlock()
+		if len(tokens) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found {
+			_, token, _ = r.BasicAuth()
+		}
+		if g.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(g.adminToken)) == 1 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		for t, site := range tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 && !allSites {
+				h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, site)))
+				return
+			}
+		}
+		log.Printf("Denied %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		w.Header().Add("WWW-Authenticate", "Bearer")
+		w.Header().Add("WWW-Authenticate", `Basic realm="forecast_solar_exporter"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// visible returns whether the data of a source may be revealed to the
+// client of the request, whose token may restrict it to a site
+func visible(r *http.Request, source string) bool {
+	site, ok := tenantSite(r)
+	if !ok {
+		return true
+	}
+	_, sourceSite, _ := splitSourceName(source)
+	return sourceSite == site
+}
+
+// tenantSite returns the site the request is restricted to by a site token
+func tenantSite(r *http.Request) (string, bool) {
+	site, ok := r.Context().Value(tenantKey{}).(string)
+	return site, ok
+}
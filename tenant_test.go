@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTenantGuard(t *testing.T) {
+	sites := []site{
+		{Name: "alice", APIToken: "alice-token"},
+		{Name: "bob", APIToken: "bob-token"},
+		{Name: "carol"},
+	}
+	tests := []struct {
+		name     string
+		auth     string
+		token    string
+		allSites bool
+		want     int
+		site     string // Site the request is restricted to, if any
+	}{
+		{"admin bearer", "bearer", "admin-token", false, http.StatusOK, ""},
+		{"admin basic", "basic", "admin-token", false, http.StatusOK, ""},
+		{"admin all sites", "bearer", "admin-token", true, http.StatusOK, ""},
+		{"site bearer", "bearer", "alice-token", false, http.StatusOK, "alice"},
+		{"site basic", "basic", "bob-token", false, http.StatusOK, "bob"},
+		{"site on all sites", "bearer", "alice-token", true, http.StatusUnauthorized, ""},
+		{"wrong token", "bearer", "mallory-token", false, http.StatusUnauthorized, ""},
+		{"missing token", "", "", false, http.StatusUnauthorized, ""},
+		{"empty token", "bearer", "", false, http.StatusUnauthorized, ""},
+	}
+	g := newTenantGuard(sites, "admin-token")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var site string
+			var restricted bool
+			h := g.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				site, restricted = tenantSite(r)
+			}), tt.allSites)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, testAdminRequest(http.MethodGet, "203.0.113.1:1234", tt.auth, tt.token))
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, want %d", w.Code, tt.want)
+			}
+			if w.Code == http.StatusUnauthorized && len(w.Header().Values("WWW-Authenticate")) != 2 {
+				t.Errorf("got challenges %v, want bearer and basic", w.Header().Values("WWW-Authenticate"))
+			}
+			if site != tt.site || restricted != (tt.site != "") {
+				t.Errorf("got request restricted to %q (%t), want %q", site, restricted, tt.site)
+			}
+		})
+	}
+}
+
+// Without site tokens, the API is open, and reloads change the tokens
+func TestTenantGuardUpdate(t *testing.T) {
+	g := newTenantGuard([]site{{Name: "alice"}}, "admin-token")
+	h := g.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), false)
+	status := func(token string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, testAdminRequest(http.MethodGet, "203.0.113.1:1234", "bearer", token))
+		return w.Code
+	}
+
+	if got := status(""); got != http.StatusOK {
+		t.Errorf("got status %d without site tokens, want 200", got)
+	}
+	g.update([]site{{Name: "alice", APIToken: "alice-token"}})
+	if got := status(""); got != http.StatusUnauthorized {
+		t.Errorf("got status %d without token, want 401", got)
+	}
+	if got := status("alice-token"); got != http.StatusOK {
+		t.Errorf("got status %d with the new site token, want 200", got)
+	}
+	g.update([]site{{Name: "alice", APIToken: "new-token"}})
+	if got := status("alice-token"); got != http.StatusUnauthorized {
+		t.Errorf("got status %d with the removed site token, want 401", got)
+	}
+}
+
+func TestVisible(t *testing.T) {
+	g := newTenantGuard([]site{{Name: "alice", APIToken: "alice-token"}}, "admin-token")
+	for token, want := range map[string]map[string]bool{
+		"alice-token": {"forecast.solar/alice": true, "forecast.solar/alice/east": true, "forecast.solar/bob": false},
+		"admin-token": {"forecast.solar/alice": true, "forecast.solar/bob": true},
+	} {
+		g.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for source, visibleWant := range want {
+				if got := visible(r, source); got != visibleWant {
+					t.Errorf("%s: got visible %t for %s, want %t", token, got, source, visibleWant)
+				}
+			}
+		}), false).ServeHTTP(httptest.NewRecorder(), testAdminRequest(http.MethodGet, "203.0.113.1:1234", "bearer", token))
+	}
+}
+
+func TestValidateTenants(t *testing.T) {
+	sites := []site{{Name: "alice", APIToken: "alice-token"}}
+	if err := validateTenants(sites, ""); err == nil {
+		t.Error("got no error for site tokens without the admin token")
+	}
+	if err := validateTenants(sites, "admin-token"); err != nil {
+		t.Errorf("got error %s with the admin token", err)
+	}
+	if err := validateTenants([]site{{Name: "alice"}}, ""); err != nil {
+		t.Errorf("got error %s without site tokens", err)
+	}
+}
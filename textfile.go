@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// textfileName is the file written for node_exporter's textfile collector
+const textfileName = "forecast_solar.prom"
+
+// forecastMetrics only gathers the metrics of the exporter with the metric
+// prefix, as the Go and process metrics would collide with the ones of
+// node_exporter itself
+func forecastMetrics(g prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := g.Gather()
+		filtered := families[:0]
+		for _, mf := range families {
+			if strings.HasPrefix(mf.GetName(), metricPrefix+"_") {
+				filtered = append(filtered, mf)
+			}
+		}
+		return filtered, err
+	})
+}
+
+// writeTextfile atomically writes the forecast metrics into dir. The textfile
+// collector rejects timestamps, so they are removed.
+func writeTextfile(dir string) error {
+	return prometheus.WriteToTextfile(
+		filepath.Join(dir, textfileName),
+		withoutTimestamps(forecastMetrics(gatherer)),
+	)
+}
+
+// runTextfile writes the metrics until ctx is cancelled, starting after the
+// first interval to give the sources time for their first poll
+func runTextfile(ctx context.Context, dir string, interval time.Duration) {
+	for sleep(ctx, interval) {
+		if err := writeTextfile(dir); err != nil {
+			log.Printf("Error writing textfile: %s", err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// tiltCollector recommends the best of the tilts an adjustable mount supports
+// for the coming days, comparing their clear-sky irradiation with the
+// configured declination
+type tiltCollector struct {
+	plane           planeGeometry
+	candidates      []float64
+	windowDays      int
+	today, tomorrow *forecastCollector
+
+	optimal   *prometheus.Desc
+	gainRatio *prometheus.Desc
+	gain      *prometheus.Desc
+}
+
+func newTiltCollector(plane planeGeometry, candidates []float64, windowDays int, today, tomorrow *forecastCollector) *tiltCollector {
+	return &tiltCollector{
+		plane:      plane,
+		candidates: candidates,
+		windowDays: windowDays,
+		today:      today,
+		tomorrow:   tomorrow,
+		optimal: prometheus.NewDesc(
+			"forecast_solar_optimal_tilt_degrees",
+			"Tilt with the highest clear-sky yield over the coming days out of the configured candidates",
+			nil,
+			nil,
+		),
+		gainRatio: prometheus.NewDesc(
+			"forecast_solar_optimal_tilt_gain_ratio",
+			"Relative clear-sky yield gain over the coming days when switching to the optimal tilt",
+			nil,
+			nil,
+		),
+		gain: prometheus.NewDesc(
+			"forecast_solar_optimal_tilt_gain_kwh",
+			"Forecasted yield gain when switching to the optimal tilt",
+			[]string{"day"},
+			nil,
+		),
+	}
+}
+
+func (c *tiltCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.optimal
+	ch <- c.gainRatio
+	ch <- c.gain
+}
+
+func (c *tiltCollector) irradiation(day time.Time, declination float64) float64 {
+	return clearSkyIrradiation(day, c.plane.latitude, c.plane.longitude, declination, c.plane.azimuth)
+}
+
+func (c *tiltCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+
+	current := 0.0
+	for d := 0; d < c.windowDays; d++ {
+		current += c.irradiation(now.AddDate(0, 0, d), c.plane.declination)
+	}
+	if current == 0 {
+		return
+	}
+
+	optimal, best := c.plane.declination, current
+	for _, candidate := range c.candidates {
+		total := 0.0
+		for d := 0; d < c.windowDays; d++ {
+			total += c.irradiation(now.AddDate(0, 0, d), candidate)
+		}
+		if total > best {
+			optimal, best = candidate, total
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.optimal, prometheus.GaugeValue, optimal)
+	ch <- prometheus.MustNewConstMetric(c.gainRatio, prometheus.GaugeValue, best/current-1)
+
+	for day, forecast := range map[string]*forecastCollector{"today": c.today, "tomorrow": c.tomorrow} {
+		date, wh := forecast.get()
+		if date.IsZero() {
+			continue
+		}
+		t := time.Date(date.Year(), date.Month(), date.Day(), 12, 0, 0, 0, time.Local)
+		if currentDay := c.irradiation(t, c.plane.declination); currentDay > 0 {
+			ratio := c.irradiation(t, optimal)/currentDay - 1
+			ch <- prometheus.MustNewConstMetric(c.gain, prometheus.GaugeValue, wh*ratio/1000, day)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// newAPITransport returns the transport of requests to the APIs via the
+// proxy, taken from the environment unless given, with the TLS configuration
+// of apiTLSConfig
+func newAPITransport(proxyURL, caFile, certFile, keyFile string, insecure bool) (*http.Transport, error) {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL: %s", proxyURL)
+		}
+		base.Proxy = http.ProxyURL(u)
+	}
+	tlsConfig, err := apiTLSConfig(caFile, certFile, keyFile, insecure)
+	if err != nil {
+		return nil, fmt.Errorf("configuring TLS: %s", err)
+	}
+	base.TLSClientConfig = tlsConfig
+	return base, nil
+}
+
+// apiTLSConfig returns the TLS configuration of requests to the APIs, e.g.
+// for TLS intercepting proxies. The CA bundle is trusted in addition to the
+// system roots.
+func apiTLSConfig(caFile, certFile, keyFile string, insecure bool) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: insecure}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("client certificate and key must be given together")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// siteTotal is the forecast for today of all planes of a site by a provider
+type siteTotal struct {
+	provider, site string
+	date           time.Time
+	wh             float64
+}
+
+// siteTotals sums the forecasts for today of the planes of each site per
+// provider, in the order of the sources. Sites are left out until all of
+// their planes have a current forecast for the same day.
+func siteTotals(sources []*source) []siteTotal {
+	return siteTotalsOf(sources, func(s *source) *forecastCollector { return s.today })
+}
+
+// siteTotalsOf sums the forecasts of the day returned by day like siteTotals
+func siteTotalsOf(sources []*source, day func(*source) *forecastCollector) []siteTotal {
+	var totals []siteTotal
+	index := map[[2]string]int{}
+	incomplete := map[[2]string]bool{}
+	for _, s := range sources {
+		provider, site, _ := splitSourceName(s.name)
+		key := [2]string{provider, site}
+		date, wh := day(s).get()
+		if s.expired.Load() {
+			date = time.Time{}
+		}
+
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(totals)
+			totals = append(totals, siteTotal{provider: provider, site: site, date: date, wh: wh})
+			incomplete[key] = date.IsZero()
+			continue
+		}
+		if !date.Equal(totals[i].date) {
+			incomplete[key] = true
+		}
+		totals[i].wh += wh
+	}
+
+	complete := totals[:0]
+	for _, t := range totals {
+		if !incomplete[[2]string{t.provider, t.site}] {
+			complete = append(complete, t)
+		}
+	}
+	return complete
+}
+
+// siteTotalCollector exports the forecast for today summed over all planes of
+// each site in fleet mode
+type siteTotalCollector struct {
+	sources *sourceSet
+	metric  *prometheus.Desc
+}
+
+func newSiteTotalCollector(sources *sourceSet) *siteTotalCollector {
+	return &siteTotalCollector{
+		sources: sources,
+		metric: prometheus.NewDesc(
+			"forecast_solar_total_today_kwh",
+			"Solar harvest forecast for today summed over all planes of the site",
+			[]string{"provider", "site"},
+			nil,
+		),
+	}
+}
+
+func (c *siteTotalCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.metric
+}
+
+func (c *siteTotalCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, t := range siteTotals(c.sources.all()) {
+		ch <- prometheus.MustNewConstMetric(c.metric, prometheus.GaugeValue, t.wh/1000, t.provider, t.site)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+// reportTranslations are the texts of the built-in report templates by
+// language, keyed by the English text
+var reportTranslations = map[string]map[string]string{
+	"de": {
+		"Solar forecast report %s to %s":                    "Solarprognose-Bericht %s bis %s",
+		"Forecast: %s kWh, actual: %s kWh":                  "Prognose: %s kWh, tatsächlich: %s kWh",
+		"Best day: %s (%s kWh forecast, %s kWh actual)":     "Bester Tag: %s (%s kWh Prognose, %s kWh tatsächlich)",
+		"Worst day: %s (%s kWh forecast, %s kWh actual)":    "Schlechtester Tag: %s (%s kWh Prognose, %s kWh tatsächlich)",
+		"%d days left out as the exporter was not running.": "%d Tage ausgelassen, da der Exporter nicht lief.",
+		"Day":                                  "Tag",
+		"Forecast (kWh)":                       "Prognose (kWh)",
+		"Actual (kWh)":                         "Tatsächlich (kWh)",
+		"Accuracy trend (mean absolute error)": "Genauigkeitstrend (mittlerer absoluter Fehler)",
+		"Week":                                 "Woche",
+		"Days":                                 "Tage",
+		"Error (%)":                            "Fehler (%)",
+		"No forecasts recorded in this week.":  "In dieser Woche wurden keine Prognosen aufgezeichnet.",
+		"Generated %s":                         "Erstellt am %s",
+	},
+	"nl": {
+		"Solar forecast report %s to %s":                    "Zonneprognoserapport %s tot %s",
+		"Forecast: %s kWh, actual: %s kWh":                  "Prognose: %s kWh, werkelijk: %s kWh",
+		"Best day: %s (%s kWh forecast, %s kWh actual)":     "Beste dag: %s (%s kWh prognose, %s kWh werkelijk)",
+		"Worst day: %s (%s kWh forecast, %s kWh actual)":    "Slechtste dag: %s (%s kWh prognose, %s kWh werkelijk)",
+		"%d days left out as the exporter was not running.": "%d dagen weggelaten omdat de exporter niet draaide.",
+		"Day":                                  "Dag",
+		"Forecast (kWh)":                       "Prognose (kWh)",
+		"Actual (kWh)":                         "Werkelijk (kWh)",
+		"Accuracy trend (mean absolute error)": "Nauwkeurigheidstrend (gemiddelde absolute fout)",
+		"Week":                                 "Week",
+		"Days":                                 "Dagen",
+		"Error (%)":                            "Fout (%)",
+		"No forecasts recorded in this week.":  "Geen prognoses vastgelegd in deze week.",
+		"Generated %s":                         "Gegenereerd op %s",
+	},
+	"fr": {
+		"Solar forecast report %s to %s":                    "Rapport de prévision solaire du %s au %s",
+		"Forecast: %s kWh, actual: %s kWh":                  "Prévision : %s kWh, réel : %s kWh",
+		"Best day: %s (%s kWh forecast, %s kWh actual)":     "Meilleur jour : %s (%s kWh prévus, %s kWh réels)",
+		"Worst day: %s (%s kWh forecast, %s kWh actual)":    "Pire jour : %s (%s kWh prévus, %s kWh réels)",
+		"%d days left out as the exporter was not running.": "%d jours omis car l'exportateur ne fonctionnait pas.",
+		"Day":                                  "Jour",
+		"Forecast (kWh)":                       "Prévision (kWh)",
+		"Actual (kWh)":                         "Réel (kWh)",
+		"Accuracy trend (mean absolute error)": "Tendance de précision (erreur absolue moyenne)",
+		"Week":                                 "Semaine",
+		"Days":                                 "Jours",
+		"Error (%)":                            "Erreur (%)",
+		"No forecasts recorded in this week.":  "Aucune prévision enregistrée cette semaine.",
+		"Generated %s":                         "Généré le %s",
+	},
+}
+
+// translate returns the text in the language, or the English text if it is
+// not translated
+func translate(lang, text string) string {
+	if t, ok := reportTranslations[lang][text]; ok {
+		return t
+	}
+	return text
+}
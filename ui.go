@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"time"
+)
+
+// webUI serves a forecast panel of all sources
+type webUI struct {
+	sources     *sourceSet
+	metricsPath string
+	locale      *localeFormat
+	history     bool // Whether the history and thus reports are enabled
+	settings    bool // Whether the settings page is enabled
+	tariffs     []tariffWindow
+}
+
+// uiPage is the data of the web UI
+type uiPage struct {
+	Kiosk       bool
+	Theme       string
+	MetricsPath string
+	History     bool
+	Settings    bool
+	Restricted  bool // Restricted to the site of a site token
+	Tariffs     []uiTariff
+	Sources     []uiSource
+}
+
+// uiTariff is a tariff window highlighted in the charts
+type uiTariff struct {
+	Name     string
+	Class    string
+	From, To time.Time
+}
+
+// Number of distinct colors of tariff windows
+const uiTariffColors = 4
+
+type uiSource struct {
+	Name      string
+	Today     float64
+	Tomorrow  float64
+	Retrieved *time.Time
+	Days      []uiDay
+
+	// Health of the polls and the rate limit of the API if it reports one
+	Failures  int64
+	Expired   bool
+	Paused    bool
+	NextPoll  *time.Time
+	RateLimit *rateLimit
+	Plan      string
+}
+
+type uiDay struct {
+	Day  string
+	Bars []uiBar
+}
+
+// uiBar is a period of the power chart
+type uiBar struct {
+	Time    time.Time
+	Watts   float64
+	Percent float64 // Of the maximum power of all periods
+	Tariff  string
+	Class   string // Class of the tariff window the period is in
+}
+
+// uiPeriodLayout is the layout of the periods of the API
+const uiPeriodLayout = "2006-01-02 15:04:05"
+
+func (u *webUI) newUISource(s *source) uiSource {
+	ui := uiSource{Name: s.name}
+	_, ui.Today = s.today.get()
+	_, ui.Tomorrow = s.tomorrow.get()
+	if last := s.lastSuccess.Load(); last != 0 {
+		retrieved := time.Unix(0, last)
+		ui.Retrieved = &retrieved
+	}
+	ui.Failures = s.lastFailures.Load()
+	ui.Expired = s.expired.Load()
+	ui.Paused = s.night(clock())
+	if last := s.lastAttempt.Load(); last != 0 {
+		next := time.Unix(0, last+s.pollDelay.Load())
+		ui.NextPoll = &next
+	}
+
+	res := s.forecast.Load()
+	if res == nil {
+		return ui
+	}
+	if l := res.Message.RateLimit; l != nil {
+		ui.RateLimit, ui.Plan = l, planOf(l.Zone)
+	}
+	max := 0.0
+	for _, watts := range res.Result.Watts {
+		if watts > max {
+			max = watts
+		}
+	}
+	for _, period := range sortedKeys(res.Result.Watts) {
+		t, err := time.Parse(uiPeriodLayout, period)
+		if err != nil {
+			continue
+		}
+		day := t.Format(time.DateOnly)
+		if len(ui.Days) == 0 || ui.Days[len(ui.Days)-1].Day != day {
+			ui.Days = append(ui.Days, uiDay{Day: day})
+		}
+		bar := uiBar{Time: t, Watts: res.Result.Watts[period]}
+		if max > 0 {
+			bar.Percent = bar.Watts / max * 100
+		}
+		for i, w := range u.tariffs {
+			if w.contains(t) {
+				bar.Tariff = w.name
+				bar.Class = fmt.Sprintf("t%d", i%uiTariffColors)
+				break
+			}
+		}
+		d := &ui.Days[len(ui.Days)-1]
+		d.Bars = append(d.Bars, bar)
+	}
+	return ui
+}
+
+const uiHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+{{if .Kiosk}}<meta http-equiv="refresh" content="60">
+{{end}}<title>Solar forecast</title>
+<style>
+body { --fg: #222; --bg: #fff; --muted: #777; --bar: #f5a623; font-family: sans-serif; color: var(--fg); background: var(--bg); max-width: 60em; margin: 2em auto; padding: 0 1em; }
+body.dark { --fg: #eee; --bg: #111; --muted: #999; --bar: #e8912a; }
+body.kiosk { max-width: none; height: 100vh; margin: 0; padding: 2vh 3vw; box-sizing: border-box; font-size: 2.5vh; overflow: hidden; cursor: none; }
+nav a { margin-right: 1em; color: var(--fg); }
+.totals { display: flex; gap: 3em; }
+.totals div span { display: block; font-size: 2.5em; font-weight: bold; }
+.chart { display: flex; align-items: flex-end; gap: 2px; height: 10em; margin: .5em 0 1.5em; }
+.kiosk .chart { height: 22vh; }
+.chart span { flex: 1; height: 100%; display: flex; align-items: flex-end; }
+.chart div { flex: 1; background: var(--bar); min-height: 1px; }
+.t0 { background: #4a90e233; } .t1 { background: #d0021b33; } .t2 { background: #7ed32133; } .t3 { background: #9013fe33; }
+.legend span { display: inline-block; padding: 0 .5em; margin-right: 1em; }
+.muted { color: var(--muted); }
+.error { color: #d0021b; }
+</style>
+</head>
+<body class="{{.Theme}}{{if .Kiosk}} kiosk{{end}}">
+{{if not .Kiosk}}<nav>{{if not .Restricted}}<a href="{{.MetricsPath}}">Metrics</a><a href="{{.MetricsPath}}/docs">Metric docs</a>{{end}}<a href="/api/v1/forecast">Forecast JSON</a>{{if and .History (not .Restricted)}}<a href="/reports/latest">Weekly report</a>{{end}}{{if and .Settings (not .Restricted)}}<a href="/settings">Settings</a>{{end}}<a href="/?kiosk&amp;theme={{.Theme}}">Kiosk</a></nav>
+{{end}}{{with .Tariffs}}<p class="legend">{{range .}}<span class="{{.Class}}">{{.Name}} {{clock .From}}–{{clock .To}}</span>{{end}}</p>
+{{end}}{{range .Sources}}
+<h1>{{.Name}}</h1>
+<div class="totals">
+<div>Today<span>{{kwh .Today}} kWh</span></div>
+<div>Tomorrow<span>{{kwh .Tomorrow}} kWh</span></div>
+</div>
+{{range .Days}}<h2>{{day .Day}}</h2>
+<div class="chart">{{range .Bars}}<span class="{{.Class}}"><div style="height: {{percent .Percent}}%" title="{{clock .Time}}: {{.Watts}} W{{with .Tariff}} ({{.}}){{end}}"></div></span>{{end}}</div>
+{{end}}<p class="muted">{{with .Retrieved}}Retrieved {{datetime .}}{{else}}No forecast retrieved yet{{end}}{{if not $.Kiosk}}{{if .Paused}}, polls paused until sunrise{{else}}{{with .NextPoll}}, next poll {{datetime .}}{{end}}{{end}}{{end}}</p>
+{{if not $.Kiosk}}{{if .Expired}}<p class="error">Forecast expired after {{.Failures}} consecutive failed polls</p>
+{{else if .Failures}}<p class="error">{{.Failures}} consecutive failed polls{{if .Retrieved}}, serving the last forecast{{end}}</p>
+{{end}}{{if .RateLimit}}<p class="muted">Rate limit: {{.RateLimit.Remaining}} of {{.RateLimit.Limit}} requests per {{.RateLimit.Period}}s remaining ({{.Plan}} plan)</p>
+{{end}}{{end}}{{end}}
+</body>
+</html>
+`
+
+var uiTemplate = htmltemplate.Must(htmltemplate.New("ui").Funcs(isoLocale.uiFuncs()).Parse(uiHTML))
+
+// uiFuncs are the template functions of the web UI
+func (l *localeFormat) uiFuncs() map[string]interface{} {
+	funcs := l.reportFuncs("en")
+	funcs["clock"] = func(t time.Time) string { return t.Format(l.time) }
+	// CSS needs decimal points regardless of the locale
+	funcs["percent"] = func(v float64) string { return isoLocale.number(v, 1) }
+	return funcs
+}
+
+// ServeHTTP renders the panel. With the kiosk parameter it is rendered
+// full-screen without navigation and refreshes itself, theme selects light or
+// dark colors.
+func (u *webUI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	page := uiPage{
+		Kiosk:       r.URL.Query().Has("kiosk"),
+		Theme:       r.FormValue("theme"),
+		MetricsPath: u.metricsPath,
+		History:     u.history,
+		Settings:    u.settings,
+	}
+	switch page.Theme {
+	case "":
+		page.Theme = "light"
+	case "light", "dark":
+	default:
+		http.Error(w, "Parameter theme must be light or dark", http.StatusBadRequest)
+		return
+	}
+	for i, t := range u.tariffs {
+		page.Tariffs = append(page.Tariffs, uiTariff{
+			Name:  t.name,
+			Class: fmt.Sprintf("t%d", i%uiTariffColors),
+			From:  time.Date(0, 1, 1, 0, t.start, 0, 0, time.UTC),
+			To:    time.Date(0, 1, 1, 0, t.end, 0, 0, time.UTC),
+		})
+	}
+	// A site token only shows its site, without the links to the pages of
+	// all sites
+	_, page.Restricted = tenantSite(r)
+	for _, s := range u.sources.all() {
+		if !visible(r, s.name) {
+			continue
+		}
+		page.Sources = append(page.Sources, u.newUISource(s))
+	}
+
+	t, err := uiTemplate.Clone()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	if err := t.Funcs(u.locale.uiFuncs()).Execute(&buf, page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// energyUnitName returns the name of an energy metric exported in the unit:
+// kwh adds the _kwh suffix to the daily forecasts in Wh, wh replaces the _kwh
+// suffix by _watthours and adds it to the daily forecasts. Other metrics and
+// the empty unit, which keeps the historical mix, keep their name.
+func energyUnitName(name, unit string) string {
+	daily := dailyEnergyMetrics[name]
+	switch {
+	case unit == "kwh" && daily:
+		return name + "_kwh"
+	case unit == "wh" && daily:
+		return name + "_watthours"
+	case unit == "wh" && strings.HasSuffix(name, "_kwh"):
+		return strings.TrimSuffix(name, "_kwh") + "_watthours"
+	}
+	return name
+}
+
+// energyUnitFactor returns the factor to convert the values of an energy
+// metric to the unit
+func energyUnitFactor(name, unit string) float64 {
+	daily := dailyEnergyMetrics[name]
+	switch {
+	case unit == "kwh" && daily:
+		return 0.001
+	case unit == "wh" && strings.HasSuffix(name, "_kwh"):
+		return 1000
+	}
+	return 1
+}
+
+// kwhExpr returns a PromQL expression of an energy metric in kWh for
+// recording rules, where {p} is the prefix
+func kwhExpr(name, unit string) string {
+	name = strings.Replace(name, "{p}", defaultMetricPrefix, 1)
+	exported := strings.Replace(energyUnitName(name, unit), defaultMetricPrefix, "{p}", 1)
+	if strings.HasSuffix(exported, "_kwh") {
+		return exported
+	}
+	return "(" + exported + " / 1000)"
+}
+
+// withEnergyUnit exports all energy metrics in the unit, see energyUnitName.
+// Families are copied, as the fleet gatherer shares them between scrapes.
+func withEnergyUnit(g prometheus.Gatherer, unit string) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := g.Gather()
+		for i, mf := range families {
+			name := energyUnitName(mf.GetName(), unit)
+			if name == mf.GetName() {
+				continue
+			}
+			factor := energyUnitFactor(mf.GetName(), unit)
+			metrics := make([]*dto.Metric, len(mf.Metric))
+			for j, m := range mf.Metric {
+				metrics[j] = scaleMetric(m, factor)
+			}
+			families[i] = &dto.MetricFamily{
+				Name:   &name,
+				Help:   mf.Help,
+				Type:   mf.Type,
+				Metric: metrics,
+			}
+		}
+		return families, err
+	})
+}
+
+// scaleMetric returns a copy of a gauge, counter or untyped metric with the
+// value multiplied by factor
+func scaleMetric(m *dto.Metric, factor float64) *dto.Metric {
+	if factor == 1 {
+		return m
+	}
+	scaled := &dto.Metric{Label: m.Label, TimestampMs: m.TimestampMs}
+	switch {
+	case m.Gauge != nil:
+		v := m.Gauge.GetValue() * factor
+		scaled.Gauge = &dto.Gauge{Value: &v}
+	case m.Counter != nil:
+		v := m.Counter.GetValue() * factor
+		scaled.Counter = &dto.Counter{Value: &v}
+	case m.Untyped != nil:
+		v := m.Untyped.GetValue() * factor
+		scaled.Untyped = &dto.Untyped{Value: &v}
+	default:
+		return m
+	}
+	return scaled
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	promVersion "github.com/prometheus/common/version"
+)
+
+// Latest release of the exporter on GitHub
+const latestReleaseURL = "https://api.github.com/repos/chr4/forecast_solar_exporter/releases/latest"
+
+// updateChecker periodically checks GitHub for a newer release of the
+// exporter, so outdated instances show up on dashboards
+type updateChecker struct {
+	client    *http.Client
+	url       string
+	interval  time.Duration
+	available *prometheus.GaugeVec
+}
+
+func newUpdateChecker(client *http.Client, interval time.Duration) *updateChecker {
+	return &updateChecker{
+		client:   client,
+		url:      latestReleaseURL,
+		interval: interval,
+		available: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "forecast_solar_exporter_update_available",
+			Help: "Whether a newer release than the running version is available, labeled with the latest version",
+		}, []string{"latest_version"}),
+	}
+}
+
+// run checks for updates until ctx is cancelled
+func (u *updateChecker) run(ctx context.Context) {
+	for {
+		if err := u.check(); err != nil {
+			log.Printf("Error checking for updates: %s", err)
+		}
+		if !sleep(ctx, u.interval) {
+			return
+		}
+	}
+}
+
+func (u *updateChecker) check() error {
+	req, err := http.NewRequest(http.MethodGet, u.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	r, err := doRequest(u.client, req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return &statusError{StatusCode: r.StatusCode, Status: r.Status}
+	}
+
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&release); err != nil {
+		return err
+	}
+
+	latest := strings.TrimPrefix(release.TagName, "v")
+	available := 0.0
+	if newerVersion(latest, promVersion.Version) {
+		available = 1
+		log.Printf("Version %s of the exporter is available, running %s", latest, promVersion.Version)
+	}
+	u.available.Reset()
+	u.available.WithLabelValues(latest).Set(available)
+	return nil
+}
+
+// newerVersion returns whether the dotted version a is newer than b, comparing
+// the numeric parts and ignoring pre-release and build suffixes
+func newerVersion(a, b string) bool {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			return x > y
+		}
+	}
+	return false
+}
+
+func versionParts(v string) []int {
+	v, _, _ = strings.Cut(v, "-")
+	v, _, _ = strings.Cut(v, "+")
+	var parts []int
+	for _, p := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+	}
+	return parts
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// usageRecord is the usage of a source in a month
+type usageRecord struct {
+	Requests    int     `json:"requests"`
+	RateLimited int     `json:"rate_limited"` // Requests rejected as the API quota was exceeded
+	Cost        float64 `json:"cost"`         // Estimated cost, see -api.request-cost
+	Datapoints  int     `json:"datapoints"`   // MQTT messages and InfluxDB points pushed
+}
+
+// usageStore accounts the usage of each source per month, e.g. for re-billing
+// monitoring to customers. It is persisted to a file if a path is given.
+type usageStore struct {
+	mu     sync.Mutex
+	path   string
+	Months map[string]map[string]*usageRecord `json:"months"` // By month (YYYY-MM) and source
+}
+
+// openUsage loads the usage from path, starting with an empty one if the
+// file does not exist yet or no path is given
+func openUsage(path string) (*usageStore, error) {
+	u := &usageStore{
+		path:   path,
+		Months: map[string]map[string]*usageRecord{},
+	}
+	if path == "" {
+		return u, nil
+	}
+
+	body, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return u, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// add updates the usage of a source in the current month and persists it
+func (u *usageStore) add(source string, update func(r *usageRecord)) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	month := clock().Format("2006-01")
+	if u.Months[month] == nil {
+		u.Months[month] = map[string]*usageRecord{}
+	}
+	r := u.Months[month][source]
+	if r == nil {
+		r = &usageRecord{}
+		u.Months[month][source] = r
+	}
+	update(r)
+
+	if u.path == "" {
+		return nil
+	}
+	body, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(u.path, body)
+}
+
+// ServeHTTP serves the usage as JSON, or as CSV with format=csv. month limits
+// it to a month given as YYYY-MM.
+func (u *usageStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	month := r.FormValue("month")
+	if month != "" {
+		if _, err := time.Parse("2006-01", month); err != nil {
+			http.Error(w, "Invalid month, expected YYYY-MM", http.StatusBadRequest)
+			return
+		}
+	}
+
+	u.mu.Lock()
+	months := map[string]map[string]usageRecord{}
+	for m, sources := range u.Months {
+		if month != "" && m != month {
+			continue
+		}
+		months[m] = map[string]usageRecord{}
+		for source, record := range sources {
+			if !visible(r, source) {
+				continue
+			}
+			months[m][source] = *record
+		}
+	}
+	u.mu.Unlock()
+
+	switch r.FormValue("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(months)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="usage.csv"`)
+		c := csv.NewWriter(w)
+		c.Write([]string{"month", "source", "requests", "rate_limited", "cost", "datapoints"})
+		for _, m := range sortedKeys(months) {
+			for _, source := range sortedKeys(months[m]) {
+				record := months[m][source]
+				c.Write([]string{
+					m,
+					source,
+					strconv.Itoa(record.Requests),
+					strconv.Itoa(record.RateLimited),
+					strconv.FormatFloat(record.Cost, 'f', -1, 64),
+					strconv.Itoa(record.Datapoints),
+				})
+			}
+		}
+		c.Flush()
+	default:
+		http.Error(w, "Unknown format, expected json or csv", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// weatherForecast holds weather values by period, in the same format as the
+// watts of a forecast
+type weatherForecast struct {
+	UVIndex         map[string]float64 `json:"uv_index"`
+	Irradiance      map[string]float64 `json:"irradiance"`       // Global horizontal irradiance in W/m²
+	PlaneIrradiance map[string]float64 `json:"plane_irradiance"` // Plane-of-array irradiance in W/m²
+	WindGusts       map[string]float64 `json:"wind_gusts"`       // Maximum gust speed in m/s
+}
+
+// weatherCollector exports the weather forecast of a weather provider per
+// hour of today and tomorrow. Sources of other providers export nothing.
+type weatherCollector struct {
+	source        *source
+	gustThreshold float64
+	gustWindow    time.Duration
+
+	uvIndex         *prometheus.Desc
+	irradiance      *prometheus.Desc
+	planeIrradiance *prometheus.Desc
+	windGusts       *prometheus.Desc
+	secure          *prometheus.Desc
+}
+
+func newWeatherCollector(s *source, gustThreshold float64, gustWindow time.Duration) *weatherCollector {
+	return &weatherCollector{
+		source:        s,
+		gustThreshold: gustThreshold,
+		gustWindow:    gustWindow,
+		uvIndex: prometheus.NewDesc(
+			"forecast_solar_uv_index",
+			"UV index forecast for the hour starting at the given hour of the day",
+			[]string{"day", "hour"},
+			nil,
+		),
+		irradiance: prometheus.NewDesc(
+			"forecast_solar_irradiance_watts_per_square_meter",
+			"Global horizontal irradiance forecast for the hour starting at the given hour of the day",
+			[]string{"day", "hour"},
+			nil,
+		),
+		planeIrradiance: prometheus.NewDesc(
+			"forecast_solar_plane_irradiance_watts_per_square_meter",
+			"Plane-of-array irradiance forecast for the hour starting at the given hour of the day",
+			[]string{"day", "hour"},
+			nil,
+		),
+		windGusts: prometheus.NewDesc(
+			"forecast_solar_wind_gust_meters_per_second",
+			"Maximum wind gust speed forecast for the hour starting at the given hour of the day",
+			[]string{"day", "hour"},
+			nil,
+		),
+		secure: prometheus.NewDesc(
+			"forecast_solar_secure_loose_equipment",
+			"Whether wind gusts reaching the configured threshold are forecasted for the coming hours",
+			nil,
+			nil,
+		),
+	}
+}
+
+func (c *weatherCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.uvIndex
+	ch <- c.irradiance
+	ch <- c.planeIrradiance
+	ch <- c.windGusts
+	ch <- c.secure
+}
+
+func (c *weatherCollector) Collect(ch chan<- prometheus.Metric) {
+	res := c.source.forecast.Load()
+	if res == nil || res.Weather == nil {
+		return
+	}
+	days := weatherDays(res)
+	for desc, values := range map[*prometheus.Desc]map[string]float64{
+		c.uvIndex:         res.Weather.UVIndex,
+		c.irradiance:      res.Weather.Irradiance,
+		c.planeIrradiance: res.Weather.PlaneIrradiance,
+		c.windGusts:       res.Weather.WindGusts,
+	} {
+		for period, v := range values {
+			day, hour, ok := weatherHour(period, days, res.location())
+			if ok {
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, day, strconv.Itoa(hour))
+			}
+		}
+	}
+
+	if c.gustThreshold > 0 && len(res.Weather.WindGusts) > 0 {
+		secure := 0.0
+		if c.gusty(res.Weather.WindGusts, res.location()) {
+			secure = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.secure, prometheus.GaugeValue, secure)
+	}
+}
+
+// gusty returns whether gusts reach the threshold in an hour ending within
+// the window from now. Periods are in the local time of the plant at loc.
+func (c *weatherCollector) gusty(gusts map[string]float64, loc *time.Location) bool {
+	now := clock()
+	for period, speed := range gusts {
+		t, err := time.ParseInLocation(time.DateTime, period, loc)
+		if err != nil || !t.After(now) || t.After(now.Add(c.gustWindow)) {
+			continue
+		}
+		if speed >= c.gustThreshold {
+			return true
+		}
+	}
+	return false
+}
+
+// weatherDays names the days of a forecast today and tomorrow in order
+func weatherDays(res *apiResponse) map[string]string {
+	names := map[string]string{}
+	for i, date := range forecastDays(res) {
+		switch i {
+		case 0:
+			names[date] = "today"
+		case 1:
+			names[date] = "tomorrow"
+		}
+	}
+	return names
+}
+
+// weatherHour returns the day name and hour of the hour a period ends, like
+// the hourly energy. Periods are in the local time of the plant at loc.
+func weatherHour(period string, days map[string]string, loc *time.Location) (string, int, bool) {
+	t, err := time.ParseInLocation(time.DateTime, period, loc)
+	if err != nil {
+		return "", 0, false
+	}
+	start := t.Add(-time.Second)
+	day, ok := days[start.Format(time.DateOnly)]
+	return day, start.Hour(), ok
+}
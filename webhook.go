@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Maximum size of a pushed forecast
+const webhookMaxBody = 1 << 20
+
+// Maximum difference between the timestamp of a push and the time it is
+// received, older pushes are rejected as replayed
+const webhookMaxSkew = 5 * time.Minute
+
+// webhookSignature returns the HMAC-SHA256 of a push to a source at the unix
+// timestamp, given as "<timestamp>.<source>.<body>"
+func webhookSignature(secret, timestamp, source string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s.%s.", timestamp, source)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// webhookHandler receives forecasts pushed in the format of the
+// forecast.solar API, e.g. POST /api/v1/webhook?source=forecast.solar. The
+// unix timestamp of the X-Timestamp header, the source and the body must be
+// signed with HMAC-SHA256 using the secret, see webhookSignature, given hex
+// encoded in the X-Signature-256 header as sha256=<signature>. Pushes with
+// timestamps off by more than webhookMaxSkew and signatures seen before are
+// rejected, so captured pushes can't be replayed. Pushed forecasts are
+// processed like polled ones, polling continues as fallback.
+func webhookHandler(secret string, sources *sourceSet) http.HandlerFunc {
+	var mu sync.Mutex
+	seen := map[string]time.Time{} // Time of the timestamp by signature
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, webhookMaxBody+1))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(body) > webhookMaxBody {
+			http.Error(w, "Body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		name := r.URL.Query().Get("source")
+		timestamp := r.Header.Get("X-Timestamp")
+		signature, _ := strings.CutPrefix(r.Header.Get("X-Signature-256"), "sha256=")
+		given, err := hex.DecodeString(signature)
+		if err != nil || !hmac.Equal(given, webhookSignature(secret, timestamp, name, body)) {
+			log.Printf("Denied webhook from %s: invalid signature", r.RemoteAddr)
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			return
+		}
+		unix, err := strconv.ParseInt(timestamp, 10, 64)
+		sent := time.Unix(unix, 0)
+		now := time.Now()
+		if err != nil || sent.Before(now.Add(-webhookMaxSkew)) || sent.After(now.Add(webhookMaxSkew)) {
+			log.Printf("Denied webhook from %s: timestamp %q not within %s", r.RemoteAddr, timestamp, webhookMaxSkew)
+			http.Error(w, "Stale timestamp", http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		for sig, t := range seen {
+			if t.Before(now.Add(-webhookMaxSkew)) {
+				delete(seen, sig)
+			}
+		}
+		key := hex.EncodeToString(given)
+		_, replayed := seen[key]
+		seen[key] = sent
+		mu.Unlock()
+		if replayed {
+			log.Printf("Denied webhook from %s: replayed signature", r.RemoteAddr)
+			http.Error(w, "Replayed signature", http.StatusUnauthorized)
+			return
+		}
+
+		var target *source
+		for _, s := range sources.all() {
+			if s.name == name {
+				target = s
+			}
+		}
+		if target == nil {
+			http.Error(w, fmt.Sprintf("Unknown source %q", name), http.StatusNotFound)
+			return
+		}
+
+		res := &apiResponse{}
+		if err := json.Unmarshal(body, res); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid forecast: %s", err), http.StatusBadRequest)
+			return
+		}
+		if !target.push(res) {
+			http.Error(w, "Invalid forecast", http.StatusUnprocessableEntity)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
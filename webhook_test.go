@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	opts := &sourceOptions{readOnly: &atomic.Bool{}, quotaBehavior: "keep", quotaAfter: 3, backoffMax: time.Hour, outputs: newOutputs()}
+	set := newSourceSet([]*source{
+		newSource("forecast.solar", nil, time.Hour, opts),
+		newSource("solcast", nil, time.Hour, opts),
+	})
+	body, err := json.Marshal(syntheticForecast(48, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := webhookHandler("secret", set)
+	push := func(source string, sent time.Time, signedSource, secret string) int {
+		ts := strconv.FormatInt(sent.Unix(), 10)
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/webhook?source="+source, bytes.NewReader(body))
+		r.Header.Set("X-Timestamp", ts)
+		r.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(webhookSignature(secret, ts, signedSource, body)))
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w.Code
+	}
+
+	now := time.Now()
+	tests := []struct {
+		name              string
+		source, signedFor string
+		sent              time.Time
+		secret            string
+		want              int
+	}{
+		{"valid", "forecast.solar", "forecast.solar", now, "secret", http.StatusNoContent},
+		{"replayed", "forecast.solar", "forecast.solar", now, "secret", http.StatusUnauthorized},
+		{"other source", "solcast", "forecast.solar", now.Add(-time.Second), "secret", http.StatusUnauthorized},
+		{"wrong secret", "forecast.solar", "forecast.solar", now.Add(-2 * time.Second), "guess", http.StatusUnauthorized},
+		{"stale", "forecast.solar", "forecast.solar", now.Add(-time.Hour), "secret", http.StatusUnauthorized},
+		{"future", "forecast.solar", "forecast.solar", now.Add(time.Hour), "secret", http.StatusUnauthorized},
+		{"unknown source", "nowhere", "nowhere", now.Add(-3 * time.Second), "secret", http.StatusNotFound},
+		{"skewed within limits", "solcast", "solcast", now.Add(-time.Minute), "secret", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		if got := push(tt.source, tt.sent, tt.signedFor, tt.secret); got != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// A signature given in upper case is the same signature
+func TestWebhookReplayCase(t *testing.T) {
+	opts := &sourceOptions{readOnly: &atomic.Bool{}, quotaBehavior: "keep", quotaAfter: 3, backoffMax: time.Hour, outputs: newOutputs()}
+	set := newSourceSet([]*source{newSource("forecast.solar", nil, time.Hour, opts)})
+	body, err := json.Marshal(syntheticForecast(48, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := webhookHandler("secret", set)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := hex.EncodeToString(webhookSignature("secret", ts, "forecast.solar", body))
+	for i, sig := range []string{signature, string(bytes.ToUpper([]byte(signature)))} {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/webhook?source=forecast.solar", bytes.NewReader(body))
+		r.Header.Set("X-Timestamp", ts)
+		r.Header.Set("X-Signature-256", "sha256="+sig)
+		w := httptest.NewRecorder()
+		h(w, r)
+		if want := []int{http.StatusNoContent, http.StatusUnauthorized}[i]; w.Code != want {
+			t.Errorf("push %d: got status %d, want %d", i+1, w.Code, want)
+		}
+	}
+}